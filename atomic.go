@@ -8,10 +8,35 @@ import (
 
 // SetNX sets the value only if the key does not exist.
 func (db *DB) SetNX(key []byte, value []byte) (bool, error) {
-	if len(key) > db.opts.MaxKeySize {
+	return db.def.SetNX(key, value)
+}
+
+// SetNX sets the value in the family only if the key does not exist.
+func (f *Family) SetNX(key []byte, value []byte) (bool, error) {
+	return f.setNX(key, value, f.defaultExpiresAt())
+}
+
+// SetNXWithTTL sets the value with a TTL only if the key does not exist.
+func (db *DB) SetNXWithTTL(key []byte, value []byte, ttl time.Duration) (bool, error) {
+	return db.def.SetNXWithTTL(key, value, ttl)
+}
+
+// SetNXWithTTL sets the value in the family with a TTL only if the key does
+// not exist, which suits lock-style keys that must not outlive a crashed
+// owner. A non-positive ttl falls back to the family's DefaultTTL.
+func (f *Family) SetNXWithTTL(key []byte, value []byte, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return f.SetNX(key, value)
+	}
+	return f.setNX(key, value, f.db.now()+int64(ttl))
+}
+
+func (f *Family) setNX(key []byte, value []byte, expiresAt int64) (bool, error) {
+	db := f.db
+	if len(key) > f.opts.MaxKeySize {
 		return false, ErrKeyTooLarge
 	}
-	if len(value) > db.opts.MaxValueSize {
+	if len(value) > f.opts.MaxValueSize {
 		return false, ErrValueTooLarge
 	}
 	if len(key) == 0 {
@@ -20,16 +45,16 @@ func (db *DB) SetNX(key []byte, value []byte) (bool, error) {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	if db.closed {
-		return false, ErrClosed
+	if err := f.unavailableLocked(); err != nil {
+		return false, err
 	}
 	if db.opts.ReadOnly {
 		return false, ErrReadOnly
 	}
-	if _, ok := db.index.Get(string(key)); ok {
+	if _, ok := f.index.Get(string(key)); ok {
 		return false, nil
 	}
-	if err := db.setWithExpiresAtLocked(key, value, -1, 0, false); err != nil {
+	if err := f.setWithExpiresAtLocked(key, value, expiresAt, 0, false); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -37,17 +62,33 @@ func (db *DB) SetNX(key []byte, value []byte) (bool, error) {
 
 // Incr increments the integer value by 1.
 func (db *DB) Incr(key []byte) (int64, error) {
-	return db.IncrBy(key, 1)
+	return db.def.Incr(key)
+}
+
+// Incr increments the integer value in the family by 1.
+func (f *Family) Incr(key []byte) (int64, error) {
+	return f.IncrBy(key, 1)
 }
 
 // Decr decrements the integer value by 1.
 func (db *DB) Decr(key []byte) (int64, error) {
-	return db.IncrBy(key, -1)
+	return db.def.Decr(key)
+}
+
+// Decr decrements the integer value in the family by 1.
+func (f *Family) Decr(key []byte) (int64, error) {
+	return f.IncrBy(key, -1)
 }
 
 // IncrBy increments the integer value by delta.
 func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
-	if len(key) > db.opts.MaxKeySize {
+	return db.def.IncrBy(key, delta)
+}
+
+// IncrBy increments the integer value in the family by delta.
+func (f *Family) IncrBy(key []byte, delta int64) (int64, error) {
+	db := f.db
+	if len(key) > f.opts.MaxKeySize {
 		return 0, ErrKeyTooLarge
 	}
 	if len(key) == 0 {
@@ -56,21 +97,27 @@ func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	if db.closed {
-		return 0, ErrClosed
+	if err := f.unavailableLocked(); err != nil {
+		return 0, err
 	}
 	if db.opts.ReadOnly {
 		return 0, ErrReadOnly
 	}
 
-	entry, ok := db.index.Get(string(key))
+	entry, ok := f.index.Get(string(key))
 	var current int64
 	var createdAt int64
+	expiresAt := int64(-1)
 	if !ok {
 		current = 0
-		createdAt = time.Now().UnixNano()
+		createdAt = db.now()
+		expiresAt = f.defaultExpiresAt()
 	} else {
-		parsed, err := strconv.ParseInt(string(entry.Value), 10, 64)
+		stored, err := db.entryValue(string(key), entry)
+		if err != nil {
+			return 0, err
+		}
+		parsed, err := strconv.ParseInt(string(stored), 10, 64)
 		if err != nil {
 			return 0, ErrInvalidValue
 		}
@@ -80,7 +127,7 @@ func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
 	newVal := current + delta
 	valueBytes := []byte(strconv.FormatInt(newVal, 10))
 
-	if err := db.setWithExpiresAtLocked(key, valueBytes, -1, createdAt, true); err != nil {
+	if err := f.setWithExpiresAtLocked(key, valueBytes, expiresAt, createdAt, true); err != nil {
 		return 0, err
 	}
 	return newVal, nil
@@ -88,10 +135,16 @@ func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
 
 // CompareAndSwap sets new value if current value matches old.
 func (db *DB) CompareAndSwap(key []byte, oldVal []byte, newVal []byte) (bool, error) {
-	if len(key) > db.opts.MaxKeySize {
+	return db.def.CompareAndSwap(key, oldVal, newVal)
+}
+
+// CompareAndSwap sets new value in the family if current value matches old.
+func (f *Family) CompareAndSwap(key []byte, oldVal []byte, newVal []byte) (bool, error) {
+	db := f.db
+	if len(key) > f.opts.MaxKeySize {
 		return false, ErrKeyTooLarge
 	}
-	if len(newVal) > db.opts.MaxValueSize {
+	if len(newVal) > f.opts.MaxValueSize {
 		return false, ErrValueTooLarge
 	}
 	if len(key) == 0 {
@@ -100,21 +153,25 @@ func (db *DB) CompareAndSwap(key []byte, oldVal []byte, newVal []byte) (bool, er
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	if db.closed {
-		return false, ErrClosed
+	if err := f.unavailableLocked(); err != nil {
+		return false, err
 	}
 	if db.opts.ReadOnly {
 		return false, ErrReadOnly
 	}
 
-	entry, ok := db.index.Get(string(key))
+	entry, ok := f.index.Get(string(key))
 	if !ok {
 		return false, nil
 	}
-	if !bytes.Equal(entry.Value, oldVal) {
+	current, err := db.entryValue(string(key), entry)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, oldVal) {
 		return false, nil
 	}
-	if err := db.setWithExpiresAtLocked(key, newVal, entry.ExpiresAt, entry.CreatedAt, true); err != nil {
+	if err := f.setWithExpiresAtLocked(key, newVal, entry.ExpiresAt, entry.CreatedAt, true); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -122,10 +179,34 @@ func (db *DB) CompareAndSwap(key []byte, oldVal []byte, newVal []byte) (bool, er
 
 // GetAndSet atomically sets new value and returns the old value.
 func (db *DB) GetAndSet(key []byte, value []byte) ([]byte, error) {
-	if len(key) > db.opts.MaxKeySize {
+	return db.def.GetAndSet(key, value)
+}
+
+// GetAndSet atomically sets new value in the family and returns the old value.
+// Like Set, it replaces the key's TTL with the family's DefaultTTL.
+func (f *Family) GetAndSet(key []byte, value []byte) ([]byte, error) {
+	return f.getAndSet(key, value, false)
+}
+
+// GetAndSetKeepTTL atomically sets new value and returns the old value,
+// keeping the key's current TTL.
+func (db *DB) GetAndSetKeepTTL(key []byte, value []byte) ([]byte, error) {
+	return db.def.GetAndSetKeepTTL(key, value)
+}
+
+// GetAndSetKeepTTL atomically sets new value in the family and returns the
+// old value. Like SetKeepTTL, it keeps the expiry of the value it replaces;
+// a new key gets the family's DefaultTTL.
+func (f *Family) GetAndSetKeepTTL(key []byte, value []byte) ([]byte, error) {
+	return f.getAndSet(key, value, true)
+}
+
+func (f *Family) getAndSet(key []byte, value []byte, keepTTL bool) ([]byte, error) {
+	db := f.db
+	if len(key) > f.opts.MaxKeySize {
 		return nil, ErrKeyTooLarge
 	}
-	if len(value) > db.opts.MaxValueSize {
+	if len(value) > f.opts.MaxValueSize {
 		return nil, ErrValueTooLarge
 	}
 	if len(key) == 0 {
@@ -134,19 +215,27 @@ func (db *DB) GetAndSet(key []byte, value []byte) ([]byte, error) {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	if db.closed {
-		return nil, ErrClosed
+	if err := f.unavailableLocked(); err != nil {
+		return nil, err
 	}
 	if db.opts.ReadOnly {
 		return nil, ErrReadOnly
 	}
 
-	entry, ok := db.index.Get(string(key))
+	entry, ok := f.index.Get(string(key))
 	var old []byte
+	expiresAt := f.defaultExpiresAt()
 	if ok {
-		old = append([]byte(nil), entry.Value...)
+		value, err := db.entryValue(string(key), entry)
+		if err != nil {
+			return nil, err
+		}
+		old = value
+		if keepTTL {
+			expiresAt = entry.ExpiresAt
+		}
 	}
-	if err := db.setWithExpiresAtLocked(key, value, -1, 0, false); err != nil {
+	if err := f.setWithExpiresAtLocked(key, value, expiresAt, 0, false); err != nil {
 		return nil, err
 	}
 	return old, nil
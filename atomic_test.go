@@ -2,6 +2,7 @@ package minikv
 
 import (
 	"testing"
+	"time"
 )
 
 func TestSetNX(t *testing.T) {
@@ -81,3 +82,33 @@ func TestGetAndSet(t *testing.T) {
 		t.Fatalf("expected v2, got %q", value)
 	}
 }
+
+func TestGetAndSetKeepTTL(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	_ = db.SetWithTTL([]byte("session"), []byte("a"), time.Hour)
+	old, err := db.GetAndSetKeepTTL([]byte("session"), []byte("b"))
+	if err != nil || string(old) != "a" {
+		t.Fatalf("expected old a, got %q %v", old, err)
+	}
+	if value, _ := db.Get([]byte("session")); string(value) != "b" {
+		t.Fatalf("expected the new value, got %q", value)
+	}
+	if remaining, _ := db.TTL([]byte("session")); remaining <= 0 || remaining > time.Hour {
+		t.Fatalf("expected the TTL to be kept, got %v", remaining)
+	}
+
+	if _, err := db.GetAndSet([]byte("session"), []byte("c")); err != nil {
+		t.Fatalf("get and set: %v", err)
+	}
+	if remaining, _ := db.TTL([]byte("session")); remaining != -1 {
+		t.Fatalf("expected GetAndSet to drop the TTL, got %v", remaining)
+	}
+	if old, err := db.GetAndSetKeepTTL([]byte("new"), []byte("v")); err != nil || old != nil {
+		t.Fatalf("expected no old value for a new key, got %q %v", old, err)
+	}
+	if remaining, _ := db.TTL([]byte("new")); remaining != -1 {
+		t.Fatalf("expected a new key to have no TTL, got %v", remaining)
+	}
+}
@@ -1,16 +1,25 @@
 package minikv
 
-import (
-	"time"
-
-	"github.com/bretuobay/mini-kv/internal/wal"
-)
+import "time"
 
 // Batch buffers write operations for atomic commit.
 type Batch interface {
 	Set(key, value []byte)
 	SetWithTTL(key, value []byte, ttl time.Duration)
 	Delete(key []byte)
+	// HSet and HDel update a single hash field; see DB.HSet and DB.HDel.
+	HSet(key, field, value []byte)
+	HDel(key, field []byte)
+	// ZAdd and ZRem update a single sorted set member; see DB.ZAdd and DB.ZRem.
+	ZAdd(key []byte, score float64, member []byte)
+	ZRem(key, member []byte)
+	// SAdd and SRem add or remove a single set member; see DB.SAdd and DB.SRem.
+	SAdd(key, member []byte)
+	SRem(key, member []byte)
+	// Family returns a view of the batch whose writes go to f. Writing or
+	// discarding the view writes or discards the whole batch, so one batch
+	// can update several families atomically.
+	Family(f *Family) Batch
 	Write() error
 	Discard()
 }
@@ -20,13 +29,24 @@ type batchOpType uint8
 const (
 	batchSet batchOpType = iota + 1
 	batchDelete
+	batchHSet
+	batchHDel
+	batchZAdd
+	batchZRem
+	batchSAdd
+	batchSRem
 )
 
 type batchOp struct {
+	family    *Family
 	opType    batchOpType
 	key       []byte
+	field     []byte
 	value     []byte
+	score     float64
 	expiresAt int64
+	// createdAt is the CreatedAt of a set imported with Import; 0 means now.
+	createdAt int64
 }
 
 type batchImpl struct {
@@ -37,29 +57,126 @@ type batchImpl struct {
 	err    error
 }
 
+// familyBatch routes writes to a family while sharing the operations of
+// the batch it wraps.
+type familyBatch struct {
+	*batchImpl
+	family *Family
+}
+
 // NewBatch creates a new batch bound to the DB.
 func (db *DB) NewBatch() Batch {
 	return &batchImpl{db: db}
 }
 
+// NewBatch creates a new batch whose writes go to the family.
+func (f *Family) NewBatch() Batch {
+	return familyBatch{batchImpl: &batchImpl{db: f.db}, family: f}
+}
+
 // Set buffers a Set operation.
 func (b *batchImpl) Set(key, value []byte) {
-	b.addOp(batchSet, key, value, -1)
+	b.set(b.db.def, key, value, 0)
 }
 
 // SetWithTTL buffers a Set operation with TTL.
 func (b *batchImpl) SetWithTTL(key, value []byte, ttl time.Duration) {
-	if ttl <= 0 {
-		b.addOp(batchSet, key, value, -1)
-		return
-	}
-	expiresAt := time.Now().Add(ttl).UnixNano()
-	b.addOp(batchSet, key, value, expiresAt)
+	b.set(b.db.def, key, value, ttl)
 }
 
 // Delete buffers a Delete operation.
 func (b *batchImpl) Delete(key []byte) {
-	b.addOp(batchDelete, key, nil, -1)
+	b.addOp(b.db.def, batchDelete, key, nil, -1)
+}
+
+// HSet buffers a hash field update.
+func (b *batchImpl) HSet(key, field, value []byte) {
+	b.addMemberOp(b.db.def, batchHSet, key, field, value)
+}
+
+// HDel buffers a hash field removal.
+func (b *batchImpl) HDel(key, field []byte) {
+	b.addMemberOp(b.db.def, batchHDel, key, field, nil)
+}
+
+// ZAdd buffers a sorted set member update.
+func (b *batchImpl) ZAdd(key []byte, score float64, member []byte) {
+	b.addZSetOp(b.db.def, batchZAdd, key, member, score)
+}
+
+// ZRem buffers a sorted set member removal.
+func (b *batchImpl) ZRem(key, member []byte) {
+	b.addZSetOp(b.db.def, batchZRem, key, member, 0)
+}
+
+// SAdd buffers a set member addition.
+func (b *batchImpl) SAdd(key, member []byte) {
+	b.addMemberOp(b.db.def, batchSAdd, key, member, nil)
+}
+
+// SRem buffers a set member removal.
+func (b *batchImpl) SRem(key, member []byte) {
+	b.addMemberOp(b.db.def, batchSRem, key, member, nil)
+}
+
+// Family returns a view of the batch whose writes go to f.
+func (b *batchImpl) Family(f *Family) Batch {
+	return familyBatch{batchImpl: b, family: f}
+}
+
+// Set buffers a Set operation in the family.
+func (b familyBatch) Set(key, value []byte) {
+	b.set(b.family, key, value, 0)
+}
+
+// SetWithTTL buffers a Set operation with TTL in the family.
+func (b familyBatch) SetWithTTL(key, value []byte, ttl time.Duration) {
+	b.set(b.family, key, value, ttl)
+}
+
+// Delete buffers a Delete operation in the family.
+func (b familyBatch) Delete(key []byte) {
+	b.addOp(b.family, batchDelete, key, nil, -1)
+}
+
+// HSet buffers a hash field update in the family.
+func (b familyBatch) HSet(key, field, value []byte) {
+	b.addMemberOp(b.family, batchHSet, key, field, value)
+}
+
+// HDel buffers a hash field removal in the family.
+func (b familyBatch) HDel(key, field []byte) {
+	b.addMemberOp(b.family, batchHDel, key, field, nil)
+}
+
+// ZAdd buffers a sorted set member update in the family.
+func (b familyBatch) ZAdd(key []byte, score float64, member []byte) {
+	b.addZSetOp(b.family, batchZAdd, key, member, score)
+}
+
+// ZRem buffers a sorted set member removal in the family.
+func (b familyBatch) ZRem(key, member []byte) {
+	b.addZSetOp(b.family, batchZRem, key, member, 0)
+}
+
+// SAdd buffers a set member addition in the family.
+func (b familyBatch) SAdd(key, member []byte) {
+	b.addMemberOp(b.family, batchSAdd, key, member, nil)
+}
+
+// SRem buffers a set member removal in the family.
+func (b familyBatch) SRem(key, member []byte) {
+	b.addMemberOp(b.family, batchSRem, key, member, nil)
+}
+
+// set buffers a Set with ttl, or with the family's DefaultTTL when ttl is not positive.
+func (b *batchImpl) set(f *Family, key, value []byte, ttl time.Duration) {
+	if ttl <= 0 {
+		b.addOp(f, batchSet, key, value, f.defaultExpiresAt())
+		return
+	}
+	expiresAt := f.db.now() + int64(ttl)
+	b.addOp(f, batchSet, key, value, expiresAt)
 }
 
 // Write applies all operations atomically.
@@ -84,61 +201,60 @@ func (b *batchImpl) Write() error {
 	if db.opts.ReadOnly {
 		return ErrReadOnly
 	}
+	for _, op := range b.opList {
+		if err := op.family.unavailableLocked(); err != nil {
+			return err
+		}
+	}
 	if b.size > int64(db.opts.MaxBatchSize) {
 		return ErrBatchTooBig
 	}
 
 	stats := db.statsOrInit()
 	start := time.Now()
-	now := time.Now().UnixNano()
-	encoded := make([][]byte, 0, len(b.opList))
-	for _, op := range b.opList {
-		record := wal.WALRecord{
-			Timestamp: now,
-			Key:       append([]byte(nil), op.key...),
-			Value:     append([]byte(nil), op.value...),
-			ExpiresAt: op.expiresAt,
-		}
-		switch op.opType {
-		case batchSet:
-			record.Type = wal.RecordSet
-		case batchDelete:
-			record.Type = wal.RecordDelete
-			record.Value = nil
-			record.ExpiresAt = -1
-		}
-		encoded = append(encoded, wal.EncodeWALRecord(record))
-	}
-
-	for _, payload := range encoded {
-		if _, err := db.wal.AppendRaw(payload); err != nil {
-			stats.writes.Add(uint64(countOps(b.opList, batchSet)))
-			stats.deletes.Add(uint64(countOps(b.opList, batchDelete)))
-			stats.writeLatency.add(time.Since(start))
-			return err
-		}
+	t := db.newTxnLocked()
+	if err := b.stageLocked(t); err != nil {
+		stats.writeLatency.add(time.Since(start))
+		return err
 	}
-	if db.opts.SyncMode == SyncAlways {
-		if err := db.wal.Sync(); err != nil {
-			stats.writes.Add(uint64(countOps(b.opList, batchSet)))
-			stats.deletes.Add(uint64(countOps(b.opList, batchDelete)))
-			stats.writeLatency.add(time.Since(start))
-			return err
-		}
+	err := t.commit()
+	stats.writes.Add(uint64(countOps(b.opList, batchSet, batchHSet, batchZAdd, batchSAdd)))
+	stats.deletes.Add(uint64(countOps(b.opList, batchDelete, batchHDel, batchZRem, batchSRem)))
+	stats.writeLatency.add(time.Since(start))
+	if err != nil {
+		return err
 	}
+	b.closed = true
+	return nil
+}
 
+// stageLocked stages every operation in t, in order, so collection operations see
+// the writes before them.
+func (b *batchImpl) stageLocked(t *txn) error {
 	for _, op := range b.opList {
+		var err error
 		switch op.opType {
 		case batchSet:
-			db.index.SetEntry(string(op.key), op.value, op.expiresAt, now)
+			err = t.setCreated(op.family, op.key, op.value, op.expiresAt, op.createdAt)
 		case batchDelete:
-			db.index.Delete(string(op.key))
+			t.delete(op.family, op.key)
+		case batchHSet:
+			_, err = t.hset(op.family, op.key, op.field, op.value)
+		case batchHDel:
+			_, err = t.hdel(op.family, op.key, op.field)
+		case batchZAdd:
+			_, err = t.zadd(op.family, op.key, op.field, op.score)
+		case batchZRem:
+			_, err = t.zrem(op.family, op.key, op.field)
+		case batchSAdd:
+			_, err = t.sadd(op.family, op.key, op.field)
+		case batchSRem:
+			_, err = t.srem(op.family, op.key, op.field)
+		}
+		if err != nil {
+			return err
 		}
 	}
-	stats.writes.Add(uint64(countOps(b.opList, batchSet)))
-	stats.deletes.Add(uint64(countOps(b.opList, batchDelete)))
-	stats.writeLatency.add(time.Since(start))
-	b.closed = true
 	return nil
 }
 
@@ -149,24 +265,29 @@ func (b *batchImpl) Discard() {
 	b.size = 0
 }
 
-func (b *batchImpl) addOp(opType batchOpType, key []byte, value []byte, expiresAt int64) {
+func (b *batchImpl) addOp(f *Family, opType batchOpType, key []byte, value []byte, expiresAt int64) {
 	if b.closed {
 		return
 	}
 	if len(key) == 0 {
 		return
 	}
-	if len(key) > b.db.opts.MaxKeySize {
+	if f.db != b.db {
+		b.err = ErrInvalidFamily
+		return
+	}
+	if len(key) > f.opts.MaxKeySize {
 		b.err = ErrKeyTooLarge
 		return
 	}
-	if len(value) > b.db.opts.MaxValueSize {
+	if len(value) > f.opts.MaxValueSize {
 		b.err = ErrValueTooLarge
 		return
 	}
 	keyCopy := append([]byte(nil), key...)
 	valueCopy := append([]byte(nil), value...)
 	b.opList = append(b.opList, batchOp{
+		family:    f,
 		opType:    opType,
 		key:       keyCopy,
 		value:     valueCopy,
@@ -175,11 +296,40 @@ func (b *batchImpl) addOp(opType batchOpType, key []byte, value []byte, expiresA
 	b.size += int64(len(keyCopy) + len(valueCopy))
 }
 
-func countOps(ops []batchOp, opType batchOpType) int {
+// addMemberOp buffers an operation on one member of a collection, such as a
+// hash field.
+func (b *batchImpl) addMemberOp(f *Family, opType batchOpType, key, field, value []byte) {
+	if b.closed || b.err != nil {
+		return
+	}
+	if len(field) > f.opts.MaxKeySize {
+		b.err = ErrKeyTooLarge
+		return
+	}
+	before := len(b.opList)
+	b.addOp(f, opType, key, value, -1)
+	if len(b.opList) > before {
+		b.opList[before].field = append([]byte(nil), field...)
+		b.size += int64(len(field))
+	}
+}
+
+// addZSetOp buffers an operation on one member of a sorted set.
+func (b *batchImpl) addZSetOp(f *Family, opType batchOpType, key, member []byte, score float64) {
+	before := len(b.opList)
+	b.addMemberOp(f, opType, key, member, nil)
+	if len(b.opList) > before {
+		b.opList[before].score = score
+	}
+}
+
+func countOps(ops []batchOp, opTypes ...batchOpType) int {
 	count := 0
 	for _, op := range ops {
-		if op.opType == opType {
-			count++
+		for _, opType := range opTypes {
+			if op.opType == opType {
+				count++
+			}
 		}
 	}
 	return count
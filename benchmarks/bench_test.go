@@ -1,7 +1,12 @@
 package benchmarks
 
 import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/bretuobay/mini-kv"
 )
@@ -119,6 +124,135 @@ func BenchmarkStartup(b *testing.B) {
 	}
 }
 
+// BenchmarkCompactLargeValues compares compaction cost for 256 KB values
+// stored inline versus in the value log. snapshot-B/op reports the size of
+// the snapshot each compaction rewrites.
+func BenchmarkCompactLargeValues(b *testing.B) {
+	cases := []struct {
+		name      string
+		threshold int
+	}{
+		{name: "inline", threshold: 0},
+		{name: "valuelog", threshold: 4096},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			dir := b.TempDir()
+			opts := minikv.DefaultOptions(dir)
+			opts.SyncMode = minikv.SyncManual
+			opts.ValueLogThreshold = tc.threshold
+			db, err := minikv.Open(opts)
+			if err != nil {
+				b.Fatalf("open: %v", err)
+			}
+			defer db.Close()
+
+			value := make([]byte, 256*1024)
+			for i := 0; i < 64; i++ {
+				_ = db.Set([]byte("k"+intToString(i)), value)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = db.Set([]byte("k0"), value)
+				if err := db.Compact(); err != nil {
+					b.Fatalf("compact: %v", err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(latestFileSize(filepath.Join(dir, "snapshots"))), "snapshot-B/op")
+		})
+	}
+}
+
+// BenchmarkGetDuringTTLSweep measures read latency on a database holding
+// many keys with a TTL while the expiry sweep runs every 10ms and a share of
+// the keys expire. p99-ns reports the 99th percentile Get latency.
+func BenchmarkGetDuringTTLSweep(b *testing.B) {
+	const keys = 200000
+	dir := b.TempDir()
+	opts := minikv.DefaultOptions(dir)
+	opts.SyncMode = minikv.SyncManual
+	opts.TTLSweepInterval = 10 * time.Millisecond
+	db, err := minikv.Open(opts)
+	if err != nil {
+		b.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	for start := 0; start < keys; start += 1000 {
+		batch := db.NewBatch()
+		for i := start; i < start+1000; i++ {
+			ttl := time.Hour
+			if i%10 == 0 {
+				ttl = time.Duration(i%5000) * time.Millisecond
+			}
+			batch.SetWithTTL([]byte("k"+intToString(i)), []byte("v"), ttl)
+		}
+		if err := batch.Write(); err != nil {
+			b.Fatalf("write: %v", err)
+		}
+	}
+
+	latencies := make([]time.Duration, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := []byte("k" + intToString(i%keys))
+		start := time.Now()
+		_, _ = db.Get(key)
+		latencies[i] = time.Since(start)
+	}
+	b.StopTimer()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100]), "p99-ns")
+}
+
+// BenchmarkExport measures a full export at growing key counts. ns/key
+// should stay roughly flat as the database grows.
+func BenchmarkExport(b *testing.B) {
+	for _, keys := range []int{25000, 50000, 100000} {
+		b.Run(intToString(keys), func(b *testing.B) {
+			opts := minikv.DefaultOptions(b.TempDir())
+			opts.SyncMode = minikv.SyncManual
+			db, err := minikv.Open(opts)
+			if err != nil {
+				b.Fatalf("open: %v", err)
+			}
+			defer db.Close()
+			for start := 0; start < keys; start += 1000 {
+				batch := db.NewBatch()
+				for i := start; i < start+1000; i++ {
+					batch.Set([]byte("k"+intToString(i)), []byte("v"))
+				}
+				if err := batch.Write(); err != nil {
+					b.Fatalf("write: %v", err)
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Export(io.Discard, minikv.ExportJSONLines); err != nil {
+					b.Fatalf("export: %v", err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*keys), "ns/key")
+		})
+	}
+}
+
+func latestFileSize(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return 0
+	}
+	info, err := entries[len(entries)-1].Info()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
 func intToString(v int) string {
 	if v == 0 {
 		return "0"
@@ -0,0 +1,490 @@
+package minikv
+
+import (
+	"bytes"
+	"container/heap"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/manifest"
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+)
+
+// bulkRunBytes is how many key and value bytes an unsorted BulkLoader
+// buffers before sorting them and spilling them to a run file.
+const bulkRunBytes = 64 << 20
+
+// BulkLoader builds a snapshot file from key/value pairs and ingests it into
+// a family in one step. The pairs never go through the WAL, so loading
+// millions of keys costs one sequential file write instead of a WAL record
+// per key and the compactions they trigger. Pairs are streamed to the file
+// as they are added, so the loader holds only the last key in memory.
+//
+// An unsorted loader instead buffers pairs and sorts them itself: every
+// bulkRunBytes of pairs are spilled to a sorted run file, and Commit merges
+// the runs into the snapshot file.
+type BulkLoader struct {
+	f       *Family
+	stage   *snapshot.Stager
+	lastKey []byte
+	closed  bool
+
+	unsorted bool
+	pending  []snapshot.Entry
+	size     int
+	runBytes int
+	runs     []bulkRun
+	added    int
+}
+
+// bulkRun is a sorted run file spilled by an unsorted BulkLoader.
+type bulkRun struct {
+	path  string
+	count uint64
+}
+
+// NewBulkLoader creates a bulk loader for the default family.
+func (db *DB) NewBulkLoader() *BulkLoader {
+	return db.def.NewBulkLoader()
+}
+
+// NewBulkLoader creates a bulk loader whose pairs go to the family.
+func (f *Family) NewBulkLoader() *BulkLoader {
+	return &BulkLoader{f: f}
+}
+
+// NewUnsortedBulkLoader creates a bulk loader for the default family that
+// accepts keys in any order.
+func (db *DB) NewUnsortedBulkLoader() *BulkLoader {
+	return db.def.NewUnsortedBulkLoader()
+}
+
+// NewUnsortedBulkLoader creates a bulk loader whose pairs go to the family
+// and may be added in any order. A key added more than once keeps the pair
+// added last. Sorting costs up to bulkRunBytes of memory and a second write
+// of the pairs, so input that is already sorted is better loaded with
+// NewBulkLoader.
+func (f *Family) NewUnsortedBulkLoader() *BulkLoader {
+	return &BulkLoader{f: f, unsorted: true, runBytes: bulkRunBytes}
+}
+
+// Add writes a pair, with the family's DefaultTTL if it has one. Unless the
+// loader is unsorted, keys must be added in strictly ascending order; a key
+// not greater than the one before fails with ErrKeyOrder and leaves the
+// loader usable.
+func (l *BulkLoader) Add(key, value []byte) error {
+	return l.add(key, value, l.f.defaultExpiresAt())
+}
+
+// AddWithTTL writes a pair that expires ttl from now. A ttl that is not
+// positive behaves like Add.
+func (l *BulkLoader) AddWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return l.Add(key, value)
+	}
+	return l.add(key, value, l.f.db.now()+int64(ttl))
+}
+
+func (l *BulkLoader) add(key, value []byte, expiresAt int64) error {
+	if l.closed {
+		return ErrClosed
+	}
+	if l.f.db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(key) > l.f.opts.MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	if len(value) > l.f.opts.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	if len(key) == 0 {
+		return ErrNotFound
+	}
+	if l.unsorted {
+		return l.buffer(key, value, expiresAt)
+	}
+	if l.lastKey != nil && bytes.Compare(key, l.lastKey) <= 0 {
+		return ErrKeyOrder
+	}
+	if l.stage == nil {
+		stage, err := l.f.db.snap.Stage(snapshot.Version, l.f.db.now())
+		if err != nil {
+			return err
+		}
+		l.stage = stage
+	}
+	err := l.stage.Append(snapshot.Entry{
+		Key:       key,
+		Value:     value,
+		ExpiresAt: expiresAt,
+		CreatedAt: l.f.db.now(),
+		Family:    l.f.id,
+	})
+	if err != nil {
+		l.Discard()
+		return err
+	}
+	l.lastKey = append(l.lastKey[:0], key...)
+	return nil
+}
+
+// buffer adds a pair to an unsorted loader, spilling the buffered pairs to
+// a run once they reach runBytes.
+func (l *BulkLoader) buffer(key, value []byte, expiresAt int64) error {
+	l.pending = append(l.pending, snapshot.Entry{
+		Key:       append([]byte(nil), key...),
+		Value:     append([]byte(nil), value...),
+		ExpiresAt: expiresAt,
+		CreatedAt: l.f.db.now(),
+		Family:    l.f.id,
+	})
+	l.size += len(key) + len(value)
+	l.added++
+	if l.size < l.runBytes {
+		return nil
+	}
+	if err := l.spill(); err != nil {
+		l.Discard()
+		return err
+	}
+	return nil
+}
+
+// spill sorts the buffered pairs and writes them to a run file, keeping the
+// pair added last for a repeated key.
+func (l *BulkLoader) spill() error {
+	pending := l.pending
+	l.pending = nil
+	l.size = 0
+	if len(pending) == 0 {
+		return nil
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return bytes.Compare(pending[i].Key, pending[j].Key) < 0
+	})
+	stage, err := l.f.db.snap.Stage(snapshot.Version, l.f.db.now())
+	if err != nil {
+		return err
+	}
+	for i, entry := range pending {
+		if i+1 < len(pending) && bytes.Equal(entry.Key, pending[i+1].Key) {
+			continue
+		}
+		if err := stage.Append(entry); err != nil {
+			stage.Abort()
+			return err
+		}
+	}
+	count := stage.Count()
+	path, err := stage.Finish()
+	if err != nil {
+		return err
+	}
+	l.runs = append(l.runs, bulkRun{path: path, count: count})
+	return nil
+}
+
+// mergeRuns merges the sorted runs into one staged file and removes them.
+// Where runs share a key, the later run wins, as its pair was added last.
+func (l *BulkLoader) mergeRuns() (string, uint64, error) {
+	runs := l.runs
+	l.runs = nil
+	defer func() {
+		for _, run := range runs {
+			_ = os.Remove(run.path)
+		}
+	}()
+	stage, err := l.f.db.snap.Stage(snapshot.Version, l.f.db.now())
+	if err != nil {
+		return "", 0, err
+	}
+	fail := func(err error) (string, uint64, error) {
+		stage.Abort()
+		return "", 0, err
+	}
+
+	heads := make(runHeap, 0, len(runs))
+	defer func() {
+		for _, head := range heads {
+			_ = head.reader.Close()
+		}
+	}()
+	for i, run := range runs {
+		reader, err := snapshot.OpenReader(run.path)
+		if err != nil {
+			return fail(err)
+		}
+		head := &runHead{reader: reader, run: i}
+		if err := head.advance(); err == io.EOF {
+			_ = reader.Close()
+			continue
+		} else if err != nil {
+			_ = reader.Close()
+			return fail(err)
+		}
+		heads = append(heads, head)
+	}
+	heap.Init(&heads)
+
+	var last []byte
+	for len(heads) > 0 {
+		head := heads[0]
+		if last == nil || !bytes.Equal(head.entry.Key, last) {
+			if err := stage.Append(head.entry); err != nil {
+				return fail(err)
+			}
+			last = head.entry.Key
+		}
+		if err := head.advance(); err == io.EOF {
+			_ = head.reader.Close()
+			heap.Pop(&heads)
+		} else if err != nil {
+			return fail(err)
+		} else {
+			heap.Fix(&heads, 0)
+		}
+	}
+	count := stage.Count()
+	path, err := stage.Finish()
+	return path, count, err
+}
+
+// runHead is the next entry of a run being merged.
+type runHead struct {
+	reader *snapshot.Reader
+	entry  snapshot.Entry
+	run    int
+}
+
+func (h *runHead) advance() error {
+	entry, err := h.reader.Next()
+	if err != nil {
+		return err
+	}
+	h.entry = entry
+	return nil
+}
+
+// runHeap orders run heads by key, and a key shared by several runs by the
+// latest run first.
+type runHeap []*runHead
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].entry.Key, h[j].entry.Key); c != 0 {
+		return c < 0
+	}
+	return h[i].run > h[j].run
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runHead)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// Len returns the number of pairs added so far.
+func (l *BulkLoader) Len() int {
+	if l.unsorted {
+		return l.added
+	}
+	if l.stage == nil {
+		return 0
+	}
+	return int(l.stage.Count())
+}
+
+// Commit finishes the snapshot file and ingests it: the WAL is sealed, the
+// pairs are applied to the index, and the file is recorded in the MANIFEST
+// as the newest delta of the snapshot chain, after a snapshot of any writes
+// the chain did not cover yet. Readers see either all of the pairs or none,
+// and writes after Commit overwrite them. The pairs are durable once Commit
+// returns; values are stored inline, never in the value log. Commit waits
+// for a running Compact to finish. The loader is closed afterwards, whether
+// or not Commit succeeded.
+func (l *BulkLoader) Commit() error {
+	if l.closed {
+		return ErrClosed
+	}
+	f := l.f
+	db := f.db
+	staged, count, err := l.finish()
+	l.closed = true
+	if err != nil || staged == "" {
+		return err
+	}
+	stats := db.statsOrInit()
+	start := time.Now()
+	err = f.ingest(staged)
+	stats.writes.Add(count)
+	stats.writeLatency.add(time.Since(start))
+	return err
+}
+
+// finish completes the staged file and returns its path and entry count, or
+// an empty path if no pairs were added. An unsorted loader spills what it
+// still buffers and merges its runs; a single run is already the file.
+func (l *BulkLoader) finish() (string, uint64, error) {
+	if !l.unsorted {
+		stage := l.stage
+		l.stage = nil
+		l.lastKey = nil
+		if stage == nil {
+			return "", 0, nil
+		}
+		count := stage.Count()
+		staged, err := stage.Finish()
+		return staged, count, err
+	}
+	if err := l.spill(); err != nil {
+		l.Discard()
+		return "", 0, err
+	}
+	switch len(l.runs) {
+	case 0:
+		return "", 0, nil
+	case 1:
+		run := l.runs[0]
+		l.runs = nil
+		return run.path, run.count, nil
+	}
+	return l.mergeRuns()
+}
+
+// Discard abandons the pairs added so far and removes the file.
+func (l *BulkLoader) Discard() {
+	if l.stage != nil {
+		l.stage.Abort()
+		l.stage = nil
+	}
+	for _, run := range l.runs {
+		_ = os.Remove(run.path)
+	}
+	l.runs = nil
+	l.pending = nil
+	l.closed = true
+	l.lastKey = nil
+}
+
+// ingest publishes a staged snapshot file as a delta. The file is checked
+// before db.mu is taken, so writers only wait for the WAL to be sealed and
+// the pairs read back into the index. Two segments are sealed: the first
+// holds writes not yet in the chain, snapshotted as usual, and the second is
+// empty and gives the ingested file a sequence of its own, so recovery
+// applies it after those writes and replays newer WAL segments on top.
+func (f *Family) ingest(staged string) error {
+	db := f.db
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	fail := func(err error) error {
+		_ = os.Remove(staged)
+		return err
+	}
+	// Verifying the checksum first means reading the file back into the
+	// index below can only fail on an I/O error.
+	if _, err := snapshot.ScanSnapshot(staged, func(snapshot.Entry) error { return nil }); err != nil {
+		return fail(err)
+	}
+
+	db.mu.Lock()
+	if err := f.unavailableLocked(); err != nil {
+		db.mu.Unlock()
+		return fail(err)
+	}
+	if err := db.checkMemoryLocked(); err != nil {
+		db.mu.Unlock()
+		return fail(err)
+	}
+	db.collectOrphansLocked()
+	full := db.needsFullSnapshotLocked()
+	pending := full || db.dirtyCountLocked() > 0
+	var chainEntries []snapshot.Entry
+	var seq uint64
+	var err error
+	if pending {
+		if chainEntries, err = db.chainEntriesLocked(full); err == nil {
+			seq, err = db.sealWALLocked()
+		}
+		if err != nil {
+			db.mu.Unlock()
+			return fail(err)
+		}
+	}
+	ingestSeq, err := db.sealWALLocked()
+	if err != nil {
+		db.mu.Unlock()
+		return fail(err)
+	}
+	dirty := db.resetDirtyLocked()
+	_, err = snapshot.ScanSnapshot(staged, func(entry snapshot.Entry) error {
+		f.index.SetEntry(string(entry.Key), entry.Value, entry.ExpiresAt, entry.CreatedAt)
+		return nil
+	})
+	db.lastWrite.Store(db.now())
+	db.evictLocked()
+	snapMgr := db.snap
+	db.mu.Unlock()
+
+	// Until the MANIFEST records the file, the pairs live only in memory.
+	// Rather than tracking every loaded key, the next Compact is made a full
+	// snapshot, which persists them along with everything else.
+	restore := func(err error) error {
+		db.restoreDirty(dirty)
+		db.mu.Lock()
+		db.hasBase = false
+		db.mu.Unlock()
+		return err
+	}
+	if err != nil {
+		return restore(fail(err))
+	}
+
+	commit := manifest.VersionEdit{HasLastSnapshotSeq: true, LastSnapshotSeq: ingestSeq}
+	if pending {
+		now := db.now()
+		var path string
+		if full {
+			path, err = snapMgr.CreateSnapshot(chainEntries, snapshot.Version, now, seq)
+		} else {
+			path, err = snapMgr.CreateDelta(chainEntries, snapshot.Version, now, seq)
+		}
+		if err != nil {
+			return restore(fail(err))
+		}
+		info := manifest.SnapshotInfo{Seq: seq, Path: path}
+		if full {
+			commit.AddSnapshots = []manifest.SnapshotInfo{info}
+		} else {
+			commit.AddDeltas = []manifest.SnapshotInfo{info}
+		}
+	}
+	path, err := snapMgr.Ingest(staged, ingestSeq)
+	if err != nil {
+		return restore(err)
+	}
+	commit.AddDeltas = append(commit.AddDeltas, manifest.SnapshotInfo{Seq: ingestSeq, Path: path})
+	if err := db.manifest.Apply(commit); err != nil {
+		return restore(err)
+	}
+
+	db.mu.Lock()
+	if full {
+		db.hasBase = true
+		db.deltas = 0
+	} else if pending {
+		db.deltas++
+	}
+	db.deltas++
+	db.mu.Unlock()
+
+	if err := db.deleteOldWALSegments(ingestSeq + 1); err != nil {
+		return err
+	}
+	return db.pruneSnapshots()
+}
@@ -0,0 +1,228 @@
+package minikv
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+func TestBulkLoadIngestsWithoutWAL(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_ = db.Set([]byte("before"), []byte("wal"))
+	_ = db.Set([]byte("key:0001"), []byte("old"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Set([]byte("dirty"), []byte("wal"))
+
+	loader := db.NewBulkLoader()
+	const n = 2000
+	for i := 0; i < n; i++ {
+		key := []byte("key:" + padInt(i))
+		value := []byte("v" + intToString(i))
+		if i == 2 {
+			value = []byte("last")
+		}
+		if err := loader.Add(key, value); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	if err := loader.Add([]byte("key:0002"), []byte("v")); err != ErrKeyOrder {
+		t.Fatalf("expected ErrKeyOrder for an earlier key, got %v", err)
+	}
+	if err := loader.Add([]byte("key:1999"), []byte("v")); err != ErrKeyOrder {
+		t.Fatalf("expected ErrKeyOrder for a repeated key, got %v", err)
+	}
+	if err := loader.Add(nil, []byte("v")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for an empty key, got %v", err)
+	}
+	if loader.Len() != n {
+		t.Fatalf("expected %d written pairs, got %d", n, loader.Len())
+	}
+	if err := loader.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if err := loader.Commit(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed on a second commit, got %v", err)
+	}
+	if records := walRecordsOfType(t, dir, wal.RecordSet); len(records) != 0 {
+		t.Fatalf("expected no loaded pair in the WAL, got %d records", len(records))
+	}
+	_ = db.Set([]byte("key:0003"), []byte("after"))
+
+	check := func() {
+		t.Helper()
+		want := map[string]string{
+			"before":   "wal",
+			"dirty":    "wal",
+			"key:0001": "v1",
+			"key:0002": "last",
+			"key:0003": "after",
+			"key:1999": "v1999",
+		}
+		for key, value := range want {
+			if got, err := db.Get([]byte(key)); err != nil || string(got) != value {
+				t.Fatalf("get %s: got %q, %v, want %q", key, got, err, value)
+			}
+		}
+		if count, _ := db.Count(); count != n+2 {
+			t.Fatalf("expected %d keys, got %d", n+2, count)
+		}
+	}
+	check()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	check()
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	db = openTestDB(t, dir)
+	defer db.Close()
+	check()
+}
+
+func TestBulkLoadIntoEmptyFamily(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewFakeClock(fakeEpoch)
+	db := openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+	users, err := db.CreateFamily("users", FamilyOptions{})
+	if err != nil {
+		t.Fatalf("create family: %v", err)
+	}
+	loader := users.NewBulkLoader()
+	_ = loader.Add([]byte("alice"), []byte("1"))
+	_ = loader.AddWithTTL([]byte("session"), []byte("s"), time.Minute)
+	if err := loader.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if _, err := db.Get([]byte("alice")); err != ErrNotFound {
+		t.Fatalf("expected the default family untouched, got %v", err)
+	}
+	if stats, _ := db.Stats(); stats.Writes != 2 {
+		t.Fatalf("expected the loaded pairs counted as writes, got %d", stats.Writes)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+	defer db.Close()
+	users, _ = db.Family("users")
+	if value, err := users.Get([]byte("alice")); err != nil || string(value) != "1" {
+		t.Fatalf("expected alice after reopen, got %q, %v", value, err)
+	}
+	if remaining, _ := users.TTL([]byte("session")); remaining != time.Minute {
+		t.Fatalf("expected the loaded TTL kept, got %v", remaining)
+	}
+	clock.Advance(time.Minute)
+	if _, err := users.Get([]byte("session")); err != ErrNotFound {
+		t.Fatalf("expected session to expire, got %v", err)
+	}
+}
+
+func padInt(v int) string {
+	s := intToString(v)
+	for len(s) < 4 {
+		s = "0" + s
+	}
+	return s
+}
+
+func TestBulkLoadDiscardRemovesStagedFile(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	defer db.Close()
+	loader := db.NewBulkLoader()
+	_ = loader.Add([]byte("a"), []byte("1"))
+	staged, _ := filepath.Glob(filepath.Join(dir, "snapshots", "ingest_*.tmp"))
+	if len(staged) != 1 {
+		t.Fatalf("expected the pair streamed to a staged file, got %v", staged)
+	}
+	loader.Discard()
+	if staged, _ = filepath.Glob(filepath.Join(dir, "snapshots", "ingest_*.tmp")); len(staged) != 0 {
+		t.Fatalf("expected Discard to remove the staged file, got %v", staged)
+	}
+	if err := loader.Add([]byte("b"), []byte("2")); err != ErrClosed {
+		t.Fatalf("expected ErrClosed after Discard, got %v", err)
+	}
+	if _, err := db.Get([]byte("a")); err != ErrNotFound {
+		t.Fatalf("expected discarded pairs not loaded, got %v", err)
+	}
+}
+
+func TestUnsortedBulkLoadMergesRuns(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	loader := db.NewUnsortedBulkLoader()
+	loader.runBytes = 256
+
+	const n = 500
+	for i := 0; i < n; i++ {
+		j := i * 7919 % n
+		if err := loader.Add([]byte("key:"+padInt(j)), []byte("v"+intToString(j))); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	// A repeated key keeps the pair added last, even across runs.
+	if err := loader.Add([]byte("key:0002"), []byte("last")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if loader.Len() != n+1 {
+		t.Fatalf("expected %d added pairs, got %d", n+1, loader.Len())
+	}
+	if runs, _ := filepath.Glob(filepath.Join(dir, "snapshots", "ingest_*.tmp")); len(runs) < 2 {
+		t.Fatalf("expected the pairs spilled to several runs, got %v", runs)
+	}
+	if err := loader.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "snapshots", "ingest_*.tmp")); len(left) != 0 {
+		t.Fatalf("expected the runs removed after Commit, got %v", left)
+	}
+
+	deltas, err := db.snap.ListDeltas()
+	if err != nil || len(deltas) == 0 {
+		t.Fatalf("list deltas: %v %v", deltas, err)
+	}
+	var keys []string
+	if _, err := snapshot.ScanSnapshot(deltas[len(deltas)-1], func(entry snapshot.Entry) error {
+		keys = append(keys, string(entry.Key))
+		return nil
+	}); err != nil {
+		t.Fatalf("scan ingested file: %v", err)
+	}
+	if len(keys) != n || !sort.StringsAreSorted(keys) {
+		t.Fatalf("expected %d sorted keys in the ingested file, got %d", n, len(keys))
+	}
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			want := "v" + intToString(i)
+			if i == 2 {
+				want = "last"
+			}
+			if value, err := db.Get([]byte("key:" + padInt(i))); err != nil || string(value) != want {
+				t.Fatalf("get key:%s: got %q %v, want %q", padInt(i), value, err, want)
+			}
+		}
+	}
+	check()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	db = openTestDB(t, dir)
+	defer db.Close()
+	check()
+}
@@ -0,0 +1,148 @@
+package minikv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+var fakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeClockTickers(t *testing.T) {
+	clock := NewFakeClock(fakeEpoch)
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatalf("expected no tick before the period")
+	default:
+	}
+	// Ticks a receiver missed are dropped.
+	clock.Advance(3 * time.Second)
+	if tick := <-ticker.C(); !tick.Equal(fakeEpoch.Add(3999 * time.Millisecond)) {
+		t.Fatalf("unexpected tick time %v", tick)
+	}
+	select {
+	case <-ticker.C():
+		t.Fatalf("expected missed ticks to be dropped")
+	default:
+	}
+
+	ticker.Reset(time.Minute)
+	clock.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatalf("expected Reset to restart the period")
+	default:
+	}
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatalf("expected a stopped ticker not to fire")
+	default:
+	}
+}
+
+func TestFakeClockDrivesExpiry(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewFakeClock(fakeEpoch)
+	db := openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+
+	_ = db.SetWithTTL([]byte("short"), []byte("v"), time.Minute)
+	_ = db.SetWithTTL([]byte("long"), []byte("v"), time.Hour)
+	if remaining, _ := db.TTL([]byte("short")); remaining != time.Minute {
+		t.Fatalf("expected exactly a minute left, got %v", remaining)
+	}
+	clock.Advance(time.Minute)
+	if _, err := db.Get([]byte("short")); err != ErrNotFound {
+		t.Fatalf("expected short to expire, got %v", err)
+	}
+	if remaining, _ := db.TTL([]byte("long")); remaining != 59*time.Minute {
+		t.Fatalf("expected 59 minutes left, got %v", remaining)
+	}
+	if n, _ := db.Count(); n != 1 {
+		t.Fatalf("expected one live key, got %d", n)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	for _, record := range walRecordsOfType(t, dir, wal.RecordSet) {
+		if record.Timestamp != fakeEpoch.UnixNano() {
+			t.Fatalf("expected WAL timestamps from the clock, got %v", time.Unix(0, record.Timestamp))
+		}
+	}
+
+	clock.Advance(time.Hour)
+	db = openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+	defer db.Close()
+	if ok, _ := db.Exists([]byte("long")); ok {
+		t.Fatalf("expected replay to drop a key expired by the clock")
+	}
+}
+
+func TestFakeClockDrivesTTLSweep(t *testing.T) {
+	clock := NewFakeClock(fakeEpoch)
+	reaped := make(chan string, 1)
+	db := openTestDB(t, t.TempDir(), func(opts *Options) {
+		opts.Clock = clock
+		opts.OnExpire = func(key, _ []byte) { reaped <- string(key) }
+	})
+	defer db.Close()
+
+	_ = db.SetWithTTL([]byte("k"), []byte("v"), 500*time.Millisecond)
+	clock.Advance(TTLSweepInterval)
+	select {
+	case key := <-reaped:
+		if key != "k" {
+			t.Fatalf("unexpected reaped key %q", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the sweep to run when the clock ticked")
+	}
+}
+
+func TestFakeClockDrivesCompactionExpiry(t *testing.T) {
+	dir := t.TempDir()
+	past := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(past)
+	db := openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+
+	_ = db.SetWithTTL([]byte("live"), []byte("v"), time.Hour)
+	_ = db.SetWithTTL([]byte("short"), []byte("v"), time.Minute)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Set([]byte("later"), []byte("v"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+	for _, key := range []string{"live", "short", "later"} {
+		if _, err := db.Get([]byte(key)); err != nil {
+			t.Fatalf("expected %s kept by snapshots taken at clock time, got %v", key, err)
+		}
+	}
+	clock.Advance(time.Minute)
+	_ = db.Set([]byte("later"), []byte("v2"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	db = openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+	defer db.Close()
+	if _, err := db.Get([]byte("live")); err != nil {
+		t.Fatalf("expected live kept, got %v", err)
+	}
+	if _, err := db.Get([]byte("short")); err != ErrNotFound {
+		t.Fatalf("expected short expired by the clock, got %v", err)
+	}
+}
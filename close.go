@@ -1,7 +1,5 @@
 package minikv
 
-import "syscall"
-
 // Close flushes pending work and releases resources.
 func (db *DB) Close() error {
 	db.mu.Lock()
@@ -11,26 +9,42 @@ func (db *DB) Close() error {
 		return nil
 	}
 	db.closed = true
+	db.notifyPushedLocked()
+	db.notifyReleasedLocked()
 
+	// Background workers take mu; release it while waiting for them so a
+	// worker that fired just now can see closed and return.
+	db.mu.Unlock()
 	db.stopWorkers()
+	db.mu.Lock()
+	if db.wal != nil {
+		db.writeReapedLocked()
+	}
 
 	var err error
 	if db.wal != nil {
-		if syncErr := db.wal.Sync(); syncErr != nil {
+		if syncErr := db.syncWAL(); syncErr != nil {
 			err = syncErr
 		}
 		if closeErr := db.wal.Close(); closeErr != nil && err == nil {
 			err = closeErr
 		}
 	}
-
-	if db.lockFile != nil {
-		_ = syscall.Flock(int(db.lockFile.Fd()), syscall.LOCK_UN)
-		if closeErr := db.lockFile.Close(); closeErr != nil && err == nil {
+	if db.vlog != nil {
+		if closeErr := db.vlog.Close(); closeErr != nil && err == nil {
 			err = closeErr
 		}
-		db.lockFile = nil
 	}
+	if db.manifest != nil {
+		if closeErr := db.manifest.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	if closeErr := unlockFile(db.lockFile); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	db.lockFile = nil
 
 	return err
 }
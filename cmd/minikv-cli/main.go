@@ -0,0 +1,47 @@
+// Command minikv-cli performs maintenance tasks on MiniKV data directories.
+//
+// Usage:
+//
+//	minikv-cli upgrade <path>
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bretuobay/mini-kv"
+)
+
+const usage = `usage: minikv-cli <command> [arguments]
+
+commands:
+  upgrade <path>   rewrite a data directory into the current on-disk format
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "minikv-cli:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("missing command")
+	}
+	switch args[0] {
+	case "upgrade":
+		if len(args) != 2 {
+			return fmt.Errorf("upgrade: expected <path>")
+		}
+		if err := minikv.Upgrade(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("%s: format %d\n", args[1], minikv.FormatVersion)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
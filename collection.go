@@ -0,0 +1,543 @@
+package minikv
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+// subFamilyPrefix starts the names of the hidden families holding collection
+// members. User family names cannot start with it.
+const subFamilyPrefix = "\x00"
+
+// collectionKind identifies the type of a collection's metadata entry.
+type collectionKind uint8
+
+const (
+	kindHash collectionKind = iota + 1
+	kindList
+	kindZSet
+	kindSet
+)
+
+func (k collectionKind) String() string {
+	switch k {
+	case kindHash:
+		return "hash"
+	case kindList:
+		return "list"
+	case kindZSet:
+		return "zset"
+	case kindSet:
+		return "set"
+	}
+	return "unknown"
+}
+
+// collectionMeta is the value of a collection's metadata entry, stored under
+// the collection's key in its family. Members live in the family's hidden
+// sub-key family under keys prefixed with the key and version, so a deleted
+// or expired collection leaves no members behind for a new one with the same
+// key: orphaned members are removed at the next compaction.
+type collectionMeta struct {
+	kind    collectionKind
+	version uint64
+	count   int64
+	// extra holds kind-specific state.
+	extra []byte
+
+	expiresAt int64
+	createdAt int64
+}
+
+func (m *collectionMeta) encode() []byte {
+	buf := []byte{byte(m.kind)}
+	buf = binary.BigEndian.AppendUint64(buf, m.version)
+	buf = binary.AppendVarint(buf, m.count)
+	return append(buf, m.extra...)
+}
+
+func decodeMeta(data []byte) (*collectionMeta, error) {
+	if len(data) < 9 {
+		return nil, ErrInvalidValue
+	}
+	meta := &collectionMeta{
+		kind:    collectionKind(data[0]),
+		version: binary.BigEndian.Uint64(data[1:9]),
+	}
+	count, n := binary.Varint(data[9:])
+	if n <= 0 {
+		return nil, ErrInvalidValue
+	}
+	meta.count = count
+	meta.extra = append([]byte(nil), data[9+n:]...)
+	return meta, nil
+}
+
+// memberPrefix returns the prefix of every member key of the collection at
+// key with version.
+func memberPrefix(key []byte, version uint64) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(key)))
+	buf = append(buf, key...)
+	return binary.BigEndian.AppendUint64(buf, version)
+}
+
+// parseMemberKey returns the collection key and version of a member key.
+func parseMemberKey(member string) (string, uint64, bool) {
+	length, n := binary.Uvarint([]byte(member))
+	if n <= 0 || uint64(len(member)-n) < length+8 {
+		return "", 0, false
+	}
+	end := n + int(length)
+	return member[n:end], binary.BigEndian.Uint64([]byte(member[end : end+8])), true
+}
+
+// readMetaLocked returns the live metadata of the collection at key, nil if
+// the key does not exist, or ErrWrongType if it holds something else.
+// Callers must hold db.mu.
+func (f *Family) readMetaLocked(key string, kind collectionKind) (*collectionMeta, error) {
+	entry, ok := f.index.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	return metaFromEntry(entry, kind)
+}
+
+func metaFromEntry(entry *index.Entry, kind collectionKind) (*collectionMeta, error) {
+	if !entry.Meta {
+		return nil, ErrWrongType
+	}
+	meta, err := decodeMeta(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	if meta.kind != kind {
+		return nil, ErrWrongType
+	}
+	meta.expiresAt = entry.ExpiresAt
+	meta.createdAt = entry.CreatedAt
+	return meta, nil
+}
+
+// subFamilyLocked returns the hidden family holding the family's collection
+// members, creating it when create is set. It returns nil if the family has
+// none yet. Callers must hold db.mu.
+func (f *Family) subFamilyLocked(create bool) (*Family, error) {
+	if f.sub != nil && !f.sub.dropped {
+		return f.sub, nil
+	}
+	if !create {
+		return nil, nil
+	}
+	// Member keys embed the collection key, so they get room for it on top of
+	// the member itself.
+	opts := FamilyOptions{
+		MaxKeySize:   2*f.opts.MaxKeySize + 2*binary.MaxVarintLen64,
+		MaxValueSize: f.opts.MaxValueSize,
+	}
+	sub, err := f.db.createFamilyLocked(subFamilyPrefix+f.name, opts)
+	if err != nil {
+		return nil, err
+	}
+	f.sub = sub
+	sub.parent = f
+	return sub, nil
+}
+
+// linkFamiliesLocked connects hidden member families to their parents after
+// families were loaded from the MANIFEST.
+func (db *DB) linkFamiliesLocked() {
+	for _, f := range db.families {
+		if !strings.HasPrefix(f.name, subFamilyPrefix) {
+			continue
+		}
+		if parent := db.familyNamedLocked(strings.TrimPrefix(f.name, subFamilyPrefix)); parent != nil {
+			parent.sub = f
+			f.parent = parent
+		}
+	}
+}
+
+// loadCollectionsLocked restores collection state after Open: lastVersion
+// becomes the highest version found in the collection metadata and member
+// keys, and every member is tracked for orphan collection. Versions are
+// timestamps, and a clock that repeats or runs behind across a restart would
+// otherwise hand a recreated collection the version of members not yet
+// collected. Orphans left before the restart are unknown, so the first
+// compaction checks every member, which also catches list elements a torn
+// write left outside their list.
+func (db *DB) loadCollectionsLocked() {
+	for _, f := range db.families {
+		f.index.ForEach(func(key string, entry *index.Entry) bool {
+			version := uint64(0)
+			if f.parent != nil {
+				if _, v, ok := parseMemberKey(key); ok {
+					version = v
+				}
+				f.trackMember(key, true)
+			} else if entry.Meta {
+				if meta, err := decodeMeta(entry.Value); err == nil {
+					version = meta.version
+				}
+			}
+			if version > db.lastVersion {
+				db.lastVersion = version
+			}
+			return true
+		})
+		if f.parent != nil {
+			f.collMu.Lock()
+			for key := range f.members {
+				f.markRecheckLocked(key, true)
+			}
+			f.collMu.Unlock()
+		}
+	}
+}
+
+// nextVersionLocked returns a collection version greater than any handed out
+// before by this DB, including those of collections loaded on Open.
+func (db *DB) nextVersionLocked(now int64) uint64 {
+	version := uint64(now)
+	if version <= db.lastVersion {
+		version = db.lastVersion + 1
+	}
+	db.lastVersion = version
+	return version
+}
+
+// trackCollection keeps orphan tracking up to date with a change to key:
+// in a member family it records the member, and in a family with members it
+// marks the collection at key for a recheck, since its metadata may now be
+// gone or carry a new version. Read-only DBs never collect orphans and track
+// nothing. It runs as part of the index observer.
+func (f *Family) trackCollection(key string, entry *index.Entry) {
+	if f.db.opts.ReadOnly {
+		return
+	}
+	if f.parent != nil {
+		f.trackMember(key, entry != nil)
+		return
+	}
+	if sub := f.sub; sub != nil {
+		sub.collMu.Lock()
+		if sub.members[key] != nil {
+			sub.markRecheckLocked(key, false)
+		}
+		sub.collMu.Unlock()
+	}
+}
+
+// trackMember records that member was stored, or removed when present is
+// false. Members with malformed keys are not tracked.
+func (f *Family) trackMember(member string, present bool) {
+	key, version, ok := parseMemberKey(member)
+	if !ok {
+		return
+	}
+	f.collMu.Lock()
+	defer f.collMu.Unlock()
+	versions := f.members[key]
+	if present {
+		if versions == nil {
+			if f.members == nil {
+				f.members = make(map[string]map[uint64]map[string]struct{})
+			}
+			versions = make(map[uint64]map[string]struct{})
+			f.members[key] = versions
+		}
+		if versions[version] == nil {
+			versions[version] = make(map[string]struct{})
+		}
+		versions[version][member] = struct{}{}
+		return
+	}
+	delete(versions[version], member)
+	if len(versions[version]) == 0 {
+		delete(versions, version)
+	}
+	if len(versions) == 0 {
+		delete(f.members, key)
+	}
+}
+
+// markRecheckLocked queues the collection at key for the next orphan
+// collection; with all set, each of its members is checked against the
+// metadata rather than only their versions. Callers must hold collMu.
+func (f *Family) markRecheckLocked(key string, all bool) {
+	if f.recheck == nil {
+		f.recheck = make(map[string]bool)
+	}
+	f.recheck[key] = f.recheck[key] || all
+}
+
+// collectOrphansLocked removes the members of collections that were deleted,
+// expired or recreated since the last collection, or that a collection no
+// longer covers, and marks them dirty so a delta snapshot records their
+// removal. Only collections whose key changed are checked. It runs under db.mu at the start of compaction; since every
+// WAL record written so far is then covered by the snapshot, replay cannot
+// bring the members back.
+func (db *DB) collectOrphansLocked() {
+	for _, sub := range db.families {
+		parent := sub.parent
+		if parent == nil {
+			continue
+		}
+		sub.collMu.Lock()
+		recheck := sub.recheck
+		sub.recheck = nil
+		sub.collMu.Unlock()
+		if len(recheck) == 0 {
+			continue
+		}
+
+		// Reading the parent may expire the metadata, which reaches the
+		// observer, so collMu is not held here.
+		metas := make(map[string]*collectionMeta, len(recheck))
+		for key := range recheck {
+			if entry, ok := parent.index.Get(key); ok && entry.Meta {
+				metas[key], _ = decodeMeta(entry.Value)
+			}
+		}
+		var orphans []string
+		sub.collMu.Lock()
+		for key, all := range recheck {
+			meta := metas[key]
+			for version, members := range sub.members[key] {
+				live := meta != nil && meta.version == version
+				if live && !all {
+					continue
+				}
+				for member := range members {
+					if !live || !meta.memberLive(version, []byte(member[len(memberPrefix([]byte(key), version)):])) {
+						orphans = append(orphans, member)
+					}
+				}
+			}
+		}
+		sub.collMu.Unlock()
+		for _, member := range orphans {
+			sub.index.Delete(member)
+			sub.dirty[member] = struct{}{}
+		}
+	}
+}
+
+// memberLive reports whether a member with version and key suffix belongs to
+// the collection.
+func (m *collectionMeta) memberLive(version uint64, suffix []byte) bool {
+	if version != m.version {
+		return false
+	}
+	if m.kind == kindList {
+		return listMemberLive(m, suffix)
+	}
+	return true
+}
+
+// stagedEntry is a write staged in a txn. A nil meta and value with deleted
+// unset never occurs: deleted marks a removed key.
+type stagedEntry struct {
+	value   []byte
+	meta    *collectionMeta
+	deleted bool
+}
+
+// txn stages the records of one logical write under db.mu. Reads go through
+// it, so later steps see earlier ones, and commit appends every record to the
+// WAL in one frame before applying any, making the whole write atomic.
+type txn struct {
+	db        *DB
+	now       int64
+	records   []wal.WALRecord
+	createdAt []int64
+	staged    map[*Family]map[string]*stagedEntry
+	// pushed is set when the txn pushes to a list, to wake BLPop on commit.
+	pushed bool
+}
+
+func (db *DB) newTxnLocked() *txn {
+	return &txn{
+		db:     db,
+		now:    db.now(),
+		staged: make(map[*Family]map[string]*stagedEntry),
+	}
+}
+
+func (t *txn) stage(f *Family, key string, entry *stagedEntry) {
+	if t.staged[f] == nil {
+		t.staged[f] = make(map[string]*stagedEntry)
+	}
+	t.staged[f][key] = entry
+}
+
+// meta returns the collection metadata at key as of the staged writes.
+func (t *txn) meta(f *Family, key string, kind collectionKind) (*collectionMeta, error) {
+	if staged, ok := t.staged[f][key]; ok {
+		switch {
+		case staged.deleted:
+			return nil, nil
+		case staged.meta == nil || staged.meta.kind != kind:
+			return nil, ErrWrongType
+		}
+		meta := *staged.meta
+		return &meta, nil
+	}
+	return f.readMetaLocked(key, kind)
+}
+
+// newMeta returns metadata for a new collection at key in the family.
+func (t *txn) newMeta(f *Family, kind collectionKind) *collectionMeta {
+	return &collectionMeta{
+		kind:      kind,
+		version:   t.db.nextVersionLocked(t.now),
+		expiresAt: f.defaultExpiresAt(),
+		createdAt: t.now,
+	}
+}
+
+// get returns the value at key as of the staged writes.
+func (t *txn) get(f *Family, key string) ([]byte, bool, error) {
+	if staged, ok := t.staged[f][key]; ok {
+		if staged.deleted {
+			return nil, false, nil
+		}
+		if staged.meta != nil {
+			return nil, false, ErrWrongType
+		}
+		return staged.value, true, nil
+	}
+	entry, ok := f.index.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	value, err := t.db.entryValue(key, entry)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (t *txn) add(record wal.WALRecord, createdAt int64) {
+	t.records = append(t.records, record)
+	t.createdAt = append(t.createdAt, createdAt)
+}
+
+// set stages a plain value.
+func (t *txn) set(f *Family, key, value []byte, expiresAt int64) error {
+	return t.setCreated(f, key, value, expiresAt, 0)
+}
+
+// setCreated stages a plain value created at createdAt, or now when it is
+// 0. The record's timestamp is createdAt, so replay restores it too.
+func (t *txn) setCreated(f *Family, key, value []byte, expiresAt, createdAt int64) error {
+	if createdAt == 0 {
+		createdAt = t.now
+	}
+	record, err := f.encodeValueLocked(key, value, expiresAt, createdAt)
+	if err != nil {
+		return err
+	}
+	t.add(record, createdAt)
+	t.stage(f, string(key), &stagedEntry{value: append([]byte(nil), value...)})
+	return nil
+}
+
+// delete stages the removal of key.
+func (t *txn) delete(f *Family, key []byte) {
+	t.add(wal.WALRecord{
+		Type:      wal.RecordDelete,
+		Timestamp: t.now,
+		Key:       append([]byte(nil), key...),
+		ExpiresAt: -1,
+		Family:    f.id,
+	}, t.now)
+	t.stage(f, string(key), &stagedEntry{deleted: true})
+}
+
+// putMeta stages collection metadata, or the removal of the collection once
+// it has no members left.
+func (t *txn) putMeta(f *Family, key []byte, meta *collectionMeta) {
+	if meta.count <= 0 {
+		t.delete(f, key)
+		return
+	}
+	t.add(wal.WALRecord{
+		Type:      wal.RecordSetMeta,
+		Timestamp: t.now,
+		ExpiresAt: meta.expiresAt,
+		Key:       append([]byte(nil), key...),
+		Value:     meta.encode(),
+		Family:    f.id,
+	}, meta.createdAt)
+	staged := *meta
+	t.stage(f, string(key), &stagedEntry{meta: &staged})
+}
+
+// commit appends the staged records to the WAL as one frame and applies
+// them, so recovery replays all of them or none. A txn
+// that stores anything is rejected once the DB is past MaxMemoryBytes; one
+// that only deletes always goes through.
+func (t *txn) commit() error {
+	if len(t.records) == 0 {
+		return nil
+	}
+	db := t.db
+	for _, record := range t.records {
+		if record.Type != wal.RecordDelete {
+			if err := db.checkMemoryLocked(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if _, err := db.wal.AppendRaw(wal.EncodeTxn(t.records)); err != nil {
+		return err
+	}
+	if db.opts.SyncMode == SyncAlways {
+		if err := db.syncWAL(); err != nil {
+			return err
+		}
+	}
+	for i, record := range t.records {
+		db.families[record.Family].applyLocked(record, t.createdAt[i])
+	}
+	if t.pushed {
+		db.notifyPushedLocked()
+	}
+	db.evictLocked()
+	return nil
+}
+
+// applyLocked mirrors any record written by a txn into the family index.
+func (f *Family) applyLocked(record wal.WALRecord, createdAt int64) {
+	switch record.Type {
+	case wal.RecordDelete:
+		f.index.Delete(string(record.Key))
+		f.markDirtyLocked(record)
+	case wal.RecordSetMeta:
+		f.markDirtyLocked(record)
+		f.index.SetMeta(string(record.Key), record.Value, record.ExpiresAt, createdAt)
+	default:
+		f.applyRecordLocked(record, createdAt)
+	}
+}
+
+// setMetaExpiresAtLocked rewrites a collection's metadata with a new expiry,
+// which expires or persists the whole collection.
+func (f *Family) setMetaExpiresAtLocked(key []byte, entry *index.Entry, expiresAt int64) (bool, error) {
+	meta, err := decodeMeta(entry.Value)
+	if err != nil {
+		return false, err
+	}
+	meta.expiresAt = expiresAt
+	meta.createdAt = entry.CreatedAt
+	t := f.db.newTxnLocked()
+	t.putMeta(f, key, meta)
+	if err := t.commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
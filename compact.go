@@ -1,70 +1,302 @@
 package minikv
 
 import (
-	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/manifest"
 	"github.com/bretuobay/mini-kv/internal/snapshot"
 	"github.com/bretuobay/mini-kv/internal/wal"
 )
 
-// Compact creates a snapshot and removes old WAL segments.
+// crashPoint is called after each step of snapshot publication. Tests
+// replace it to stop Compact at that step and reopen the database.
+var crashPoint = func(step string) error { return nil }
+
+// Compact seals the current WAL segment and records the state it covers in
+// the snapshot chain. It writes a delta holding only keys changed since the
+// previous snapshot, or a full snapshot once MaxSnapshotDeltas deltas have
+// accumulated. The snapshot is durable and recorded in the MANIFEST before
+// the WAL segments it covers are removed, so a crash at any step loses no
+// acknowledged write. Older chains outside SnapshotRetention are pruned last.
 func (db *DB) Compact() error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
 	if !db.beginCompaction() {
 		return nil
 	}
 	defer db.endCompaction()
+	start := time.Now()
 
-	db.mu.RLock()
+	db.mu.Lock()
 	if db.closed {
-		db.mu.RUnlock()
+		db.mu.Unlock()
 		return ErrClosed
 	}
-	entries := db.index.Scan("", 0)
-	seq := db.wal.CurrentSeq()
+	db.collectOrphansLocked()
+	full := db.needsFullSnapshotLocked()
+	if !full && db.dirtyCountLocked() == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	snapEntries, err := db.chainEntriesLocked(full)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	seq, err := db.sealWALLocked()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	dirty := db.resetDirtyLocked()
 	snapMgr := db.snap
-	db.mu.RUnlock()
+	db.mu.Unlock()
 
-	now := time.Now().UnixNano()
-	snapEntries := make([]snapshot.Entry, 0, len(entries))
-	for _, entry := range entries {
-		snapEntries = append(snapEntries, snapshot.Entry{
-			Key:       entry.Key,
-			Value:     entry.Entry.Value,
-			ExpiresAt: entry.Entry.ExpiresAt,
-			CreatedAt: entry.Entry.CreatedAt,
-		})
+	// Until every step succeeds, put the changed keys back so the next
+	// snapshot covers them again. After the MANIFEST commit that only costs a
+	// redundant delta entry; before it, it keeps the keys from being lost.
+	fail := func(err error) error {
+		db.restoreDirty(dirty)
+		return err
 	}
 
-	if seq == 0 {
-		seq = 1
+	now := db.now()
+	var path string
+	if full {
+		path, err = snapMgr.CreateSnapshot(snapEntries, snapshot.Version, now, seq)
+	} else {
+		path, err = snapMgr.CreateDelta(snapEntries, snapshot.Version, now, seq)
 	}
-	_, err := snapMgr.CreateSnapshot(snapEntries, 1, now, seq)
 	if err != nil {
-		return err
+		return fail(err)
+	}
+	if err := crashPoint("snapshot"); err != nil {
+		return fail(err)
 	}
 
-	if err := deleteOldWALSegments(filepath.Join(db.path, "wal"), seq); err != nil {
-		return err
+	commit := manifest.VersionEdit{HasLastSnapshotSeq: true, LastSnapshotSeq: seq}
+	info := manifest.SnapshotInfo{Seq: seq, Path: path}
+	if full {
+		commit.AddSnapshots = []manifest.SnapshotInfo{info}
+	} else {
+		commit.AddDeltas = []manifest.SnapshotInfo{info}
+	}
+	if err := db.manifest.Apply(commit); err != nil {
+		return fail(err)
+	}
+	// The chain counters follow the MANIFEST, so they only move once the
+	// snapshot is recorded there.
+	db.mu.Lock()
+	if full {
+		db.hasBase = true
+		db.deltas = 0
+	} else {
+		db.deltas++
+	}
+	db.mu.Unlock()
+	if err := crashPoint("manifest"); err != nil {
+		return fail(err)
 	}
 
-	return refreshManifest(db.path)
+	if err := db.deleteOldWALSegments(seq + 1); err != nil {
+		return fail(err)
+	}
+	if err := crashPoint("wal"); err != nil {
+		return fail(err)
+	}
+	if err := db.pruneSnapshots(); err != nil {
+		return fail(err)
+	}
+	db.lastCompAt.Store(db.now())
+	db.lastCompNs.Store(int64(time.Since(start)))
+	return nil
 }
 
-func (db *DB) beginCompaction() bool {
-	db.compactMu.Lock()
-	defer db.compactMu.Unlock()
-	if db.compacting {
+// chainEntriesLocked returns the entries of the next snapshot: every key
+// when full, otherwise only the keys changed since the last snapshot.
+func (db *DB) chainEntriesLocked(full bool) ([]snapshot.Entry, error) {
+	var snapEntries []snapshot.Entry
+	for _, f := range db.families {
+		var entries []snapshot.Entry
+		var err error
+		if full {
+			entries, err = f.snapshotEntriesLocked(f.index.Scan("", 0))
+		} else {
+			entries, err = f.deltaEntriesLocked(f.dirty)
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapEntries = append(snapEntries, entries...)
+	}
+	return snapEntries, nil
+}
+
+// sealWALLocked rotates the WAL and records the new segment in the
+// MANIFEST. It returns the sequence of the sealed segment.
+func (db *DB) sealWALLocked() (uint64, error) {
+	seq, err := db.wal.Rotate()
+	if err != nil {
+		return 0, err
+	}
+	if err := db.logWALSegment(seq + 1); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// resetDirtyLocked starts tracking changes for the next snapshot and
+// returns the keys changed since the last one, for restoreDirty.
+func (db *DB) resetDirtyLocked() map[*Family]map[string]struct{} {
+	dirty := make(map[*Family]map[string]struct{}, len(db.families))
+	for _, f := range db.families {
+		dirty[f] = f.dirty
+		f.dirty = make(map[string]struct{})
+		f.walBytes = 0
+		f.index.ResetGarbage()
+	}
+	db.walMark = db.wal.BytesWritten()
+	return dirty
+}
+
+// needsFullSnapshotLocked reports whether the next snapshot should be a
+// full merge: there is no base yet, or keys changed and either the delta
+// chain is at its limit or most keys changed anyway. With a base and no
+// changed key the current chain already holds the state.
+func (db *DB) needsFullSnapshotLocked() bool {
+	if !db.hasBase {
+		return true
+	}
+	dirty := db.dirtyCountLocked()
+	if dirty == 0 {
 		return false
 	}
-	db.compacting = true
-	return true
+	if db.deltas >= db.opts.MaxSnapshotDeltas {
+		return true
+	}
+	keys := 0
+	for _, f := range db.families {
+		keys += f.index.Len()
+	}
+	return dirty*2 >= keys
+}
+
+// dirtyCountLocked returns the number of keys changed since the last snapshot.
+func (db *DB) dirtyCountLocked() int {
+	count := 0
+	for _, f := range db.families {
+		count += len(f.dirty)
+	}
+	return count
+}
+
+func (f *Family) deltaEntriesLocked(dirty map[string]struct{}) ([]snapshot.Entry, error) {
+	entries := make([]snapshot.Entry, 0, len(dirty))
+	for key := range dirty {
+		entry, ok := f.index.Get(key)
+		if !ok {
+			entries = append(entries, snapshot.Entry{Key: []byte(key), ExpiresAt: -1, Tombstone: true, Family: f.id})
+			continue
+		}
+		snapEntry, err := f.snapshotEntryLocked([]byte(key), entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, snapEntry)
+	}
+	return entries, nil
+}
+
+// restoreDirty puts keys back after a failed snapshot so the next one covers
+// them. Keys of families dropped in the meantime are discarded.
+func (db *DB) restoreDirty(dirty map[*Family]map[string]struct{}) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for f, keys := range dirty {
+		if f.dropped {
+			continue
+		}
+		for key := range keys {
+			f.dirty[key] = struct{}{}
+		}
+	}
+}
+
+// markDirtyLocked records that the record's key changed since the last
+// snapshot and counts its bytes toward the family's compaction trigger.
+func (f *Family) markDirtyLocked(record wal.WALRecord) {
+	f.dirty[string(record.Key)] = struct{}{}
+	f.walBytes += int64(len(record.Key) + len(record.Value))
+	f.db.lastWrite.Store(f.db.now())
+}
+
+// markRangeDirtyLocked records the keys a range delete removed, so a delta
+// snapshot writes a tombstone for each.
+func (f *Family) markRangeDirtyLocked(record wal.WALRecord, keys []string) {
+	for _, key := range keys {
+		f.dirty[key] = struct{}{}
+	}
+	f.walBytes += int64(len(record.Key) + len(record.Value))
+	f.db.lastWrite.Store(f.db.now())
+}
+
+func (f *Family) snapshotEntriesLocked(entries []index.KeyEntry) ([]snapshot.Entry, error) {
+	snapEntries := make([]snapshot.Entry, 0, len(entries))
+	for i := range entries {
+		snapEntry, err := f.snapshotEntryLocked(entries[i].Key, &entries[i].Entry)
+		if err != nil {
+			return nil, err
+		}
+		snapEntries = append(snapEntries, snapEntry)
+	}
+	return snapEntries, nil
+}
+
+// snapshotEntryLocked converts an index entry for a snapshot. Pending merge
+// operands are folded and the result stored inline. If the operator fails,
+// say on a base value it cannot parse, the operands are kept as they are so
+// one bad key cannot stop compaction; reads of it keep reporting the error.
+func (f *Family) snapshotEntryLocked(key []byte, entry *index.Entry) (snapshot.Entry, error) {
+	snapEntry := snapshot.Entry{
+		Key:       key,
+		Value:     append([]byte(nil), entry.Value...),
+		ExpiresAt: entry.ExpiresAt,
+		CreatedAt: entry.CreatedAt,
+		Pointer:   entry.Pointer,
+		Family:    f.id,
+		Meta:      entry.Meta,
+	}
+	if len(entry.Operands) == 0 {
+		return snapEntry, nil
+	}
+	base, err := f.db.mergeBase(entry)
+	if err != nil {
+		return snapshot.Entry{}, err
+	}
+	if f.db.opts.MergeOperator == nil {
+		return snapshot.Entry{}, ErrNoMergeOperator
+	}
+	value, err := f.db.opts.MergeOperator.Merge(key, base, entry.Operands)
+	if err != nil {
+		snapEntry.Operands = entry.Operands
+		snapEntry.NoBase = entry.NoBase
+		return snapEntry, nil
+	}
+	snapEntry.Value = value
+	snapEntry.Pointer = false
+	return snapEntry, nil
+}
+
+// beginCompaction claims compactMu for a compaction, reporting false if one
+// is already running. Bulk loads wait for compactMu instead.
+func (db *DB) beginCompaction() bool {
+	return db.compactMu.TryLock()
 }
 
 func (db *DB) endCompaction() {
-	db.compactMu.Lock()
-	db.compacting = false
 	db.compactMu.Unlock()
 }
 
@@ -74,19 +306,20 @@ func (db *DB) compactAsync() {
 	}()
 }
 
-func deleteOldWALSegments(walDir string, keepSeq uint64) error {
-	segments, err := wal.ListSegments(walDir)
+func (db *DB) deleteOldWALSegments(keepSeq uint64) error {
+	segments, err := wal.ListSegments(filepath.Join(db.path, "wal"))
 	if err != nil {
 		return err
 	}
+	var old []string
 	for _, path := range segments {
 		seq, ok := parseSegmentSeq(path)
 		if !ok {
 			continue
 		}
 		if seq < keepSeq {
-			_ = os.Remove(path)
+			old = append(old, path)
 		}
 	}
-	return nil
+	return db.removeFiles(old)
 }
@@ -0,0 +1,186 @@
+package minikv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+var errSimulatedCrash = errors.New("simulated crash")
+
+// crash abandons db without the flush Close performs, so only what earlier
+// steps made durable is visible to the next Open.
+func crash(db *DB) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closed = true
+	db.stopWorkers()
+	_ = db.wal.Close()
+	if db.vlog != nil {
+		_ = db.vlog.Close()
+	}
+	_ = db.manifest.Close()
+	_ = syscall.Flock(int(db.lockFile.Fd()), syscall.LOCK_UN)
+	_ = db.lockFile.Close()
+}
+
+func TestCompactCrashPointsLoseNoWrites(t *testing.T) {
+	for _, step := range []string{"snapshot", "manifest", "wal"} {
+		t.Run(step, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := DefaultOptions(dir)
+			opts.SyncMode = SyncAlways
+			db, err := Open(opts)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+
+			want := make(map[string]string)
+			for i := 0; i < 20; i++ {
+				key := "k" + intToString(i)
+				want[key] = "v0"
+				if err := db.Set([]byte(key), []byte("v0")); err != nil {
+					t.Fatalf("set: %v", err)
+				}
+			}
+			if err := db.Compact(); err != nil {
+				t.Fatalf("compact: %v", err)
+			}
+			for i := 0; i < 5; i++ {
+				key := "k" + intToString(i)
+				want[key] = "v1"
+				if err := db.Set([]byte(key), []byte("v1")); err != nil {
+					t.Fatalf("set: %v", err)
+				}
+			}
+			if err := db.Delete([]byte("k19")); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			delete(want, "k19")
+
+			crashPoint = func(at string) error {
+				if at == step {
+					return errSimulatedCrash
+				}
+				return nil
+			}
+			err = db.Compact()
+			crashPoint = func(string) error { return nil }
+			if !errors.Is(err, errSimulatedCrash) {
+				t.Fatalf("expected simulated crash, got %v", err)
+			}
+			crash(db)
+
+			db, err = Open(opts)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer db.Close()
+			for key, value := range want {
+				got, err := db.Get([]byte(key))
+				if err != nil || string(got) != value {
+					t.Fatalf("get %s: %q %v, want %q", key, got, err, value)
+				}
+			}
+			if _, err := db.Get([]byte("k19")); err != ErrNotFound {
+				t.Fatalf("expected deleted key to stay deleted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenRemovesSnapshotTempFile(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	crash(db)
+
+	// A crash mid-write leaves only the temp file behind.
+	snapDir := filepath.Join(dir, "snapshots")
+	if err := os.MkdirAll(snapDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	tmp := filepath.Join(snapDir, "snapshot_000001.snap.tmp")
+	if err := os.WriteFile(tmp, []byte("MINIKVSN"), 0o644); err != nil {
+		t.Fatalf("write temp: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file to be removed, got %v", err)
+	}
+	if value, err := db.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Fatalf("get: %q %v", value, err)
+	}
+}
+
+func TestCompactFailureKeepsDirtyKeys(t *testing.T) {
+	for _, step := range []string{"snapshot", "apply", "manifest", "wal"} {
+		t.Run(step, func(t *testing.T) {
+			dir := t.TempDir()
+			db := openTestDB(t, dir)
+			for i := 0; i < 10; i++ {
+				_ = db.Set([]byte("k"+intToString(i)), []byte("v0"))
+			}
+			if err := db.Compact(); err != nil {
+				t.Fatalf("compact: %v", err)
+			}
+			_ = db.Set([]byte("k1"), []byte("v1"))
+			_ = db.Delete([]byte("k2"))
+			deltas := db.deltas
+
+			crashPoint = func(at string) error {
+				if step == "apply" && at == "snapshot" {
+					// A MANIFEST that cannot be written fails the commit itself.
+					return db.manifest.Close()
+				}
+				if at == step {
+					return errSimulatedCrash
+				}
+				return nil
+			}
+			err := db.Compact()
+			crashPoint = func(string) error { return nil }
+			if err == nil {
+				t.Fatalf("expected compaction to fail")
+			}
+			db.mu.RLock()
+			dirty := db.dirtyCountLocked()
+			counted := db.deltas
+			db.mu.RUnlock()
+			if dirty != 2 {
+				t.Fatalf("expected both changed keys kept dirty, got %d", dirty)
+			}
+			committed := step == "manifest" || step == "wal"
+			if committed && counted != deltas+1 || !committed && counted != deltas {
+				t.Fatalf("expected the delta counted only once recorded, got %d from %d", counted, deltas)
+			}
+			if step == "apply" {
+				crash(db)
+				db = openTestDB(t, dir)
+			} else if err := db.Compact(); err != nil {
+				t.Fatalf("retry compact: %v", err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			db = openTestDB(t, dir)
+			defer db.Close()
+			if value, _ := db.Get([]byte("k1")); string(value) != "v1" {
+				t.Fatalf("expected k1=v1, got %q", value)
+			}
+			if _, err := db.Get([]byte("k2")); err != ErrNotFound {
+				t.Fatalf("expected k2 deleted, got %v", err)
+			}
+		})
+	}
+}
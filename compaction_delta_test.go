@@ -0,0 +1,220 @@
+package minikv
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestDB opens a database in dir that syncs only on demand, after
+// applying each configure function to the options.
+func openTestDB(t *testing.T, dir string, configure ...func(opts *Options)) *DB {
+	t.Helper()
+	opts := DefaultOptions(dir)
+	opts.SyncMode = SyncManual
+	for _, fn := range configure {
+		fn(&opts)
+	}
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return db
+}
+
+func TestCompactKeepsWritesAfterSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	for _, key := range []string{"a", "b"} {
+		if _, err := db.Get([]byte(key)); err != nil {
+			t.Fatalf("get %s after reopen: %v", key, err)
+		}
+	}
+}
+
+func TestCompactWritesDeltasAndReloadsChain(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	for i := 0; i < 20; i++ {
+		if err := db.Set([]byte("k"+intToString(i)), []byte("v0")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	if err := db.Set([]byte("k1"), []byte("v1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := db.Delete([]byte("k2")); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	snapDir := filepath.Join(dir, "snapshots")
+	if got := dirCount(snapDir, ".snap"); got != 1 {
+		t.Fatalf("expected 1 full snapshot, got %d", got)
+	}
+	if got := dirCount(snapDir, ".delta"); got != 1 {
+		t.Fatalf("expected 1 delta, got %d", got)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	value, err := db.Get([]byte("k1"))
+	if err != nil || string(value) != "v1" {
+		t.Fatalf("expected delta value, got %q %v", value, err)
+	}
+	if _, err := db.Get([]byte("k2")); err != ErrNotFound {
+		t.Fatalf("expected tombstoned key to stay deleted, got %v", err)
+	}
+	value, err = db.Get([]byte("k3"))
+	if err != nil || string(value) != "v0" {
+		t.Fatalf("expected base value, got %q %v", value, err)
+	}
+}
+
+func TestCompactFullMergeBoundsChain(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) { opts.MaxSnapshotDeltas = 2 })
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		_ = db.Set([]byte("k"+intToString(i)), []byte("v"))
+	}
+	for round := 0; round < 4; round++ {
+		_ = db.Set([]byte("k0"), []byte("r"+intToString(round)))
+		if err := db.Compact(); err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+	}
+
+	// base, delta, delta, base
+	snapDir := filepath.Join(dir, "snapshots")
+	if got := dirCount(snapDir, ".snap"); got != 2 {
+		t.Fatalf("expected 2 full snapshots, got %d", got)
+	}
+	if got := dirCount(snapDir, ".delta"); got != 2 {
+		t.Fatalf("expected 2 deltas, got %d", got)
+	}
+}
+
+func TestCompactSkipsWhenNothingChanged(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	defer db.Close()
+
+	_ = db.Set([]byte("a"), []byte("1"))
+	_ = db.Set([]byte("b"), []byte("1"))
+	_ = db.Set([]byte("c"), []byte("1"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	snapDir := filepath.Join(dir, "snapshots")
+	if got := dirCount(snapDir, ".snap") + dirCount(snapDir, ".delta"); got != 1 {
+		t.Fatalf("expected a single snapshot, got %d", got)
+	}
+}
+
+func TestCompactSkipsFullSnapshotWhenNothingChanged(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) { opts.MaxSnapshotDeltas = 1 })
+	defer db.Close()
+
+	snapDir := filepath.Join(dir, "snapshots")
+	// An empty database gets its base once.
+	for i := 0; i < 3; i++ {
+		if err := db.Compact(); err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+	}
+	if got := dirCount(snapDir, ".snap"); got != 1 {
+		t.Fatalf("expected one full snapshot of the empty database, got %d", got)
+	}
+
+	// A chain at MaxSnapshotDeltas is not merged again until a key changes.
+	for i := 0; i < 4; i++ {
+		_ = db.Set([]byte("k"+intToString(i)), []byte("v"))
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Set([]byte("k0"), []byte("v2"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if db.deltas != db.opts.MaxSnapshotDeltas {
+		t.Fatalf("expected the chain at its delta limit, got %d deltas", db.deltas)
+	}
+	snaps, deltas := dirCount(snapDir, ".snap"), dirCount(snapDir, ".delta")
+	for i := 0; i < 3; i++ {
+		if err := db.Compact(); err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+	}
+	if got := dirCount(snapDir, ".snap"); got != snaps {
+		t.Fatalf("expected no new full snapshot without changes, got %d, had %d", got, snaps)
+	}
+	if got := dirCount(snapDir, ".delta"); got != deltas {
+		t.Fatalf("expected no new delta without changes, got %d, had %d", got, deltas)
+	}
+}
+
+func TestCompactDeltaExpiryStaysInFamily(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	users, err := db.CreateFamily("users", FamilyOptions{})
+	if err != nil {
+		t.Fatalf("create family: %v", err)
+	}
+	_ = db.Set([]byte("k"), []byte("default"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Set([]byte("other"), []byte("v"))
+	if err := users.SetWithTTL([]byte("k"), []byte("user"), time.Millisecond); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if value, err := db.Get([]byte("k")); err != nil || string(value) != "default" {
+		t.Fatalf("expected the default family's k to survive, got %q, %v", value, err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	users, _ = db.Family("users")
+	if _, err := users.Get([]byte("k")); err != ErrNotFound {
+		t.Fatalf("expected the family's k to expire, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package minikv
+
+import "time"
+
+const (
+	compactionCheckInterval = 1 * time.Second
+	defaultIdleAfter        = 1 * time.Second
+)
+
+func (p CompactionPolicy) enabled() bool {
+	return p.WALBytes > 0 || p.GarbageRatio > 0 || p.Interval > 0 || p.IdleOnly
+}
+
+// checkInterval returns how often the worker evaluates the policy, tightened
+// for short intervals so that triggers are not delayed by a full second.
+func (p CompactionPolicy) checkInterval() time.Duration {
+	interval := compactionCheckInterval
+	if p.Interval > 0 && p.Interval < interval {
+		interval = p.Interval
+	}
+	if p.IdleOnly && p.IdleAfter > 0 && p.IdleAfter < interval {
+		interval = p.IdleAfter
+	}
+	return interval
+}
+
+// hasCompactionTriggers reports whether the family adds compaction triggers
+// of its own.
+func (f *Family) hasCompactionTriggers() bool {
+	p := f.opts.Compaction
+	return p.WALBytes > 0 || p.GarbageRatio > 0 || p.Interval > 0
+}
+
+// startCompactionWorker starts the policy worker when the database or any
+// family has triggers. When it is already running, the check interval is
+// tightened to suit a newly created family. Callers must hold db.mu or own
+// db exclusively.
+func (db *DB) startCompactionWorker() {
+	if db.opts.ReadOnly {
+		return
+	}
+	enabled := db.opts.Compaction.enabled()
+	interval := db.opts.Compaction.checkInterval()
+	for _, f := range db.families {
+		if f.hasCompactionTriggers() {
+			enabled = true
+			if check := f.opts.Compaction.checkInterval(); check < interval {
+				interval = check
+			}
+		}
+	}
+	if !enabled {
+		return
+	}
+	if db.compTicker != nil {
+		db.compTicker.Reset(interval)
+		return
+	}
+	if db.stopCh == nil {
+		db.stopCh = make(chan struct{})
+	}
+	db.compTicker = db.opts.Clock.NewTicker(interval)
+
+	db.wg.Add(1)
+	go func() {
+		defer db.wg.Done()
+		for {
+			select {
+			case <-db.compTicker.C():
+				if db.shouldCompact(db.opts.Clock.Now()) {
+					_ = db.Compact()
+				}
+			case <-db.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// shouldCompact evaluates the compaction policy, and the triggers of each
+// family, against the changes made since the last snapshot.
+func (db *DB) shouldCompact(now time.Time) bool {
+	policy := db.opts.Compaction
+
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return false
+	}
+	pending := db.dirtyCountLocked() > 0
+	walBytes := int64(db.wal.BytesWritten() - db.walMark)
+	var garbage, live int64
+	familyDue := false
+	for _, f := range db.families {
+		garbage += f.index.Garbage()
+		live += f.index.Size()
+		if f.hasCompactionTriggers() && db.triggered(f.opts.Compaction, f.walBytes, f.index.Garbage(), f.index.Size(), now) {
+			familyDue = true
+		}
+	}
+	db.mu.RUnlock()
+	if !pending {
+		return false
+	}
+
+	if policy.IdleOnly {
+		if now.Sub(time.Unix(0, db.lastWrite.Load())) < policy.IdleAfter {
+			return false
+		}
+		if policy.WALBytes == 0 && policy.GarbageRatio == 0 && policy.Interval == 0 {
+			return true
+		}
+	}
+	return familyDue || db.triggered(policy, walBytes, garbage, live, now)
+}
+
+// triggered reports whether a WALBytes, GarbageRatio or Interval trigger of
+// policy fires, given the bytes written since the last snapshot and the
+// garbage and live bytes they apply to.
+func (db *DB) triggered(policy CompactionPolicy, walBytes, garbage, live int64, now time.Time) bool {
+	if policy.WALBytes > 0 && walBytes >= policy.WALBytes {
+		return true
+	}
+	if policy.GarbageRatio > 0 {
+		if garbage > 0 && (live == 0 || float64(garbage)/float64(live) >= policy.GarbageRatio) {
+			return true
+		}
+	}
+	if policy.Interval > 0 {
+		last := db.openedAt
+		if at := db.lastCompAt.Load(); at > 0 {
+			last = time.Unix(0, at)
+		}
+		if now.Sub(last) >= policy.Interval {
+			return true
+		}
+	}
+	return false
+}
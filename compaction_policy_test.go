@@ -0,0 +1,86 @@
+package minikv
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompactionPolicyWALBytes(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Compaction = CompactionPolicy{WALBytes: 512} })
+	defer db.Close()
+
+	_ = db.Set([]byte("k"), []byte("v"))
+	if db.shouldCompact(time.Now()) {
+		t.Fatalf("expected no compaction below WAL threshold")
+	}
+	for i := 0; i < 32; i++ {
+		_ = db.Set([]byte("k"+intToString(i)), []byte("value-value-value"))
+	}
+	if !db.shouldCompact(time.Now()) {
+		t.Fatalf("expected compaction above WAL threshold")
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if db.shouldCompact(time.Now()) {
+		t.Fatalf("expected WAL counter to reset after compaction")
+	}
+}
+
+func TestCompactionPolicyGarbageRatio(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Compaction = CompactionPolicy{GarbageRatio: 0.5} })
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		_ = db.Set([]byte("k"+intToString(i)), []byte("value"))
+	}
+	if db.shouldCompact(time.Now()) {
+		t.Fatalf("expected no compaction without garbage")
+	}
+	for i := 0; i < 6; i++ {
+		_ = db.Delete([]byte("k" + intToString(i)))
+	}
+	if !db.shouldCompact(time.Now()) {
+		t.Fatalf("expected compaction once garbage exceeds ratio")
+	}
+}
+
+func TestCompactionPolicyIdleOnly(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Compaction = CompactionPolicy{IdleOnly: true, IdleAfter: time.Hour} })
+	defer db.Close()
+
+	_ = db.Set([]byte("k"), []byte("v"))
+	now := time.Now()
+	if db.shouldCompact(now) {
+		t.Fatalf("expected no compaction while writes are recent")
+	}
+	if !db.shouldCompact(now.Add(2 * time.Hour)) {
+		t.Fatalf("expected compaction once idle")
+	}
+}
+
+func TestCompactionPolicyIntervalRunsWorker(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Compaction = CompactionPolicy{Interval: 20 * time.Millisecond} })
+	defer db.Close()
+
+	_ = db.Set([]byte("k"), []byte("v"))
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		stats, err := db.Stats()
+		if err != nil {
+			t.Fatalf("stats: %v", err)
+		}
+		if !stats.LastCompaction.IsZero() {
+			if stats.LastCompactionDuration <= 0 {
+				t.Fatalf("expected compaction duration, got %v", stats.LastCompactionDuration)
+			}
+			if dirCount(filepath.Join(db.path, "snapshots"), ".snap") == 0 {
+				t.Fatalf("expected snapshot file")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected interval trigger to compact")
+}
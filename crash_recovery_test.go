@@ -1,6 +1,12 @@
 package minikv
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
 
 func TestCrashRecoverySimulation(t *testing.T) {
 	dir := t.TempDir()
@@ -30,3 +36,52 @@ func TestCrashRecoverySimulation(t *testing.T) {
 		t.Fatalf("expected recovered value, got %v %v", value, err)
 	}
 }
+
+func TestTornTxnIsDiscardedOnReplay(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	if err := db.HSet([]byte("h"), []byte("f"), []byte("v")); err != nil {
+		t.Fatalf("hset: %v", err)
+	}
+	_, before := db.wal.Position()
+	// A ZAdd that creates a sorted set writes its metadata and two member
+	// records in one txn.
+	if _, err := db.ZAdd([]byte("z"), 1, []byte("m")); err != nil {
+		t.Fatalf("zadd: %v", err)
+	}
+	_, after := db.wal.Position()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	segments, err := wal.ListSegments(filepath.Join(dir, "wal"))
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("list segments: %v", err)
+	}
+	last := segments[len(segments)-1]
+	data, err := os.ReadFile(last)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// Tear the ZAdd at every byte, as a crash in the middle of appending it
+	// would, and expect none of it after replay.
+	for end := before; end < after; end++ {
+		if err := os.WriteFile(last, data[:end], 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		db = openTestDB(t, dir)
+		if n, _ := db.HLen([]byte("h")); n != 1 {
+			t.Fatalf("torn at %d: expected the hash kept, got %d fields", end, n)
+		}
+		if n, _ := db.ZCard([]byte("z")); n != 0 {
+			t.Fatalf("torn at %d: expected no part of the zadd, got %d members", end, n)
+		}
+		if _, err := db.ZScore([]byte("z"), []byte("m")); err != ErrNotFound {
+			t.Fatalf("torn at %d: expected ErrNotFound, got %v", end, err)
+		}
+		if n := db.def.sub.index.Len(); n != 1 {
+			t.Fatalf("torn at %d: expected only the hash field's member record, got %d", end, n)
+		}
+		_ = db.Close()
+	}
+}
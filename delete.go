@@ -8,9 +8,15 @@ import (
 
 // Delete removes a key if it exists.
 func (db *DB) Delete(key []byte) error {
+	return db.def.Delete(key)
+}
+
+// Delete removes a key from the family if it exists.
+func (f *Family) Delete(key []byte) error {
+	db := f.db
 	stats := db.statsOrInit()
 	start := time.Now()
-	if len(key) > db.opts.MaxKeySize {
+	if len(key) > f.opts.MaxKeySize {
 		stats.deletes.Add(1)
 		stats.writeLatency.add(time.Since(start))
 		return ErrKeyTooLarge
@@ -24,10 +30,10 @@ func (db *DB) Delete(key []byte) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		stats.deletes.Add(1)
 		stats.writeLatency.add(time.Since(start))
-		return ErrClosed
+		return err
 	}
 	if db.opts.ReadOnly {
 		stats.deletes.Add(1)
@@ -35,27 +41,31 @@ func (db *DB) Delete(key []byte) error {
 		return ErrReadOnly
 	}
 
+	err := f.deleteLocked(key)
+	stats.deletes.Add(1)
+	stats.writeLatency.add(time.Since(start))
+	return err
+}
+
+// deleteLocked writes a delete record for key and applies it.
+func (f *Family) deleteLocked(key []byte) error {
+	db := f.db
 	record := wal.WALRecord{
 		Type:      wal.RecordDelete,
-		Timestamp: time.Now().UnixNano(),
+		Timestamp: db.now(),
 		ExpiresAt: -1,
 		Key:       append([]byte(nil), key...),
+		Family:    f.id,
 	}
 	if err := db.wal.AppendRecord(record); err != nil {
-		stats.deletes.Add(1)
-		stats.writeLatency.add(time.Since(start))
 		return err
 	}
 	if db.opts.SyncMode == SyncAlways {
-		if err := db.wal.Sync(); err != nil {
-			stats.deletes.Add(1)
-			stats.writeLatency.add(time.Since(start))
+		if err := db.syncWAL(); err != nil {
 			return err
 		}
 	}
-
-	db.index.Delete(string(key))
-	stats.deletes.Add(1)
-	stats.writeLatency.add(time.Since(start))
+	f.index.Delete(string(key))
+	f.markDirtyLocked(record)
 	return nil
 }
@@ -0,0 +1,100 @@
+package minikv
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+// DeleteRange removes every key in [start, end).
+func (db *DB) DeleteRange(start, end []byte) error {
+	return db.def.DeleteRange(start, end)
+}
+
+// DeleteRange removes every key in [start, end) from the family, or every key
+// from start on when end is empty. It writes a single WAL record whatever the
+// number of keys removed, and readers see either all of them or none.
+// Collections whose key is in the range are removed whole.
+func (f *Family) DeleteRange(start, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	return f.deleteRange(start, end)
+}
+
+// DeletePrefix removes every key starting with prefix.
+func (db *DB) DeletePrefix(prefix []byte) error {
+	return db.def.DeletePrefix(prefix)
+}
+
+// DeletePrefix removes every key starting with prefix from the family, as a
+// DeleteRange over the keys with that prefix. An empty prefix removes every key.
+func (f *Family) DeletePrefix(prefix []byte) error {
+	return f.deleteRange(prefix, prefixEnd(prefix))
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (f *Family) deleteRange(start, end []byte) error {
+	db := f.db
+	stats := db.statsOrInit()
+	begin := time.Now()
+	if len(start) > f.opts.MaxKeySize || len(end) > f.opts.MaxKeySize {
+		stats.deletes.Add(1)
+		stats.writeLatency.add(time.Since(begin))
+		return ErrKeyTooLarge
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := f.unavailableLocked(); err != nil {
+		stats.deletes.Add(1)
+		stats.writeLatency.add(time.Since(begin))
+		return err
+	}
+	if db.opts.ReadOnly {
+		stats.deletes.Add(1)
+		stats.writeLatency.add(time.Since(begin))
+		return ErrReadOnly
+	}
+
+	record := wal.WALRecord{
+		Type:      wal.RecordDeleteRange,
+		Timestamp: db.now(),
+		ExpiresAt: -1,
+		Key:       append([]byte(nil), start...),
+		Value:     append([]byte(nil), end...),
+		Family:    f.id,
+	}
+	if err := db.wal.AppendRecord(record); err != nil {
+		stats.deletes.Add(1)
+		stats.writeLatency.add(time.Since(begin))
+		return err
+	}
+	if db.opts.SyncMode == SyncAlways {
+		if err := db.syncWAL(); err != nil {
+			stats.deletes.Add(1)
+			stats.writeLatency.add(time.Since(begin))
+			return err
+		}
+	}
+
+	removed := f.index.DeleteRange(string(start), string(end))
+	f.markRangeDirtyLocked(record, removed)
+	stats.deletes.Add(1)
+	stats.writeLatency.add(time.Since(begin))
+	return nil
+}
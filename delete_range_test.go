@@ -0,0 +1,108 @@
+package minikv
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	cases := []struct {
+		prefix, end []byte
+	}{
+		{[]byte("a"), []byte("b")},
+		{[]byte("tenant:"), []byte("tenant;")},
+		{[]byte{'a', 0xff}, []byte("b")},
+		{[]byte{0xff, 0xff}, nil},
+		{nil, nil},
+	}
+	for _, c := range cases {
+		if got := prefixEnd(c.prefix); !bytes.Equal(got, c.end) {
+			t.Fatalf("prefixEnd(%q) = %q, want %q", c.prefix, got, c.end)
+		}
+	}
+}
+
+func TestDeleteRangeAndPrefix(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5", "tenant:a:1", "tenant:a:2", "tenant:ab", "tenant:b:1"} {
+		_ = db.Set([]byte(key), []byte("v"))
+	}
+	_ = db.HSet([]byte("tenant:a:hash"), []byte("f"), []byte("v"))
+
+	if err := db.DeletePrefix([]byte("tenant:a:")); err != nil {
+		t.Fatalf("delete prefix: %v", err)
+	}
+	if err := db.DeleteRange([]byte("k2"), []byte("k4")); err != nil {
+		t.Fatalf("delete range: %v", err)
+	}
+	if err := db.DeleteRange([]byte("k5"), []byte("k1")); err != nil {
+		t.Fatalf("expected an empty range to be a no-op, got %v", err)
+	}
+	want := "k1,k4,k5,tenant:ab,tenant:b:1"
+	check := func() {
+		t.Helper()
+		keys, err := db.Keys("*")
+		if err != nil {
+			t.Fatalf("keys: %v", err)
+		}
+		if got := joinKeys(keys); got != want {
+			t.Fatalf("got keys %q, want %q", got, want)
+		}
+	}
+	check()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if records := walRecordsOfType(t, dir, wal.RecordDeleteRange); len(records) != 2 {
+		t.Fatalf("expected one WAL record per range delete, got %d", len(records))
+	}
+
+	db = openTestDB(t, dir)
+	check()
+	if n, _ := db.HLen([]byte("tenant:a:hash")); n != 0 {
+		t.Fatalf("expected the hash in the prefix to be removed, got %d fields", n)
+	}
+	if err := db.DeletePrefix(nil); err != nil {
+		t.Fatalf("delete all: %v", err)
+	}
+	want = ""
+	check()
+	_ = db.Close()
+}
+
+func TestDeleteRangeIsRecordedInDeltaSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	for i := 0; i < 20; i++ {
+		_ = db.Set([]byte("user:"+intToString(i)), []byte("v"))
+	}
+	_ = db.Set([]byte("keep"), []byte("v"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.DeletePrefix([]byte("user:")); err != nil {
+		t.Fatalf("delete prefix: %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if n, _ := db.Count(); n != 1 {
+		t.Fatalf("expected only keep after reopen, got %d keys", n)
+	}
+}
+
+func joinKeys(keys []string) string {
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
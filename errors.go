@@ -1,17 +1,38 @@
 package minikv
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
-	ErrNotFound      = errors.New("minikv: not found")
-	ErrKeyTooLarge   = errors.New("minikv: key too large")
-	ErrValueTooLarge = errors.New("minikv: value too large")
-	ErrBatchTooBig   = errors.New("minikv: batch too big")
-	ErrReadOnly      = errors.New("minikv: read-only")
-	ErrClosed        = errors.New("minikv: db closed")
-	ErrInvalidValue  = errors.New("minikv: invalid value")
-	ErrCorruptWAL    = errors.New("minikv: corrupt wal")
-	ErrLocked        = errors.New("minikv: database locked")
+	ErrNotFound        = errors.New("minikv: not found")
+	ErrKeyTooLarge     = errors.New("minikv: key too large")
+	ErrValueTooLarge   = errors.New("minikv: value too large")
+	ErrBatchTooBig     = errors.New("minikv: batch too big")
+	ErrReadOnly        = errors.New("minikv: read-only")
+	ErrClosed          = errors.New("minikv: db closed")
+	ErrInvalidValue    = errors.New("minikv: invalid value")
+	ErrCorruptWAL      = errors.New("minikv: corrupt wal")
+	ErrLocked          = errors.New("minikv: database locked")
+	ErrNoMergeOperator = errors.New("minikv: no merge operator configured")
+	ErrWrongType       = errors.New("minikv: operation against a key holding the wrong kind of value")
+	ErrCorruptVLog     = errors.New("minikv: corrupt value log")
+	ErrMemoryLimit     = errors.New("minikv: memory limit reached")
+	ErrLockNotHeld     = errors.New("minikv: lock not held")
+	ErrInvalidExport   = errors.New("minikv: invalid export data")
+	ErrKeyOrder        = errors.New("minikv: keys not in ascending order")
+
+	ErrFamilyExists   = errors.New("minikv: column family already exists")
+	ErrFamilyNotFound = errors.New("minikv: column family not found")
+	ErrInvalidFamily  = errors.New("minikv: invalid column family")
+
+	ErrIndexExists   = errors.New("minikv: index already exists")
+	ErrIndexNotFound = errors.New("minikv: index not found")
+	ErrInvalidIndex  = errors.New("minikv: invalid index")
+
+	ErrUpgradeRequired   = errors.New("minikv: data directory uses an older format; run Upgrade")
+	ErrUnsupportedFormat = errors.New("minikv: data directory uses an unsupported format")
 )
 
 const (
@@ -19,4 +40,13 @@ const (
 	MaxValueSize = 10 * 1024 * 1024
 	MaxBatchSize = 100 * 1024 * 1024
 	MaxWALSize   = 256 * 1024 * 1024
+
+	MaxSnapshotDeltas = 8
+	SnapshotKeepLast  = 2
+
+	ValueLogFileSize = 256 * 1024 * 1024
+	ValueLogGCRatio  = 0.5
+
+	TTLSweepInterval = time.Second
+	TTLSweepBudget   = 10000
 )
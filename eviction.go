@@ -0,0 +1,176 @@
+package minikv
+
+import (
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+// evictionSamples is how many keys each family offers as LRU or LFU
+// eviction candidates; the worst of them is evicted.
+const evictionSamples = 16
+
+// lfuDecayPeriod is how long a key must go unread for its read count to
+// halve when ranking LFU candidates.
+const lfuDecayPeriod = time.Minute
+
+// tracksAccess reports whether eviction ranks keys by their reads, which
+// the indexes then record.
+func (db *DB) tracksAccess() bool {
+	policy := db.opts.EvictionPolicy
+	return db.opts.MaxMemoryBytes > 0 && (policy == EvictLRU || policy == EvictLFU)
+}
+
+// prefers reports whether a is a better eviction candidate than b at now.
+func (p EvictionPolicy) prefers(a, b index.Sample, now int64) bool {
+	switch p {
+	case EvictLFU:
+		fa, fb := decayedHits(a, now), decayedHits(b, now)
+		if fa != fb {
+			return fa < fb
+		}
+		return a.LastAccess < b.LastAccess
+	case EvictVolatileTTL:
+		return a.ExpiresAt < b.ExpiresAt
+	}
+	return a.LastAccess < b.LastAccess
+}
+
+func decayedHits(s index.Sample, now int64) uint32 {
+	periods := (now - s.LastAccess) / int64(lfuDecayPeriod)
+	if periods >= 32 {
+		return 0
+	}
+	if periods < 0 {
+		periods = 0
+	}
+	return s.Hits >> uint(periods)
+}
+
+// memoryUsageLocked returns the estimated in-memory size of every family,
+// including the hidden ones holding collection members.
+func (db *DB) memoryUsageLocked() int64 {
+	var usage int64
+	for _, f := range db.families {
+		usage += f.index.Size()
+	}
+	return usage
+}
+
+// checkMemoryLocked returns ErrMemoryLimit if the database is past
+// MaxMemoryBytes. Writes evict on their way out, so this only fails once
+// nothing is left to evict or with NoEviction.
+func (db *DB) checkMemoryLocked() error {
+	if db.opts.MaxMemoryBytes <= 0 || db.memoryUsageLocked() <= db.opts.MaxMemoryBytes {
+		return nil
+	}
+	return ErrMemoryLimit
+}
+
+// evictLocked removes keys picked by the eviction policy until the database
+// fits in MaxMemoryBytes or there is nothing left to evict. Expired keys are
+// reaped first, since removing them costs nothing. It runs after a write has
+// been applied, never between a write's reads and its records.
+func (db *DB) evictLocked() {
+	limit := db.opts.MaxMemoryBytes
+	if limit <= 0 || db.opts.EvictionPolicy == NoEviction || db.opts.ReadOnly {
+		return
+	}
+	if db.memoryUsageLocked() <= limit {
+		return
+	}
+	now := db.now()
+	for _, f := range db.families {
+		f.index.ExpireDue(now, 0)
+	}
+	// Candidates found expired while evicting are reaped rather than evicted.
+	defer db.writeReapedLocked()
+
+	for db.memoryUsageLocked() > limit {
+		f, key, ok := db.evictionCandidateLocked(now)
+		if !ok {
+			return
+		}
+		if err := f.evictLocked(key); err != nil {
+			return
+		}
+	}
+}
+
+// evictionCandidateLocked returns the key the eviction policy removes next.
+// Only user-visible keys are candidates; a collection is evicted through its
+// metadata entry.
+func (db *DB) evictionCandidateLocked(now int64) (*Family, string, bool) {
+	policy := db.opts.EvictionPolicy
+	var best *Family
+	var bestSample index.Sample
+	for _, f := range db.families {
+		if f.parent != nil {
+			continue
+		}
+		var samples []index.Sample
+		if policy == EvictVolatileTTL {
+			if sample, ok := f.index.NextExpiry(); ok {
+				samples = append(samples, sample)
+			}
+		} else {
+			samples = f.index.SampleKeys(evictionSamples)
+		}
+		for _, sample := range samples {
+			if best == nil || policy.prefers(sample, bestSample, now) {
+				best, bestSample = f, sample
+			}
+		}
+	}
+	return best, bestSample.Key, best != nil
+}
+
+// evictLocked removes key and, for a collection, its members, writing the
+// removal to the WAL as a delete.
+func (f *Family) evictLocked(key string) error {
+	db := f.db
+	entry, ok := f.index.Get(key)
+	if !ok {
+		return nil
+	}
+	record := wal.WALRecord{
+		Type:      wal.RecordDelete,
+		Timestamp: db.now(),
+		ExpiresAt: -1,
+		Key:       []byte(key),
+		Family:    f.id,
+	}
+	if err := db.wal.AppendRecord(record); err != nil {
+		return err
+	}
+	if db.opts.SyncMode == SyncAlways {
+		if err := db.syncWAL(); err != nil {
+			return err
+		}
+	}
+	f.index.Delete(key)
+	f.markDirtyLocked(record)
+	if entry.Meta {
+		f.dropMembersLocked(key, entry)
+	}
+	db.statsOrInit().evictions.Add(1)
+	return nil
+}
+
+// dropMembersLocked removes the members of the collection at key from memory
+// right away instead of leaving them to the next compaction. They are marked
+// dirty so a delta snapshot records their removal; until then, replay brings
+// them back as orphans for compaction to collect.
+func (f *Family) dropMembersLocked(key string, entry *index.Entry) {
+	meta, err := decodeMeta(entry.Value)
+	if err != nil || f.sub == nil {
+		return
+	}
+	sub := f.sub
+	prefix := string(memberPrefix([]byte(key), meta.version))
+	for _, member := range sub.index.Scan(prefix, 0) {
+		sub.index.Delete(string(member.Key))
+		sub.dirty[string(member.Key)] = struct{}{}
+	}
+}
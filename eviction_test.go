@@ -0,0 +1,176 @@
+package minikv
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// limitMemory caps memory at nine keys written by fillKeys, evicting by policy.
+func limitMemory(policy EvictionPolicy) func(opts *Options) {
+	return func(opts *Options) {
+		opts.MaxMemoryBytes = 1000
+		opts.EvictionPolicy = policy
+	}
+}
+
+// fillKeys writes keys k<from>..k<to-1> with 100-byte values, 102 bytes each.
+func fillKeys(t *testing.T, db *DB, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := db.Set([]byte("k"+intToString(i)), bytes.Repeat([]byte("v"), 100)); err != nil {
+			t.Fatalf("set k%d: %v", i, err)
+		}
+	}
+}
+
+func expectKeys(t *testing.T, db *DB, present, evicted []string) {
+	t.Helper()
+	for _, key := range present {
+		if ok, _ := db.Exists([]byte(key)); !ok {
+			t.Fatalf("expected %s to be kept", key)
+		}
+	}
+	for _, key := range evicted {
+		if ok, _ := db.Exists([]byte(key)); ok {
+			t.Fatalf("expected %s to be evicted", key)
+		}
+	}
+}
+
+func TestEvictLRUSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, limitMemory(EvictLRU))
+	fillKeys(t, db, 0, 9)
+	if _, err := db.Get([]byte("k0")); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	fillKeys(t, db, 9, 10)
+	expectKeys(t, db, []string{"k0", "k2", "k9"}, []string{"k1"})
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Evictions != 1 || stats.MemoryBytes > 1000 {
+		t.Fatalf("expected one eviction within the limit, got %d evictions and %d bytes", stats.Evictions, stats.MemoryBytes)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, limitMemory(EvictLRU))
+	defer db.Close()
+	expectKeys(t, db, []string{"k0", "k2", "k9"}, []string{"k1"})
+}
+
+func TestEvictLFUKeepsFrequentlyReadKeys(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), limitMemory(EvictLFU))
+	defer db.Close()
+	fillKeys(t, db, 0, 9)
+	for i := 0; i < 9; i++ {
+		if i == 4 {
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			_, _ = db.Get([]byte("k" + intToString(i)))
+		}
+	}
+	fillKeys(t, db, 9, 10)
+	expectKeys(t, db, []string{"k0", "k8", "k9"}, []string{"k4"})
+}
+
+func TestEvictVolatileTTLOnlyEvictsKeysWithTTL(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), limitMemory(EvictVolatileTTL))
+	defer db.Close()
+	fillKeys(t, db, 0, 7)
+	value := bytes.Repeat([]byte("v"), 100)
+	_ = db.SetWithTTL([]byte("t1"), value, 2*time.Hour)
+	_ = db.SetWithTTL([]byte("t2"), value, time.Hour)
+	fillKeys(t, db, 7, 8)
+	expectKeys(t, db, []string{"k0", "k7", "t1"}, []string{"t2"})
+
+	fillKeys(t, db, 8, 10)
+	expectKeys(t, db, []string{"k0", "k9"}, []string{"t1"})
+	if err := db.Set([]byte("k10"), value); err != ErrMemoryLimit {
+		t.Fatalf("expected ErrMemoryLimit with no keys to evict, got %v", err)
+	}
+	if err := db.Delete([]byte("k0")); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := db.Set([]byte("k10"), value); err != nil {
+		t.Fatalf("expected a write to fit after a delete, got %v", err)
+	}
+}
+
+func TestNoEvictionRejectsWrites(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), limitMemory(NoEviction))
+	defer db.Close()
+	fillKeys(t, db, 0, 10)
+	if err := db.Set([]byte("k10"), []byte("v")); err != ErrMemoryLimit {
+		t.Fatalf("expected ErrMemoryLimit, got %v", err)
+	}
+	if err := db.HSet([]byte("h"), []byte("f"), []byte("v")); err != ErrMemoryLimit {
+		t.Fatalf("expected ErrMemoryLimit from a collection write, got %v", err)
+	}
+	batch := db.NewBatch()
+	batch.Delete([]byte("k9"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("expected a delete-only batch to succeed, got %v", err)
+	}
+	if err := db.Set([]byte("k10"), []byte("v")); err != nil {
+		t.Fatalf("expected a write to fit after a delete, got %v", err)
+	}
+	if stats, _ := db.Stats(); stats.Evictions != 0 {
+		t.Fatalf("expected no evictions, got %d", stats.Evictions)
+	}
+}
+
+func TestEvictionRemovesCollectionsWhole(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, limitMemory(EvictLRU))
+	for i := 0; i < 5; i++ {
+		if _, err := db.SAdd([]byte("set"), bytes.Repeat([]byte{byte('a' + i)}, 60)); err != nil {
+			t.Fatalf("sadd: %v", err)
+		}
+	}
+	fillKeys(t, db, 0, 8)
+	expectKeys(t, db, []string{"k0", "k7"}, []string{"set"})
+	if n := db.def.sub.index.Len(); n != 0 {
+		t.Fatalf("expected evicted members to be dropped, got %d", n)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, limitMemory(EvictLRU))
+	defer db.Close()
+	if n, _ := db.SCard([]byte("set")); n != 0 {
+		t.Fatalf("expected evicted set to stay gone, got %d members", n)
+	}
+	if n := db.def.sub.index.Len(); n != 0 {
+		t.Fatalf("expected no members after reopen, got %d", n)
+	}
+}
+
+func TestOpenEvictsWhenLimitLowered(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	fillKeys(t, db, 0, 12)
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, limitMemory(EvictLRU))
+	defer db.Close()
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.KeyCount != 9 || stats.Evictions != 3 {
+		t.Fatalf("expected 3 evictions leaving 9 keys, got %d and %d", stats.Evictions, stats.KeyCount)
+	}
+}
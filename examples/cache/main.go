@@ -8,7 +8,10 @@ import (
 )
 
 func main() {
-	db, err := minikv.Open(minikv.DefaultOptions("./cache"))
+	opts := minikv.DefaultOptions("./cache")
+	opts.MaxMemoryBytes = 64 << 20
+	opts.EvictionPolicy = minikv.EvictLRU
+	db, err := minikv.Open(opts)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -20,4 +23,7 @@ func main() {
 	if ttl, err := db.TTL([]byte("token")); err == nil {
 		log.Printf("ttl: %s", ttl)
 	}
+	if stats, err := db.Stats(); err == nil {
+		log.Printf("memory: %d bytes, evictions: %d", stats.MemoryBytes, stats.Evictions)
+	}
 }
@@ -4,9 +4,15 @@ import "time"
 
 // Exists reports whether a key exists and is not expired.
 func (db *DB) Exists(key []byte) (bool, error) {
+	return db.def.Exists(key)
+}
+
+// Exists reports whether a key exists in the family and is not expired.
+func (f *Family) Exists(key []byte) (bool, error) {
+	db := f.db
 	stats := db.statsOrInit()
 	start := time.Now()
-	if len(key) > db.opts.MaxKeySize {
+	if len(key) > f.opts.MaxKeySize {
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
 		return false, ErrKeyTooLarge
@@ -18,13 +24,13 @@ func (db *DB) Exists(key []byte) (bool, error) {
 	}
 
 	db.mu.RLock()
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		db.mu.RUnlock()
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
-		return false, ErrClosed
+		return false, err
 	}
-	_, ok := db.index.Get(string(key))
+	_, ok := f.index.Get(string(key))
 	db.mu.RUnlock()
 	stats.reads.Add(1)
 	stats.readLatency.add(time.Since(start))
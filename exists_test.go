@@ -8,7 +8,7 @@ import (
 )
 
 func TestExistsKeyTooLarge(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp"), index: index.NewMemIndex()}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
 	key := make([]byte, db.opts.MaxKeySize+1)
 	if _, err := db.Exists(key); err != ErrKeyTooLarge {
 		t.Fatalf("expected ErrKeyTooLarge, got %v", err)
@@ -16,14 +16,15 @@ func TestExistsKeyTooLarge(t *testing.T) {
 }
 
 func TestExistsClosed(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp"), index: index.NewMemIndex(), closed: true}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
+	db.closed = true
 	if _, err := db.Exists([]byte("k")); err != ErrClosed {
 		t.Fatalf("expected ErrClosed, got %v", err)
 	}
 }
 
 func TestExistsReportsState(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp"), index: index.NewMemIndex()}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
 	key := []byte("k")
 
 	ok, err := db.Exists(key)
@@ -34,7 +35,7 @@ func TestExistsReportsState(t *testing.T) {
 		t.Fatalf("expected missing key")
 	}
 
-	db.index.SetEntry(string(key), []byte("v"), -1, time.Now().UnixNano())
+	db.def.index.SetEntry(string(key), []byte("v"), -1, time.Now().UnixNano())
 	ok, err = db.Exists(key)
 	if err != nil {
 		t.Fatalf("exists: %v", err)
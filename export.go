@@ -0,0 +1,319 @@
+package minikv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// ExportFormat selects the encoding used by Export and Import.
+type ExportFormat int
+
+const (
+	// ExportJSONLines writes one JSON object per line with the key and value
+	// in base64, so any bytes round-trip:
+	//
+	//	{"key":"a2V5","value":"dmFsdWU=","expires_at":"...","created_at":"..."}
+	//
+	// expires_at is left out for keys without a TTL. Times are RFC 3339.
+	ExportJSONLines ExportFormat = iota + 1
+	// ExportCSV writes a header row and then key,value,expires_at,created_at
+	// rows with the key and value as text, for data that is UTF-8 strings.
+	// expires_at is empty for keys without a TTL.
+	ExportCSV
+)
+
+// exportPageSize is how many keys Export reads under one lock.
+const exportPageSize = 256
+
+// importBatchOps is how many pairs Import writes per batch.
+const importBatchOps = 1000
+
+var csvHeader = []string{"key", "value", "expires_at", "created_at"}
+
+// exportRecord is one exported pair. ExpiresAt is -1 for no TTL.
+type exportRecord struct {
+	key       []byte
+	value     []byte
+	expiresAt int64
+	createdAt int64
+}
+
+type jsonRecord struct {
+	Key       []byte     `json:"key"`
+	Value     []byte     `json:"value"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Export writes the live key/value pairs whose key starts with any of
+// prefixes to w, or every pair with no prefixes.
+func (db *DB) Export(w io.Writer, format ExportFormat, prefixes ...[]byte) error {
+	return db.def.Export(w, format, prefixes...)
+}
+
+// Export writes the family's live key/value pairs whose key starts with any
+// of prefixes to w in key order, or every pair with no prefixes. The
+// matching keys are listed and sorted once; values are then read a page of
+// keys at a time, so only one page of values is held in memory. The export
+// is consistent per page, not a point-in-time copy: keys set after it starts
+// are left out and keys deleted since are skipped.
+// Collections such as hashes are left out. ExportCSV fails with
+// ErrInvalidExport on a key or value that is not UTF-8.
+func (f *Family) Export(w io.Writer, format ExportFormat, prefixes ...[]byte) error {
+	write, flush, err := newExportWriter(w, format)
+	if err != nil {
+		return err
+	}
+	db := f.db
+	filter := prefixStrings(prefixes)
+	db.mu.RLock()
+	if err := f.unavailableLocked(); err != nil {
+		db.mu.RUnlock()
+		return err
+	}
+	keys := f.index.SortedKeys(filter...)
+	db.mu.RUnlock()
+
+	records := make([]exportRecord, 0, exportPageSize)
+	for len(keys) > 0 {
+		page := keys
+		if len(page) > exportPageSize {
+			page = page[:exportPageSize]
+		}
+		keys = keys[len(page):]
+		records = records[:0]
+		db.mu.RLock()
+		if err := f.unavailableLocked(); err != nil {
+			db.mu.RUnlock()
+			return err
+		}
+		for _, entry := range f.index.Lookup(page) {
+			if entry.Entry.Meta {
+				continue
+			}
+			value, err := db.entryValue(string(entry.Key), &entry.Entry)
+			if err != nil {
+				db.mu.RUnlock()
+				return err
+			}
+			records = append(records, exportRecord{
+				key:       entry.Key,
+				value:     value,
+				expiresAt: entry.Entry.ExpiresAt,
+				createdAt: entry.Entry.CreatedAt,
+			})
+		}
+		db.mu.RUnlock()
+
+		for _, record := range records {
+			if err := write(record); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// Import reads pairs written by Export from r and sets those whose key
+// starts with any of prefixes, or every pair with no prefixes.
+func (db *DB) Import(r io.Reader, format ExportFormat, prefixes ...[]byte) error {
+	return db.def.Import(r, format, prefixes...)
+}
+
+// Import reads pairs written by Export from r and sets those whose key
+// starts with any of prefixes in the family, or every pair with no
+// prefixes. Pairs keep their expiry and CreatedAt; pairs already expired are
+// skipped. Input is read one record at a time and written in batches, each
+// atomic on its own, so a failed import may have applied earlier batches.
+// Malformed input fails with ErrInvalidExport.
+func (f *Family) Import(r io.Reader, format ExportFormat, prefixes ...[]byte) error {
+	read, err := newImportReader(r, format)
+	if err != nil {
+		return err
+	}
+	db := f.db
+	batch := &batchImpl{db: db}
+	flush := func() error {
+		if len(batch.opList) == 0 {
+			return nil
+		}
+		err := batch.Write()
+		batch = &batchImpl{db: db}
+		return err
+	}
+	for {
+		record, err := read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !hasAnyPrefix(record.key, prefixes) {
+			continue
+		}
+		if record.expiresAt >= 0 && record.expiresAt <= db.now() {
+			continue
+		}
+		size := int64(len(record.key) + len(record.value))
+		if len(batch.opList) >= importBatchOps || batch.size+size > int64(db.opts.MaxBatchSize) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		before := len(batch.opList)
+		batch.addOp(f, batchSet, record.key, record.value, record.expiresAt)
+		if batch.err != nil {
+			return batch.err
+		}
+		if len(batch.opList) > before {
+			batch.opList[before].createdAt = record.createdAt
+		}
+	}
+	return flush()
+}
+
+func newExportWriter(w io.Writer, format ExportFormat) (func(exportRecord) error, func() error, error) {
+	switch format {
+	case ExportJSONLines:
+		buf := bufio.NewWriter(w)
+		enc := json.NewEncoder(buf)
+		write := func(record exportRecord) error {
+			out := jsonRecord{Key: record.key, Value: record.value, CreatedAt: time.Unix(0, record.createdAt).UTC()}
+			if record.expiresAt >= 0 {
+				expiresAt := time.Unix(0, record.expiresAt).UTC()
+				out.ExpiresAt = &expiresAt
+			}
+			return enc.Encode(out)
+		}
+		return write, buf.Flush, nil
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, nil, err
+		}
+		write := func(record exportRecord) error {
+			if !utf8.Valid(record.key) || !utf8.Valid(record.value) {
+				return fmt.Errorf("%w: %q is not UTF-8 text", ErrInvalidExport, record.key)
+			}
+			expiresAt := ""
+			if record.expiresAt >= 0 {
+				expiresAt = formatExportTime(record.expiresAt)
+			}
+			return cw.Write([]string{string(record.key), string(record.value), expiresAt, formatExportTime(record.createdAt)})
+		}
+		flush := func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		return write, flush, nil
+	}
+	return nil, nil, fmt.Errorf("%w: unknown format %d", ErrInvalidExport, format)
+}
+
+func newImportReader(r io.Reader, format ExportFormat) (func() (exportRecord, error), error) {
+	switch format {
+	case ExportJSONLines:
+		dec := json.NewDecoder(bufio.NewReader(r))
+		read := func() (exportRecord, error) {
+			var in jsonRecord
+			if err := dec.Decode(&in); err != nil {
+				if err == io.EOF {
+					return exportRecord{}, err
+				}
+				return exportRecord{}, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+			}
+			record := exportRecord{key: in.Key, value: in.Value, expiresAt: -1}
+			if in.ExpiresAt != nil {
+				record.expiresAt = in.ExpiresAt.UnixNano()
+			}
+			if !in.CreatedAt.IsZero() {
+				record.createdAt = in.CreatedAt.UnixNano()
+			}
+			return record, nil
+		}
+		return read, nil
+	case ExportCSV:
+		cr := csv.NewReader(bufio.NewReader(r))
+		cr.FieldsPerRecord = len(csvHeader)
+		cr.ReuseRecord = true
+		first := true
+		var read func() (exportRecord, error)
+		read = func() (exportRecord, error) {
+			row, err := cr.Read()
+			if err != nil {
+				if err == io.EOF {
+					return exportRecord{}, err
+				}
+				return exportRecord{}, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+			}
+			if first {
+				first = false
+				if isCSVHeader(row) {
+					return read()
+				}
+			}
+			record := exportRecord{key: []byte(row[0]), value: []byte(row[1]), expiresAt: -1}
+			if row[2] != "" {
+				if record.expiresAt, err = parseExportTime(row[2]); err != nil {
+					return exportRecord{}, err
+				}
+			}
+			if row[3] != "" {
+				if record.createdAt, err = parseExportTime(row[3]); err != nil {
+					return exportRecord{}, err
+				}
+			}
+			return record, nil
+		}
+		return read, nil
+	}
+	return nil, fmt.Errorf("%w: unknown format %d", ErrInvalidExport, format)
+}
+
+func isCSVHeader(row []string) bool {
+	for i, name := range csvHeader {
+		if row[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+func formatExportTime(ns int64) string {
+	return time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+}
+
+func parseExportTime(value string) (int64, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+	return t.UnixNano(), nil
+}
+
+func prefixStrings(prefixes [][]byte) []string {
+	out := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		out[i] = string(prefix)
+	}
+	return out
+}
+
+func hasAnyPrefix(key []byte, prefixes [][]byte) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
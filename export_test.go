@@ -0,0 +1,180 @@
+package minikv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImportJSONLinesRoundTrip(t *testing.T) {
+	clock := NewFakeClock(fakeEpoch)
+	src := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Clock = clock })
+	defer src.Close()
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+	_ = src.Set([]byte("bin\x00key"), binary)
+	_ = src.SetWithTTL([]byte("user:1"), []byte("alice"), time.Hour)
+	_ = src.Set([]byte("user:2"), []byte("bob"))
+	_ = src.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	clock.Advance(time.Minute)
+
+	var exported bytes.Buffer
+	if err := src.Export(&exported, ExportJSONLines); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if lines := strings.Count(exported.String(), "\n"); lines != 3 {
+		t.Fatalf("expected 3 lines without the hash, got %d:\n%s", lines, exported.String())
+	}
+
+	dir := t.TempDir()
+	dst := openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+	if err := dst.Import(bytes.NewReader(exported.Bytes()), ExportJSONLines); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if value, _ := dst.Get([]byte("bin\x00key")); !bytes.Equal(value, binary) {
+		t.Fatalf("expected binary value to round-trip, got %v", value)
+	}
+	if remaining, _ := dst.TTL([]byte("user:1")); remaining != 59*time.Minute {
+		t.Fatalf("expected the expiry kept, got %v", remaining)
+	}
+	if n, _ := dst.HLen([]byte("hash")); n != 0 {
+		t.Fatalf("expected collections left out, got %d fields", n)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// CreatedAt survives the import and WAL replay, so a second export
+	// matches the first byte for byte.
+	dst = openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+	defer dst.Close()
+	var again bytes.Buffer
+	if err := dst.Export(&again, ExportJSONLines); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if again.String() != exported.String() {
+		t.Fatalf("expected identical exports, got\n%s\nwant\n%s", again.String(), exported.String())
+	}
+}
+
+func TestExportImportPrefixFilters(t *testing.T) {
+	src := openTestDB(t, t.TempDir())
+	defer src.Close()
+	for _, key := range []string{"a:1", "a:2", "b:1", "c:1"} {
+		_ = src.Set([]byte(key), []byte("v"))
+	}
+	var exported bytes.Buffer
+	if err := src.Export(&exported, ExportJSONLines, []byte("a:"), []byte("c:")); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	dst := openTestDB(t, t.TempDir())
+	defer dst.Close()
+	if err := dst.Import(&exported, ExportJSONLines, []byte("a:")); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	keys, _ := dst.Keys("*")
+	if got := joinKeys(keys); got != "a:1,a:2" {
+		t.Fatalf("unexpected imported keys %q", got)
+	}
+}
+
+func TestExportImportCSV(t *testing.T) {
+	clock := NewFakeClock(fakeEpoch)
+	src := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Clock = clock })
+	defer src.Close()
+	_ = src.Set([]byte("quote"), []byte("say \"hi\", then\nleave"))
+	_ = src.SetWithTTL([]byte("session"), []byte("s"), time.Minute)
+	var exported bytes.Buffer
+	if err := src.Export(&exported, ExportCSV); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if !strings.HasPrefix(exported.String(), "key,value,expires_at,created_at\n") {
+		t.Fatalf("expected a header row, got %q", exported.String())
+	}
+
+	dst := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Clock = clock })
+	defer dst.Close()
+	if err := dst.Import(bytes.NewReader(exported.Bytes()), ExportCSV); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if value, _ := dst.Get([]byte("quote")); string(value) != "say \"hi\", then\nleave" {
+		t.Fatalf("unexpected value %q", value)
+	}
+	if remaining, _ := dst.TTL([]byte("session")); remaining != time.Minute {
+		t.Fatalf("expected the expiry kept, got %v", remaining)
+	}
+
+	// Expired pairs are skipped on import.
+	clock.Advance(time.Minute)
+	_ = dst.Delete([]byte("quote"))
+	if err := dst.Import(bytes.NewReader(exported.Bytes()), ExportCSV); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if n, _ := dst.Count(); n != 1 {
+		t.Fatalf("expected only the unexpired pair, got %d keys", n)
+	}
+
+	_ = src.Set([]byte("bin"), []byte{0xff, 0xfe})
+	if err := src.Export(&bytes.Buffer{}, ExportCSV); !errors.Is(err, ErrInvalidExport) {
+		t.Fatalf("expected ErrInvalidExport for binary data, got %v", err)
+	}
+	if err := dst.Import(strings.NewReader("k,v\n"), ExportCSV); !errors.Is(err, ErrInvalidExport) {
+		t.Fatalf("expected ErrInvalidExport for a short row, got %v", err)
+	}
+	if err := dst.Import(strings.NewReader("{\"key\": 1}\n"), ExportJSONLines); !errors.Is(err, ErrInvalidExport) {
+		t.Fatalf("expected ErrInvalidExport for a malformed line, got %v", err)
+	}
+	if err := dst.Export(&bytes.Buffer{}, ExportFormat(9)); !errors.Is(err, ErrInvalidExport) {
+		t.Fatalf("expected ErrInvalidExport for an unknown format, got %v", err)
+	}
+}
+
+func TestImportWritesInBatches(t *testing.T) {
+	src := openTestDB(t, t.TempDir())
+	defer src.Close()
+	const n = 2*importBatchOps + 10
+	for i := 0; i < n; i++ {
+		_ = src.Set([]byte("k"+intToString(i)), []byte("v"))
+	}
+	var exported bytes.Buffer
+	if err := src.Export(&exported, ExportJSONLines); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	dst := openTestDB(t, t.TempDir(), func(opts *Options) { opts.MaxBatchSize = 64 * 100 })
+	defer dst.Close()
+	if err := dst.Import(&exported, ExportJSONLines); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if count, _ := dst.Count(); count != n {
+		t.Fatalf("expected %d keys, got %d", n, count)
+	}
+}
+
+func TestExportPagesThroughKeys(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	const n = 3*exportPageSize + 7
+	for i := 0; i < n; i++ {
+		_ = db.Set([]byte("k:"+padInt(i)), []byte(intToString(i)))
+	}
+	_ = db.Set([]byte("other"), []byte("x"))
+	var exported bytes.Buffer
+	if err := db.Export(&exported, ExportCSV, []byte("k:")); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(exported.String(), "\n"), "\n")
+	if len(lines) != n+1 {
+		t.Fatalf("expected %d rows and a header, got %d lines", n, len(lines))
+	}
+	for i, line := range lines[1:] {
+		if want := "k:" + padInt(i) + "," + intToString(i) + ","; !strings.HasPrefix(line, want) {
+			t.Fatalf("row %d: got %q, want prefix %q", i, line, want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package minikv
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/manifest"
+)
+
+// DefaultFamily names the family behind the DB's own key-value methods.
+const DefaultFamily = "default"
+
+// familyOptionsVersion prefixes FamilyOptions stored in the MANIFEST.
+const familyOptionsVersion = 1
+
+// FamilyOptions configures a column family. Zero limits inherit the
+// database Options.
+type FamilyOptions struct {
+	// DefaultTTL expires keys written without an explicit TTL. Zero keeps them until deleted.
+	DefaultTTL   time.Duration
+	MaxKeySize   int
+	MaxValueSize int
+	// Compaction adds triggers evaluated against this family's writes and
+	// garbage. A triggered compaction still snapshots every family; IdleOnly
+	// and RateLimit only apply at the database level.
+	Compaction CompactionPolicy
+}
+
+// Family is a named keyspace with its own index, limits and TTL default.
+// Families share the database WAL, so a batch spanning families commits
+// atomically. Handles stay valid until the family is dropped, after which
+// every call returns ErrFamilyNotFound.
+type Family struct {
+	db    *DB
+	id    uint32
+	name  string
+	opts  FamilyOptions
+	index *index.MemIndex
+	// dirty holds keys changed since the last snapshot and walBytes the key
+	// and value bytes written since then. Both are guarded by db.mu.
+	dirty    map[string]struct{}
+	walBytes int64
+	dropped  bool
+
+	// secMu guards secondary and secPending, the keys changed since the
+	// secondary indexes were last brought up to date. It is taken inside the
+	// index lock by the index observer, so it must not be held while calling
+	// into the index.
+	secMu      sync.Mutex
+	secondary  map[string]*secondaryIndex
+	secPending map[string]struct{}
+	// secSync serializes syncSecondary from draining secPending until the
+	// drained keys are indexed, so no IndexScan returns before keys drained
+	// by a concurrent one are visible. It is taken before secMu.
+	secSync sync.Mutex
+
+	// sub is the hidden family holding this family's collection members and
+	// parent links it back. Both are guarded by db.mu.
+	sub    *Family
+	parent *Family
+	// collMu guards members, which groups a member family's keys by
+	// collection key and version, and recheck, the collection keys changed
+	// since orphans were last collected, set to true for those whose every
+	// member must be checked. Like secMu, it is taken inside the index lock
+	// by the index observer.
+	collMu  sync.Mutex
+	members map[string]map[uint64]map[string]struct{}
+	recheck map[string]bool
+}
+
+// newDB returns a DB with an empty family set whose default family uses idx.
+func newDB(opts Options, idx *index.MemIndex) *DB {
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	db := &DB{
+		path:     opts.Path,
+		opts:     opts,
+		stats:    newStatsTracker(),
+		openedAt: opts.Clock.Now(),
+		families: make(map[uint32]*Family),
+	}
+	db.def = db.addFamily(0, DefaultFamily, FamilyOptions{DefaultTTL: opts.DefaultTTL}, idx)
+	return db
+}
+
+// addFamily registers a family handle. Callers must hold db.mu or own db exclusively.
+func (db *DB) addFamily(id uint32, name string, opts FamilyOptions, idx *index.MemIndex) *Family {
+	if opts.MaxKeySize == 0 {
+		opts.MaxKeySize = db.opts.MaxKeySize
+	}
+	if opts.MaxValueSize == 0 {
+		opts.MaxValueSize = db.opts.MaxValueSize
+	}
+	f := &Family{
+		db:    db,
+		id:    id,
+		name:  name,
+		opts:  opts,
+		index: idx,
+		dirty: make(map[string]struct{}),
+	}
+	idx.SetObserver(f.observe)
+	idx.SetExpiryHook(f.reapedExpired)
+	idx.SetAccessTracking(db.tracksAccess())
+	idx.SetClock(db.now)
+	db.families[id] = f
+	return f
+}
+
+// loadFamilies registers the families recorded in man, using the index
+// built for each ID when there is one.
+func (db *DB) loadFamilies(man manifest.Manifest, indexes map[uint32]*index.MemIndex) error {
+	for _, info := range man.Families {
+		opts, err := decodeFamilyOptions(info.Options)
+		if err != nil {
+			return err
+		}
+		idx := indexes[info.ID]
+		if idx == nil {
+			idx = index.NewMemIndex()
+		}
+		db.addFamily(info.ID, info.Name, opts, idx)
+	}
+	return nil
+}
+
+// familyIndexes returns an empty index for the default family and every
+// family in man. Records of any other family ID belong to dropped families.
+func familyIndexes(man manifest.Manifest) map[uint32]*index.MemIndex {
+	indexes := map[uint32]*index.MemIndex{0: index.NewMemIndex()}
+	for _, info := range man.Families {
+		indexes[info.ID] = index.NewMemIndex()
+	}
+	return indexes
+}
+
+// CreateFamily creates a column family and returns its handle. The family
+// and its options are recorded in the MANIFEST and reopened with the database.
+func (db *DB) CreateFamily(name string, opts FamilyOptions) (*Family, error) {
+	if name == "" || name == DefaultFamily || len(name) > MaxKeySize || strings.HasPrefix(name, subFamilyPrefix) {
+		return nil, ErrInvalidFamily
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if db.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if db.familyNamedLocked(name) != nil {
+		return nil, ErrFamilyExists
+	}
+	f, err := db.createFamilyLocked(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if f.hasCompactionTriggers() {
+		db.startCompactionWorker()
+	}
+	return f, nil
+}
+
+// createFamilyLocked records a new family in the MANIFEST and registers it.
+func (db *DB) createFamilyLocked(name string, opts FamilyOptions) (*Family, error) {
+	id := db.manifest.State().NextFamilyID
+	if id == 0 {
+		id = 1
+	}
+	edit := manifest.VersionEdit{AddFamilies: []manifest.FamilyInfo{{
+		ID:      id,
+		Name:    name,
+		Options: encodeFamilyOptions(opts),
+	}}}
+	if err := db.manifest.Apply(edit); err != nil {
+		return nil, err
+	}
+	return db.addFamily(id, name, opts, index.NewMemIndex()), nil
+}
+
+// Family returns the handle of an existing family. DefaultFamily returns the
+// family behind the DB's own methods.
+func (db *DB) Family(name string) (*Family, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	f := db.familyNamedLocked(name)
+	if f == nil || f.parent != nil {
+		return nil, ErrFamilyNotFound
+	}
+	return f, nil
+}
+
+// Families returns the names of all families other than the default one, sorted.
+func (db *DB) Families() ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	names := make([]string, 0, len(db.families))
+	for id, f := range db.families {
+		if id != 0 && f.parent == nil {
+			names = append(names, f.name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DropFamily removes a family and all of its keys. Only a MANIFEST edit is
+// written, so the cost does not depend on the family's size: its records in
+// the WAL and the snapshot chain are skipped on replay, and the next
+// compaction writes a full snapshot without them.
+func (db *DB) DropFamily(name string) error {
+	if name == DefaultFamily {
+		return ErrInvalidFamily
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	f := db.familyNamedLocked(name)
+	if f == nil || f.parent != nil {
+		return ErrFamilyNotFound
+	}
+	drop := []*Family{f}
+	if sub, _ := f.subFamilyLocked(false); sub != nil {
+		drop = append(drop, sub)
+	}
+	edit := manifest.VersionEdit{}
+	for _, d := range drop {
+		edit.DropFamilies = append(edit.DropFamilies, d.id)
+	}
+	if err := db.manifest.Apply(edit); err != nil {
+		return err
+	}
+	for _, d := range drop {
+		db.dropFamilyLocked(d)
+	}
+	db.hasBase = false
+	return nil
+}
+
+func (db *DB) dropFamilyLocked(f *Family) {
+	delete(db.families, f.id)
+	f.dropped = true
+	f.index = index.NewMemIndex()
+	f.dirty = make(map[string]struct{})
+	f.walBytes = 0
+}
+
+func (db *DB) familyNamedLocked(name string) *Family {
+	for _, f := range db.families {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Name returns the family name.
+func (f *Family) Name() string {
+	return f.name
+}
+
+// unavailableLocked returns the error for calls on a closed database or a
+// dropped family. Callers must hold db.mu.
+func (f *Family) unavailableLocked() error {
+	if f.db.closed {
+		return ErrClosed
+	}
+	if f.dropped {
+		return ErrFamilyNotFound
+	}
+	return nil
+}
+
+// defaultExpiresAt returns the expiry for a write without an explicit TTL.
+func (f *Family) defaultExpiresAt() int64 {
+	if f.opts.DefaultTTL <= 0 {
+		return -1
+	}
+	return f.db.opts.Clock.Now().Add(f.opts.DefaultTTL).UnixNano()
+}
+
+func encodeFamilyOptions(opts FamilyOptions) []byte {
+	buf := []byte{familyOptionsVersion}
+	buf = binary.AppendVarint(buf, int64(opts.DefaultTTL))
+	buf = binary.AppendVarint(buf, int64(opts.MaxKeySize))
+	buf = binary.AppendVarint(buf, int64(opts.MaxValueSize))
+	buf = binary.AppendVarint(buf, opts.Compaction.WALBytes)
+	buf = binary.AppendUvarint(buf, math.Float64bits(opts.Compaction.GarbageRatio))
+	buf = binary.AppendVarint(buf, int64(opts.Compaction.Interval))
+	return buf
+}
+
+func decodeFamilyOptions(data []byte) (FamilyOptions, error) {
+	if len(data) == 0 || data[0] != familyOptionsVersion {
+		return FamilyOptions{}, ErrInvalidFamily
+	}
+	data = data[1:]
+	ok := true
+	varint := func() int64 {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			ok = false
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+	var opts FamilyOptions
+	opts.DefaultTTL = time.Duration(varint())
+	opts.MaxKeySize = int(varint())
+	opts.MaxValueSize = int(varint())
+	opts.Compaction.WALBytes = varint()
+	bits, n := binary.Uvarint(data)
+	if n <= 0 {
+		return FamilyOptions{}, ErrInvalidFamily
+	}
+	data = data[n:]
+	opts.Compaction.GarbageRatio = math.Float64frombits(bits)
+	opts.Compaction.Interval = time.Duration(varint())
+	if !ok {
+		return FamilyOptions{}, ErrInvalidFamily
+	}
+	return opts, nil
+}
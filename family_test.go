@@ -0,0 +1,262 @@
+package minikv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func createFamily(t *testing.T, db *DB, name string, opts FamilyOptions) *Family {
+	t.Helper()
+	f, err := db.CreateFamily(name, opts)
+	if err != nil {
+		t.Fatalf("create family %s: %v", name, err)
+	}
+	return f
+}
+
+func expectFamilyValue(t *testing.T, f *Family, key, want string) {
+	t.Helper()
+	value, err := f.Get([]byte(key))
+	if err != nil || string(value) != want {
+		t.Fatalf("%s: get %s: %q %v, want %q", f.Name(), key, value, err, want)
+	}
+}
+
+func TestFamiliesAreIsolated(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	sessions := createFamily(t, db, "sessions", FamilyOptions{})
+	docs := createFamily(t, db, "docs", FamilyOptions{})
+
+	_ = db.Set([]byte("k"), []byte("default"))
+	_ = sessions.Set([]byte("k"), []byte("session"))
+	_ = docs.Set([]byte("k"), []byte("doc"))
+	_ = docs.Set([]byte("k2"), []byte("doc2"))
+
+	expectValue(t, db, "k", "default")
+	expectFamilyValue(t, sessions, "k", "session")
+	expectFamilyValue(t, docs, "k", "doc")
+	if count, _ := docs.Count(); count != 2 {
+		t.Fatalf("expected 2 docs, got %d", count)
+	}
+	if count, _ := db.Count(); count != 1 {
+		t.Fatalf("expected 1 default key, got %d", count)
+	}
+	keys, _, err := sessions.Scan(nil, 0)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected 1 session key, got %d %v", len(keys), err)
+	}
+
+	if _, err := db.CreateFamily("docs", FamilyOptions{}); err != ErrFamilyExists {
+		t.Fatalf("expected ErrFamilyExists, got %v", err)
+	}
+	if _, err := db.CreateFamily(DefaultFamily, FamilyOptions{}); err != ErrInvalidFamily {
+		t.Fatalf("expected ErrInvalidFamily, got %v", err)
+	}
+	names, _ := db.Families()
+	if len(names) != 2 || names[0] != "docs" || names[1] != "sessions" {
+		t.Fatalf("unexpected families: %v", names)
+	}
+}
+
+func TestFamilyOptions(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	small := createFamily(t, db, "small", FamilyOptions{MaxKeySize: 4, MaxValueSize: 4, DefaultTTL: time.Hour})
+
+	if err := small.Set([]byte("toolong"), []byte("v")); err != ErrKeyTooLarge {
+		t.Fatalf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := small.Set([]byte("k"), []byte("toolong")); err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if err := db.Set([]byte("toolong"), []byte("toolong")); err != nil {
+		t.Fatalf("default family should keep database limits: %v", err)
+	}
+
+	_ = small.Set([]byte("k"), []byte("v"))
+	if ttl, err := small.TTL([]byte("k")); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("expected DefaultTTL to apply, got %v %v", ttl, err)
+	}
+	_ = small.SetWithTTL([]byte("k2"), []byte("v"), time.Minute)
+	if ttl, _ := small.TTL([]byte("k2")); ttl > time.Minute {
+		t.Fatalf("expected explicit TTL to win, got %v", ttl)
+	}
+	if ttl, _ := db.TTL([]byte("toolong")); ttl != -1 {
+		t.Fatalf("expected default family keys without TTL, got %v", ttl)
+	}
+}
+
+func TestFamiliesSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	sessions := createFamily(t, db, "sessions", FamilyOptions{DefaultTTL: time.Hour})
+	_ = sessions.Set([]byte("snap"), []byte("1"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = sessions.Set([]byte("wal"), []byte("2"))
+	_ = db.Set([]byte("snap"), []byte("default"))
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	sessions, err := db.Family("sessions")
+	if err != nil {
+		t.Fatalf("family: %v", err)
+	}
+	expectFamilyValue(t, sessions, "snap", "1")
+	expectFamilyValue(t, sessions, "wal", "2")
+	expectValue(t, db, "snap", "default")
+	_ = sessions.Set([]byte("new"), []byte("3"))
+	if ttl, _ := sessions.TTL([]byte("new")); ttl <= 0 {
+		t.Fatalf("expected DefaultTTL to be restored, got %v", ttl)
+	}
+}
+
+func TestBatchSpansFamilies(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	small := createFamily(t, db, "small", FamilyOptions{MaxValueSize: 2})
+	other := createFamily(t, db, "other", FamilyOptions{})
+
+	batch := db.NewBatch()
+	batch.Set([]byte("a"), []byte("1"))
+	batch.Family(other).Set([]byte("b"), []byte("2"))
+	batch.Family(small).Set([]byte("c"), []byte("too large"))
+	if err := batch.Write(); err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if ok, _ := other.Exists([]byte("b")); ok {
+		t.Fatalf("expected failed batch to write nothing")
+	}
+
+	batch = small.NewBatch()
+	batch.Set([]byte("c"), []byte("3"))
+	batch.Family(other).Set([]byte("b"), []byte("2"))
+	batch.Family(db.def).Delete([]byte("a"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	expectFamilyValue(t, small, "c", "3")
+	expectFamilyValue(t, other, "b", "2")
+}
+
+func TestDropFamily(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	temp := createFamily(t, db, "temp", FamilyOptions{})
+	for i := 0; i < 10; i++ {
+		_ = temp.Set([]byte("k"+intToString(i)), []byte("v"))
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = temp.Set([]byte("wal"), []byte("v"))
+	_ = db.Set([]byte("keep"), []byte("v"))
+
+	if err := db.DropFamily("temp"); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if _, err := temp.Get([]byte("k1")); err != ErrFamilyNotFound {
+		t.Fatalf("expected dropped handle to fail, got %v", err)
+	}
+	if err := temp.Set([]byte("k1"), []byte("v")); err != ErrFamilyNotFound {
+		t.Fatalf("expected dropped handle to fail, got %v", err)
+	}
+	if err := db.DropFamily("temp"); err != ErrFamilyNotFound {
+		t.Fatalf("expected ErrFamilyNotFound, got %v", err)
+	}
+	if err := db.DropFamily(DefaultFamily); err != ErrInvalidFamily {
+		t.Fatalf("expected ErrInvalidFamily, got %v", err)
+	}
+
+	// A family recreated under the same name starts empty, before and after
+	// the dropped data is compacted away.
+	temp = createFamily(t, db, "temp", FamilyOptions{})
+	if count, _ := temp.Count(); count != 0 {
+		t.Fatalf("expected recreated family to be empty, got %d", count)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	db = openTestDB(t, dir)
+	temp, err := db.Family("temp")
+	if err != nil {
+		t.Fatalf("family: %v", err)
+	}
+	if count, _ := temp.Count(); count != 0 {
+		t.Fatalf("expected dropped data to stay gone after reopen, got %d", count)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	db = openTestDB(t, dir)
+	defer db.Close()
+	temp, _ = db.Family("temp")
+	if count, _ := temp.Count(); count != 0 {
+		t.Fatalf("expected dropped data to stay gone after compaction, got %d", count)
+	}
+	expectValue(t, db, "keep", "v")
+}
+
+func TestFamilyCompactionTrigger(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	busy := createFamily(t, db, "busy", FamilyOptions{Compaction: CompactionPolicy{WALBytes: 256}})
+
+	_ = db.Set([]byte("k"), []byte("v"))
+	if db.shouldCompact(time.Now()) {
+		t.Fatalf("expected no compaction below family threshold")
+	}
+	for i := 0; i < 32; i++ {
+		_ = busy.Set([]byte("k"+intToString(i)), []byte("value-value-value"))
+	}
+	if !db.shouldCompact(time.Now()) {
+		t.Fatalf("expected compaction above family WAL threshold")
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if db.shouldCompact(time.Now()) {
+		t.Fatalf("expected family counter to reset after compaction")
+	}
+}
+
+func TestReadOnlyFollowsFamilies(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir)
+	defer writer.Close()
+	reader := openTestDB(t, dir, readOnly)
+	defer reader.Close()
+
+	docs := createFamily(t, writer, "docs", FamilyOptions{})
+	_ = docs.Set([]byte("a"), []byte("1"))
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	followed, err := reader.Family("docs")
+	if err != nil {
+		t.Fatalf("family: %v", err)
+	}
+	expectFamilyValue(t, followed, "a", "1")
+	if _, err := reader.CreateFamily("x", FamilyOptions{}); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	if err := writer.DropFamily("docs"); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if _, err := followed.Get([]byte("a")); !errors.Is(err, ErrFamilyNotFound) {
+		t.Fatalf("expected dropped family on follower, got %v", err)
+	}
+}
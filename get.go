@@ -4,9 +4,15 @@ import "time"
 
 // Get returns the value for a key or ErrNotFound.
 func (db *DB) Get(key []byte) ([]byte, error) {
+	return db.def.Get(key)
+}
+
+// Get returns the value for a key in the family or ErrNotFound.
+func (f *Family) Get(key []byte) ([]byte, error) {
+	db := f.db
 	stats := db.statsOrInit()
 	start := time.Now()
-	if len(key) > db.opts.MaxKeySize {
+	if len(key) > f.opts.MaxKeySize {
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
 		return nil, ErrKeyTooLarge
@@ -18,26 +24,34 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	}
 
 	db.mu.RLock()
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		db.mu.RUnlock()
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
-		return nil, ErrClosed
+		return nil, err
+	}
+	entry, ok := f.index.Get(string(key))
+	var value []byte
+	var err error
+	if ok {
+		value, err = db.entryValue(string(key), entry)
 	}
-	entry, ok := db.index.Get(string(key))
 	db.mu.RUnlock()
 	if !ok {
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
 		return nil, ErrNotFound
 	}
-	if entry.ExpiresAt >= 0 && entry.ExpiresAt <= time.Now().UnixNano() {
+	if entry.ExpiresAt >= 0 && entry.ExpiresAt <= db.now() {
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
 		return nil, ErrNotFound
 	}
-	value := make([]byte, len(entry.Value))
-	copy(value, entry.Value)
+	if err != nil {
+		stats.reads.Add(1)
+		stats.readLatency.add(time.Since(start))
+		return nil, err
+	}
 	stats.reads.Add(1)
 	stats.readLatency.add(time.Since(start))
 	return value, nil
@@ -5,9 +5,15 @@ import "time"
 // GetInto copies the value into dst and returns the resulting slice.
 // If dst has sufficient capacity, it is reused to reduce allocations.
 func (db *DB) GetInto(dst []byte, key []byte) ([]byte, error) {
+	return db.def.GetInto(dst, key)
+}
+
+// GetInto copies the value for a key in the family into dst.
+func (f *Family) GetInto(dst []byte, key []byte) ([]byte, error) {
+	db := f.db
 	stats := db.statsOrInit()
 	start := time.Now()
-	if len(key) > db.opts.MaxKeySize {
+	if len(key) > f.opts.MaxKeySize {
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
 		return nil, ErrKeyTooLarge
@@ -19,31 +25,45 @@ func (db *DB) GetInto(dst []byte, key []byte) ([]byte, error) {
 	}
 
 	db.mu.RLock()
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		db.mu.RUnlock()
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
-		return nil, ErrClosed
+		return nil, err
+	}
+	entry, ok := f.index.Get(string(key))
+	var value []byte
+	var err error
+	if ok {
+		value = entry.Value
+		if entry.Pointer || entry.Meta || len(entry.Operands) > 0 {
+			value, err = db.entryValue(string(key), entry)
+		}
 	}
-	entry, ok := db.index.Get(string(key))
 	db.mu.RUnlock()
 	if !ok {
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
 		return nil, ErrNotFound
 	}
-	if entry.ExpiresAt >= 0 && entry.ExpiresAt <= time.Now().UnixNano() {
+	if entry.ExpiresAt >= 0 && entry.ExpiresAt <= db.now() {
 		stats.reads.Add(1)
 		stats.readLatency.add(time.Since(start))
 		return nil, ErrNotFound
 	}
 
-	if cap(dst) < len(entry.Value) {
-		dst = make([]byte, len(entry.Value))
+	if err != nil {
+		stats.reads.Add(1)
+		stats.readLatency.add(time.Since(start))
+		return nil, err
+	}
+
+	if cap(dst) < len(value) {
+		dst = make([]byte, len(value))
 	} else {
-		dst = dst[:len(entry.Value)]
+		dst = dst[:len(value)]
 	}
-	copy(dst, entry.Value)
+	copy(dst, value)
 	stats.reads.Add(1)
 	stats.readLatency.add(time.Since(start))
 	return dst, nil
@@ -9,7 +9,7 @@ import (
 )
 
 func TestGetKeyTooLarge(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp")}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
 	key := make([]byte, db.opts.MaxKeySize+1)
 	if _, err := db.Get(key); err != ErrKeyTooLarge {
 		t.Fatalf("expected ErrKeyTooLarge, got %v", err)
@@ -17,17 +17,17 @@ func TestGetKeyTooLarge(t *testing.T) {
 }
 
 func TestGetNotFound(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp"), index: index.NewMemIndex()}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
 	if _, err := db.Get([]byte("missing")); err != ErrNotFound {
 		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
 }
 
 func TestGetReturnsValue(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp"), index: index.NewMemIndex()}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
 	key := []byte("hello")
 	value := []byte("world")
-	db.index.SetEntry(string(key), value, -1, time.Now().UnixNano())
+	db.def.index.SetEntry(string(key), value, -1, time.Now().UnixNano())
 	got, err := db.Get(key)
 	if err != nil {
 		t.Fatalf("get: %v", err)
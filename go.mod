@@ -2,4 +2,4 @@ module github.com/bretuobay/mini-kv
 
 go 1.21
 
-require github.com/leanovate/gopter v0.2.11 // indirect
+require github.com/leanovate/gopter v0.2.11
@@ -0,0 +1,304 @@
+package minikv
+
+import (
+	"strconv"
+	"time"
+)
+
+// HSet sets field in the hash at key, creating the hash if needed.
+func (db *DB) HSet(key, field, value []byte) error {
+	return db.def.HSet(key, field, value)
+}
+
+// HSet sets field in the hash at key in the family. Each field is stored as
+// its own sub-key, so only that field is written. A new hash gets the
+// family's DefaultTTL; Expire and Persist on key apply to the whole hash.
+func (f *Family) HSet(key, field, value []byte) error {
+	return f.writeCollection(key, field, value, func(t *txn) error {
+		_, err := t.hset(f, key, field, value)
+		return err
+	})
+}
+
+// HDel removes field from the hash at key. Removing the last field removes the hash.
+func (db *DB) HDel(key, field []byte) (bool, error) {
+	return db.def.HDel(key, field)
+}
+
+// HDel removes field from the hash at key in the family.
+func (f *Family) HDel(key, field []byte) (bool, error) {
+	var removed bool
+	err := f.writeCollection(key, field, nil, func(t *txn) error {
+		var err error
+		removed, err = t.hdel(f, key, field)
+		return err
+	})
+	return removed, err
+}
+
+// HIncrBy adds delta to the integer stored in field of the hash at key.
+func (db *DB) HIncrBy(key, field []byte, delta int64) (int64, error) {
+	return db.def.HIncrBy(key, field, delta)
+}
+
+// HIncrBy adds delta to the integer stored in field of the hash at key in
+// the family. A missing field counts as zero.
+func (f *Family) HIncrBy(key, field []byte, delta int64) (int64, error) {
+	var result int64
+	err := f.writeCollection(key, field, nil, func(t *txn) error {
+		var current int64
+		meta, err := t.meta(f, string(key), kindHash)
+		if err != nil {
+			return err
+		}
+		if meta != nil {
+			sub, err := f.subFamilyLocked(false)
+			if err != nil {
+				return err
+			}
+			if sub != nil {
+				value, ok, err := t.get(sub, string(append(memberPrefix(key, meta.version), field...)))
+				if err != nil {
+					return err
+				}
+				if ok {
+					if current, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+						return ErrInvalidValue
+					}
+				}
+			}
+		}
+		result = current + delta
+		_, err = t.hset(f, key, field, []byte(strconv.FormatInt(result, 10)))
+		return err
+	})
+	return result, err
+}
+
+// HGet returns the value of field in the hash at key.
+func (db *DB) HGet(key, field []byte) ([]byte, error) {
+	return db.def.HGet(key, field)
+}
+
+// HGet returns the value of field in the hash at key in the family.
+func (f *Family) HGet(key, field []byte) ([]byte, error) {
+	var value []byte
+	err := f.readCollection(key, kindHash, func(meta *collectionMeta, sub *Family) error {
+		member := string(append(memberPrefix(key, meta.version), field...))
+		entry, ok := sub.index.Get(member)
+		if !ok {
+			return ErrNotFound
+		}
+		var err error
+		value, err = f.db.entryValue(member, entry)
+		return err
+	})
+	return value, err
+}
+
+// HGetAll returns every field of the hash at key.
+func (db *DB) HGetAll(key []byte) (map[string][]byte, error) {
+	return db.def.HGetAll(key)
+}
+
+// HGetAll returns every field of the hash at key in the family. A missing
+// hash yields an empty map.
+func (f *Family) HGetAll(key []byte) (map[string][]byte, error) {
+	fields, values, err := f.HScan(key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string][]byte, len(fields))
+	for i, field := range fields {
+		all[string(field)] = values[i]
+	}
+	return all, nil
+}
+
+// HScan returns up to limit fields of the hash at key matching prefix, in
+// lexicographic order, with their values.
+func (db *DB) HScan(key, prefix []byte, limit int) ([][]byte, [][]byte, error) {
+	return db.def.HScan(key, prefix, limit)
+}
+
+// HScan returns up to limit fields of the hash at key in the family matching
+// prefix, in lexicographic order, with their values.
+func (f *Family) HScan(key, prefix []byte, limit int) ([][]byte, [][]byte, error) {
+	var fields, values [][]byte
+	err := f.readCollection(key, kindHash, func(meta *collectionMeta, sub *Family) error {
+		base := memberPrefix(key, meta.version)
+		entries := sub.index.Scan(string(append(base, prefix...)), limit)
+		members, resolved, err := f.db.resolveEntries(entries)
+		if err != nil {
+			return err
+		}
+		for i, member := range members {
+			fields = append(fields, member[len(base):])
+			values = append(values, resolved[i])
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil, nil, nil
+	}
+	return fields, values, err
+}
+
+// HLen returns the number of fields in the hash at key.
+func (db *DB) HLen(key []byte) (int, error) {
+	return db.def.HLen(key)
+}
+
+// HLen returns the number of fields in the hash at key in the family; 0 if it does not exist.
+func (f *Family) HLen(key []byte) (int, error) {
+	var count int
+	err := f.readCollection(key, kindHash, func(meta *collectionMeta, _ *Family) error {
+		count = int(meta.count)
+		return nil
+	})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return count, err
+}
+
+// hset stages field of the hash at key and reports whether it is new.
+func (t *txn) hset(f *Family, key, field, value []byte) (bool, error) {
+	meta, err := t.meta(f, string(key), kindHash)
+	if err != nil {
+		return false, err
+	}
+	if meta == nil {
+		meta = t.newMeta(f, kindHash)
+	}
+	sub, err := f.subFamilyLocked(true)
+	if err != nil {
+		return false, err
+	}
+	member := append(memberPrefix(key, meta.version), field...)
+	_, exists, err := t.get(sub, string(member))
+	if err != nil {
+		return false, err
+	}
+	if err := t.set(sub, member, value, -1); err != nil {
+		return false, err
+	}
+	if !exists {
+		meta.count++
+		t.putMeta(f, key, meta)
+	}
+	return !exists, nil
+}
+
+// hdel stages the removal of field from the hash at key and reports whether it existed.
+func (t *txn) hdel(f *Family, key, field []byte) (bool, error) {
+	meta, err := t.meta(f, string(key), kindHash)
+	if err != nil || meta == nil {
+		return false, err
+	}
+	sub, err := f.subFamilyLocked(false)
+	if err != nil || sub == nil {
+		return false, err
+	}
+	member := append(memberPrefix(key, meta.version), field...)
+	if _, exists, err := t.get(sub, string(member)); err != nil || !exists {
+		return false, err
+	}
+	t.delete(sub, member)
+	meta.count--
+	t.putMeta(f, key, meta)
+	return true, nil
+}
+
+// writeCollection validates a collection write and runs fn in a txn that
+// is committed when fn succeeds.
+func (f *Family) writeCollection(key, member, value []byte, fn func(t *txn) error) error {
+	db := f.db
+	stats := db.statsOrInit()
+	start := time.Now()
+	defer func() {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+	}()
+	if len(key) > f.opts.MaxKeySize || len(member) > f.opts.MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	if len(value) > f.opts.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	if len(key) == 0 {
+		return ErrNotFound
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := f.unavailableLocked(); err != nil {
+		return err
+	}
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	t := db.newTxnLocked()
+	if err := fn(t); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// readCollection runs fn with the live metadata of the collection at key and
+// the family holding its members. It returns ErrNotFound if there is no such
+// collection.
+func (f *Family) readCollection(key []byte, kind collectionKind, fn func(meta *collectionMeta, sub *Family) error) error {
+	return f.readCollections(func() error {
+		meta, sub, err := f.collectionLocked(key, kind)
+		if err != nil {
+			return err
+		}
+		return fn(meta, sub)
+	})
+}
+
+// readCollections runs fn under a read lock, for reads that look at several
+// collections at once.
+func (f *Family) readCollections(fn func() error) error {
+	db := f.db
+	stats := db.statsOrInit()
+	start := time.Now()
+	defer func() {
+		stats.reads.Add(1)
+		stats.readLatency.add(time.Since(start))
+	}()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := f.unavailableLocked(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// collectionLocked returns the live metadata of the collection at key and the
+// family holding its members, or ErrNotFound. Callers must hold db.mu.
+func (f *Family) collectionLocked(key []byte, kind collectionKind) (*collectionMeta, *Family, error) {
+	if len(key) > f.opts.MaxKeySize {
+		return nil, nil, ErrKeyTooLarge
+	}
+	if len(key) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	meta, err := f.readMetaLocked(string(key), kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta == nil {
+		return nil, nil, ErrNotFound
+	}
+	sub, err := f.subFamilyLocked(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sub == nil {
+		return nil, nil, ErrNotFound
+	}
+	return meta, sub, nil
+}
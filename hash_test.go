@@ -0,0 +1,363 @@
+package minikv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+func expectHash(t *testing.T, f *Family, key string, want map[string]string) {
+	t.Helper()
+	all, err := f.HGetAll([]byte(key))
+	if err != nil {
+		t.Fatalf("hgetall %s: %v", key, err)
+	}
+	if len(all) != len(want) {
+		t.Fatalf("hgetall %s: got %d fields, want %d", key, len(all), len(want))
+	}
+	for field, value := range want {
+		if string(all[field]) != value {
+			t.Fatalf("hgetall %s: field %s = %q, want %q", key, field, all[field], value)
+		}
+	}
+	if n, err := f.HLen([]byte(key)); err != nil || n != len(want) {
+		t.Fatalf("hlen %s: %d %v, want %d", key, n, err, len(want))
+	}
+}
+
+func TestHashFieldOperations(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	_ = db.HSet([]byte("user"), []byte("name"), []byte("ada"))
+	_ = db.HSet([]byte("user"), []byte("lang"), []byte("go"))
+	_ = db.HSet([]byte("user"), []byte("name"), []byte("grace"))
+	if value, err := db.HGet([]byte("user"), []byte("name")); err != nil || string(value) != "grace" {
+		t.Fatalf("hget: %q %v", value, err)
+	}
+	if _, err := db.HGet([]byte("user"), []byte("missing")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	expectHash(t, db.def, "user", map[string]string{"name": "grace", "lang": "go"})
+
+	if n, err := db.HIncrBy([]byte("user"), []byte("visits"), 5); err != nil || n != 5 {
+		t.Fatalf("hincrby: %d %v", n, err)
+	}
+	if n, _ := db.HIncrBy([]byte("user"), []byte("visits"), -2); n != 3 {
+		t.Fatalf("expected 3, got %d", n)
+	}
+	if _, err := db.HIncrBy([]byte("user"), []byte("name"), 1); err != ErrInvalidValue {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+
+	fields, values, err := db.HScan([]byte("user"), []byte("l"), 0)
+	if err != nil || len(fields) != 1 || string(fields[0]) != "lang" || string(values[0]) != "go" {
+		t.Fatalf("hscan: %q %q %v", fields, values, err)
+	}
+	if fields, _, _ := db.HScan([]byte("user"), nil, 2); len(fields) != 2 || string(fields[0]) != "lang" {
+		t.Fatalf("expected limited sorted scan, got %q", fields)
+	}
+
+	for _, field := range []string{"name", "lang", "visits"} {
+		if removed, err := db.HDel([]byte("user"), []byte(field)); err != nil || !removed {
+			t.Fatalf("hdel %s: %v %v", field, removed, err)
+		}
+	}
+	if removed, _ := db.HDel([]byte("user"), []byte("name")); removed {
+		t.Fatalf("expected second hdel to report nothing removed")
+	}
+	if ok, _ := db.Exists([]byte("user")); ok {
+		t.Fatalf("expected empty hash to be removed")
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.MergeOperator = AppendOperator })
+	defer db.Close()
+	_ = db.Set([]byte("plain"), []byte("v"))
+	_ = db.HSet([]byte("hash"), []byte("f"), []byte("v"))
+
+	if err := db.HSet([]byte("plain"), []byte("f"), []byte("v")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := db.HGet([]byte("plain"), []byte("f")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := db.Get([]byte("hash")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := db.IncrBy([]byte("hash"), 1); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if err := db.Merge([]byte("hash"), []byte("x")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	keys, _, err := db.Scan(nil, 0)
+	if err != nil || len(keys) != 1 || string(keys[0]) != "plain" {
+		t.Fatalf("expected scan to skip hashes, got %q %v", keys, err)
+	}
+	if count, _ := db.Count(); count != 1 {
+		t.Fatalf("expected Count to skip hashes like scans, got %d", count)
+	}
+
+	// Set replaces the hash, and a new hash at the key starts empty.
+	_ = db.Set([]byte("hash"), []byte("plain now"))
+	expectValue(t, db, "hash", "plain now")
+	_ = db.Delete([]byte("hash"))
+	_ = db.HSet([]byte("hash"), []byte("g"), []byte("v"))
+	expectHash(t, db.def, "hash", map[string]string{"g": "v"})
+}
+
+func TestHashExpiresAsWhole(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_ = db.HSet([]byte("session"), []byte("a"), []byte("1"))
+	_ = db.HSet([]byte("session"), []byte("b"), []byte("2"))
+	if ok, err := db.Expire([]byte("session"), time.Hour); err != nil || !ok {
+		t.Fatalf("expire: %v %v", ok, err)
+	}
+	_ = db.HSet([]byte("session"), []byte("c"), []byte("3"))
+	if ttl, _ := db.TTL([]byte("session")); ttl <= 0 {
+		t.Fatalf("expected field writes to keep the hash TTL, got %v", ttl)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if ttl, _ := db.TTL([]byte("session")); ttl <= 0 {
+		t.Fatalf("expected hash TTL to survive reopen, got %v", ttl)
+	}
+	if ok, _ := db.Persist([]byte("session")); !ok {
+		t.Fatalf("expected persist to succeed")
+	}
+	if ttl, _ := db.TTL([]byte("session")); ttl != -1 {
+		t.Fatalf("expected no TTL after persist, got %v", ttl)
+	}
+	expectHash(t, db.def, "session", map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	_, _ = db.Expire([]byte("session"), 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if _, err := db.HGet([]byte("session"), []byte("a")); err != ErrNotFound {
+		t.Fatalf("expected expired hash, got %v", err)
+	}
+	_ = db.HSet([]byte("session"), []byte("z"), []byte("new"))
+	expectHash(t, db.def, "session", map[string]string{"z": "new"})
+}
+
+func TestHashOrphansCollectedOnCompact(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	for i := 0; i < 10; i++ {
+		_ = db.HSet([]byte("big"), []byte("f"+intToString(i)), []byte("v"))
+	}
+	_ = db.HSet([]byte("kept"), []byte("f"), []byte("v"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Delete([]byte("big"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if n := db.def.sub.index.Len(); n != 1 {
+		t.Fatalf("expected orphaned fields to be removed, %d members left", n)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if n := db.def.sub.index.Len(); n != 1 {
+		t.Fatalf("expected orphan removal to persist, %d members left", n)
+	}
+	expectHash(t, db.def, "kept", map[string]string{"f": "v"})
+	if names, _ := db.Families(); len(names) != 0 {
+		t.Fatalf("expected member family to stay hidden, got %v", names)
+	}
+}
+
+func TestHashBatchIsAtomic(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	docs := createFamily(t, db, "docs", FamilyOptions{MaxValueSize: 4})
+
+	batch := db.NewBatch()
+	batch.HSet([]byte("h"), []byte("a"), []byte("1"))
+	batch.Family(docs).HSet([]byte("h"), []byte("b"), []byte("too large"))
+	if err := batch.Write(); err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if ok, _ := db.Exists([]byte("h")); ok {
+		t.Fatalf("expected failed batch to write nothing")
+	}
+
+	batch = db.NewBatch()
+	batch.HSet([]byte("h"), []byte("a"), []byte("1"))
+	batch.HSet([]byte("h"), []byte("b"), []byte("2"))
+	batch.HSet([]byte("h"), []byte("c"), []byte("3"))
+	batch.HDel([]byte("h"), []byte("b"))
+	batch.Family(docs).HSet([]byte("h"), []byte("x"), []byte("9"))
+	batch.Set([]byte("plain"), []byte("v"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	expectHash(t, db.def, "h", map[string]string{"a": "1", "c": "3"})
+	expectHash(t, docs, "h", map[string]string{"x": "9"})
+
+	// A hash deleted and recreated within one batch does not keep old fields.
+	batch = db.NewBatch()
+	batch.Delete([]byte("h"))
+	batch.HSet([]byte("h"), []byte("d"), []byte("4"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	expectHash(t, db.def, "h", map[string]string{"d": "4"})
+
+	if err := db.DropFamily("docs"); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	docs = createFamily(t, db, "docs", FamilyOptions{})
+	if n, _ := docs.HLen([]byte("h")); n != 0 {
+		t.Fatalf("expected dropped family's hash to be gone, got %d fields", n)
+	}
+}
+
+func TestHashMatchesModel(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 30
+	properties := gopter.NewProperties(parameters)
+
+	type op struct {
+		del   bool
+		field string
+		value string
+	}
+	genOp := gopter.CombineGens(gen.Bool(), gen.IntRange(0, 5), gen.AlphaString()).Map(func(v []interface{}) op {
+		return op{del: v[0].(bool), field: "f" + intToString(v[1].(int)), value: v[2].(string)}
+	})
+
+	properties.Property("hash matches a map across compaction and reopen", prop.ForAll(
+		func(ops []op, compactAt int) bool {
+			dir := t.TempDir()
+			db := openTestDB(t, dir)
+			model := make(map[string]string)
+			for i, o := range ops {
+				if o.del {
+					_, _ = db.HDel([]byte("h"), []byte(o.field))
+					delete(model, o.field)
+				} else {
+					_ = db.HSet([]byte("h"), []byte(o.field), []byte(o.value))
+					model[o.field] = o.value
+				}
+				if i == compactAt {
+					_ = db.Compact()
+				}
+			}
+			_ = db.Close()
+			db = openTestDB(t, dir)
+			defer db.Close()
+			all, err := db.HGetAll([]byte("h"))
+			if err != nil || len(all) != len(model) {
+				return false
+			}
+			for field, value := range model {
+				if string(all[field]) != value {
+					return false
+				}
+			}
+			n, _ := db.HLen([]byte("h"))
+			return n == len(model)
+		},
+		gen.SliceOf(genOp),
+		gen.IntRange(-1, 20),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestHashOnFollower(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir)
+	defer writer.Close()
+	reader := openTestDB(t, dir, readOnly)
+	defer reader.Close()
+
+	_ = writer.HSet([]byte("h"), []byte("a"), []byte("1"))
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if value, err := reader.HGet([]byte("h"), []byte("a")); err != nil || string(value) != "1" {
+		t.Fatalf("hget on follower: %q %v", value, err)
+	}
+	if err := reader.HSet([]byte("h"), []byte("b"), []byte("2")); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestRecreatedHashAfterClockGoesBack(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) { opts.Clock = NewFakeClock(fakeEpoch) })
+	_ = db.HSet([]byte("h"), []byte("old"), []byte("1"))
+	_ = db.Delete([]byte("h"))
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A clock that went back and catches up to the old hash's creation time
+	// must not hand the new hash the old version, which would bring the
+	// deleted hash's uncollected members back.
+	clock := NewFakeClock(fakeEpoch.Add(-time.Hour))
+	db = openTestDB(t, dir, func(opts *Options) { opts.Clock = clock })
+	clock.Advance(time.Hour)
+	_ = db.HSet([]byte("h"), []byte("new"), []byte("2"))
+	expectHash(t, db.def, "h", map[string]string{"new": "2"})
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// The same holds once the old members only survive in the snapshot.
+	db = openTestDB(t, dir, func(opts *Options) { opts.Clock = NewFakeClock(fakeEpoch) })
+	defer db.Close()
+	_ = db.Delete([]byte("h"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.HSet([]byte("h"), []byte("newer"), []byte("3"))
+	expectHash(t, db.def, "h", map[string]string{"newer": "3"})
+}
+
+func TestOrphanCollectionChecksOnlyChangedCollections(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	for i := 0; i < 500; i++ {
+		_ = db.HSet([]byte("big"), []byte("f"+intToString(i)), []byte("v"))
+	}
+	for i := 0; i < 3; i++ {
+		_ = db.HSet([]byte("gone"), []byte("f"+intToString(i)), []byte("v"))
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Delete([]byte("gone"))
+
+	sub := db.def.sub
+	db.mu.Lock()
+	if _, ok := sub.recheck["gone"]; !ok || len(sub.recheck) != 1 {
+		t.Fatalf("expected only the deleted hash to be rechecked, got %v", sub.recheck)
+	}
+	db.collectOrphansLocked()
+	collected := len(sub.dirty)
+	db.mu.Unlock()
+	if collected != 3 {
+		t.Fatalf("expected the 3 orphaned members to be collected, got %d", collected)
+	}
+	if n, err := db.HLen([]byte("big")); err != nil || n != 500 {
+		t.Fatalf("expected the live hash to keep its fields, got %d %v", n, err)
+	}
+}
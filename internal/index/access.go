@@ -0,0 +1,95 @@
+package index
+
+import (
+	"container/heap"
+	"math"
+	"sync/atomic"
+)
+
+// initialHits is the read count a newly stored key starts with, so a key
+// that was just written is not the first one an LFU eviction picks.
+const initialHits = 5
+
+// accessStats records when and how often an entry was read. Readers update
+// it atomically under the read lock; entries copied from one another share it.
+type accessStats struct {
+	last atomic.Int64
+	hits atomic.Uint32
+}
+
+func newAccessStats(now int64) *accessStats {
+	stats := &accessStats{}
+	stats.last.Store(now)
+	stats.hits.Store(initialHits)
+	return stats
+}
+
+func (a *accessStats) touch(now int64) {
+	a.last.Store(now)
+	if a.hits.Load() < math.MaxUint32 {
+		a.hits.Add(1)
+	}
+}
+
+// Sample is a key considered for eviction, with its access history. LastAccess
+// and Hits are zero unless access tracking is enabled.
+type Sample struct {
+	Key        string
+	ExpiresAt  int64
+	LastAccess int64
+	Hits       uint32
+}
+
+// SetAccessTracking enables or disables recording when and how often each key
+// is read. Keys already stored count as read now when it is enabled.
+func (m *MemIndex) SetAccessTracking(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.trackAccess == enabled {
+		return
+	}
+	m.trackAccess = enabled
+	now := m.clock()
+	for _, entry := range m.data {
+		if enabled {
+			entry.access = newAccessStats(now)
+		} else {
+			entry.access = nil
+		}
+	}
+}
+
+// SampleKeys returns up to n keys picked in map iteration order, which starts
+// at a random position, for approximate LRU and LFU eviction.
+func (m *MemIndex) SampleKeys(n int) []Sample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	samples := make([]Sample, 0, n)
+	for key, entry := range m.data {
+		if len(samples) >= n {
+			break
+		}
+		sample := Sample{Key: key, ExpiresAt: entry.ExpiresAt}
+		if entry.access != nil {
+			sample.LastAccess = entry.access.last.Load()
+			sample.Hits = entry.access.hits.Load()
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// NextExpiry returns the key with a TTL that expires first, dropping stale
+// items from the expiry heap on the way.
+func (m *MemIndex) NextExpiry() (Sample, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.expiries) > 0 {
+		item := m.expiries[0]
+		if entry, ok := m.data[item.key]; ok && entry.ExpiresAt == item.expiresAt {
+			return Sample{Key: item.key, ExpiresAt: item.expiresAt}, true
+		}
+		heap.Pop(&m.expiries)
+	}
+	return Sample{}, false
+}
@@ -0,0 +1,76 @@
+package index
+
+import "container/heap"
+
+// expiryItem records that key was stored with expiresAt. Items are not
+// removed when the key is overwritten or deleted; ExpireDue skips items that
+// no longer match the key's entry.
+type expiryItem struct {
+	expiresAt int64
+	key       string
+}
+
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expiresAt < h[j].expiresAt }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryItem)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// minExpiryRebuild is the heap size below which stale items are never
+// compacted away.
+const minExpiryRebuild = 1024
+
+// trackExpiryLocked records a store of key with expiresAt. Once stale items
+// outnumber live keys the heap is rebuilt from the entries, which keeps its
+// size proportional to the index.
+func (m *MemIndex) trackExpiryLocked(key string, expiresAt int64) {
+	if expiresAt < 0 {
+		return
+	}
+	heap.Push(&m.expiries, expiryItem{expiresAt: expiresAt, key: key})
+	if len(m.expiries) > minExpiryRebuild && len(m.expiries) > 2*len(m.data) {
+		m.rebuildExpiriesLocked()
+	}
+}
+
+func (m *MemIndex) rebuildExpiriesLocked() {
+	items := make(expiryHeap, 0, len(m.data))
+	for key, entry := range m.data {
+		if entry.ExpiresAt >= 0 {
+			items = append(items, expiryItem{expiresAt: entry.ExpiresAt, key: key})
+		}
+	}
+	heap.Init(&items)
+	m.expiries = items
+}
+
+// ExpireDue removes up to limit keys that expired at or before now, earliest
+// first, and returns them. A non-positive limit removes every expired key.
+// The cost is proportional to the number of keys removed (and stale heap
+// items skipped), not to the size of the index.
+func (m *MemIndex) ExpireDue(now int64, limit int) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	for len(m.expiries) > 0 && m.expiries[0].expiresAt <= now {
+		if limit > 0 && len(removed) >= limit {
+			break
+		}
+		item := heap.Pop(&m.expiries).(expiryItem)
+		entry, ok := m.data[item.key]
+		if !ok || entry.ExpiresAt != item.expiresAt {
+			continue
+		}
+		m.expireLocked(item.key, entry)
+		removed = append(removed, item.key)
+	}
+	return removed
+}
@@ -3,7 +3,6 @@ package index
 import (
 	"sort"
 	"strings"
-	"time"
 )
 
 // KeyEntry bundles a key with its entry data.
@@ -22,9 +21,13 @@ func (m *MemIndex) ScanRange(start, end string, limit int) []KeyEntry {
 	return m.scan(func(key string) bool { return key >= start && key <= end }, limit)
 }
 
-// Keys returns all keys matching the glob pattern (supports '*' and '?').
+// Keys returns all keys matching the glob pattern (supports '*' and '?'),
+// leaving out collection metadata entries as scans do.
 func (m *MemIndex) Keys(pattern string) []string {
-	matches := m.scanKeys(func(key string) bool {
+	matches := m.scanKeys(func(key string, entry *Entry) bool {
+		if entry.Meta {
+			return false
+		}
 		ok, _ := pathMatch(pattern, key)
 		return ok
 	})
@@ -32,7 +35,7 @@ func (m *MemIndex) Keys(pattern string) []string {
 }
 
 func (m *MemIndex) scan(match func(string) bool, limit int) []KeyEntry {
-	now := time.Now().UnixNano()
+	now := m.clock()
 
 	m.mu.RLock()
 	keys := make([]string, 0, len(m.data))
@@ -47,7 +50,35 @@ func (m *MemIndex) scan(match func(string) bool, limit int) []KeyEntry {
 	if limit > 0 && len(keys) > limit {
 		keys = keys[:limit]
 	}
+	return m.lookup(keys, now)
+}
+
+// SortedKeys returns, sorted, the live keys that start with any of prefixes,
+// or every live key with no prefixes. The keys are a snapshot; callers page
+// through them with Lookup rather than rescanning the index per page.
+func (m *MemIndex) SortedKeys(prefixes ...string) []string {
+	return m.scanKeys(func(key string, _ *Entry) bool { return hasAnyPrefix(key, prefixes) })
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
 
+// Lookup returns copies of the entries of keys in order, leaving out keys
+// that are missing or expired. Like Scan, it does not count as an access.
+func (m *MemIndex) Lookup(keys []string) []KeyEntry {
+	return m.lookup(keys, m.clock())
+}
+
+func (m *MemIndex) lookup(keys []string, now int64) []KeyEntry {
 	results := make([]KeyEntry, 0, len(keys))
 	for _, key := range keys {
 		m.mu.RLock()
@@ -60,8 +91,7 @@ func (m *MemIndex) scan(match func(string) bool, limit int) []KeyEntry {
 			m.mu.Lock()
 			entry, ok = m.data[key]
 			if ok && isExpired(entry.ExpiresAt, now) {
-				delete(m.data, key)
-				m.size -= entrySize(key, entry)
+				m.expireLocked(key, entry)
 			}
 			m.mu.Unlock()
 			continue
@@ -72,20 +102,24 @@ func (m *MemIndex) scan(match func(string) bool, limit int) []KeyEntry {
 				Value:     cloneBytes(entry.Value),
 				ExpiresAt: entry.ExpiresAt,
 				CreatedAt: entry.CreatedAt,
+				Pointer:   entry.Pointer,
+				Operands:  entry.Operands,
+				NoBase:    entry.NoBase,
+				Meta:      entry.Meta,
 			},
 		})
 	}
 	return results
 }
 
-func (m *MemIndex) scanKeys(match func(string) bool) []string {
+func (m *MemIndex) scanKeys(match func(string, *Entry) bool) []string {
 	m.mu.RLock()
 	keys := make([]string, 0, len(m.data))
 	for k, entry := range m.data {
-		if isExpired(entry.ExpiresAt, time.Now().UnixNano()) {
+		if isExpired(entry.ExpiresAt, m.clock()) {
 			continue
 		}
-		if match(k) {
+		if match(k, entry) {
 			keys = append(keys, k)
 		}
 	}
@@ -6,65 +6,234 @@ import (
 )
 
 // Entry represents a key-value pair with metadata.
+// When Pointer is set, Value holds an encoded value-log pointer rather than the value itself.
 type Entry struct {
 	Value     []byte
 	ExpiresAt int64
 	CreatedAt int64
+	Pointer   bool
+	// Operands are merge operands not yet folded into Value, oldest first.
+	// NoBase marks an entry whose operands apply to a key that had no value.
+	Operands [][]byte
+	NoBase   bool
+	// Meta marks the metadata entry of a collection such as a hash.
+	Meta bool
+
+	access *accessStats
 }
 
+// Observer is notified of every stored or removed key. entry is nil when
+// the key was deleted or expired.
+type Observer func(key string, entry *Entry)
+
+// ExpiryHook is notified of every key removed because it expired, with the
+// entry that expired.
+type ExpiryHook func(key string, entry *Entry)
+
 // MemIndex is the in-memory key-value index.
 type MemIndex struct {
-	mu   sync.RWMutex
-	data map[string]*Entry
-	size int64
+	mu       sync.RWMutex
+	data     map[string]*Entry
+	size     int64
+	garbage  int64
+	observer Observer
+	onExpiry ExpiryHook
+	// expiries orders the keys stored with a TTL by expiry, for ExpireDue.
+	expiries expiryHeap
+	// trackAccess records reads of each entry, for eviction.
+	trackAccess bool
+	// clock returns the current time in Unix nanoseconds.
+	clock func() int64
 }
 
 // NewMemIndex creates an empty in-memory index.
 func NewMemIndex() *MemIndex {
-	return &MemIndex{data: make(map[string]*Entry)}
+	return &MemIndex{data: make(map[string]*Entry), clock: wallClock}
+}
+
+func wallClock() int64 {
+	return time.Now().UnixNano()
+}
+
+// SetClock sets the time source used to decide whether entries have expired
+// and to timestamp accesses. It must be called before the index is shared.
+func (m *MemIndex) SetClock(fn func() int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = fn
 }
 
 // Set stores a key with value and expiration timestamp (Unix nanoseconds).
 // Use expiresAt = -1 to indicate no expiration.
 func (m *MemIndex) Set(key string, value []byte, expiresAt int64) {
-	m.SetEntry(key, value, expiresAt, time.Now().UnixNano())
+	m.SetEntry(key, value, expiresAt, m.clock())
 }
 
 // SetEntry stores a key with explicit creation timestamp.
 func (m *MemIndex) SetEntry(key string, value []byte, expiresAt int64, createdAt int64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.store(key, &Entry{
+		Value:     cloneBytes(value),
+		ExpiresAt: expiresAt,
+		CreatedAt: createdAt,
+	})
+}
 
-	if existing, ok := m.data[key]; ok {
-		m.size -= entrySize(key, existing)
+// SetValuePointer stores a key whose value lives in the value log.
+func (m *MemIndex) SetValuePointer(key string, ptr []byte, expiresAt int64, createdAt int64) {
+	m.store(key, &Entry{
+		Value:     cloneBytes(ptr),
+		ExpiresAt: expiresAt,
+		CreatedAt: createdAt,
+		Pointer:   true,
+	})
+}
+
+// SetUnmerged stores entry with merge operands not folded into its value,
+// as kept by a snapshot whose fold failed. Only the value, pointer, operand,
+// expiry and creation fields of entry are used.
+func (m *MemIndex) SetUnmerged(key string, entry Entry) {
+	operands := make([][]byte, len(entry.Operands))
+	for i, operand := range entry.Operands {
+		operands[i] = cloneBytes(operand)
 	}
+	m.store(key, &Entry{
+		Value:     cloneBytes(entry.Value),
+		ExpiresAt: entry.ExpiresAt,
+		CreatedAt: entry.CreatedAt,
+		Pointer:   entry.Pointer,
+		Operands:  operands,
+		NoBase:    entry.NoBase,
+	})
+}
 
-	entry := &Entry{
+// SetMeta stores a collection metadata entry.
+func (m *MemIndex) SetMeta(key string, value []byte, expiresAt int64, createdAt int64) {
+	m.store(key, &Entry{
 		Value:     cloneBytes(value),
 		ExpiresAt: expiresAt,
 		CreatedAt: createdAt,
+		Meta:      true,
+	})
+}
+
+func (m *MemIndex) store(key string, entry *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.storeLocked(key, entry)
+}
+
+// Merge appends a merge operand to key. If key holds an entry that is live at
+// timestamp the operand is stacked on it; otherwise the operand starts a new
+// entry without a base value. expiresAt becomes the entry's expiry.
+func (m *MemIndex) Merge(key string, operand []byte, expiresAt int64, timestamp int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.data[key]
+	if !ok || isExpired(existing.ExpiresAt, timestamp) {
+		m.storeLocked(key, &Entry{
+			ExpiresAt: expiresAt,
+			CreatedAt: timestamp,
+			Operands:  [][]byte{cloneBytes(operand)},
+			NoBase:    true,
+		})
+		return
+	}
+	// Entries are shared with readers, so the operand list is copied rather
+	// than appended in place. Only the new operand counts toward the size.
+	operands := make([][]byte, 0, len(existing.Operands)+1)
+	operands = append(append(operands, existing.Operands...), cloneBytes(operand))
+	entry := *existing
+	entry.ExpiresAt = expiresAt
+	entry.Operands = operands
+	m.data[key] = &entry
+	m.size += int64(len(operand))
+	if expiresAt != existing.ExpiresAt {
+		m.trackExpiryLocked(key, expiresAt)
+	}
+	if m.observer != nil {
+		m.observer(key, &entry)
+	}
+}
+
+func (m *MemIndex) storeLocked(key string, entry *Entry) {
+	existing, ok := m.data[key]
+	if ok {
+		m.size -= entrySize(key, existing)
+		m.garbage += entrySize(key, existing)
+	}
+	if m.trackAccess {
+		// A write counts as an access; an overwritten key keeps its history.
+		now := m.clock()
+		if ok && existing.access != nil {
+			entry.access = existing.access
+			entry.access.touch(now)
+		} else {
+			entry.access = newAccessStats(now)
+		}
 	}
 	m.data[key] = entry
 	m.size += entrySize(key, entry)
+	// A live entry's expiry is already tracked.
+	if !ok || existing.ExpiresAt != entry.ExpiresAt {
+		m.trackExpiryLocked(key, entry.ExpiresAt)
+	}
+	if m.observer != nil {
+		m.observer(key, entry)
+	}
+}
+
+// SetObserver registers a callback invoked after each change. It runs with
+// the index lock held, so it must not call back into the index.
+func (m *MemIndex) SetObserver(fn Observer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observer = fn
+}
+
+// SetExpiryHook registers a callback invoked for each key removed because it
+// expired, whether by ExpireDue or lazily by a read. It runs with the index
+// lock held, so it must not call back into the index.
+func (m *MemIndex) SetExpiryHook(fn ExpiryHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onExpiry = fn
+}
+
+// DeleteExpired removes key if its entry has a TTL and expires at or before
+// expiresAt, and reports whether it did. It replays a reaped expiry without
+// depending on the local clock.
+func (m *MemIndex) DeleteExpired(key string, expiresAt int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.data[key]
+	if !ok || entry.ExpiresAt < 0 || entry.ExpiresAt > expiresAt {
+		return false
+	}
+	m.removeLocked(key, entry)
+	return true
 }
 
 // Get returns the entry for key if it exists and is not expired.
 func (m *MemIndex) Get(key string) (*Entry, bool) {
+	now := m.clock()
 	m.mu.RLock()
 	entry, ok := m.data[key]
+	if ok && entry.access != nil && !isExpired(entry.ExpiresAt, now) {
+		entry.access.touch(now)
+	}
 	m.mu.RUnlock()
 
 	if !ok {
 		return nil, false
 	}
 
-	if isExpired(entry.ExpiresAt, time.Now().UnixNano()) {
+	if isExpired(entry.ExpiresAt, now) {
 		m.mu.Lock()
 		// Recheck under write lock before delete.
 		entry, ok = m.data[key]
-		if ok && isExpired(entry.ExpiresAt, time.Now().UnixNano()) {
-			delete(m.data, key)
-			m.size -= entrySize(key, entry)
+		if ok && isExpired(entry.ExpiresAt, m.clock()) {
+			m.expireLocked(key, entry)
 		}
 		m.mu.Unlock()
 		return nil, false
@@ -79,20 +248,36 @@ func (m *MemIndex) Delete(key string) {
 	defer m.mu.Unlock()
 
 	if entry, ok := m.data[key]; ok {
-		delete(m.data, key)
-		m.size -= entrySize(key, entry)
+		m.removeLocked(key, entry)
 	}
 }
 
+// DeleteRange removes every key in [start, end), or every key from start on
+// when end is empty, under one lock, and returns the removed keys.
+func (m *MemIndex) DeleteRange(start, end string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var removed []string
+	for key, entry := range m.data {
+		if key < start || (end != "" && key >= end) {
+			continue
+		}
+		m.removeLocked(key, entry)
+		removed = append(removed, key)
+	}
+	return removed
+}
+
 // Exists reports whether key exists and is not expired.
 func (m *MemIndex) Exists(key string) bool {
 	_, ok := m.Get(key)
 	return ok
 }
 
-// Count returns the number of non-expired keys.
+// Count returns the number of non-expired keys, leaving out collection
+// metadata entries as scans do.
 func (m *MemIndex) Count() int {
-	now := time.Now().UnixNano()
+	now := m.clock()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -100,8 +285,10 @@ func (m *MemIndex) Count() int {
 	count := 0
 	for k, entry := range m.data {
 		if isExpired(entry.ExpiresAt, now) {
-			delete(m.data, k)
-			m.size -= entrySize(k, entry)
+			m.expireLocked(k, entry)
+			continue
+		}
+		if entry.Meta {
 			continue
 		}
 		count++
@@ -110,6 +297,40 @@ func (m *MemIndex) Count() int {
 	return count
 }
 
+// ForEach calls fn for every entry, including expired ones, until fn returns false.
+// fn must not modify the index.
+func (m *MemIndex) ForEach(fn func(key string, entry *Entry) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for k, entry := range m.data {
+		if !fn(k, entry) {
+			return
+		}
+	}
+}
+
+// Garbage returns the bytes of overwritten, deleted or expired entries since
+// the last ResetGarbage call.
+func (m *MemIndex) Garbage() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.garbage
+}
+
+// ResetGarbage clears the garbage counter, typically after a snapshot.
+func (m *MemIndex) ResetGarbage() {
+	m.mu.Lock()
+	m.garbage = 0
+	m.mu.Unlock()
+}
+
+// Len returns the number of stored keys, including expired keys not yet removed.
+func (m *MemIndex) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.data)
+}
+
 // Size returns the estimated memory size in bytes.
 func (m *MemIndex) Size() int64 {
 	m.mu.RLock()
@@ -117,6 +338,22 @@ func (m *MemIndex) Size() int64 {
 	return m.size
 }
 
+func (m *MemIndex) expireLocked(key string, entry *Entry) {
+	m.removeLocked(key, entry)
+	if m.onExpiry != nil {
+		m.onExpiry(key, entry)
+	}
+}
+
+func (m *MemIndex) removeLocked(key string, entry *Entry) {
+	delete(m.data, key)
+	m.size -= entrySize(key, entry)
+	m.garbage += entrySize(key, entry)
+	if m.observer != nil {
+		m.observer(key, nil)
+	}
+}
+
 func isExpired(expiresAt int64, now int64) bool {
 	if expiresAt < 0 {
 		return false
@@ -131,7 +368,11 @@ func entrySize(key string, entry *Entry) int64 {
 	if entry == nil {
 		return 0
 	}
-	return int64(len(key) + len(entry.Value))
+	size := int64(len(key) + len(entry.Value))
+	for _, operand := range entry.Operands {
+		size += int64(len(operand))
+	}
+	return size
 }
 
 func cloneBytes(src []byte) []byte {
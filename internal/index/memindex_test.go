@@ -2,7 +2,10 @@ package index
 
 import (
 	"bytes"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/leanovate/gopter"
 	"github.com/leanovate/gopter/gen"
@@ -89,6 +92,78 @@ func TestMemIndexDeleteIsIdempotent(t *testing.T) {
 	properties.TestingRun(t)
 }
 
+func TestMemIndexDeleteRangeRemovesOnlyKeysInRange(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 100
+	properties := gopter.NewProperties(parameters)
+
+	properties.Property("delete range removes exactly [start, end)", prop.ForAll(
+		func(keys []string, start, end string) bool {
+			idx := NewMemIndex()
+			for _, key := range keys {
+				idx.Set(key, []byte("v"), -1)
+			}
+			removed := make(map[string]bool)
+			for _, key := range idx.DeleteRange(start, end) {
+				removed[key] = true
+			}
+			for _, key := range keys {
+				inRange := key >= start && (end == "" || key < end)
+				if removed[key] != inRange || idx.Exists(key) == inRange {
+					return false
+				}
+			}
+			return true
+		},
+		gen.SliceOf(gen.AlphaString()),
+		gen.AlphaString(),
+		gen.AlphaString(),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestMemIndexSortedKeysMatchesPrefix(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 100
+	properties := gopter.NewProperties(parameters)
+
+	properties.Property("sorted keys list every matching key once, sorted", prop.ForAll(
+		func(keys []string, prefix string) bool {
+			idx := NewMemIndex()
+			want := make(map[string]bool)
+			for _, key := range keys {
+				if key == "" {
+					continue
+				}
+				idx.Set(key, []byte("v"), -1)
+				if strings.HasPrefix(key, prefix) {
+					want[key] = true
+				}
+			}
+			got := idx.SortedKeys(prefix)
+			if len(got) != len(want) || !sort.StringsAreSorted(got) {
+				return false
+			}
+			for i, key := range got {
+				if !want[key] || (i > 0 && got[i-1] == key) {
+					return false
+				}
+			}
+			return true
+		},
+		gen.SliceOf(gen.AlphaString()),
+		gen.AlphaString().Map(func(s string) string {
+			if len(s) > 1 {
+				return s[:1]
+			}
+			return s
+		}),
+	))
+
+	properties.TestingRun(t)
+}
+
 func TestMemIndexExistsReflectsKeyState(t *testing.T) {
 	parameters := gopter.DefaultTestParameters()
 	parameters.MinSuccessfulTests = 100
@@ -113,3 +188,150 @@ func TestMemIndexExistsReflectsKeyState(t *testing.T) {
 
 	properties.TestingRun(t)
 }
+
+func TestMemIndexObserverSeesEveryChange(t *testing.T) {
+	idx := NewMemIndex()
+	live := make(map[string]bool)
+	idx.SetObserver(func(key string, entry *Entry) {
+		live[key] = entry != nil
+	})
+
+	idx.Set("a", []byte("1"), -1)
+	idx.Set("b", []byte("2"), time.Now().Add(-time.Second).UnixNano())
+	idx.Set("c", []byte("3"), -1)
+	idx.Delete("c")
+	if !live["a"] || !live["b"] || live["c"] {
+		t.Fatalf("unexpected observed state: %v", live)
+	}
+	if idx.Count() != 1 || live["b"] {
+		t.Fatalf("expected expiry to be observed: %v", live)
+	}
+}
+
+func TestMemIndexMergeStacksOperands(t *testing.T) {
+	idx := NewMemIndex()
+	now := time.Now().UnixNano()
+
+	idx.Merge("fresh", []byte("1"), -1, now)
+	entry, ok := idx.Get("fresh")
+	if !ok || !entry.NoBase || len(entry.Operands) != 1 {
+		t.Fatalf("expected operand without base, got %+v", entry)
+	}
+
+	idx.Set("base", []byte("v"), -1)
+	before, _ := idx.Get("base")
+	idx.Merge("base", []byte("a"), -1, now)
+	idx.Merge("base", []byte("b"), -1, now)
+	entry, _ = idx.Get("base")
+	if entry.NoBase || string(entry.Value) != "v" || len(entry.Operands) != 2 || string(entry.Operands[1]) != "b" {
+		t.Fatalf("expected operands on base, got %+v", entry)
+	}
+	if len(before.Operands) != 0 {
+		t.Fatalf("merge modified an entry held by a reader")
+	}
+
+	idx.Set("old", []byte("v"), now-1)
+	idx.Merge("old", []byte("a"), -1, now)
+	entry, _ = idx.Get("old")
+	if !entry.NoBase || entry.Value != nil {
+		t.Fatalf("expected expired base to be dropped, got %+v", entry)
+	}
+}
+
+func TestMemIndexExpireDue(t *testing.T) {
+	idx := NewMemIndex()
+	now := time.Now().UnixNano()
+	var observed []string
+	idx.SetObserver(func(key string, entry *Entry) {
+		if entry == nil {
+			observed = append(observed, key)
+		}
+	})
+
+	idx.Set("c", []byte("v"), now-1)
+	idx.Set("a", []byte("v"), now-3)
+	idx.Set("b", []byte("v"), now-2)
+	idx.Set("live", []byte("v"), now+int64(time.Hour))
+	idx.Set("forever", []byte("v"), -1)
+	// Overwritten and deleted keys leave stale heap items behind.
+	idx.Set("renewed", []byte("v"), now-4)
+	idx.Set("renewed", []byte("v"), now+int64(time.Hour))
+	idx.Set("deleted", []byte("v"), now-5)
+	idx.Delete("deleted")
+	observed = nil
+
+	if removed := idx.ExpireDue(now, 2); len(removed) != 2 || removed[0] != "a" || removed[1] != "b" {
+		t.Fatalf("expected earliest expiries first within the limit, got %v", removed)
+	}
+	if removed := idx.ExpireDue(now, 0); len(removed) != 1 || removed[0] != "c" {
+		t.Fatalf("expected the remaining expired key, got %v", removed)
+	}
+	if len(observed) != 3 {
+		t.Fatalf("expected removals to be observed, got %v", observed)
+	}
+	if idx.Len() != 3 {
+		t.Fatalf("expected live keys to stay, got %d keys", idx.Len())
+	}
+	if removed := idx.ExpireDue(now+int64(2*time.Hour), 0); len(removed) != 2 {
+		t.Fatalf("expected renewed and live keys to expire later, got %v", removed)
+	}
+}
+
+func TestMemIndexExpiryHeapStaysBounded(t *testing.T) {
+	idx := NewMemIndex()
+	expiresAt := time.Now().Add(time.Hour).UnixNano()
+	for i := 0; i < 10*minExpiryRebuild; i++ {
+		idx.Set("k", []byte("v"), expiresAt+int64(i))
+	}
+	if len(idx.expiries) > 2*minExpiryRebuild {
+		t.Fatalf("expected stale expiry items to be dropped, heap holds %d", len(idx.expiries))
+	}
+	if removed := idx.ExpireDue(expiresAt+int64(10*minExpiryRebuild), 0); len(removed) != 1 {
+		t.Fatalf("expected the key to expire once, got %v", removed)
+	}
+}
+
+func TestMemIndexAccessTracking(t *testing.T) {
+	idx := NewMemIndex()
+	idx.Set("loaded", []byte("v"), -1)
+	if samples := idx.SampleKeys(10); len(samples) != 1 || samples[0].Hits != 0 {
+		t.Fatalf("expected no access history before tracking, got %+v", samples)
+	}
+	idx.SetAccessTracking(true)
+	idx.Set("read", []byte("v"), -1)
+	idx.Get("read")
+	idx.Get("read")
+	idx.Set("read", []byte("v2"), -1)
+
+	hits := make(map[string]uint32)
+	last := make(map[string]int64)
+	for _, sample := range idx.SampleKeys(10) {
+		hits[sample.Key] = sample.Hits
+		last[sample.Key] = sample.LastAccess
+	}
+	if hits["loaded"] != initialHits || hits["read"] != initialHits+3 {
+		t.Fatalf("unexpected hits %v", hits)
+	}
+	if last["read"] <= last["loaded"] {
+		t.Fatalf("expected the read key to be more recent, got %v", last)
+	}
+	if samples := idx.SampleKeys(1); len(samples) != 1 {
+		t.Fatalf("expected the sample to be capped, got %d", len(samples))
+	}
+}
+
+func TestMemIndexNextExpiry(t *testing.T) {
+	idx := NewMemIndex()
+	if _, ok := idx.NextExpiry(); ok {
+		t.Fatalf("expected no expiry in an empty index")
+	}
+	idx.Set("forever", []byte("v"), -1)
+	idx.Set("later", []byte("v"), 300)
+	idx.Set("renewed", []byte("v"), 100)
+	idx.Set("renewed", []byte("v"), 400)
+	idx.Set("deleted", []byte("v"), 50)
+	idx.Delete("deleted")
+	if sample, ok := idx.NextExpiry(); !ok || sample.Key != "later" || sample.ExpiresAt != 300 {
+		t.Fatalf("expected later to expire first, got %+v %v", sample, ok)
+	}
+}
@@ -0,0 +1,216 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Version is the binary manifest format version.
+const Version uint32 = 1
+
+var magic = []byte("MINIKVMF")
+
+const headerSize = 12
+
+// VersionEdit is one committed change to the manifest state.
+type VersionEdit struct {
+	HasCurrentWALSeq   bool
+	CurrentWALSeq      uint64
+	HasLastSnapshotSeq bool
+	LastSnapshotSeq    uint64
+	AddWALSegments     []WALSegment
+	AddSnapshots       []SnapshotInfo
+	AddDeltas          []SnapshotInfo
+	// RemovedFiles lists WAL segment or snapshot paths that were deleted.
+	RemovedFiles []string
+
+	HasNextFamilyID bool
+	NextFamilyID    uint32
+	AddFamilies     []FamilyInfo
+	DropFamilies    []uint32
+}
+
+type editTag uint8
+
+const (
+	tagCurrentWALSeq editTag = iota + 1
+	tagLastSnapshotSeq
+	tagAddWAL
+	tagAddSnapshot
+	tagAddDelta
+	tagRemoveFile
+	tagAddFamily
+	tagDropFamily
+	tagNextFamilyID
+)
+
+func encodeHeader() []byte {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.LittleEndian.PutUint32(header[8:], Version)
+	return header
+}
+
+func isBinary(data []byte) bool {
+	return len(data) >= len(magic) && string(data[:len(magic)]) == string(magic)
+}
+
+// encodeEdit encodes an edit as uvarint(payload length) + payload + CRC32,
+// the same framing the WAL uses.
+func encodeEdit(edit VersionEdit) []byte {
+	var payload []byte
+	putSeq := func(tag editTag, seq uint64) {
+		payload = append(payload, byte(tag))
+		payload = binary.AppendUvarint(payload, seq)
+	}
+	putBytes := func(data []byte) {
+		payload = binary.AppendUvarint(payload, uint64(len(data)))
+		payload = append(payload, data...)
+	}
+	putPath := func(tag editTag, seq uint64, path string) {
+		putSeq(tag, seq)
+		putBytes([]byte(path))
+	}
+	if edit.HasCurrentWALSeq {
+		putSeq(tagCurrentWALSeq, edit.CurrentWALSeq)
+	}
+	if edit.HasLastSnapshotSeq {
+		putSeq(tagLastSnapshotSeq, edit.LastSnapshotSeq)
+	}
+	for _, seg := range edit.AddWALSegments {
+		putPath(tagAddWAL, seg.Seq, seg.Path)
+	}
+	for _, snap := range edit.AddSnapshots {
+		putPath(tagAddSnapshot, snap.Seq, snap.Path)
+	}
+	for _, delta := range edit.AddDeltas {
+		putPath(tagAddDelta, delta.Seq, delta.Path)
+	}
+	for _, path := range edit.RemovedFiles {
+		putPath(tagRemoveFile, 0, path)
+	}
+	if edit.HasNextFamilyID {
+		putSeq(tagNextFamilyID, uint64(edit.NextFamilyID))
+	}
+	for _, family := range edit.AddFamilies {
+		putPath(tagAddFamily, uint64(family.ID), family.Name)
+		putBytes(family.Options)
+	}
+	for _, id := range edit.DropFamilies {
+		putSeq(tagDropFamily, uint64(id))
+	}
+
+	out := binary.AppendUvarint(nil, uint64(len(payload)))
+	out = append(out, payload...)
+	return binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(payload))
+}
+
+func decodeEdit(payload []byte) (VersionEdit, error) {
+	var edit VersionEdit
+	readBytes := func() ([]byte, bool) {
+		length, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < length {
+			return nil, false
+		}
+		data := payload[n : n+int(length)]
+		payload = payload[n+int(length):]
+		return data, true
+	}
+	for len(payload) > 0 {
+		tag := editTag(payload[0])
+		payload = payload[1:]
+		seq, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return VersionEdit{}, ErrInvalidManifest
+		}
+		payload = payload[n:]
+
+		var path string
+		switch tag {
+		case tagAddWAL, tagAddSnapshot, tagAddDelta, tagRemoveFile, tagAddFamily:
+			data, ok := readBytes()
+			if !ok {
+				return VersionEdit{}, ErrInvalidManifest
+			}
+			path = string(data)
+		}
+		switch tag {
+		case tagAddFamily, tagDropFamily, tagNextFamilyID:
+			if seq > 1<<32-1 {
+				return VersionEdit{}, ErrInvalidManifest
+			}
+		}
+
+		switch tag {
+		case tagCurrentWALSeq:
+			edit.HasCurrentWALSeq = true
+			edit.CurrentWALSeq = seq
+		case tagLastSnapshotSeq:
+			edit.HasLastSnapshotSeq = true
+			edit.LastSnapshotSeq = seq
+		case tagAddWAL:
+			edit.AddWALSegments = append(edit.AddWALSegments, WALSegment{Seq: seq, Path: path})
+		case tagAddSnapshot:
+			edit.AddSnapshots = append(edit.AddSnapshots, SnapshotInfo{Seq: seq, Path: path})
+		case tagAddDelta:
+			edit.AddDeltas = append(edit.AddDeltas, SnapshotInfo{Seq: seq, Path: path})
+		case tagRemoveFile:
+			edit.RemovedFiles = append(edit.RemovedFiles, path)
+		case tagAddFamily:
+			options, ok := readBytes()
+			if !ok {
+				return VersionEdit{}, ErrInvalidManifest
+			}
+			edit.AddFamilies = append(edit.AddFamilies, FamilyInfo{
+				ID:      uint32(seq),
+				Name:    path,
+				Options: append([]byte(nil), options...),
+			})
+		case tagDropFamily:
+			edit.DropFamilies = append(edit.DropFamilies, uint32(seq))
+		case tagNextFamilyID:
+			edit.HasNextFamilyID = true
+			edit.NextFamilyID = uint32(seq)
+		default:
+			return VersionEdit{}, ErrInvalidManifest
+		}
+	}
+	return edit, nil
+}
+
+// replay folds every edit in a binary manifest and returns the state and the
+// length of the valid prefix. An edit cut short by a crash ends the log; a
+// checksum mismatch before the final edit is reported as corruption.
+func replay(data []byte) (Manifest, int, error) {
+	if len(data) < headerSize || !isBinary(data) {
+		return Manifest{}, 0, ErrInvalidManifest
+	}
+	if binary.LittleEndian.Uint32(data[8:headerSize]) != Version {
+		return Manifest{}, 0, ErrUnsupportedVersion
+	}
+
+	manifest := Manifest{}
+	off := headerSize
+	for off < len(data) {
+		length, n := binary.Uvarint(data[off:])
+		if n <= 0 || uint64(len(data)-off-n) < length+4 {
+			break
+		}
+		start := off + n
+		end := start + int(length)
+		payload := data[start:end]
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[end:end+4]) {
+			if end+4 == len(data) {
+				break
+			}
+			return Manifest{}, 0, ErrManifestChecksum
+		}
+		edit, err := decodeEdit(payload)
+		if err != nil {
+			return Manifest{}, 0, err
+		}
+		manifest.Apply(edit)
+		off = end + 4
+	}
+	return manifest, off, nil
+}
@@ -0,0 +1,138 @@
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+)
+
+// DefaultMaxLogSize is the size above which the log is rolled over.
+const DefaultMaxLogSize = 4 << 20
+
+// Log is an append-only manifest. Each Apply appends one checksummed edit
+// and fsyncs it before the in-memory state changes. When the file grows past
+// maxSize it is replaced by a single edit holding the current state.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64
+	state   Manifest
+}
+
+// OpenLog replays the manifest at path, creating an empty one if it does not
+// exist. Text manifests are migrated to the binary format, and a torn final
+// edit is truncated away so new edits follow the last complete one.
+func OpenLog(path string, maxSize int64) (*Log, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxLogSize
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var state Manifest
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := WriteManifest(path, state); err != nil {
+			return nil, err
+		}
+	case !isBinary(data):
+		state, err = readText(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if err := WriteManifest(path, state); err != nil {
+			return nil, err
+		}
+	default:
+		var valid int
+		state, valid, err = replay(data)
+		if err != nil {
+			return nil, err
+		}
+		if valid < len(data) {
+			if err := os.Truncate(path, int64(valid)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	l := &Log{path: path, maxSize: maxSize, state: state}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// State returns a copy of the current manifest state.
+func (l *Log) State() Manifest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state.Clone()
+}
+
+// Apply durably appends edit and folds it into the state.
+func (l *Log) Apply(edit VersionEdit) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+
+	record := encodeEdit(edit)
+	if _, err := l.file.Write(record); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.size += int64(len(record))
+	l.state.Apply(edit)
+
+	if l.size > l.maxSize {
+		return l.rollover()
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *Log) rollover() error {
+	if err := WriteManifest(l.path, l.state); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	return l.openFile()
+}
+
+func (l *Log) openFile() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
@@ -0,0 +1,229 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+func TestLogReplaysEdits(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 50
+	properties := gopter.NewProperties(parameters)
+
+	properties.Property("reopened log matches applied state", prop.ForAll(
+		func(segments []WALSegment, snapshots []SnapshotInfo, removeFirst bool) bool {
+			path := filepath.Join(t.TempDir(), "MANIFEST")
+			log, err := OpenLog(path, 0)
+			if err != nil {
+				return false
+			}
+			for _, seg := range segments {
+				if err := log.Apply(VersionEdit{AddWALSegments: []WALSegment{seg}, HasCurrentWALSeq: true, CurrentWALSeq: seg.Seq}); err != nil {
+					return false
+				}
+			}
+			for _, snap := range snapshots {
+				if err := log.Apply(VersionEdit{AddSnapshots: []SnapshotInfo{snap}, HasLastSnapshotSeq: true, LastSnapshotSeq: snap.Seq}); err != nil {
+					return false
+				}
+			}
+			if removeFirst && len(segments) > 0 {
+				if err := log.Apply(VersionEdit{RemovedFiles: []string{segments[0].Path}}); err != nil {
+					return false
+				}
+			}
+			want := log.State()
+			if err := log.Close(); err != nil {
+				return false
+			}
+
+			reopened, err := OpenLog(path, 0)
+			if err != nil {
+				return false
+			}
+			defer reopened.Close()
+			got := reopened.State()
+			return got.CurrentWALSeq == want.CurrentWALSeq &&
+				got.LastSnapshotSeq == want.LastSnapshotSeq &&
+				segmentsEqual(got.WALSegments, want.WALSegments) &&
+				snapshotsEqual(got.Snapshots, want.Snapshots)
+		},
+		gen.SliceOf(genSegment()),
+		gen.SliceOf(genSnapshot()),
+		gen.Bool(),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestLogTruncatesTornEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "MANIFEST")
+	log, err := OpenLog(path, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := log.Apply(VersionEdit{AddWALSegments: []WALSegment{{Seq: 1, Path: "wal/1"}}}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	_ = log.Close()
+
+	torn := encodeEdit(VersionEdit{AddWALSegments: []WALSegment{{Seq: 2, Path: "wal/2"}}})
+	appendBytes(t, path, torn[:len(torn)-3])
+
+	log, err = OpenLog(path, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got := log.State().WALSegments; len(got) != 1 {
+		t.Fatalf("expected torn edit to be dropped, got %v", got)
+	}
+	if err := log.Apply(VersionEdit{AddWALSegments: []WALSegment{{Seq: 3, Path: "wal/3"}}}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	_ = log.Close()
+
+	state, err := ReadManifest(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(state.WALSegments) != 2 || state.WALSegments[1].Seq != 3 {
+		t.Fatalf("expected edits after truncation to replay, got %v", state.WALSegments)
+	}
+}
+
+func TestLogDetectsCorruptEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "MANIFEST")
+	log, err := OpenLog(path, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := log.Apply(VersionEdit{HasCurrentWALSeq: true, CurrentWALSeq: seq}); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+	}
+	_ = log.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	// Flip a payload byte in the first appended edit, which is not the tail.
+	first := len(data) - 2*len(encodeEdit(VersionEdit{HasCurrentWALSeq: true, CurrentWALSeq: 1}))
+	data[first+2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := OpenLog(path, 0); err != ErrManifestChecksum {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+}
+
+func TestLogRollsOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "MANIFEST")
+	log, err := OpenLog(path, 256)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer log.Close()
+	for seq := uint64(1); seq <= 100; seq++ {
+		seg := WALSegment{Seq: seq, Path: "wal/segment"}
+		if err := log.Apply(VersionEdit{AddWALSegments: []WALSegment{seg}}); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if err := log.Apply(VersionEdit{RemovedFiles: []string{seg.Path}}); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Size() > 256+64 {
+		t.Fatalf("expected rollover to bound size, got %d bytes", info.Size())
+	}
+}
+
+func TestLogMigratesTextManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "MANIFEST")
+	text := "current_wal_seq: 3\nlast_snapshot_seq: 2\n" +
+		"wal: 3 \"wal/000003.wal\"\n" +
+		"snapshot: 1 \"snapshots/snapshot_000001.snap\"\n" +
+		"delta: 2 \"snapshots/snapshot_000002.delta\"\n"
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	log, err := OpenLog(path, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer log.Close()
+	state := log.State()
+	if state.CurrentWALSeq != 3 || state.LastSnapshotSeq != 2 ||
+		len(state.WALSegments) != 1 || len(state.Snapshots) != 1 || len(state.Deltas) != 1 {
+		t.Fatalf("unexpected migrated state: %+v", state)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !isBinary(data) {
+		t.Fatalf("expected manifest to be rewritten in binary format")
+	}
+}
+
+func appendBytes(t *testing.T, path string, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+}
+
+func TestLogTracksFamilies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "MANIFEST")
+	log, err := OpenLog(path, 128)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	sessions := FamilyInfo{ID: 1, Name: "sessions", Options: []byte{1, 2, 3}}
+	docs := FamilyInfo{ID: 2, Name: "docs"}
+	if err := log.Apply(VersionEdit{AddFamilies: []FamilyInfo{sessions, docs}}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if err := log.Apply(VersionEdit{DropFamilies: []uint32{docs.ID}}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	// Enough edits to roll the log over, which must keep the dropped ID reserved.
+	for seq := uint64(1); seq <= 20; seq++ {
+		if err := log.Apply(VersionEdit{HasCurrentWALSeq: true, CurrentWALSeq: seq}); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reopened, err := OpenLog(path, 128)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	state := reopened.State()
+	if len(state.Families) != 1 || state.Families[0].Name != "sessions" || string(state.Families[0].Options) != string(sessions.Options) {
+		t.Fatalf("unexpected families: %+v", state.Families)
+	}
+	if state.NextFamilyID != 3 {
+		t.Fatalf("expected next family ID 3, got %d", state.NextFamilyID)
+	}
+}
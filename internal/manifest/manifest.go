@@ -1,12 +1,10 @@
 package manifest
 
 import (
-	"bufio"
-	"fmt"
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 )
 
 // WALSegment describes a WAL log segment.
@@ -21,65 +19,133 @@ type SnapshotInfo struct {
 	Path string
 }
 
+// FamilyInfo describes a column family. Options is opaque to the manifest.
+type FamilyInfo struct {
+	ID      uint32
+	Name    string
+	Options []byte
+}
+
 // Manifest tracks WAL and snapshot state.
+// The snapshot chain is the newest entry in Snapshots followed by every
+// entry in Deltas with a higher sequence, applied in sequence order.
 type Manifest struct {
 	CurrentWALSeq   uint64
 	LastSnapshotSeq uint64
 	WALSegments     []WALSegment
 	Snapshots       []SnapshotInfo
+	Deltas          []SnapshotInfo
+	// Families lists live column families. NextFamilyID is never reused, so
+	// WAL records and snapshot entries of dropped families stay unreachable.
+	Families     []FamilyInfo
+	NextFamilyID uint32
 }
 
-// ReadManifest loads a manifest from disk.
-func ReadManifest(path string) (Manifest, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return Manifest{}, err
-	}
-	defer file.Close()
+var (
+	ErrInvalidManifest  = errors.New("manifest: invalid file")
+	ErrManifestChecksum = errors.New("manifest: checksum mismatch")
+	// ErrUnsupportedVersion is returned for manifests written by a newer format.
+	ErrUnsupportedVersion = errors.New("manifest: unsupported version")
+)
 
-	manifest := Manifest{}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+// Apply folds a version edit into the manifest state.
+func (m *Manifest) Apply(edit VersionEdit) {
+	if edit.HasCurrentWALSeq {
+		m.CurrentWALSeq = edit.CurrentWALSeq
+	}
+	if edit.HasLastSnapshotSeq {
+		m.LastSnapshotSeq = edit.LastSnapshotSeq
+	}
+	m.WALSegments = append(m.WALSegments, edit.AddWALSegments...)
+	m.Snapshots = append(m.Snapshots, edit.AddSnapshots...)
+	m.Deltas = append(m.Deltas, edit.AddDeltas...)
+	for _, path := range edit.RemovedFiles {
+		m.remove(path)
+	}
+	if edit.HasNextFamilyID && edit.NextFamilyID > m.NextFamilyID {
+		m.NextFamilyID = edit.NextFamilyID
+	}
+	for _, family := range edit.AddFamilies {
+		m.Families = append(m.Families, family)
+		if family.ID >= m.NextFamilyID {
+			m.NextFamilyID = family.ID + 1
 		}
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			return Manifest{}, fmt.Errorf("manifest: invalid line %q", line)
-		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		switch key {
-		case "current_wal_seq":
-			manifest.CurrentWALSeq, err = parseUint(value)
-		case "last_snapshot_seq":
-			manifest.LastSnapshotSeq, err = parseUint(value)
-		case "wal":
-			seg, parseErr := parseSegment(value)
-			if parseErr != nil {
-				return Manifest{}, parseErr
-			}
-			manifest.WALSegments = append(manifest.WALSegments, seg)
-		case "snapshot":
-			snap, parseErr := parseSnapshot(value)
-			if parseErr != nil {
-				return Manifest{}, parseErr
+	}
+	for _, id := range edit.DropFamilies {
+		families := m.Families[:0]
+		for _, family := range m.Families {
+			if family.ID != id {
+				families = append(families, family)
 			}
-			manifest.Snapshots = append(manifest.Snapshots, snap)
 		}
-		if err != nil {
-			return Manifest{}, err
+		m.Families = families
+	}
+}
+
+func (m *Manifest) remove(path string) {
+	segments := m.WALSegments[:0]
+	for _, seg := range m.WALSegments {
+		if seg.Path != path {
+			segments = append(segments, seg)
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return Manifest{}, err
+	m.WALSegments = segments
+	m.Snapshots = removeSnapshot(m.Snapshots, path)
+	m.Deltas = removeSnapshot(m.Deltas, path)
+}
+
+func removeSnapshot(infos []SnapshotInfo, path string) []SnapshotInfo {
+	out := infos[:0]
+	for _, info := range infos {
+		if info.Path != path {
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+// Clone returns a deep copy of the manifest.
+func (m Manifest) Clone() Manifest {
+	m.WALSegments = append([]WALSegment(nil), m.WALSegments...)
+	m.Snapshots = append([]SnapshotInfo(nil), m.Snapshots...)
+	m.Deltas = append([]SnapshotInfo(nil), m.Deltas...)
+	m.Families = append([]FamilyInfo(nil), m.Families...)
+	return m
+}
+
+// edit returns a single version edit that recreates the manifest from empty.
+func (m Manifest) edit() VersionEdit {
+	return VersionEdit{
+		HasCurrentWALSeq:   true,
+		CurrentWALSeq:      m.CurrentWALSeq,
+		HasLastSnapshotSeq: true,
+		LastSnapshotSeq:    m.LastSnapshotSeq,
+		AddWALSegments:     m.WALSegments,
+		AddSnapshots:       m.Snapshots,
+		AddDeltas:          m.Deltas,
+		HasNextFamilyID:    true,
+		NextFamilyID:       m.NextFamilyID,
+		AddFamilies:        m.Families,
 	}
+}
 
-	return manifest, nil
+// ReadManifest loads a manifest from disk. Binary manifests are replayed
+// edit by edit; a torn final edit is ignored. Text manifests written by
+// older versions are parsed as-is.
+func ReadManifest(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if !isBinary(data) {
+		return readText(bytes.NewReader(data))
+	}
+	manifest, _, err := replay(data)
+	return manifest, err
 }
 
-// WriteManifest writes the manifest atomically using a temp file + rename.
+// WriteManifest writes the manifest atomically as a binary log holding a
+// single edit, using a temp file + rename followed by a directory fsync.
 func WriteManifest(path string, manifest Manifest) error {
 	tmpPath := path + ".tmp"
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
@@ -91,30 +157,8 @@ func WriteManifest(path string, manifest Manifest) error {
 		return err
 	}
 
-	writer := bufio.NewWriter(file)
-	if _, err := fmt.Fprintf(writer, "current_wal_seq: %d\n", manifest.CurrentWALSeq); err != nil {
-		file.Close()
-		return err
-	}
-	if _, err := fmt.Fprintf(writer, "last_snapshot_seq: %d\n", manifest.LastSnapshotSeq); err != nil {
-		file.Close()
-		return err
-	}
-	for _, seg := range manifest.WALSegments {
-		path := strconv.Quote(seg.Path)
-		if _, err := fmt.Fprintf(writer, "wal: %d %s\n", seg.Seq, path); err != nil {
-			file.Close()
-			return err
-		}
-	}
-	for _, snap := range manifest.Snapshots {
-		path := strconv.Quote(snap.Path)
-		if _, err := fmt.Fprintf(writer, "snapshot: %d %s\n", snap.Seq, path); err != nil {
-			file.Close()
-			return err
-		}
-	}
-	if err := writer.Flush(); err != nil {
+	data := append(encodeHeader(), encodeEdit(manifest.edit())...)
+	if _, err := file.Write(data); err != nil {
 		file.Close()
 		return err
 	}
@@ -126,55 +170,17 @@ func WriteManifest(path string, manifest Manifest) error {
 		return err
 	}
 
-	return os.Rename(tmpPath, path)
-}
-
-func parseUint(value string) (uint64, error) {
-	return strconv.ParseUint(value, 10, 64)
-}
-
-func parseSegment(value string) (WALSegment, error) {
-	seqStr, pathStr, ok := splitSeqPath(value)
-	if !ok {
-		return WALSegment{}, fmt.Errorf("manifest: invalid wal segment %q", value)
-	}
-	seq, err := strconv.ParseUint(seqStr, 10, 64)
-	if err != nil {
-		return WALSegment{}, err
-	}
-	path, err := strconv.Unquote(pathStr)
-	if err != nil {
-		return WALSegment{}, err
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
 	}
-	return WALSegment{Seq: seq, Path: path}, nil
+	return syncDir(filepath.Dir(path))
 }
 
-func parseSnapshot(value string) (SnapshotInfo, error) {
-	seqStr, pathStr, ok := splitSeqPath(value)
-	if !ok {
-		return SnapshotInfo{}, fmt.Errorf("manifest: invalid snapshot %q", value)
-	}
-	seq, err := strconv.ParseUint(seqStr, 10, 64)
-	if err != nil {
-		return SnapshotInfo{}, err
-	}
-	path, err := strconv.Unquote(pathStr)
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
 	if err != nil {
-		return SnapshotInfo{}, err
-	}
-	return SnapshotInfo{Seq: seq, Path: path}, nil
-}
-
-func splitSeqPath(value string) (string, string, bool) {
-	value = strings.TrimSpace(value)
-	idx := strings.IndexByte(value, ' ')
-	if idx <= 0 {
-		return "", "", false
-	}
-	seqStr := strings.TrimSpace(value[:idx])
-	pathStr := strings.TrimSpace(value[idx+1:])
-	if seqStr == "" || pathStr == "" {
-		return "", "", false
+		return err
 	}
-	return seqStr, pathStr, true
+	defer file.Close()
+	return file.Sync()
 }
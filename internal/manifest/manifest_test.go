@@ -15,6 +15,7 @@ type manifestFixture struct {
 	LastSnapshotSeq uint64
 	WALSegments     []WALSegment
 	Snapshots       []SnapshotInfo
+	Deltas          []SnapshotInfo
 }
 
 func TestManifestTracksState(t *testing.T) {
@@ -32,6 +33,7 @@ func TestManifestTracksState(t *testing.T) {
 				LastSnapshotSeq: fixture.LastSnapshotSeq,
 				WALSegments:     fixture.WALSegments,
 				Snapshots:       fixture.Snapshots,
+				Deltas:          fixture.Deltas,
 			}
 			if err := WriteManifest(path, manifest); err != nil {
 				return false
@@ -51,6 +53,9 @@ func TestManifestTracksState(t *testing.T) {
 			if !snapshotsEqual(loaded.Snapshots, manifest.Snapshots) {
 				return false
 			}
+			if !snapshotsEqual(loaded.Deltas, manifest.Deltas) {
+				return false
+			}
 			return true
 		},
 		genManifestFixture(),
@@ -79,12 +84,14 @@ func genManifestFixture() gopter.Gen {
 		gen.UInt64(),
 		gen.SliceOf(genSegment()),
 		gen.SliceOf(genSnapshot()),
+		gen.SliceOf(genSnapshot()),
 	).Map(func(values []interface{}) manifestFixture {
 		return manifestFixture{
 			CurrentWALSeq:   values[0].(uint64),
 			LastSnapshotSeq: values[1].(uint64),
 			WALSegments:     values[2].([]WALSegment),
 			Snapshots:       values[3].([]SnapshotInfo),
+			Deltas:          values[4].([]SnapshotInfo),
 		}
 	})
 }
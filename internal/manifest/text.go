@@ -0,0 +1,111 @@
+package manifest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// readText parses the line-oriented MANIFEST written before the binary log.
+// It is kept so that existing databases can be migrated on open.
+func readText(r io.Reader) (Manifest, error) {
+	var err error
+	manifest := Manifest{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return Manifest{}, fmt.Errorf("manifest: invalid line %q", line)
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		switch key {
+		case "current_wal_seq":
+			manifest.CurrentWALSeq, err = parseUint(value)
+		case "last_snapshot_seq":
+			manifest.LastSnapshotSeq, err = parseUint(value)
+		case "wal":
+			seg, parseErr := parseSegment(value)
+			if parseErr != nil {
+				return Manifest{}, parseErr
+			}
+			manifest.WALSegments = append(manifest.WALSegments, seg)
+		case "snapshot":
+			snap, parseErr := parseSnapshot(value)
+			if parseErr != nil {
+				return Manifest{}, parseErr
+			}
+			manifest.Snapshots = append(manifest.Snapshots, snap)
+		case "delta":
+			delta, parseErr := parseSnapshot(value)
+			if parseErr != nil {
+				return Manifest{}, parseErr
+			}
+			manifest.Deltas = append(manifest.Deltas, delta)
+		}
+		if err != nil {
+			return Manifest{}, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Manifest{}, err
+	}
+
+	return manifest, nil
+}
+
+func parseUint(value string) (uint64, error) {
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func parseSegment(value string) (WALSegment, error) {
+	seqStr, pathStr, ok := splitSeqPath(value)
+	if !ok {
+		return WALSegment{}, fmt.Errorf("manifest: invalid wal segment %q", value)
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return WALSegment{}, err
+	}
+	path, err := strconv.Unquote(pathStr)
+	if err != nil {
+		return WALSegment{}, err
+	}
+	return WALSegment{Seq: seq, Path: path}, nil
+}
+
+func parseSnapshot(value string) (SnapshotInfo, error) {
+	seqStr, pathStr, ok := splitSeqPath(value)
+	if !ok {
+		return SnapshotInfo{}, fmt.Errorf("manifest: invalid snapshot %q", value)
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	path, err := strconv.Unquote(pathStr)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return SnapshotInfo{Seq: seq, Path: path}, nil
+}
+
+func splitSeqPath(value string) (string, string, bool) {
+	value = strings.TrimSpace(value)
+	idx := strings.IndexByte(value, ' ')
+	if idx <= 0 {
+		return "", "", false
+	}
+	seqStr := strings.TrimSpace(value[:idx])
+	pathStr := strings.TrimSpace(value[idx+1:])
+	if seqStr == "" || pathStr == "" {
+		return "", "", false
+	}
+	return seqStr, pathStr, true
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"hash"
 	"hash/crc32"
 	"io"
 	"os"
@@ -14,12 +15,41 @@ var (
 	snapshotMagic = [8]byte{'M', 'I', 'N', 'I', 'K', 'V', 'S', 'N'}
 )
 
+// Version is the current snapshot format version.
+// Version 1 has no per-entry flags; version 2 adds a flags byte to each entry;
+// version 3 adds a column family ID to entries outside the default family;
+// version 4 adds the collection metadata flag; version 5 adds unfolded merge
+// operands.
+const Version uint32 = 5
+
+const (
+	flagPointer uint8 = 1 << iota
+	flagTombstone
+	flagFamily
+	flagMeta
+	flagOperands
+	flagNoBase
+)
+
 // Entry is a snapshot record.
+// When Pointer is set, Value holds an encoded value-log pointer.
+// Tombstone entries only appear in delta snapshots and mark deleted keys.
 type Entry struct {
 	Key       []byte
 	Value     []byte
 	ExpiresAt int64
 	CreatedAt int64
+	Pointer   bool
+	Tombstone bool
+	// Family is the column family of the entry; 0 is the default family.
+	Family uint32
+	// Meta marks the metadata entry of a collection such as a hash.
+	Meta bool
+	// Operands are merge operands that could not be folded into Value when
+	// the snapshot was taken, oldest first. NoBase marks operands applying
+	// to a key that had no value.
+	Operands [][]byte
+	NoBase   bool
 }
 
 // Header captures snapshot metadata.
@@ -33,14 +63,21 @@ type Header struct {
 var (
 	ErrInvalidSnapshot  = errors.New("snapshot: invalid file")
 	ErrSnapshotChecksum = errors.New("snapshot: checksum mismatch")
+	// ErrUnsupportedVersion is returned for files written by a newer format.
+	ErrUnsupportedVersion = errors.New("snapshot: unsupported version")
 )
 
-// EncodeSnapshot writes entries to writer in sorted key order.
+// EncodeSnapshot writes entries to writer sorted by family, then key.
 // It returns the CRC32 checksum of the payload.
 func EncodeSnapshot(w io.Writer, entries []Entry, version uint32, timestamp int64) (uint32, error) {
 	sorted := make([]Entry, len(entries))
 	copy(sorted, entries)
-	sort.Slice(sorted, func(i, j int) bool { return string(sorted[i].Key) < string(sorted[j].Key) })
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Family != sorted[j].Family {
+			return sorted[i].Family < sorted[j].Family
+		}
+		return string(sorted[i].Key) < string(sorted[j].Key)
+	})
 
 	head := Header{
 		Magic:     snapshotMagic,
@@ -58,7 +95,7 @@ func EncodeSnapshot(w io.Writer, entries []Entry, version uint32, timestamp int6
 	multi := io.MultiWriter(buf, hash)
 
 	for _, entry := range sorted {
-		if err := writeEntry(multi, entry); err != nil {
+		if err := writeEntry(multi, entry, version); err != nil {
 			return 0, err
 		}
 	}
@@ -77,44 +114,114 @@ func EncodeSnapshot(w io.Writer, entries []Entry, version uint32, timestamp int6
 
 // DecodeSnapshot reads a snapshot file and returns header and entries.
 func DecodeSnapshot(path string) (Header, []Entry, error) {
-	file, err := os.Open(path)
+	var entries []Entry
+	head, err := ScanSnapshot(path, func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
 	if err != nil {
 		return Header{}, nil, err
 	}
-	defer file.Close()
+	return head, entries, nil
+}
+
+// ScanSnapshot reads a snapshot file one entry at a time, passing each to
+// fn, and verifies the checksum once all are read. An error from fn stops
+// the scan and is returned.
+func ScanSnapshot(path string, fn func(Entry) error) (Header, error) {
+	r, err := OpenReader(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer r.Close()
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return r.Header(), nil
+		}
+		if err != nil {
+			return Header{}, err
+		}
+		if err := fn(entry); err != nil {
+			return Header{}, err
+		}
+	}
+}
 
+// Reader reads a snapshot file one entry at a time, for callers that pull
+// entries from several files at once.
+type Reader struct {
+	file     *os.File
+	reader   *bufio.Reader
+	entries  io.Reader
+	hash     hash.Hash32
+	head     Header
+	read     uint64
+	verified bool
+}
+
+// OpenReader opens a snapshot file and reads its header.
+func OpenReader(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
 	reader := bufio.NewReader(file)
 	head, err := readHeader(reader)
-	if err != nil {
-		return Header{}, nil, err
+	if err == nil && head.Magic != snapshotMagic {
+		err = ErrInvalidSnapshot
 	}
-	if head.Magic != snapshotMagic {
-		return Header{}, nil, ErrInvalidSnapshot
+	if err == nil && (head.Version == 0 || head.Version > Version) {
+		err = ErrUnsupportedVersion
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
 	}
+	r := &Reader{file: file, reader: reader, hash: crc32.NewIEEE(), head: head}
+	r.entries = io.TeeReader(reader, r.hash)
+	return r, nil
+}
 
-	entries := make([]Entry, 0, head.Count)
-	hash := crc32.NewIEEE()
-	multi := io.TeeReader(reader, hash)
+// Header returns the file's header.
+func (r *Reader) Header() Header {
+	return r.head
+}
 
-	for i := uint64(0); i < head.Count; i++ {
-		entry, err := readEntry(multi)
-		if err != nil {
-			return Header{}, nil, err
+// Next returns the next entry. After the last one it verifies the checksum
+// and returns io.EOF; a file that ends early fails with io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Entry, error) {
+	if r.read == r.head.Count {
+		if !r.verified {
+			// The checksum follows the entries and is not part of the hash.
+			var stored uint32
+			if err := binary.Read(r.reader, binary.LittleEndian, &stored); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return Entry{}, err
+			}
+			if stored != r.hash.Sum32() {
+				return Entry{}, ErrSnapshotChecksum
+			}
+			r.verified = true
 		}
-		entries = append(entries, entry)
+		return Entry{}, io.EOF
 	}
-
-	// After reading entries, read checksum from the underlying reader.
-	var stored uint32
-	if err := binary.Read(reader, binary.LittleEndian, &stored); err != nil {
-		return Header{}, nil, err
+	entry, err := readEntry(r.entries, r.head.Version)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
-	computed := hash.Sum32()
-	if stored != computed {
-		return Header{}, nil, ErrSnapshotChecksum
+	if err != nil {
+		return Entry{}, err
 	}
+	r.read++
+	return entry, nil
+}
 
-	return head, entries, nil
+// Close closes the file.
+func (r *Reader) Close() error {
+	return r.file.Close()
 }
 
 func writeHeader(w io.Writer, head Header) error {
@@ -150,7 +257,36 @@ func readHeader(r io.Reader) (Header, error) {
 	return head, nil
 }
 
-func writeEntry(w io.Writer, entry Entry) error {
+func writeEntry(w io.Writer, entry Entry, version uint32) error {
+	if version >= 2 {
+		var flags uint8
+		if entry.Pointer {
+			flags |= flagPointer
+		}
+		if entry.Tombstone {
+			flags |= flagTombstone
+		}
+		if version >= 3 && entry.Family != 0 {
+			flags |= flagFamily
+		}
+		if version >= 4 && entry.Meta {
+			flags |= flagMeta
+		}
+		if version >= 5 && len(entry.Operands) > 0 {
+			flags |= flagOperands
+			if entry.NoBase {
+				flags |= flagNoBase
+			}
+		}
+		if err := binary.Write(w, binary.LittleEndian, flags); err != nil {
+			return err
+		}
+		if flags&flagFamily != 0 {
+			if err := binary.Write(w, binary.LittleEndian, entry.Family); err != nil {
+				return err
+			}
+		}
+	}
 	if err := writeBytes(w, entry.Key); err != nil {
 		return err
 	}
@@ -163,10 +299,32 @@ func writeEntry(w io.Writer, entry Entry) error {
 	if err := binary.Write(w, binary.LittleEndian, entry.CreatedAt); err != nil {
 		return err
 	}
+	if version >= 5 && len(entry.Operands) > 0 {
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(entry.Operands))); err != nil {
+			return err
+		}
+		for _, operand := range entry.Operands {
+			if err := writeBytes(w, operand); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func readEntry(r io.Reader) (Entry, error) {
+func readEntry(r io.Reader, version uint32) (Entry, error) {
+	var flags uint8
+	var family uint32
+	if version >= 2 {
+		if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
+			return Entry{}, err
+		}
+		if flags&flagFamily != 0 {
+			if err := binary.Read(r, binary.LittleEndian, &family); err != nil {
+				return Entry{}, err
+			}
+		}
+	}
 	key, err := readBytes(r)
 	if err != nil {
 		return Entry{}, err
@@ -183,7 +341,32 @@ func readEntry(r io.Reader) (Entry, error) {
 	if err := binary.Read(r, binary.LittleEndian, &createdAt); err != nil {
 		return Entry{}, err
 	}
-	return Entry{Key: key, Value: value, ExpiresAt: expiresAt, CreatedAt: createdAt}, nil
+	var operands [][]byte
+	if flags&flagOperands != 0 {
+		var count uint64
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return Entry{}, err
+		}
+		for i := uint64(0); i < count; i++ {
+			operand, err := readBytes(r)
+			if err != nil {
+				return Entry{}, err
+			}
+			operands = append(operands, operand)
+		}
+	}
+	return Entry{
+		Key:       key,
+		Value:     value,
+		ExpiresAt: expiresAt,
+		CreatedAt: createdAt,
+		Pointer:   flags&flagPointer != 0,
+		Tombstone: flags&flagTombstone != 0,
+		Family:    family,
+		Meta:      flags&flagMeta != 0,
+		Operands:  operands,
+		NoBase:    flags&flagNoBase != 0,
+	}, nil
 }
 
 func writeBytes(w io.Writer, data []byte) error {
@@ -54,11 +54,23 @@ func TestSnapshotRoundTrip(t *testing.T) {
 				if sorted[i].ExpiresAt != decoded[i].ExpiresAt || sorted[i].CreatedAt != decoded[i].CreatedAt {
 					return false
 				}
+				if (sorted[i].Pointer && version >= 2) != decoded[i].Pointer {
+					return false
+				}
+				if version >= 3 && sorted[i].Family != decoded[i].Family {
+					return false
+				}
+				if (sorted[i].Meta && version >= 4) != decoded[i].Meta {
+					return false
+				}
+				if version >= 5 && !operandsEqual(sorted[i].Operands, decoded[i].Operands) {
+					return false
+				}
 			}
 			return true
 		},
 		gen.SliceOf(genEntry()),
-		gen.UInt32(),
+		gen.UInt32Range(1, Version),
 		gen.Int64(),
 	))
 
@@ -73,25 +85,81 @@ func genEntry() gopter.Gen {
 		gen.SliceOf(gen.UInt8()),
 		gen.Int64(),
 		gen.Int64(),
+		gen.Bool(),
+		gen.UInt32Range(0, 3),
+		gen.Bool(),
+		gen.IntRange(0, 2),
 	).Map(func(values []interface{}) Entry {
 		key := values[0].([]byte)
 		if len(key) == 0 {
 			key = []byte{0}
 		}
+		// Entries carry up to two merge operands, copies of the key.
+		var operands [][]byte
+		for i := 0; i < values[7].(int); i++ {
+			operands = append(operands, key)
+		}
 		return Entry{
 			Key:       key,
 			Value:     values[1].([]byte),
 			ExpiresAt: values[2].(int64),
 			CreatedAt: values[3].(int64),
+			Pointer:   values[4].(bool),
+			Family:    values[5].(uint32),
+			Meta:      values[6].(bool),
+			Operands:  operands,
 		}
 	})
 }
 
+func operandsEqual(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 func sortEntries(entries []Entry) []Entry {
 	sorted := make([]Entry, len(entries))
 	copy(sorted, entries)
 	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Family != sorted[j].Family {
+			return sorted[i].Family < sorted[j].Family
+		}
 		return string(sorted[i].Key) < string(sorted[j].Key)
 	})
 	return sorted
 }
+
+func TestReaderRejectsTruncatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.snap")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	entries := []Entry{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("2")}}
+	if _, err := EncodeSnapshot(file, entries, Version, 1); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	_ = file.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// Cutting anywhere past the header, including just the checksum, must
+	// not read as a complete file.
+	for size := headerCountOffset + 8; size < len(data); size++ {
+		if err := os.WriteFile(path, data[:size], 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := ScanSnapshot(path, func(Entry) error { return nil }); err == nil {
+			t.Fatalf("expected a file cut to %d of %d bytes to fail", size, len(data))
+		}
+	}
+}
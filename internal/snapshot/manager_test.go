@@ -58,3 +58,185 @@ func TestSnapshotManagerCreatesFile(t *testing.T) {
 		t.Fatalf("expected .snap file, got %s", path)
 	}
 }
+
+func TestSnapshotManagerDeltaTombstonesExpired(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	entries := []Entry{
+		{Key: []byte("live"), Value: []byte("v"), ExpiresAt: -1},
+		{Key: []byte("expired"), Value: []byte("v"), ExpiresAt: 5},
+		{Key: []byte("deleted"), Tombstone: true, ExpiresAt: -1},
+	}
+	path, err := manager.CreateDelta(entries, Version, 10, 3)
+	if err != nil {
+		t.Fatalf("create delta: %v", err)
+	}
+	if filepath.Ext(path) != ".delta" {
+		t.Fatalf("expected .delta file, got %s", path)
+	}
+
+	_, decoded, err := manager.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("load delta: %v", err)
+	}
+	tombstones := map[string]bool{}
+	for _, entry := range decoded {
+		tombstones[string(entry.Key)] = entry.Tombstone
+	}
+	if len(tombstones) != 3 || tombstones["live"] || !tombstones["expired"] || !tombstones["deleted"] {
+		t.Fatalf("unexpected delta entries: %+v", tombstones)
+	}
+
+	deltas, err := manager.ListDeltas()
+	if err != nil || len(deltas) != 1 {
+		t.Fatalf("list deltas: %v %v", deltas, err)
+	}
+	snaps, err := manager.ListSnapshots()
+	if err != nil || len(snaps) != 0 {
+		t.Fatalf("expected no full snapshots, got %v %v", snaps, err)
+	}
+}
+
+func TestSnapshotManagerDeltaTombstoneKeepsFamily(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	entries := []Entry{
+		{Key: []byte("k"), Value: []byte("v"), ExpiresAt: -1},
+		{Key: []byte("k"), Value: []byte("v"), ExpiresAt: 5, Family: 1},
+	}
+	path, err := manager.CreateDelta(entries, Version, 10, 3)
+	if err != nil {
+		t.Fatalf("create delta: %v", err)
+	}
+	_, decoded, err := manager.LoadSnapshot(path)
+	if err != nil || len(decoded) != 2 {
+		t.Fatalf("load delta: %v %v", decoded, err)
+	}
+	for _, entry := range decoded {
+		if entry.Tombstone != (entry.Family == 1) {
+			t.Fatalf("expected only the expired entry of family 1 tombstoned, got %+v", entry)
+		}
+	}
+}
+
+func TestSnapshotManagerLeavesNoTempFile(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager(dir)
+	if _, err := manager.CreateSnapshot([]Entry{{Key: []byte("a"), Value: []byte("b"), ExpiresAt: -1}}, Version, 1, 1); err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+tempSuffix))
+	if len(matches) != 0 {
+		t.Fatalf("expected no temp files, got %v", matches)
+	}
+}
+
+func TestSnapshotManagerRemoveTemp(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager(dir)
+	partial := filepath.Join(dir, snapshotName(2)+tempSuffix)
+	if err := os.WriteFile(partial, []byte("MINIKV"), 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	if err := manager.RemoveTemp(); err != nil {
+		t.Fatalf("remove temp: %v", err)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file removed, got %v", err)
+	}
+	paths, err := manager.ListSnapshots()
+	if err != nil || len(paths) != 0 {
+		t.Fatalf("expected no snapshots, got %v %v", paths, err)
+	}
+	if err := NewManager(filepath.Join(dir, "missing")).RemoveTemp(); err != nil {
+		t.Fatalf("remove temp on missing dir: %v", err)
+	}
+}
+
+func TestSnapshotManagerStageAndIngest(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager(dir)
+	stage := func(keys ...string) string {
+		t.Helper()
+		stager, err := manager.Stage(Version, 1)
+		if err != nil {
+			t.Fatalf("stage: %v", err)
+		}
+		for _, key := range keys {
+			if err := stager.Append(Entry{Key: []byte(key), Value: []byte("v" + key), ExpiresAt: -1}); err != nil {
+				t.Fatalf("append: %v", err)
+			}
+		}
+		staged, err := stager.Finish()
+		if err != nil {
+			t.Fatalf("finish: %v", err)
+		}
+		return staged
+	}
+
+	staged := stage("a", "b", "c")
+	if deltas, _ := manager.ListDeltas(); len(deltas) != 0 {
+		t.Fatalf("expected a staged file not to be a delta yet, got %v", deltas)
+	}
+	path, err := manager.Ingest(staged, 7)
+	if err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	if filepath.Base(path) != deltaName(7) {
+		t.Fatalf("unexpected ingested path %q", path)
+	}
+	head, loaded, err := manager.LoadSnapshot(path)
+	if err != nil || head.Count != 3 || len(loaded) != 3 || string(loaded[2].Value) != "vc" {
+		t.Fatalf("unexpected ingested entries %v: %v", loaded, err)
+	}
+
+	abandoned := stage("a")
+	if err := manager.RemoveTemp(); err != nil {
+		t.Fatalf("remove temp: %v", err)
+	}
+	if _, err := os.Stat(abandoned); !os.IsNotExist(err) {
+		t.Fatalf("expected an abandoned staged file removed, got %v", err)
+	}
+
+	stager, err := manager.Stage(Version, 1)
+	if err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	stager.Abort()
+	if paths, _ := manager.list(tempSuffix); len(paths) != 0 {
+		t.Fatalf("expected an aborted stage removed, got %v", paths)
+	}
+}
+
+func TestSnapshotManagerUpgradesVersion1(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager(dir)
+	entries := []Entry{{Key: []byte("a"), Value: []byte("1"), ExpiresAt: -1, CreatedAt: 5}}
+	path, err := manager.CreateSnapshot(entries, 1, 42, 3)
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+
+	if err := manager.Upgrade(path); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	head, decoded, err := manager.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if head.Version != Version || head.Timestamp != 42 {
+		t.Fatalf("unexpected header after upgrade: %+v", head)
+	}
+	if len(decoded) != 1 || string(decoded[0].Value) != "1" || decoded[0].CreatedAt != 5 {
+		t.Fatalf("unexpected entries after upgrade: %+v", decoded)
+	}
+}
+
+func TestDecodeSnapshotRejectsNewerVersion(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	path, err := manager.CreateSnapshot(nil, Version+1, 1, 1)
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+	if _, _, err := manager.LoadSnapshot(path); err != ErrUnsupportedVersion {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package snapshot
+
+import (
+	"io"
+	"time"
+)
+
+// rateLimitedWriter throttles writes to at most rate bytes per second.
+type rateLimitedWriter struct {
+	w       io.Writer
+	rate    int64
+	start   time.Time
+	written int64
+	sleep   func(time.Duration)
+}
+
+func newRateLimitedWriter(w io.Writer, rate int64) io.Writer {
+	if rate <= 0 {
+		return w
+	}
+	return &rateLimitedWriter{w: w, rate: rate, start: time.Now(), sleep: time.Sleep}
+}
+
+func (r *rateLimitedWriter) Write(p []byte) (int, error) {
+	n, err := r.w.Write(p)
+	r.written += int64(n)
+	expected := time.Duration(float64(r.written) / float64(r.rate) * float64(time.Second))
+	if elapsed := time.Since(r.start); elapsed < expected {
+		r.sleep(expected - elapsed)
+	}
+	return n, err
+}
@@ -0,0 +1,34 @@
+package snapshot
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedWriterThrottles(t *testing.T) {
+	var buf bytes.Buffer
+	var slept time.Duration
+	w := &rateLimitedWriter{
+		w:     &buf,
+		rate:  1000,
+		start: time.Now(),
+		sleep: func(d time.Duration) { slept += d },
+	}
+	if _, err := w.Write(make([]byte, 500)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if slept < 400*time.Millisecond {
+		t.Fatalf("expected ~500ms of throttling, got %v", slept)
+	}
+	if buf.Len() != 500 {
+		t.Fatalf("expected all bytes written, got %d", buf.Len())
+	}
+}
+
+func TestRateLimitDisabledPassesThrough(t *testing.T) {
+	var buf bytes.Buffer
+	if w := newRateLimitedWriter(&buf, 0); w != &buf {
+		t.Fatalf("expected unwrapped writer when rate is zero")
+	}
+}
@@ -1,16 +1,28 @@
 package snapshot
 
 import (
+	"bufio"
+	"encoding/binary"
 	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 )
 
+const tempSuffix = ".tmp"
+
+// headerCountOffset is the offset of the entry count in a snapshot header,
+// after the magic, version and timestamp.
+const headerCountOffset = 8 + 4 + 8
+
 // Manager handles snapshot creation and loading.
 type Manager struct {
-	dir string
+	dir       string
+	rateLimit int64
 }
 
 // NewManager returns a snapshot manager rooted at dir.
@@ -18,42 +30,253 @@ func NewManager(dir string) *Manager {
 	return &Manager{dir: dir}
 }
 
+// SetRateLimit caps snapshot write throughput in bytes per second.
+// Zero disables the limit.
+func (m *Manager) SetRateLimit(bytesPerSec int64) {
+	m.rateLimit = bytesPerSec
+}
+
 // CreateSnapshot writes a snapshot file from the provided entries.
 // Expired entries (expiresAt >=0 and <= now) are excluded.
 func (m *Manager) CreateSnapshot(entries []Entry, version uint32, timestamp int64, seq uint64) (string, error) {
-	if err := os.MkdirAll(m.dir, 0o755); err != nil {
-		return "", err
-	}
-
 	filtered := make([]Entry, 0, len(entries))
 	for _, entry := range entries {
+		if entry.Tombstone {
+			continue
+		}
 		if entry.ExpiresAt >= 0 && entry.ExpiresAt <= timestamp {
 			continue
 		}
 		filtered = append(filtered, entry)
 	}
+	return m.writeFile(snapshotName(seq), filtered, version, timestamp)
+}
+
+// CreateDelta writes a delta snapshot holding only keys changed since the
+// previous snapshot in the chain. Expired entries become tombstones so that
+// older values in the chain are not resurrected on load.
+func (m *Manager) CreateDelta(entries []Entry, version uint32, timestamp int64, seq uint64) (string, error) {
+	delta := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Tombstone && entry.ExpiresAt >= 0 && entry.ExpiresAt <= timestamp {
+			entry.Value = nil
+			entry.Pointer = false
+			entry.Meta = false
+			entry.Tombstone = true
+			entry.ExpiresAt = -1
+		}
+		delta = append(delta, entry)
+	}
+	return m.writeFile(deltaName(seq), delta, version, timestamp)
+}
+
+// writeFile publishes a snapshot atomically: entries are written to a temp
+// file which is fsynced and renamed into place, and the directory is then
+// fsynced so the rename survives a crash. A crash at any point leaves either
+// no file under name or a complete one.
+func (m *Manager) writeFile(name string, entries []Entry, version uint32, timestamp int64) (string, error) {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return "", err
+	}
 
-	path := filepath.Join(m.dir, snapshotName(seq))
-	file, err := os.Create(path)
+	path := filepath.Join(m.dir, name)
+	file, err := os.Create(path + tempSuffix)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	tmpPath, err := m.writeTemp(file, entries, version, timestamp)
+	if err != nil {
+		return "", err
+	}
+	return m.publish(tmpPath, path)
+}
+
+// Stager writes a snapshot file for Ingest one entry at a time, so the
+// entries never have to be held in memory together. Entries must be appended
+// in the order the file should hold them.
+type Stager struct {
+	file    *os.File
+	buf     *bufio.Writer
+	hash    hash.Hash32
+	out     io.Writer
+	version uint32
+	count   uint64
+}
+
+// Stage starts a staged snapshot file. The entry count in its header is
+// filled in by Finish. A staged file that is never ingested is removed by
+// Abort, or by RemoveTemp after a crash.
+func (m *Manager) Stage(version uint32, timestamp int64) (*Stager, error) {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(m.dir, "ingest_*"+tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stager{
+		file:    file,
+		buf:     bufio.NewWriter(newRateLimitedWriter(file, m.rateLimit)),
+		hash:    crc32.NewIEEE(),
+		version: version,
+	}
+	s.out = io.MultiWriter(s.buf, s.hash)
+	head := Header{Magic: snapshotMagic, Version: version, Timestamp: timestamp}
+	if err := writeHeader(s.buf, head); err != nil {
+		s.Abort()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append writes entry to the staged file.
+func (s *Stager) Append(entry Entry) error {
+	if err := writeEntry(s.out, entry, s.version); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+// Count returns the number of entries appended so far.
+func (s *Stager) Count() uint64 {
+	return s.count
+}
+
+// Finish writes the checksum, patches the entry count into the header,
+// fsyncs and closes the file, and returns its path for Ingest. The file is
+// removed if any step fails.
+func (s *Stager) Finish() (string, error) {
+	if err := binary.Write(s.buf, binary.LittleEndian, s.hash.Sum32()); err != nil {
+		s.Abort()
+		return "", err
+	}
+	if err := s.buf.Flush(); err != nil {
+		s.Abort()
+		return "", err
+	}
+	var count [8]byte
+	binary.LittleEndian.PutUint64(count[:], s.count)
+	if _, err := s.file.WriteAt(count[:], headerCountOffset); err != nil {
+		s.Abort()
+		return "", err
+	}
+	if err := s.file.Sync(); err != nil {
+		s.Abort()
+		return "", err
+	}
+	path := s.file.Name()
+	if err := s.file.Close(); err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// Abort closes and removes the staged file.
+func (s *Stager) Abort() {
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+}
+
+// Ingest publishes a file written by Stage as the delta snapshot at seq.
+func (m *Manager) Ingest(staged string, seq uint64) (string, error) {
+	return m.publish(staged, filepath.Join(m.dir, deltaName(seq)))
+}
+
+// writeTemp encodes entries into file, fsyncs and closes it. The file is
+// removed if any step fails.
+func (m *Manager) writeTemp(file *os.File, entries []Entry, version uint32, timestamp int64) (string, error) {
+	tmpPath := file.Name()
+	fail := func(err error) (string, error) {
+		_ = file.Close()
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+
+	if _, err := EncodeSnapshot(newRateLimitedWriter(file, m.rateLimit), entries, version, timestamp); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
 
-	if _, err := EncodeSnapshot(file, filtered, version, timestamp); err != nil {
+// publish renames a complete temp file to path and fsyncs the directory.
+func (m *Manager) publish(tmpPath, path string) (string, error) {
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err := syncDir(m.dir); err != nil {
 		return "", err
 	}
 
 	return path, nil
 }
 
+// Upgrade rewrites the snapshot or delta at path in the current format,
+// keeping its name, timestamp and entries. Files already at Version are
+// left untouched.
+func (m *Manager) Upgrade(path string) error {
+	head, entries, err := DecodeSnapshot(path)
+	if err != nil {
+		return err
+	}
+	if head.Version == Version {
+		return nil
+	}
+	_, err = m.writeFile(filepath.Base(path), entries, Version, head.Timestamp)
+	return err
+}
+
+// RemoveTemp deletes temp files left behind by a crash during writeFile.
+func (m *Manager) RemoveTemp() error {
+	paths, err := m.list(tempSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
 // LoadSnapshot reads the snapshot file and returns entries.
 func (m *Manager) LoadSnapshot(path string) (Header, []Entry, error) {
 	return DecodeSnapshot(path)
 }
 
-// ListSnapshots returns snapshot files sorted by name.
+// ListSnapshots returns full snapshot files sorted by name.
 func (m *Manager) ListSnapshots() ([]string, error) {
+	return m.list(".snap")
+}
+
+// ListDeltas returns delta snapshot files sorted by name.
+func (m *Manager) ListDeltas() ([]string, error) {
+	return m.list(".delta")
+}
+
+func (m *Manager) list(suffix string) ([]string, error) {
 	entries, err := os.ReadDir(m.dir)
 	if err != nil {
 		return nil, err
@@ -65,7 +288,7 @@ func (m *Manager) ListSnapshots() ([]string, error) {
 			continue
 		}
 		name := entry.Name()
-		if !strings.HasSuffix(name, ".snap") {
+		if !strings.HasSuffix(name, suffix) {
 			continue
 		}
 		paths = append(paths, filepath.Join(m.dir, name))
@@ -78,3 +301,7 @@ func (m *Manager) ListSnapshots() ([]string, error) {
 func snapshotName(seq uint64) string {
 	return fmt.Sprintf("snapshot_%06d.snap", seq)
 }
+
+func deltaName(seq uint64) string {
+	return fmt.Sprintf("snapshot_%06d.delta", seq)
+}
@@ -0,0 +1,293 @@
+package vlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PointerSize is the encoded size of a Pointer.
+const PointerSize = 24
+
+var (
+	ErrInvalidPointer   = errors.New("vlog: invalid pointer")
+	ErrChecksumMismatch = errors.New("vlog: checksum mismatch")
+)
+
+// Pointer locates a value stored in a value-log file.
+type Pointer struct {
+	Seq      uint64
+	Offset   uint64
+	Length   uint32
+	Checksum uint32
+}
+
+// Encode returns the fixed-size binary form of the pointer.
+func (p Pointer) Encode() []byte {
+	buf := make([]byte, PointerSize)
+	binary.LittleEndian.PutUint64(buf[0:8], p.Seq)
+	binary.LittleEndian.PutUint64(buf[8:16], p.Offset)
+	binary.LittleEndian.PutUint32(buf[16:20], p.Length)
+	binary.LittleEndian.PutUint32(buf[20:24], p.Checksum)
+	return buf
+}
+
+// DecodePointer parses a pointer produced by Encode.
+func DecodePointer(data []byte) (Pointer, error) {
+	if len(data) != PointerSize {
+		return Pointer{}, ErrInvalidPointer
+	}
+	return Pointer{
+		Seq:      binary.LittleEndian.Uint64(data[0:8]),
+		Offset:   binary.LittleEndian.Uint64(data[8:16]),
+		Length:   binary.LittleEndian.Uint32(data[16:20]),
+		Checksum: binary.LittleEndian.Uint32(data[20:24]),
+	}, nil
+}
+
+// FileInfo describes a value-log file on disk.
+type FileInfo struct {
+	Seq  uint64
+	Path string
+	Size int64
+}
+
+// Manager appends values to rotating value-log files and reads them back.
+type Manager struct {
+	mu          sync.RWMutex
+	dir         string
+	maxSize     int64
+	currentFile *os.File
+	currentSeq  uint64
+	currentSize int64
+	readers     map[uint64]*os.File
+}
+
+// Open creates or opens a value-log directory and prepares the current file.
+func Open(dir string, maxSize int64) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	seq := uint64(1)
+	if len(files) > 0 {
+		seq = files[len(files)-1].Seq
+	}
+	file, size, err := openFile(dir, seq)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		dir:         dir,
+		maxSize:     maxSize,
+		currentFile: file,
+		currentSeq:  seq,
+		currentSize: size,
+		readers:     make(map[uint64]*os.File),
+	}, nil
+}
+
+// OpenReader returns a manager that only reads existing value-log files.
+// It creates nothing on disk; Append and Sync fail with os.ErrInvalid.
+func OpenReader(dir string) *Manager {
+	return &Manager{dir: dir, readers: make(map[uint64]*os.File)}
+}
+
+// Append writes value to the current file, rotating if needed, and returns its pointer.
+func (m *Manager) Append(value []byte) (Pointer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.currentFile == nil {
+		return Pointer{}, os.ErrInvalid
+	}
+	if m.maxSize > 0 && m.currentSize > 0 && m.currentSize+int64(len(value)) > m.maxSize {
+		if err := m.rotate(); err != nil {
+			return Pointer{}, err
+		}
+	}
+
+	n, err := m.currentFile.Write(value)
+	if err != nil {
+		return Pointer{}, err
+	}
+	ptr := Pointer{
+		Seq:      m.currentSeq,
+		Offset:   uint64(m.currentSize),
+		Length:   uint32(len(value)),
+		Checksum: crc32.ChecksumIEEE(value),
+	}
+	m.currentSize += int64(n)
+	return ptr, nil
+}
+
+// Read returns the value referenced by ptr after verifying its checksum.
+func (m *Manager) Read(ptr Pointer) ([]byte, error) {
+	file, err := m.reader(ptr.Seq)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, ptr.Length)
+	if _, err := file.ReadAt(value, int64(ptr.Offset)); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(value) != ptr.Checksum {
+		return nil, ErrChecksumMismatch
+	}
+	return value, nil
+}
+
+// Sync flushes the current value-log file to disk.
+func (m *Manager) Sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.currentFile == nil {
+		return os.ErrInvalid
+	}
+	return m.currentFile.Sync()
+}
+
+// Close closes the current file and any cached read handles.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	for seq, file := range m.readers {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(m.readers, seq)
+	}
+	if m.currentFile != nil {
+		if closeErr := m.currentFile.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		m.currentFile = nil
+	}
+	return err
+}
+
+// CurrentSeq returns the sequence of the file currently receiving appends.
+func (m *Manager) CurrentSeq() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.currentSeq
+}
+
+// Files returns the value-log files in increasing sequence order.
+func (m *Manager) Files() ([]FileInfo, error) {
+	return listFiles(m.dir)
+}
+
+// Remove deletes a value-log file. The current file cannot be removed.
+func (m *Manager) Remove(seq uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if seq == m.currentSeq {
+		return fmt.Errorf("vlog: cannot remove active file %d", seq)
+	}
+	if file, ok := m.readers[seq]; ok {
+		_ = file.Close()
+		delete(m.readers, seq)
+	}
+	err := os.Remove(filepath.Join(m.dir, fileName(seq)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (m *Manager) reader(seq uint64) (*os.File, error) {
+	m.mu.RLock()
+	file, ok := m.readers[seq]
+	m.mu.RUnlock()
+	if ok {
+		return file, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if file, ok := m.readers[seq]; ok {
+		return file, nil
+	}
+	file, err := os.Open(filepath.Join(m.dir, fileName(seq)))
+	if err != nil {
+		return nil, err
+	}
+	m.readers[seq] = file
+	return file, nil
+}
+
+func (m *Manager) rotate() error {
+	if err := m.currentFile.Sync(); err != nil {
+		return err
+	}
+	if err := m.currentFile.Close(); err != nil {
+		return err
+	}
+	m.currentSeq++
+	file, size, err := openFile(m.dir, m.currentSeq)
+	if err != nil {
+		m.currentFile = nil
+		return err
+	}
+	m.currentFile = file
+	m.currentSize = size
+	return nil
+}
+
+func openFile(dir string, seq uint64) (*os.File, int64, error) {
+	path := filepath.Join(dir, fileName(seq))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func listFiles(dir string) ([]FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".vlog") {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".vlog"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileInfo{Seq: seq, Path: filepath.Join(dir, name), Size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Seq < files[j].Seq })
+	return files, nil
+}
+
+func fileName(seq uint64) string {
+	return fmt.Sprintf("%06d.vlog", seq)
+}
@@ -0,0 +1,103 @@
+package vlog
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+func TestValueLogAppendReadRoundTrip(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 50
+	properties := gopter.NewProperties(parameters)
+
+	properties.Property("append-read round trip", prop.ForAll(
+		func(values [][]byte) bool {
+			mgr, err := Open(t.TempDir(), 256)
+			if err != nil {
+				return false
+			}
+			defer mgr.Close()
+
+			ptrs := make([]Pointer, 0, len(values))
+			for _, value := range values {
+				ptr, err := mgr.Append(value)
+				if err != nil {
+					return false
+				}
+				ptrs = append(ptrs, ptr)
+			}
+			for i, ptr := range ptrs {
+				decoded, err := DecodePointer(ptr.Encode())
+				if err != nil || decoded != ptr {
+					return false
+				}
+				got, err := mgr.Read(decoded)
+				if err != nil || !bytes.Equal(got, values[i]) {
+					return false
+				}
+			}
+			return true
+		},
+		gen.SliceOf(gen.SliceOf(gen.UInt8())),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestValueLogRotatesAndRemoves(t *testing.T) {
+	mgr, err := Open(t.TempDir(), 64)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer mgr.Close()
+
+	first, err := mgr.Append(bytes.Repeat([]byte("a"), 48))
+	if err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	second, err := mgr.Append(bytes.Repeat([]byte("b"), 48))
+	if err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if first.Seq == second.Seq {
+		t.Fatalf("expected rotation, both values in file %d", first.Seq)
+	}
+	if err := mgr.Remove(second.Seq); err == nil {
+		t.Fatalf("expected error removing active file")
+	}
+	if err := mgr.Remove(first.Seq); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := mgr.Read(first); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+	files, err := mgr.Files()
+	if err != nil {
+		t.Fatalf("files: %v", err)
+	}
+	if len(files) != 1 || files[0].Seq != second.Seq {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+}
+
+func TestValueLogChecksumDetectsCorruption(t *testing.T) {
+	mgr, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer mgr.Close()
+
+	ptr, err := mgr.Append([]byte("value"))
+	if err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	ptr.Checksum ^= 0xFF
+	if _, err := mgr.Read(ptr); err != ErrChecksumMismatch {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
@@ -13,8 +13,28 @@ type RecordType uint8
 const (
 	RecordSet RecordType = iota + 1
 	RecordDelete
+	// RecordSetPointer is a set whose Value is an encoded value-log pointer.
+	RecordSetPointer
+	// RecordMerge appends Value as a merge operand to the key's pending operands.
+	RecordMerge
+	// RecordSetMeta is a set of a collection's metadata entry.
+	RecordSetMeta
+	// RecordExpire removes the key if its entry expires at or before
+	// ExpiresAt. It is written when an expired key is reaped.
+	RecordExpire
+	// RecordDeleteRange removes every key from Key up to but excluding
+	// Value, or every key from Key on when Value is empty.
+	RecordDeleteRange
+	// RecordTxn frames the records of one transaction: its Value holds them
+	// encoded back to back. The frame has a single checksum, so a torn write
+	// loses the whole transaction rather than a prefix of it.
+	RecordTxn
 )
 
+// familyFlag marks a record type byte that is followed by a uvarint family
+// ID. Records for the default family (ID 0) are written without it.
+const familyFlag = 0x80
+
 // WALRecord represents a single write-ahead log entry.
 type WALRecord struct {
 	Type      RecordType
@@ -22,6 +42,8 @@ type WALRecord struct {
 	Key       []byte
 	Value     []byte
 	ExpiresAt int64
+	// Family is the column family the record belongs to; 0 is the default family.
+	Family uint32
 }
 
 var (
@@ -41,7 +63,11 @@ func EncodeWALRecord(record WALRecord) []byte {
 	keyLen := uint64(len(record.Key))
 	valueLen := uint64(len(record.Value))
 
-	payloadLen := 1 + 8 + 8 +
+	head := 1
+	if record.Family != 0 {
+		head += uvarintSize(uint64(record.Family))
+	}
+	payloadLen := head + 8 + 8 +
 		uvarintSize(keyLen) + uvarintSize(valueLen) +
 		int(keyLen) + int(valueLen) + 4
 	var lengthBuf [binary.MaxVarintLen64]byte
@@ -56,9 +82,13 @@ func EncodeWALRecord(record WALRecord) []byte {
 	}
 
 	payload[0] = byte(record.Type)
-	binary.LittleEndian.PutUint64(payload[1:9], uint64(record.Timestamp))
-	binary.LittleEndian.PutUint64(payload[9:17], uint64(record.ExpiresAt))
-	off := 17
+	if record.Family != 0 {
+		payload[0] |= familyFlag
+		binary.PutUvarint(payload[1:], uint64(record.Family))
+	}
+	binary.LittleEndian.PutUint64(payload[head:head+8], uint64(record.Timestamp))
+	binary.LittleEndian.PutUint64(payload[head+8:head+16], uint64(record.ExpiresAt))
+	off := head + 16
 	off += binary.PutUvarint(payload[off:], keyLen)
 	off += binary.PutUvarint(payload[off:], valueLen)
 	copy(payload[off:], record.Key)
@@ -79,6 +109,36 @@ func EncodeWALRecord(record WALRecord) []byte {
 	return out
 }
 
+// EncodeTxn encodes records as one RecordTxn frame, or as a plain record
+// when there is only one.
+func EncodeTxn(records []WALRecord) []byte {
+	if len(records) == 1 {
+		return EncodeWALRecord(records[0])
+	}
+	var body []byte
+	for _, record := range records {
+		body = append(body, EncodeWALRecord(record)...)
+	}
+	return EncodeWALRecord(WALRecord{Type: RecordTxn, Value: body})
+}
+
+// decodeTxn splits the body of a RecordTxn frame into its records.
+func decodeTxn(body []byte) ([]WALRecord, error) {
+	var records []WALRecord
+	for off := 0; off < len(body); {
+		rec, consumed, err := DecodeWALRecord(body[off:])
+		if err != nil {
+			return nil, err
+		}
+		if rec.Type == RecordTxn {
+			return nil, ErrInvalidRecord
+		}
+		records = append(records, rec)
+		off += consumed
+	}
+	return records, nil
+}
+
 // DecodeWALRecord decodes a record from data, returning the record and bytes consumed.
 func DecodeWALRecord(data []byte) (WALRecord, int, error) {
 	var rec WALRecord
@@ -94,10 +154,19 @@ func DecodeWALRecord(data []byte) (WALRecord, int, error) {
 		return rec, 0, ErrInvalidRecord
 	}
 
-	rec.Type = RecordType(payload[0])
-	rec.Timestamp = int64(binary.LittleEndian.Uint64(payload[1:9]))
-	rec.ExpiresAt = int64(binary.LittleEndian.Uint64(payload[9:17]))
-	off := 17
+	rec.Type = RecordType(payload[0] &^ familyFlag)
+	off := 1
+	if payload[0]&familyFlag != 0 {
+		family, read := binary.Uvarint(payload[off:])
+		if read <= 0 || family > 1<<32-1 || len(payload) < off+read+8+8+4 {
+			return rec, 0, ErrInvalidRecord
+		}
+		rec.Family = uint32(family)
+		off += read
+	}
+	rec.Timestamp = int64(binary.LittleEndian.Uint64(payload[off : off+8]))
+	rec.ExpiresAt = int64(binary.LittleEndian.Uint64(payload[off+8 : off+16]))
+	off += 16
 	keyLen, read := binary.Uvarint(payload[off:])
 	if read <= 0 {
 		return rec, 0, ErrInvalidRecord
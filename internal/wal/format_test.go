@@ -2,6 +2,7 @@ package wal
 
 import (
 	"bytes"
+	"os"
 	"testing"
 
 	"github.com/leanovate/gopter"
@@ -15,7 +16,7 @@ func TestWALRecordEncodeDecodeRoundTrip(t *testing.T) {
 	properties := gopter.NewProperties(parameters)
 
 	properties.Property("encode-decode round trip", prop.ForAll(
-		func(recType uint8, ts int64, expiresAt int64, key []byte, value []byte) bool {
+		func(recType uint8, family uint32, ts int64, expiresAt int64, key []byte, value []byte) bool {
 			if len(key) == 0 {
 				key = []byte{0}
 			}
@@ -28,6 +29,7 @@ func TestWALRecordEncodeDecodeRoundTrip(t *testing.T) {
 				ExpiresAt: expiresAt,
 				Key:       key,
 				Value:     value,
+				Family:    family,
 			}
 
 			encoded := EncodeWALRecord(record)
@@ -38,7 +40,7 @@ func TestWALRecordEncodeDecodeRoundTrip(t *testing.T) {
 			if consumed != len(encoded) {
 				return false
 			}
-			if decoded.Type != record.Type || decoded.Timestamp != record.Timestamp || decoded.ExpiresAt != record.ExpiresAt {
+			if decoded.Type != record.Type || decoded.Family != record.Family || decoded.Timestamp != record.Timestamp || decoded.ExpiresAt != record.ExpiresAt {
 				return false
 			}
 			if !bytes.Equal(decoded.Key, record.Key) || !bytes.Equal(decoded.Value, record.Value) {
@@ -46,7 +48,8 @@ func TestWALRecordEncodeDecodeRoundTrip(t *testing.T) {
 			}
 			return true
 		},
-		gen.UInt8(),
+		gen.UInt8Range(0, familyFlag-1),
+		gen.UInt32(),
 		gen.Int64(),
 		gen.Int64(),
 		gen.SliceOf(gen.UInt8()),
@@ -79,3 +82,40 @@ func TestWALChecksumDetectsCorruption(t *testing.T) {
 		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
 	}
 }
+
+func TestTxnFrameIsAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = w.AppendRecord(WALRecord{Type: RecordSet, Key: []byte("a"), Value: []byte("1")})
+	txn := []WALRecord{
+		{Type: RecordSetMeta, Key: []byte("h"), Value: []byte("meta")},
+		{Type: RecordSet, Key: []byte("m"), Value: []byte("v"), Family: 3},
+	}
+	if _, err := w.AppendRaw(EncodeTxn(txn)); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	_ = w.Close()
+
+	path := SegmentPath(dir, 1)
+	records, err := ReadWAL(path)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("expected the txn expanded to 3 records, got %d, %v", len(records), err)
+	}
+	if records[2].Family != 3 || string(records[2].Key) != "m" {
+		t.Fatalf("unexpected txn record %+v", records[2])
+	}
+
+	info, _ := os.Stat(path)
+	for cut := int64(1); cut < int64(len(EncodeTxn(txn))); cut++ {
+		if err := os.Truncate(path, info.Size()-cut); err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+		records, err := ReadWAL(path)
+		if err != nil || len(records) != 1 {
+			t.Fatalf("cut %d: expected only the record before the torn txn, got %d, %v", cut, len(records), err)
+		}
+	}
+}
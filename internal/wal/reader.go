@@ -9,35 +9,66 @@ import (
 	"strings"
 )
 
-// ReadWAL reads and decodes WAL records from a segment path.
+// ReadWAL reads and decodes WAL records from a segment path. Both the
+// current layout and headerless version 1 segments are accepted.
 // Stops at the first corrupt record and returns the records read so far.
 func ReadWAL(path string) ([]WALRecord, error) {
+	records, _, err := ReadWALFrom(path, 0)
+	return records, err
+}
+
+// ReadWALFrom decodes records starting at byte offset, which must be 0 or a
+// value previously returned by ReadWALFrom. It returns the offset just past
+// the last complete record, so a reader tailing a segment that is still
+// being written can resume there once more data arrives. Transaction frames
+// are expanded into the records they hold.
+func ReadWALFrom(path string, offset int64) ([]WALRecord, int64, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, offset, err
 	}
 	defer file.Close()
 
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, offset, err
+		}
+	}
 	data, err := io.ReadAll(file)
 	if err != nil {
-		return nil, err
+		return nil, offset, err
 	}
 
-	records := make([]WALRecord, 0)
 	off := 0
+	if offset == 0 {
+		_, off, err = parseHeader(data)
+		if err != nil {
+			return nil, offset, err
+		}
+		if off < HeaderSize && off == len(data) && off > 0 {
+			// Header still being written; retry from the start.
+			return nil, 0, nil
+		}
+	}
+	records := make([]WALRecord, 0)
 	for off < len(data) {
 		rec, consumed, err := DecodeWALRecord(data[off:])
-		if err != nil {
-			return records, nil
+		if err != nil || consumed == 0 {
+			break
 		}
-		if consumed == 0 {
-			return records, nil
+		if rec.Type == RecordTxn {
+			txn, err := decodeTxn(rec.Value)
+			if err != nil {
+				break
+			}
+			records = append(records, txn...)
+		} else {
+			records = append(records, rec)
 		}
-		records = append(records, rec)
 		off += consumed
 	}
 
-	return records, nil
+	return records, offset + int64(off), nil
 }
 
 // ListSegments returns WAL segment paths in increasing sequence order.
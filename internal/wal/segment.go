@@ -0,0 +1,115 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Version is the current segment layout. Version 1 segments have no header
+// and start directly with the first record.
+const Version uint32 = 2
+
+// HeaderSize is the length of the segment header: magic plus version.
+const HeaderSize = 12
+
+var segmentMagic = []byte("MINIKVWL")
+
+// ErrUnsupportedVersion is returned for segments written by a newer layout.
+var ErrUnsupportedVersion = errors.New("wal: unsupported segment version")
+
+func encodeHeader() []byte {
+	header := make([]byte, HeaderSize)
+	copy(header, segmentMagic)
+	binary.LittleEndian.PutUint32(header[len(segmentMagic):], Version)
+	return header
+}
+
+// parseHeader returns the segment version and the offset of the first
+// record. Data without the magic is a version 1 segment.
+func parseHeader(data []byte) (uint32, int, error) {
+	if len(data) < len(segmentMagic) || !bytes.Equal(data[:len(segmentMagic)], segmentMagic) {
+		if len(data) < len(segmentMagic) && bytes.HasPrefix(segmentMagic, data) && len(data) > 0 {
+			// Torn header from a crash while creating the segment.
+			return Version, len(data), nil
+		}
+		return 1, 0, nil
+	}
+	if len(data) < HeaderSize {
+		return Version, len(data), nil
+	}
+	version := binary.LittleEndian.Uint32(data[len(segmentMagic):HeaderSize])
+	if version != Version {
+		return version, 0, ErrUnsupportedVersion
+	}
+	return version, HeaderSize, nil
+}
+
+// SegmentVersion reports the layout version of the segment at path.
+func SegmentVersion(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	header := make([]byte, HeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	if n == 0 {
+		return Version, nil
+	}
+	version, _, err := parseHeader(header[:n])
+	return version, err
+}
+
+// UpgradeSegment rewrites a version 1 segment in the current layout. The
+// new file is written beside the old one, fsynced and renamed over it.
+// Segments already in the current layout are left untouched.
+func UpgradeSegment(path string) error {
+	version, err := SegmentVersion(path)
+	if err != nil {
+		return err
+	}
+	if version == Version {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(encodeHeader(), data...)); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
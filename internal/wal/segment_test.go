@@ -0,0 +1,136 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSegmentHasHeader(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := w.AppendRecord(WALRecord{Type: RecordSet, Key: []byte("a"), Value: []byte("1")}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	_ = w.Close()
+
+	path := SegmentPath(dir, 1)
+	version, err := SegmentVersion(path)
+	if err != nil || version != Version {
+		t.Fatalf("expected version %d, got %d %v", Version, version, err)
+	}
+	records, err := ReadWAL(path)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d %v", len(records), err)
+	}
+}
+
+func TestUpgradeLegacySegment(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "000001.log")
+	var legacy []byte
+	for _, key := range []string{"a", "b"} {
+		legacy = append(legacy, EncodeWALRecord(WALRecord{Type: RecordSet, Key: []byte(key), Value: []byte("v")})...)
+	}
+	if err := os.WriteFile(path, legacy, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if version, err := SegmentVersion(path); err != nil || version != 1 {
+		t.Fatalf("expected legacy version 1, got %d %v", version, err)
+	}
+	records, err := ReadWAL(path)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("expected legacy records to be readable, got %d %v", len(records), err)
+	}
+
+	if err := UpgradeSegment(path); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if version, err := SegmentVersion(path); err != nil || version != Version {
+		t.Fatalf("expected version %d after upgrade, got %d %v", Version, version, err)
+	}
+	records, err = ReadWAL(path)
+	if err != nil || len(records) != 2 || string(records[1].Key) != "b" {
+		t.Fatalf("expected records to survive upgrade, got %v %v", records, err)
+	}
+	if err := UpgradeSegment(path); err != nil {
+		t.Fatalf("second upgrade: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if len(data) != HeaderSize+len(legacy) {
+		t.Fatalf("expected upgrade to be idempotent, got %d bytes", len(data))
+	}
+}
+
+func TestReadWALRejectsNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.log")
+	header := encodeHeader()
+	binary.LittleEndian.PutUint32(header[len(segmentMagic):], Version+1)
+	if err := os.WriteFile(path, header, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := ReadWAL(path); err != ErrUnsupportedVersion {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestOpenWALRepairsTornHeader(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(SegmentPath(dir, 1), segmentMagic[:4], 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	w, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := w.AppendRecord(WALRecord{Type: RecordSet, Key: []byte("a"), Value: []byte("1")}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	_ = w.Close()
+	records, err := ReadWAL(SegmentPath(dir, 1))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected 1 record after repair, got %d %v", len(records), err)
+	}
+}
+
+func TestPreRotateHookRunsBeforeSealing(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, 64)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer w.Close()
+	var calls int
+	failing := errors.New("sync failed")
+	var hookErr error
+	w.SetPreRotateHook(func() error {
+		calls++
+		return hookErr
+	})
+
+	record := WALRecord{Type: RecordSetPointer, Key: []byte("k"), Value: bytes.Repeat([]byte("p"), 24)}
+	for i := 0; i < 4; i++ {
+		if err := w.AppendRecord(record); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if calls == 0 || w.CurrentSeq() != uint64(calls)+1 {
+		t.Fatalf("expected the hook before each of the rotations, got %d calls at segment %d", calls, w.CurrentSeq())
+	}
+
+	hookErr = failing
+	seq := w.CurrentSeq()
+	if _, err := w.Rotate(); err != failing {
+		t.Fatalf("expected the hook error from Rotate, got %v", err)
+	}
+	if w.CurrentSeq() != seq {
+		t.Fatalf("expected a failed hook to keep segment %d, got %d", seq, w.CurrentSeq())
+	}
+}
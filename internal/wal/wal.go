@@ -17,8 +17,11 @@ type WALManager struct {
 	currentFile *os.File
 	currentSeq  uint64
 	currentSize int64
+	syncedSize  int64
 	maxSize     int64
-	rotateHook  func()
+	written     uint64
+	rotateHook  func(seq uint64)
+	preRotate   func() error
 }
 
 // OpenWAL creates or opens a WAL directory and prepares the current segment.
@@ -42,6 +45,7 @@ func OpenWAL(dir string, maxSize int64) (*WALManager, error) {
 		currentFile: file,
 		currentSeq:  seq,
 		currentSize: size,
+		syncedSize:  size,
 		maxSize:     maxSize,
 	}, nil
 }
@@ -74,6 +78,7 @@ func (w *WALManager) AppendRaw(encoded []byte) (int, error) {
 		return 0, err
 	}
 	w.currentSize += int64(n)
+	w.written += uint64(n)
 	return n, nil
 }
 
@@ -84,7 +89,11 @@ func (w *WALManager) Sync() error {
 	if w.currentFile == nil {
 		return os.ErrInvalid
 	}
-	return w.currentFile.Sync()
+	if err := w.currentFile.Sync(); err != nil {
+		return err
+	}
+	w.syncedSize = w.currentSize
+	return nil
 }
 
 // Close closes the WAL file handle.
@@ -106,7 +115,60 @@ func (w *WALManager) CurrentSeq() uint64 {
 	return w.currentSeq
 }
 
+// Position returns the current segment sequence and the offset at which the
+// next record will be written. It only grows, across rotations and reopens.
+func (w *WALManager) Position() (uint64, int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.currentSeq, w.currentSize
+}
+
+// Synced returns the current segment sequence and the offset up to which it
+// has been synced; an OS crash may lose what lies past it.
+func (w *WALManager) Synced() (uint64, int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.currentSeq, w.syncedSize
+}
+
+// Rotate seals the current segment and opens the next one without invoking
+// the rotate hook. It returns the sequence of the sealed segment.
+func (w *WALManager) Rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.currentFile == nil {
+		return 0, os.ErrInvalid
+	}
+	sealed := w.currentSeq
+	if err := w.nextSegment(); err != nil {
+		return 0, err
+	}
+	return sealed, nil
+}
+
+// BytesWritten returns the total bytes appended since the WAL was opened.
+func (w *WALManager) BytesWritten() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
 func (w *WALManager) rotate() error {
+	if err := w.nextSegment(); err != nil {
+		return err
+	}
+	if w.rotateHook != nil {
+		w.rotateHook(w.currentSeq)
+	}
+	return nil
+}
+
+func (w *WALManager) nextSegment() error {
+	if w.preRotate != nil {
+		if err := w.preRotate(); err != nil {
+			return err
+		}
+	}
 	if w.currentFile != nil {
 		if err := w.currentFile.Sync(); err != nil {
 			return err
@@ -122,21 +184,29 @@ func (w *WALManager) rotate() error {
 	}
 	w.currentFile = file
 	w.currentSize = size
-	if w.rotateHook != nil {
-		w.rotateHook()
-	}
+	w.syncedSize = size
 	return nil
 }
 
-// SetRotateHook registers a callback invoked after WAL rotation.
-func (w *WALManager) SetRotateHook(hook func()) {
+// SetRotateHook registers a callback invoked after WAL rotation with the
+// sequence of the newly opened segment. It runs with the WAL lock held.
+func (w *WALManager) SetRotateHook(hook func(seq uint64)) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	w.rotateHook = hook
 }
 
+// SetPreRotateHook registers a callback invoked before the current segment
+// is synced and sealed, by both Rotate and size-based rotation. An error
+// aborts the rotation. It runs with the WAL lock held.
+func (w *WALManager) SetPreRotateHook(hook func() error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.preRotate = hook
+}
+
 func openSegment(dir string, seq uint64) (*os.File, int64, error) {
-	path := filepath.Join(dir, segmentName(seq))
+	path := SegmentPath(dir, seq)
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
 		return nil, 0, err
@@ -146,7 +216,23 @@ func openSegment(dir string, seq uint64) (*os.File, int64, error) {
 		_ = file.Close()
 		return nil, 0, err
 	}
-	return file, info.Size(), nil
+	size := info.Size()
+	if size >= HeaderSize {
+		return file, size, nil
+	}
+	if size > 0 {
+		// Only a torn header can be this short; start the segment over.
+		if err := file.Truncate(0); err != nil {
+			_ = file.Close()
+			return nil, 0, err
+		}
+	}
+	header := encodeHeader()
+	if _, err := file.Write(header); err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+	return file, int64(len(header)), nil
 }
 
 func latestSequence(dir string) (uint64, error) {
@@ -177,6 +263,11 @@ func latestSequence(dir string) (uint64, error) {
 	return seqs[len(seqs)-1], nil
 }
 
+// SegmentPath returns the path of the segment with the given sequence.
+func SegmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, segmentName(seq))
+}
+
 func segmentName(seq uint64) string {
 	return fmt.Sprintf("%06d.log", seq)
 }
@@ -1,79 +1,96 @@
 package minikv
 
-import "time"
+import (
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+)
 
 // Scan returns up to limit key/value pairs matching prefix in lexicographic order.
 func (db *DB) Scan(prefix []byte, limit int) ([][]byte, [][]byte, error) {
+	return db.def.Scan(prefix, limit)
+}
+
+// Scan returns up to limit key/value pairs in the family matching prefix in lexicographic order.
+func (f *Family) Scan(prefix []byte, limit int) ([][]byte, [][]byte, error) {
+	db := f.db
 	stats := db.statsOrInit()
 	start := time.Now()
-	if len(prefix) > db.opts.MaxKeySize {
+	if len(prefix) > f.opts.MaxKeySize {
 		stats.scans.Add(1)
 		stats.readLatency.add(time.Since(start))
 		return nil, nil, ErrKeyTooLarge
 	}
 	db.mu.RLock()
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		db.mu.RUnlock()
 		stats.scans.Add(1)
 		stats.readLatency.add(time.Since(start))
-		return nil, nil, ErrClosed
+		return nil, nil, err
 	}
-	entries := db.index.Scan(string(prefix), limit)
+	entries := f.index.Scan(string(prefix), limit)
+	keys, values, err := db.resolveEntries(entries)
 	db.mu.RUnlock()
-
-	keys := make([][]byte, 0, len(entries))
-	values := make([][]byte, 0, len(entries))
-	for _, entry := range entries {
-		keys = append(keys, entry.Key)
-		values = append(values, entry.Entry.Value)
-	}
 	stats.scans.Add(1)
 	stats.readLatency.add(time.Since(start))
+	if err != nil {
+		return nil, nil, err
+	}
 	return keys, values, nil
 }
 
 // ScanRange returns up to limit key/value pairs whose keys are within [start, end].
 func (db *DB) ScanRange(start, end []byte, limit int) ([][]byte, [][]byte, error) {
+	return db.def.ScanRange(start, end, limit)
+}
+
+// ScanRange returns up to limit key/value pairs in the family whose keys are within [start, end].
+func (f *Family) ScanRange(start, end []byte, limit int) ([][]byte, [][]byte, error) {
+	db := f.db
 	stats := db.statsOrInit()
 	startTime := time.Now()
-	if len(start) > db.opts.MaxKeySize || len(end) > db.opts.MaxKeySize {
+	if len(start) > f.opts.MaxKeySize || len(end) > f.opts.MaxKeySize {
 		stats.scans.Add(1)
 		stats.readLatency.add(time.Since(startTime))
 		return nil, nil, ErrKeyTooLarge
 	}
 	db.mu.RLock()
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		db.mu.RUnlock()
 		stats.scans.Add(1)
 		stats.readLatency.add(time.Since(startTime))
-		return nil, nil, ErrClosed
+		return nil, nil, err
 	}
-	entries := db.index.ScanRange(string(start), string(end), limit)
+	entries := f.index.ScanRange(string(start), string(end), limit)
+	keys, values, err := db.resolveEntries(entries)
 	db.mu.RUnlock()
-
-	keys := make([][]byte, 0, len(entries))
-	values := make([][]byte, 0, len(entries))
-	for _, entry := range entries {
-		keys = append(keys, entry.Key)
-		values = append(values, entry.Entry.Value)
-	}
 	stats.scans.Add(1)
 	stats.readLatency.add(time.Since(startTime))
+	if err != nil {
+		return nil, nil, err
+	}
 	return keys, values, nil
 }
 
 // Keys returns keys matching a glob pattern.
 func (db *DB) Keys(pattern string) ([]string, error) {
+	return db.def.Keys(pattern)
+}
+
+// Keys returns keys in the family matching a glob pattern. Like scans, it
+// leaves out collections such as hashes.
+func (f *Family) Keys(pattern string) ([]string, error) {
+	db := f.db
 	stats := db.statsOrInit()
 	start := time.Now()
 	db.mu.RLock()
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		db.mu.RUnlock()
 		stats.scans.Add(1)
 		stats.readLatency.add(time.Since(start))
-		return nil, ErrClosed
+		return nil, err
 	}
-	keys := db.index.Keys(pattern)
+	keys := f.index.Keys(pattern)
 	db.mu.RUnlock()
 	stats.scans.Add(1)
 	stats.readLatency.add(time.Since(start))
@@ -82,18 +99,49 @@ func (db *DB) Keys(pattern string) ([]string, error) {
 
 // Count returns the total number of non-expired keys.
 func (db *DB) Count() (int, error) {
+	return db.def.Count()
+}
+
+// Count returns the number of non-expired keys in the family, leaving out
+// collections such as hashes as scans do.
+func (f *Family) Count() (int, error) {
+	db := f.db
 	stats := db.statsOrInit()
 	start := time.Now()
 	db.mu.RLock()
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		db.mu.RUnlock()
 		stats.scans.Add(1)
 		stats.readLatency.add(time.Since(start))
-		return 0, ErrClosed
+		return 0, err
 	}
-	count := db.index.Count()
+	count := f.index.Count()
 	db.mu.RUnlock()
 	stats.scans.Add(1)
 	stats.readLatency.add(time.Since(start))
 	return count, nil
 }
+
+// resolveEntries splits scan results into keys and values, reading
+// value-log pointers and folding merge operands. Collections have no single
+// value and are left out. Callers must hold db.mu.
+func (db *DB) resolveEntries(entries []index.KeyEntry) ([][]byte, [][]byte, error) {
+	keys := make([][]byte, 0, len(entries))
+	values := make([][]byte, 0, len(entries))
+	for i := range entries {
+		if entries[i].Entry.Meta {
+			continue
+		}
+		value := entries[i].Entry.Value
+		if entries[i].Entry.Pointer || len(entries[i].Entry.Operands) > 0 {
+			resolved, err := db.entryValue(string(entries[i].Key), &entries[i].Entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			value = resolved
+		}
+		keys = append(keys, entries[i].Key)
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
@@ -141,7 +141,7 @@ func TestIteratorProperties(t *testing.T) {
 			seedDB(db, keys, values)
 			// Insert an expired key directly.
 			now := time.Now().UnixNano()
-			db.index.SetEntry("expired", []byte("v"), now-1, now-2)
+			db.def.index.SetEntry("expired", []byte("v"), now-1, now-2)
 
 			scanKeys, _, err := db.Scan([]byte(""), 0)
 			if err != nil {
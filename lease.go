@@ -0,0 +1,192 @@
+package minikv
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// lockRetryInterval is how often Lock checks again for a lock held by another
+// owner, in case it expired or was deleted without a Release.
+const lockRetryInterval = 50 * time.Millisecond
+
+// Lease is a lock acquired with Lock. It is held until Release, or until its
+// TTL runs out without a Refresh.
+type Lease struct {
+	f     *Family
+	name  []byte
+	owner []byte
+	fence uint64
+}
+
+// Lock acquires the lock called name, waiting until it is free or ctx is done.
+func (db *DB) Lock(ctx context.Context, name []byte, ttl time.Duration) (*Lease, error) {
+	return db.def.Lock(ctx, name, ttl)
+}
+
+// Lock acquires the lock called name in the family, waiting until it is free
+// or ctx is done, in which case it returns ctx.Err(). The lock is the key name
+// holding a random owner token with a TTL of ttl, set only if the key does
+// not exist, so an owner that stops refreshing loses the lock once ttl
+// passes. ttl must be positive.
+func (f *Family) Lock(ctx context.Context, name []byte, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidValue
+	}
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return nil, err
+	}
+	owner = []byte(hex.EncodeToString(owner))
+
+	for {
+		lease, released, err := f.tryLock(name, owner, ttl)
+		if err != nil || lease != nil {
+			return lease, err
+		}
+		ticker := f.db.opts.Clock.NewTicker(lockRetryInterval)
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return nil, ctx.Err()
+		case <-released:
+		case <-ticker.C():
+		}
+		ticker.Stop()
+	}
+}
+
+// tryLock takes the lock if it is free. Otherwise it returns a channel closed
+// by the next Release, taken under the same lock so no release is missed.
+func (f *Family) tryLock(name, owner []byte, ttl time.Duration) (*Lease, <-chan struct{}, error) {
+	db := f.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := f.unavailableLocked(); err != nil {
+		return nil, nil, err
+	}
+	if _, ok := f.index.Get(string(name)); ok {
+		return nil, db.releasedLocked(), nil
+	}
+	if err := f.setWithExpiresAtLocked(name, owner, db.now()+int64(ttl), 0, false); err != nil {
+		return nil, nil, err
+	}
+	if err := db.syncLeaseLocked(); err != nil {
+		return nil, nil, err
+	}
+	return &Lease{
+		f:     f,
+		name:  append([]byte(nil), name...),
+		owner: owner,
+		fence: db.fenceLocked(),
+	}, nil, nil
+}
+
+// syncLeaseLocked makes the lock record just appended durable before its
+// lease is handed out, whatever the SyncMode. Otherwise an OS crash could
+// drop the record, and the WAL position its fencing token came from would be
+// reused by the next lease.
+func (db *DB) syncLeaseLocked() error {
+	if db.opts.SyncMode == SyncAlways {
+		return nil
+	}
+	return db.syncWAL()
+}
+
+// fenceLocked returns a fencing token for the record just appended to the
+// WAL: its segment sequence and end offset, which grow with every write, so
+// a later lease always gets a larger token. Open rejects a MaxWALSize of
+// 4 GiB or more, keeping offsets within the low 32 bits.
+func (db *DB) fenceLocked() uint64 {
+	seq, offset := db.wal.Position()
+	return seq<<32 | uint64(offset)
+}
+
+// Name returns the name of the lock.
+func (l *Lease) Name() []byte {
+	return append([]byte(nil), l.name...)
+}
+
+// Owner returns the token stored under the lock's name while the lease holds it.
+func (l *Lease) Owner() []byte {
+	return append([]byte(nil), l.owner...)
+}
+
+// Fence returns the lease's fencing token. Tokens of successive leases on a
+// database only grow, so a resource guarded by the lock can reject writes
+// carrying a token older than the newest it has seen.
+func (l *Lease) Fence() uint64 {
+	return l.fence
+}
+
+// Refresh extends the lease to expire ttl from now. It returns
+// ErrLockNotHeld if the lease expired or the lock changed hands.
+func (l *Lease) Refresh(ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidValue
+	}
+	return l.ifHeld(func() error {
+		if err := l.f.setWithExpiresAtLocked(l.name, l.owner, l.f.db.now()+int64(ttl), 0, false); err != nil {
+			return err
+		}
+		return l.f.db.syncLeaseLocked()
+	})
+}
+
+// Release gives up the lease and wakes callers waiting in Lock. It returns
+// ErrLockNotHeld if the lease expired or the lock changed hands, in which
+// case the lock is left alone.
+func (l *Lease) Release() error {
+	return l.ifHeld(func() error {
+		if err := l.f.deleteLocked(l.name); err != nil {
+			return err
+		}
+		l.f.db.notifyReleasedLocked()
+		return nil
+	})
+}
+
+// ifHeld runs fn under db.mu if the lock still holds the lease's owner token.
+func (l *Lease) ifHeld(fn func() error) error {
+	db := l.f.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := l.f.unavailableLocked(); err != nil {
+		return err
+	}
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	entry, ok := l.f.index.Get(string(l.name))
+	if !ok || entry.Meta {
+		return ErrLockNotHeld
+	}
+	value, err := db.entryValue(string(l.name), entry)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(value, l.owner) {
+		return ErrLockNotHeld
+	}
+	return fn()
+}
+
+// releasedLocked returns a channel closed by the next Release, or by Close.
+// Callers must hold db.mu.
+func (db *DB) releasedLocked() <-chan struct{} {
+	if db.released == nil {
+		db.released = make(chan struct{})
+	}
+	return db.released
+}
+
+// notifyReleasedLocked wakes every Lock waiting for a release. Callers must
+// hold db.mu.
+func (db *DB) notifyReleasedLocked() {
+	if db.released != nil {
+		close(db.released)
+		db.released = nil
+	}
+}
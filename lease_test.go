@@ -0,0 +1,215 @@
+package minikv
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+func TestLockIsExclusiveAndFenced(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	ctx := context.Background()
+
+	first, err := db.Lock(ctx, []byte("job"), time.Minute)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if value, _ := db.Get([]byte("job")); string(value) != string(first.Owner()) {
+		t.Fatalf("expected the lock key to hold the owner token, got %q", value)
+	}
+	if _, err := db.Lock(ctx, []byte("job"), 0); err != ErrInvalidValue {
+		t.Fatalf("expected ErrInvalidValue for a zero ttl, got %v", err)
+	}
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.Lock(short, []byte("job"), time.Minute); err != context.DeadlineExceeded {
+		t.Fatalf("expected a held lock to time out, got %v", err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if err := first.Release(); err != ErrLockNotHeld {
+		t.Fatalf("expected ErrLockNotHeld on a second release, got %v", err)
+	}
+	second, err := db.Lock(ctx, []byte("job"), time.Minute)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if second.Fence() <= first.Fence() {
+		t.Fatalf("expected fencing tokens to grow, got %d then %d", first.Fence(), second.Fence())
+	}
+	if err := first.Refresh(time.Minute); err != ErrLockNotHeld {
+		t.Fatalf("expected a stale lease not to refresh, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	_ = db.Delete([]byte("job"))
+	third, err := db.Lock(ctx, []byte("job"), time.Minute)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if third.Fence() <= second.Fence() {
+		t.Fatalf("expected fencing tokens to grow across reopen, got %d then %d", second.Fence(), third.Fence())
+	}
+}
+
+func TestLockWaitsForRelease(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	ctx := context.Background()
+
+	const workers = 8
+	var mu sync.Mutex
+	holders, maxHolders := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lease, err := db.Lock(ctx, []byte("mutex"), time.Minute)
+			if err != nil {
+				t.Errorf("lock: %v", err)
+				return
+			}
+			mu.Lock()
+			holders++
+			if holders > maxHolders {
+				maxHolders = holders
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+			if err := lease.Release(); err != nil {
+				t.Errorf("release: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxHolders != 1 {
+		t.Fatalf("expected one holder at a time, got %d", maxHolders)
+	}
+}
+
+func TestLeaseExpiresWithoutRefresh(t *testing.T) {
+	clock := NewFakeClock(fakeEpoch)
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Clock = clock })
+	defer db.Close()
+	ctx := context.Background()
+
+	first, err := db.Lock(ctx, []byte("job"), 10*time.Second)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	clock.Advance(5 * time.Second)
+	if err := first.Refresh(10 * time.Second); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	clock.Advance(8 * time.Second)
+	if remaining, _ := db.TTL([]byte("job")); remaining != 2*time.Second {
+		t.Fatalf("expected the refresh to extend the lease, got %v", remaining)
+	}
+
+	acquired := make(chan *Lease)
+	go func() {
+		lease, err := db.Lock(ctx, []byte("job"), 10*time.Second)
+		if err != nil {
+			t.Errorf("lock: %v", err)
+		}
+		acquired <- lease
+	}()
+	var second *Lease
+	for second == nil {
+		clock.Advance(time.Second)
+		select {
+		case second = <-acquired:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if now := clock.Now(); now.Before(fakeEpoch.Add(15 * time.Second)) {
+		t.Fatalf("expected the lock to be taken only once the lease expired, got %v", now.Sub(fakeEpoch))
+	}
+	if err := first.Refresh(10 * time.Second); err != ErrLockNotHeld {
+		t.Fatalf("expected the expired lease to be lost, got %v", err)
+	}
+	if second.Fence() <= first.Fence() {
+		t.Fatalf("expected fencing tokens to grow, got %d then %d", first.Fence(), second.Fence())
+	}
+}
+
+func TestLockReturnsOnClose(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	if _, err := db.Lock(context.Background(), []byte("job"), time.Minute); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := db.Lock(context.Background(), []byte("job"), time.Minute)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_ = db.Close()
+	select {
+	case err := <-done:
+		if err != ErrClosed {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Close to wake a waiting Lock")
+	}
+}
+
+func TestLeaseFenceSurvivesOSCrash(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	ctx := context.Background()
+
+	first, err := db.Lock(ctx, []byte("job"), time.Minute)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if err := first.Refresh(time.Hour); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	// An OS crash loses whatever was written to the WAL but not synced.
+	seq, synced := db.wal.Synced()
+	crash(db)
+	if err := os.Truncate(wal.SegmentPath(filepath.Join(dir, "wal"), seq), synced); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if ttl, err := db.TTL([]byte("job")); err != nil || ttl <= time.Minute {
+		t.Fatalf("expected the refreshed lock to survive the crash, got %v %v", ttl, err)
+	}
+	_ = db.Delete([]byte("job"))
+	second, err := db.Lock(ctx, []byte("job"), time.Minute)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if second.Fence() <= first.Fence() {
+		t.Fatalf("expected fencing tokens to grow across a crash, got %d then %d", first.Fence(), second.Fence())
+	}
+}
+
+func TestOpenRejectsWALSizeBeyondFenceRange(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.MaxWALSize = 4 << 30
+	if db, err := Open(opts); err == nil {
+		db.Close()
+		t.Fatalf("expected a 4 GiB MaxWALSize to be rejected")
+	}
+}
@@ -0,0 +1,365 @@
+package minikv
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// A list's members are keyed by their position, encoded so byte order is
+// numeric order. Positions of live elements are always the contiguous range
+// [head, tail) kept in the metadata: LPush decrements head, RPush increments
+// tail, and pops and LTrim move them back.
+//
+// Records are staged so that any prefix of a write that reaches the WAL
+// before a crash leaves a consistent list: pushes write the new elements
+// before the metadata that makes them visible, and pops and trims write the
+// metadata before deleting the elements it no longer covers. Elements left
+// outside [head, tail) by a torn write are removed at the next compaction.
+
+// listPosition encodes a list position as a member key suffix.
+func listPosition(pos int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(pos)^(1<<63))
+}
+
+// listBounds returns the [head, tail) range of a list's metadata.
+func listBounds(meta *collectionMeta) (int64, int64) {
+	head, n := binary.Varint(meta.extra)
+	if n <= 0 {
+		return 0, 0
+	}
+	tail, m := binary.Varint(meta.extra[n:])
+	if m <= 0 {
+		return 0, 0
+	}
+	return head, tail
+}
+
+func setListBounds(meta *collectionMeta, head, tail int64) {
+	meta.extra = binary.AppendVarint(binary.AppendVarint(nil, head), tail)
+	meta.count = tail - head
+}
+
+// listMemberLive reports whether a member suffix is a position within the list.
+func listMemberLive(meta *collectionMeta, suffix []byte) bool {
+	if len(suffix) != 8 {
+		return false
+	}
+	pos := int64(binary.BigEndian.Uint64(suffix) ^ (1 << 63))
+	head, tail := listBounds(meta)
+	return pos >= head && pos < tail
+}
+
+// LPush inserts values at the head of the list at key, one after another, so
+// the last value ends up first. It returns the length of the list.
+func (db *DB) LPush(key []byte, values ...[]byte) (int, error) {
+	return db.def.LPush(key, values...)
+}
+
+// LPush inserts values at the head of the list at key in the family. A new
+// list gets the family's DefaultTTL; Expire and Persist on key apply to the
+// whole list.
+func (f *Family) LPush(key []byte, values ...[]byte) (int, error) {
+	return f.push(key, values, true)
+}
+
+// RPush appends values to the tail of the list at key and returns its length.
+func (db *DB) RPush(key []byte, values ...[]byte) (int, error) {
+	return db.def.RPush(key, values...)
+}
+
+// RPush appends values to the tail of the list at key in the family.
+func (f *Family) RPush(key []byte, values ...[]byte) (int, error) {
+	return f.push(key, values, false)
+}
+
+func (f *Family) push(key []byte, values [][]byte, left bool) (int, error) {
+	var longest []byte
+	for _, value := range values {
+		if len(value) > len(longest) {
+			longest = value
+		}
+	}
+	var length int
+	err := f.writeCollection(key, nil, longest, func(t *txn) error {
+		var err error
+		length, err = t.push(f, key, values, left)
+		return err
+	})
+	return length, err
+}
+
+// LPop removes and returns the first element of the list at key. It returns
+// ErrNotFound if the list is empty or missing.
+func (db *DB) LPop(key []byte) ([]byte, error) {
+	return db.def.LPop(key)
+}
+
+// LPop removes and returns the first element of the list at key in the family.
+func (f *Family) LPop(key []byte) ([]byte, error) {
+	value, _, err := f.pop(key, true, false)
+	return value, err
+}
+
+// RPop removes and returns the last element of the list at key.
+func (db *DB) RPop(key []byte) ([]byte, error) {
+	return db.def.RPop(key)
+}
+
+// RPop removes and returns the last element of the list at key in the family.
+func (f *Family) RPop(key []byte) ([]byte, error) {
+	value, _, err := f.pop(key, false, false)
+	return value, err
+}
+
+// BLPop removes and returns the first element of the list at key, waiting
+// for one to be pushed if the list is empty. It returns ctx.Err() once ctx is
+// done, and ErrClosed if the database is closed while waiting.
+func (db *DB) BLPop(ctx context.Context, key []byte) ([]byte, error) {
+	return db.def.BLPop(ctx, key)
+}
+
+// BLPop removes and returns the first element of the list at key in the
+// family, waiting for one to be pushed if the list is empty. When several
+// callers wait on the same list, each element goes to exactly one of them.
+func (f *Family) BLPop(ctx context.Context, key []byte) ([]byte, error) {
+	for {
+		value, wait, err := f.pop(key, true, true)
+		if err != ErrNotFound || wait == nil {
+			return value, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// pop removes an element from one end of the list at key. If the list is
+// empty and wait is set, it also returns a channel that is closed by the
+// next push, taken under the same lock so no push can be missed.
+func (f *Family) pop(key []byte, left, wait bool) ([]byte, <-chan struct{}, error) {
+	var value []byte
+	var pushed <-chan struct{}
+	err := f.writeCollection(key, nil, nil, func(t *txn) error {
+		var err error
+		value, err = t.pop(f, key, left)
+		if err == ErrNotFound && wait {
+			pushed = t.db.pushedLocked()
+		}
+		return err
+	})
+	return value, pushed, err
+}
+
+// LRange returns the elements of the list at key from start to stop
+// inclusive. Negative indexes count from the end, so LRange(key, 0, -1)
+// returns the whole list. A missing list yields no elements.
+func (db *DB) LRange(key []byte, start, stop int) ([][]byte, error) {
+	return db.def.LRange(key, start, stop)
+}
+
+// LRange returns the elements of the list at key in the family from start to
+// stop inclusive.
+func (f *Family) LRange(key []byte, start, stop int) ([][]byte, error) {
+	var values [][]byte
+	err := f.readCollection(key, kindList, func(meta *collectionMeta, sub *Family) error {
+		head, _ := listBounds(meta)
+		from, to, ok := listRange(meta.count, start, stop)
+		if !ok {
+			return nil
+		}
+		base := memberPrefix(key, meta.version)
+		for pos := head + from; pos <= head+to; pos++ {
+			member := string(append(base, listPosition(pos)...))
+			entry, ok := sub.index.Get(member)
+			if !ok {
+				return ErrInvalidValue
+			}
+			value, err := f.db.entryValue(member, entry)
+			if err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return values, err
+}
+
+// LLen returns the length of the list at key.
+func (db *DB) LLen(key []byte) (int, error) {
+	return db.def.LLen(key)
+}
+
+// LLen returns the length of the list at key in the family; 0 if it does not exist.
+func (f *Family) LLen(key []byte) (int, error) {
+	var length int
+	err := f.readCollection(key, kindList, func(meta *collectionMeta, _ *Family) error {
+		length = int(meta.count)
+		return nil
+	})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return length, err
+}
+
+// LTrim keeps only the elements of the list at key from start to stop
+// inclusive, with the same indexing as LRange. Trimming every element
+// removes the list.
+func (db *DB) LTrim(key []byte, start, stop int) error {
+	return db.def.LTrim(key, start, stop)
+}
+
+// LTrim keeps only the elements of the list at key in the family from start
+// to stop inclusive.
+func (f *Family) LTrim(key []byte, start, stop int) error {
+	return f.writeCollection(key, nil, nil, func(t *txn) error {
+		return t.ltrim(f, key, start, stop)
+	})
+}
+
+// listRange resolves LRange-style indexes against a list of length n into
+// offsets from the head, reporting false if the range is empty.
+func listRange(n int64, start, stop int) (int64, int64, bool) {
+	from, to := int64(start), int64(stop)
+	if from < 0 {
+		from += n
+	}
+	if to < 0 {
+		to += n
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to >= n {
+		to = n - 1
+	}
+	return from, to, from <= to
+}
+
+// push stages values at one end of the list at key and returns its new length.
+func (t *txn) push(f *Family, key []byte, values [][]byte, left bool) (int, error) {
+	meta, err := t.meta(f, string(key), kindList)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		meta = t.newMeta(f, kindList)
+	}
+	if len(values) == 0 {
+		return int(meta.count), nil
+	}
+	sub, err := f.subFamilyLocked(true)
+	if err != nil {
+		return 0, err
+	}
+	head, tail := listBounds(meta)
+	base := memberPrefix(key, meta.version)
+	for _, value := range values {
+		pos := tail
+		if left {
+			head--
+			pos = head
+		} else {
+			tail++
+		}
+		if err := t.set(sub, append(base[:len(base):len(base)], listPosition(pos)...), value, -1); err != nil {
+			return 0, err
+		}
+	}
+	setListBounds(meta, head, tail)
+	t.putMeta(f, key, meta)
+	t.pushed = true
+	return int(meta.count), nil
+}
+
+// pop stages the removal of the element at one end of the list at key and
+// returns it.
+func (t *txn) pop(f *Family, key []byte, left bool) ([]byte, error) {
+	meta, err := t.meta(f, string(key), kindList)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrNotFound
+	}
+	sub, err := f.subFamilyLocked(false)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return nil, ErrInvalidValue
+	}
+	head, tail := listBounds(meta)
+	pos := tail - 1
+	if left {
+		pos = head
+		head++
+	} else {
+		tail--
+	}
+	member := append(memberPrefix(key, meta.version), listPosition(pos)...)
+	value, ok, err := t.get(sub, string(member))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidValue
+	}
+	setListBounds(meta, head, tail)
+	t.putMeta(f, key, meta)
+	t.delete(sub, member)
+	return value, nil
+}
+
+// ltrim stages the removal of the elements of the list at key outside
+// [start, stop].
+func (t *txn) ltrim(f *Family, key []byte, start, stop int) error {
+	meta, err := t.meta(f, string(key), kindList)
+	if err != nil || meta == nil {
+		return err
+	}
+	sub, err := f.subFamilyLocked(false)
+	if err != nil {
+		return err
+	}
+	if sub == nil {
+		return ErrInvalidValue
+	}
+	head, tail := listBounds(meta)
+	newHead, newTail := tail, tail
+	if from, to, ok := listRange(meta.count, start, stop); ok {
+		newHead, newTail = head+from, head+to+1
+	}
+	setListBounds(meta, newHead, newTail)
+	t.putMeta(f, key, meta)
+	base := memberPrefix(key, meta.version)
+	for pos := head; pos < tail; pos++ {
+		if pos < newHead || pos >= newTail {
+			t.delete(sub, append(base[:len(base):len(base)], listPosition(pos)...))
+		}
+	}
+	return nil
+}
+
+// pushedLocked returns a channel closed by the next write that pushes to a
+// list, or by Close. Callers must hold db.mu.
+func (db *DB) pushedLocked() <-chan struct{} {
+	if db.pushed == nil {
+		db.pushed = make(chan struct{})
+	}
+	return db.pushed
+}
+
+// notifyPushedLocked wakes every BLPop waiting for a push. Callers must hold db.mu.
+func (db *DB) notifyPushedLocked() {
+	if db.pushed != nil {
+		close(db.pushed)
+		db.pushed = nil
+	}
+}
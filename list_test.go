@@ -0,0 +1,298 @@
+package minikv
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+func expectList(t *testing.T, db *DB, key, want string) {
+	t.Helper()
+	values, err := db.LRange([]byte(key), 0, -1)
+	if err != nil {
+		t.Fatalf("lrange %s: %v", key, err)
+	}
+	got := make([]string, len(values))
+	for i, value := range values {
+		got[i] = string(value)
+	}
+	if strings.Join(got, ",") != want {
+		t.Fatalf("lrange %s: got %q, want %q", key, strings.Join(got, ","), want)
+	}
+}
+
+func TestListPushPopAndRange(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	if n, err := db.RPush([]byte("q"), []byte("b"), []byte("c")); err != nil || n != 2 {
+		t.Fatalf("rpush: %d %v", n, err)
+	}
+	if n, _ := db.LPush([]byte("q"), []byte("a"), []byte("z")); n != 4 {
+		t.Fatalf("expected length 4, got %d", n)
+	}
+	expectList(t, db, "q", "z,a,b,c")
+
+	cases := []struct {
+		start, stop int
+		want        string
+	}{
+		{1, 2, "a,b"},
+		{-2, -1, "b,c"},
+		{2, 100, "b,c"},
+		{-100, 0, "z"},
+		{3, 1, ""},
+	}
+	for _, tc := range cases {
+		values, err := db.LRange([]byte("q"), tc.start, tc.stop)
+		if err != nil {
+			t.Fatalf("lrange: %v", err)
+		}
+		got := make([]string, len(values))
+		for i, value := range values {
+			got[i] = string(value)
+		}
+		if strings.Join(got, ",") != tc.want {
+			t.Fatalf("lrange %d %d: got %v, want %q", tc.start, tc.stop, got, tc.want)
+		}
+	}
+
+	if value, err := db.LPop([]byte("q")); err != nil || string(value) != "z" {
+		t.Fatalf("lpop: %q %v", value, err)
+	}
+	if value, err := db.RPop([]byte("q")); err != nil || string(value) != "c" {
+		t.Fatalf("rpop: %q %v", value, err)
+	}
+	if n, _ := db.LLen([]byte("q")); n != 2 {
+		t.Fatalf("expected length 2, got %d", n)
+	}
+	_, _ = db.LPop([]byte("q"))
+	_, _ = db.LPop([]byte("q"))
+	if _, err := db.LPop([]byte("q")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if ok, _ := db.Exists([]byte("q")); ok {
+		t.Fatalf("expected empty list to be removed")
+	}
+
+	_ = db.Set([]byte("plain"), []byte("v"))
+	if _, err := db.RPush([]byte("plain"), []byte("x")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	_ = db.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	if _, err := db.LLen([]byte("hash")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestListTrim(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	for i := 0; i < 6; i++ {
+		_, _ = db.RPush([]byte("log"), []byte(intToString(i)))
+	}
+	if err := db.LTrim([]byte("log"), 1, -2); err != nil {
+		t.Fatalf("ltrim: %v", err)
+	}
+	expectList(t, db, "log", "1,2,3,4")
+	_, _ = db.LPush([]byte("log"), []byte("x"))
+	expectList(t, db, "log", "x,1,2,3,4")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if n := db.def.sub.index.Len(); n != 5 {
+		t.Fatalf("expected trimmed elements to be gone, %d members left", n)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	expectList(t, db, "log", "x,1,2,3,4")
+	if err := db.LTrim([]byte("log"), 5, 10); err != nil {
+		t.Fatalf("ltrim: %v", err)
+	}
+	if ok, _ := db.Exists([]byte("log")); ok {
+		t.Fatalf("expected trimming every element to remove the list")
+	}
+}
+
+func TestListTornWriteIsInvisible(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_, _ = db.RPush([]byte("q"), []byte("a"))
+
+	// Stage a push but write only its element record, as if the process had
+	// died before the metadata record reached the WAL.
+	db.mu.Lock()
+	txn := db.newTxnLocked()
+	if _, err := txn.push(db.def, []byte("q"), [][]byte{[]byte("lost")}, false); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	txn.records = txn.records[:1]
+	txn.createdAt = txn.createdAt[:1]
+	if err := txn.commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	db.mu.Unlock()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	expectList(t, db, "q", "a")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if n := db.def.sub.index.Len(); n != 1 {
+		t.Fatalf("expected the torn element to be collected, %d members left", n)
+	}
+	_, _ = db.RPush([]byte("q"), []byte("b"))
+	expectList(t, db, "q", "a,b")
+}
+
+func TestListMatchesModel(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 30
+	properties := gopter.NewProperties(parameters)
+
+	properties.Property("list matches a slice across compaction and reopen", prop.ForAll(
+		func(ops []int, compactAt int) bool {
+			dir := t.TempDir()
+			db := openTestDB(t, dir)
+			var model []string
+			for i, op := range ops {
+				value := intToString(i)
+				switch op {
+				case 0:
+					_, _ = db.LPush([]byte("l"), []byte(value))
+					model = append([]string{value}, model...)
+				case 1:
+					_, _ = db.RPush([]byte("l"), []byte(value))
+					model = append(model, value)
+				case 2:
+					got, err := db.LPop([]byte("l"))
+					if len(model) == 0 {
+						if err != ErrNotFound {
+							return false
+						}
+						continue
+					}
+					if string(got) != model[0] {
+						return false
+					}
+					model = model[1:]
+				case 3:
+					got, err := db.RPop([]byte("l"))
+					if len(model) == 0 {
+						if err != ErrNotFound {
+							return false
+						}
+						continue
+					}
+					if string(got) != model[len(model)-1] {
+						return false
+					}
+					model = model[:len(model)-1]
+				}
+				if i == compactAt {
+					_ = db.Compact()
+				}
+			}
+			_ = db.Close()
+			db = openTestDB(t, dir)
+			defer db.Close()
+			values, err := db.LRange([]byte("l"), 0, -1)
+			if err != nil || len(values) != len(model) {
+				return false
+			}
+			for i, value := range values {
+				if string(value) != model[i] {
+					return false
+				}
+			}
+			n, _ := db.LLen([]byte("l"))
+			return n == len(model)
+		},
+		gen.SliceOf(gen.IntRange(0, 3)),
+		gen.IntRange(-1, 20),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestBLPopWaitsForPush(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	results := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			value, err := db.BLPop(context.Background(), []byte("jobs"))
+			if err != nil {
+				results <- "error: " + err.Error()
+				return
+			}
+			results <- string(value)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	_, _ = db.RPush([]byte("jobs"), []byte("a"))
+	batch := db.NewBatch()
+	batch.Set([]byte("unrelated"), []byte("v"))
+	_ = batch.Write()
+	_, _ = db.RPush([]byte("jobs"), []byte("b"), []byte("c"))
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		select {
+		case value := <-results:
+			got = append(got, value)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for BLPop, got %v", got)
+		}
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Fatalf("expected each element once, got %v", got)
+	}
+}
+
+func TestBLPopCancellation(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.BLPop(ctx, []byte("jobs")); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	_, _ = db.RPush([]byte("jobs"), []byte("ready"))
+	if value, err := db.BLPop(context.Background(), []byte("jobs")); err != nil || string(value) != "ready" {
+		t.Fatalf("expected an available element without waiting, got %q %v", value, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.BLPop(context.Background(), []byte("jobs"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_ = db.Close()
+	select {
+	case err := <-done:
+		if err != ErrClosed {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("BLPop did not return after Close")
+	}
+}
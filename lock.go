@@ -0,0 +1,47 @@
+package minikv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// The writer holds an exclusive lock on LOCK. Read-only openers instead hold
+// a shared lock on READERS, so any number of them can run alongside the
+// writer. Upgrade takes both exclusively because it rewrites files in place.
+const (
+	writerLockFile = "LOCK"
+	readerLockFile = "READERS"
+)
+
+// lockDir takes the lock for an opener of the directory at path.
+func lockDir(path string, readOnly bool) (*os.File, error) {
+	if readOnly {
+		return flockFile(filepath.Join(path, readerLockFile), syscall.LOCK_SH)
+	}
+	return flockFile(filepath.Join(path, writerLockFile), syscall.LOCK_EX)
+}
+
+func flockFile(path string, how int) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+func unlockFile(file *os.File) error {
+	if file == nil {
+		return nil
+	}
+	_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	return file.Close()
+}
@@ -1,6 +1,8 @@
 package minikv
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -9,14 +11,32 @@ import (
 	"github.com/bretuobay/mini-kv/internal/wal"
 )
 
-func refreshManifest(dbPath string) error {
+// openManifest opens the MANIFEST log. A database without one is
+// bootstrapped from the files on disk, which is what older versions did on
+// every refresh.
+func openManifest(dbPath string) (*manifest.Log, error) {
+	manifestPath := filepath.Join(dbPath, "MANIFEST")
+	if _, err := os.Stat(manifestPath); errors.Is(err, os.ErrNotExist) {
+		man, err := scanManifest(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := manifest.WriteManifest(manifestPath, man); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return manifest.OpenLog(manifestPath, manifest.DefaultMaxLogSize)
+}
+
+func scanManifest(dbPath string) (manifest.Manifest, error) {
 	walDir := filepath.Join(dbPath, "wal")
 	snapDir := filepath.Join(dbPath, "snapshots")
-	manifestPath := filepath.Join(dbPath, "MANIFEST")
 
 	segments, err := wal.ListSegments(walDir)
-	if err != nil {
-		return err
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return manifest.Manifest{}, err
 	}
 	walSegments := make([]manifest.WALSegment, 0, len(segments))
 	var currentSeq uint64
@@ -31,43 +51,136 @@ func refreshManifest(dbPath string) error {
 		walSegments = append(walSegments, manifest.WALSegment{Seq: seq, Path: path})
 	}
 
-	snapshots, err := listSnapshots(snapDir)
+	mgr := snapshot.NewManager(snapDir)
+	snapshots, err := mgr.ListSnapshots()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return manifest.Manifest{}, err
+	}
+	deltas, err := mgr.ListDeltas()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return manifest.Manifest{}, err
+	}
+	var lastSnapSeq uint64
+	snapInfos := snapshotInfos(snapshots, &lastSnapSeq)
+	deltaInfos := snapshotInfos(deltas, &lastSnapSeq)
+
+	return manifest.Manifest{
+		CurrentWALSeq:   currentSeq,
+		LastSnapshotSeq: lastSnapSeq,
+		WALSegments:     walSegments,
+		Snapshots:       snapInfos,
+		Deltas:          deltaInfos,
+	}, nil
+}
+
+// reconcileManifest commits WAL segments created before a crash cut off
+// their manifest edit, and removes snapshot files that were renamed into
+// place but never committed. Such snapshots are redundant: the WAL segments
+// they cover are only deleted after the commit.
+func reconcileManifest(log *manifest.Log, walDir string, snapMgr *snapshot.Manager) error {
+	state := log.State()
+	known := make(map[string]struct{})
+	for _, seg := range state.WALSegments {
+		known[seg.Path] = struct{}{}
+	}
+	for _, info := range append(append([]manifest.SnapshotInfo(nil), state.Snapshots...), state.Deltas...) {
+		known[info.Path] = struct{}{}
+	}
+
+	segments, err := wal.ListSegments(walDir)
 	if err != nil {
 		return err
 	}
-	snapInfos := make([]manifest.SnapshotInfo, 0, len(snapshots))
-	var lastSnapSeq uint64
-	for _, path := range snapshots {
-		seq, ok := parseSnapshotSeq(path)
+	var edit manifest.VersionEdit
+	for _, path := range segments {
+		seq, ok := parseSegmentSeq(path)
 		if !ok {
 			continue
 		}
-		if seq > lastSnapSeq {
-			lastSnapSeq = seq
+		if _, ok := known[path]; ok {
+			continue
+		}
+		edit.AddWALSegments = append(edit.AddWALSegments, manifest.WALSegment{Seq: seq, Path: path})
+		if seq > state.CurrentWALSeq {
+			edit.HasCurrentWALSeq = true
+			edit.CurrentWALSeq = seq
+			state.CurrentWALSeq = seq
+		}
+	}
+	if len(edit.AddWALSegments) > 0 {
+		if err := log.Apply(edit); err != nil {
+			return err
 		}
-		snapInfos = append(snapInfos, manifest.SnapshotInfo{Seq: seq, Path: path})
 	}
 
-	man := manifest.Manifest{
-		CurrentWALSeq:   currentSeq,
-		LastSnapshotSeq: lastSnapSeq,
-		WALSegments:     walSegments,
-		Snapshots:       snapInfos,
+	for _, list := range []func() ([]string, error){snapMgr.ListSnapshots, snapMgr.ListDeltas} {
+		paths, err := list()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		for _, path := range paths {
+			if _, ok := known[path]; ok {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// logWALSegment commits a newly opened WAL segment to the MANIFEST.
+func (db *DB) logWALSegment(seq uint64) error {
+	return db.manifest.Apply(manifest.VersionEdit{
+		HasCurrentWALSeq: true,
+		CurrentWALSeq:    seq,
+		AddWALSegments:   []manifest.WALSegment{{Seq: seq, Path: wal.SegmentPath(filepath.Join(db.path, "wal"), seq)}},
+	})
+}
+
+// removeFiles commits the removal of paths to the MANIFEST, then deletes
+// them. A crash in between leaves only unreferenced files behind.
+func (db *DB) removeFiles(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if err := db.manifest.Apply(manifest.VersionEdit{RemovedFiles: paths}); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
 	}
-	return manifest.WriteManifest(manifestPath, man)
+	return nil
 }
 
-func listSnapshots(dir string) ([]string, error) {
-	mgr := snapshot.NewManager(dir)
-	return mgr.ListSnapshots()
+func snapshotInfos(paths []string, lastSeq *uint64) []manifest.SnapshotInfo {
+	infos := make([]manifest.SnapshotInfo, 0, len(paths))
+	for _, path := range paths {
+		seq, ok := parseSnapshotSeq(path)
+		if !ok {
+			continue
+		}
+		if seq > *lastSeq {
+			*lastSeq = seq
+		}
+		infos = append(infos, manifest.SnapshotInfo{Seq: seq, Path: path})
+	}
+	return infos
 }
 
 func parseSnapshotSeq(path string) (uint64, bool) {
 	base := filepath.Base(path)
-	if !strings.HasPrefix(base, "snapshot_") || !strings.HasSuffix(base, ".snap") {
+	ext := filepath.Ext(base)
+	if !strings.HasPrefix(base, "snapshot_") || (ext != ".snap" && ext != ".delta") {
 		return 0, false
 	}
-	value := strings.TrimSuffix(strings.TrimPrefix(base, "snapshot_"), ".snap")
+	value := strings.TrimSuffix(strings.TrimPrefix(base, "snapshot_"), ext)
 	seq, err := parseUint(value)
 	if err != nil {
 		return 0, false
@@ -0,0 +1,124 @@
+package minikv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bretuobay/mini-kv/internal/manifest"
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+)
+
+func TestManifestMatchesCommittedFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) {
+		opts.MaxSnapshotDeltas = 1
+		opts.SnapshotRetention = SnapshotRetention{KeepLast: 1}
+	})
+	for i := 0; i < 20; i++ {
+		_ = db.Set([]byte("k"+intToString(i)), []byte("v"))
+	}
+	for round := 0; round < 5; round++ {
+		_ = db.Set([]byte("k0"), []byte("r"+intToString(round)))
+		if err := db.Compact(); err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	man, err := manifest.ReadManifest(filepath.Join(dir, "MANIFEST"))
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var recorded []string
+	for _, seg := range man.WALSegments {
+		recorded = append(recorded, seg.Path)
+	}
+	for _, info := range append(man.Snapshots, man.Deltas...) {
+		recorded = append(recorded, info.Path)
+	}
+	onDisk, _ := filepath.Glob(filepath.Join(dir, "wal", "*.log"))
+	snaps, _ := filepath.Glob(filepath.Join(dir, "snapshots", "snapshot_*"))
+	onDisk = append(onDisk, snaps...)
+	sort.Strings(recorded)
+	sort.Strings(onDisk)
+	if strings.Join(recorded, ",") != strings.Join(onDisk, ",") {
+		t.Fatalf("manifest %v does not match files %v", recorded, onDisk)
+	}
+}
+
+func TestOpenMigratesTextManifest(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_ = db.Set([]byte("a"), []byte("1"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Set([]byte("b"), []byte("2"))
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	path := filepath.Join(dir, "MANIFEST")
+	man, err := manifest.ReadManifest(path)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "current_wal_seq: %d\nlast_snapshot_seq: %d\n", man.CurrentWALSeq, man.LastSnapshotSeq)
+	for _, seg := range man.WALSegments {
+		fmt.Fprintf(&text, "wal: %d %q\n", seg.Seq, seg.Path)
+	}
+	for _, snap := range man.Snapshots {
+		fmt.Fprintf(&text, "snapshot: %d %q\n", snap.Seq, snap.Path)
+	}
+	if err := os.WriteFile(path, []byte(text.String()), 0o644); err != nil {
+		t.Fatalf("write text manifest: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		value, err := db.Get([]byte(key))
+		if err != nil || string(value) != want {
+			t.Fatalf("get %s: %q %v", key, value, err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "MINIKVMF") {
+		t.Fatalf("expected manifest to be migrated to binary")
+	}
+}
+
+func TestOpenRemovesUncommittedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_ = db.Set([]byte("a"), []byte("1"))
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A snapshot renamed into place whose manifest edit never landed.
+	snapMgr := snapshot.NewManager(filepath.Join(dir, "snapshots"))
+	stale, err := snapMgr.CreateSnapshot([]snapshot.Entry{{Key: []byte("a"), Value: []byte("stale"), ExpiresAt: -1}}, snapshot.Version, 1, 1)
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected uncommitted snapshot to be removed, got %v", err)
+	}
+	if value, err := db.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Fatalf("get: %q %v", value, err)
+	}
+}
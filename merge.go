@@ -0,0 +1,364 @@
+package minikv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+// maxMergeOperands bounds the operands stacked on one key. Once reached, the
+// write that adds one folds them in memory so reads stay cheap; the WAL keeps
+// the operands and replays to the same value.
+const maxMergeOperands = 64
+
+// MergeOperator folds merge operands into a value. existing is nil when the
+// key had no value; operands are given oldest first. Merge must be
+// deterministic, since operands are folded again whenever they are replayed
+// from the WAL, and must not call back into the DB.
+type MergeOperator interface {
+	Merge(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// OperandValidator is implemented by merge operators that can check an
+// operand on its own. Merge rejects an operand the validator returns an
+// error for before writing it, so a malformed operand cannot leave a key
+// that no longer folds. The built-in operators other than AppendOperator
+// implement it.
+type OperandValidator interface {
+	ValidateOperand(key, operand []byte) error
+}
+
+// MergeFunc adapts a function to MergeOperator.
+type MergeFunc func(key, existing []byte, operands [][]byte) ([]byte, error)
+
+// Merge calls fn.
+func (fn MergeFunc) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return fn(key, existing, operands)
+}
+
+// Merge appends operand to key for Options.MergeOperator to fold in. Only
+// the operand is written, so no read is needed; the value is folded on read,
+// on compaction, or once maxMergeOperands operands have accumulated.
+func (db *DB) Merge(key []byte, operand []byte) error {
+	return db.def.Merge(key, operand)
+}
+
+// Merge appends operand to key in the family. A key without a value gets the
+// family's DefaultTTL; otherwise its expiry is kept. If the operator is an
+// OperandValidator, an operand it rejects is not written and its error is
+// returned.
+func (f *Family) Merge(key []byte, operand []byte) error {
+	db := f.db
+	stats := db.statsOrInit()
+	start := time.Now()
+	if len(key) > f.opts.MaxKeySize {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return ErrKeyTooLarge
+	}
+	if len(operand) > f.opts.MaxValueSize {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return ErrValueTooLarge
+	}
+	if len(key) == 0 {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return ErrNotFound
+	}
+	if db.opts.MergeOperator == nil {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return ErrNoMergeOperator
+	}
+	if validator, ok := db.opts.MergeOperator.(OperandValidator); ok {
+		if err := validator.ValidateOperand(key, operand); err != nil {
+			stats.writes.Add(1)
+			stats.writeLatency.add(time.Since(start))
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := f.unavailableLocked(); err != nil {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return err
+	}
+	if db.opts.ReadOnly {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return ErrReadOnly
+	}
+
+	if err := db.checkMemoryLocked(); err != nil {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return err
+	}
+
+	now := db.now()
+	record, err := f.mergeRecordLocked(key, operand, now)
+	if err != nil {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return err
+	}
+	if err := db.wal.AppendRecord(record); err != nil {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return err
+	}
+	if db.opts.SyncMode == SyncAlways {
+		if err := db.syncWAL(); err != nil {
+			stats.writes.Add(1)
+			stats.writeLatency.add(time.Since(start))
+			return err
+		}
+	}
+	f.applyMergeLocked(record)
+	db.evictLocked()
+	stats.writes.Add(1)
+	stats.writeLatency.add(time.Since(start))
+	return nil
+}
+
+// mergeRecordLocked returns the WAL record for a merge at timestamp. Its
+// ExpiresAt is the expiry the key has after the merge, so replay rebuilds the
+// same entry even once the base value has expired.
+func (f *Family) mergeRecordLocked(key []byte, operand []byte, timestamp int64) (wal.WALRecord, error) {
+	expiresAt := f.defaultExpiresAt()
+	if entry, ok := f.index.Get(string(key)); ok {
+		if entry.Meta {
+			return wal.WALRecord{}, ErrWrongType
+		}
+		expiresAt = entry.ExpiresAt
+	}
+	return wal.WALRecord{
+		Type:      wal.RecordMerge,
+		Timestamp: timestamp,
+		ExpiresAt: expiresAt,
+		Key:       append([]byte(nil), key...),
+		Value:     append([]byte(nil), operand...),
+		Family:    f.id,
+	}, nil
+}
+
+// applyMergeLocked mirrors a merge record into the family index, folding the
+// operands in memory once there are maxMergeOperands of them. A fold that
+// fails, such as on a base value the operator cannot parse, leaves the
+// operands stacked: reads report the error and snapshots keep the operands.
+func (f *Family) applyMergeLocked(record wal.WALRecord) {
+	f.markDirtyLocked(record)
+	key := string(record.Key)
+	f.index.Merge(key, record.Value, record.ExpiresAt, record.Timestamp)
+	entry, ok := f.index.Get(key)
+	if !ok || len(entry.Operands) < maxMergeOperands {
+		return
+	}
+	if value, err := f.db.entryValue(key, entry); err == nil {
+		f.index.SetEntry(key, value, entry.ExpiresAt, entry.CreatedAt)
+	}
+}
+
+// entryValue returns the logical value of an entry: its stored value, read
+// from the value log if needed, with pending merge operands folded in.
+// Callers must hold db.mu.
+func (db *DB) entryValue(key string, entry *index.Entry) ([]byte, error) {
+	if entry.Meta {
+		return nil, ErrWrongType
+	}
+	if len(entry.Operands) == 0 {
+		return db.resolveValue(entry)
+	}
+	base, err := db.mergeBase(entry)
+	if err != nil {
+		return nil, err
+	}
+	if db.opts.MergeOperator == nil {
+		return nil, ErrNoMergeOperator
+	}
+	return db.opts.MergeOperator.Merge([]byte(key), base, entry.Operands)
+}
+
+// mergeBase returns the value merge operands of entry apply to, or nil if
+// the key had none.
+func (db *DB) mergeBase(entry *index.Entry) ([]byte, error) {
+	if entry.NoBase {
+		return nil, nil
+	}
+	value, err := db.resolveValue(entry)
+	if err != nil {
+		return nil, err
+	}
+	// An empty value is still a value; only a missing one is nil.
+	return append([]byte{}, value...), nil
+}
+
+// Int64AddOperator adds operands to the value as decimal int64s, the format
+// IncrBy uses. A missing value counts as zero.
+var Int64AddOperator MergeOperator = checkedOperator{validateInt64, func(_, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum int64
+	if existing != nil {
+		v, err := strconv.ParseInt(string(existing), 10, 64)
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+		sum = v
+	}
+	for _, operand := range operands {
+		v, err := strconv.ParseInt(string(operand), 10, 64)
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+		sum += v
+	}
+	return []byte(strconv.FormatInt(sum, 10)), nil
+}}
+
+// FloatAddOperator adds operands to the value as decimal float64s.
+var FloatAddOperator MergeOperator = checkedOperator{validateFloat, func(_, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum float64
+	if existing != nil {
+		v, err := strconv.ParseFloat(string(existing), 64)
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+		sum = v
+	}
+	for _, operand := range operands {
+		v, err := strconv.ParseFloat(string(operand), 64)
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+		sum += v
+	}
+	return []byte(strconv.FormatFloat(sum, 'g', -1, 64)), nil
+}}
+
+// AppendOperator appends operands to the value.
+var AppendOperator MergeOperator = MergeFunc(func(_, existing []byte, operands [][]byte) ([]byte, error) {
+	value := append([]byte(nil), existing...)
+	for _, operand := range operands {
+		value = append(value, operand...)
+	}
+	return value, nil
+})
+
+// MaxOperator keeps the largest of the value and operands, compared as
+// decimal int64s.
+var MaxOperator MergeOperator = extremeOperator(func(a, b int64) bool { return a > b })
+
+// MinOperator keeps the smallest of the value and operands, compared as
+// decimal int64s.
+var MinOperator MergeOperator = extremeOperator(func(a, b int64) bool { return a < b })
+
+func extremeOperator(better func(a, b int64) bool) MergeOperator {
+	return checkedOperator{validateInt64, func(_, existing []byte, operands [][]byte) ([]byte, error) {
+		candidates := operands
+		if existing != nil {
+			candidates = append([][]byte{existing}, operands...)
+		}
+		var best int64
+		for i, candidate := range candidates {
+			v, err := strconv.ParseInt(string(candidate), 10, 64)
+			if err != nil {
+				return nil, ErrInvalidValue
+			}
+			if i == 0 || better(v, best) {
+				best = v
+			}
+		}
+		return []byte(strconv.FormatInt(best, 10)), nil
+	}}
+}
+
+// SetUnionOperator treats the value and each operand as a set of members and
+// stores their union. Sets are encoded by EncodeSet.
+var SetUnionOperator MergeOperator = checkedOperator{validateSet, func(_, existing []byte, operands [][]byte) ([]byte, error) {
+	members := make(map[string]struct{})
+	for _, data := range append([][]byte{existing}, operands...) {
+		set, err := DecodeSet(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range set {
+			members[string(member)] = struct{}{}
+		}
+	}
+	union := make([][]byte, 0, len(members))
+	for member := range members {
+		union = append(union, []byte(member))
+	}
+	return EncodeSet(union...), nil
+}}
+
+// checkedOperator is a built-in operator that validates operands with check.
+type checkedOperator struct {
+	check func(operand []byte) error
+	merge MergeFunc
+}
+
+func (o checkedOperator) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return o.merge(key, existing, operands)
+}
+
+func (o checkedOperator) ValidateOperand(_, operand []byte) error {
+	return o.check(operand)
+}
+
+func validateInt64(operand []byte) error {
+	if _, err := strconv.ParseInt(string(operand), 10, 64); err != nil {
+		return ErrInvalidValue
+	}
+	return nil
+}
+
+func validateFloat(operand []byte) error {
+	if _, err := strconv.ParseFloat(string(operand), 64); err != nil {
+		return ErrInvalidValue
+	}
+	return nil
+}
+
+func validateSet(operand []byte) error {
+	_, err := DecodeSet(operand)
+	return err
+}
+
+// EncodeSet encodes members for SetUnionOperator as uvarint-length-prefixed
+// byte strings in sorted order, without duplicates.
+func EncodeSet(members ...[]byte) []byte {
+	sorted := append([][]byte(nil), members...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	var buf []byte
+	for i, member := range sorted {
+		if i > 0 && bytes.Equal(member, sorted[i-1]) {
+			continue
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(member)))
+		buf = append(buf, member...)
+	}
+	return buf
+}
+
+// DecodeSet decodes a set written by EncodeSet.
+func DecodeSet(data []byte) ([][]byte, error) {
+	var members [][]byte
+	for len(data) > 0 {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || length > math.MaxInt32 || uint64(len(data)-n) < length {
+			return nil, ErrInvalidValue
+		}
+		members = append(members, append([]byte(nil), data[n:n+int(length)]...))
+		data = data[n+int(length):]
+	}
+	return members, nil
+}
@@ -0,0 +1,257 @@
+package minikv
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+func TestMergeCounterMatchesSum(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 20
+	properties := gopter.NewProperties(parameters)
+
+	properties.Property("merged int64 adds survive compaction and reopen", prop.ForAll(
+		func(deltas []int32, compactAt int) bool {
+			dir := t.TempDir()
+			db := openTestDB(t, dir, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+			var sum int64
+			for i, delta := range deltas {
+				if err := db.Merge([]byte("n"), []byte(strconv.Itoa(int(delta)))); err != nil {
+					return false
+				}
+				sum += int64(delta)
+				if i == compactAt {
+					if err := db.Compact(); err != nil {
+						return false
+					}
+				}
+			}
+			want := strconv.FormatInt(sum, 10)
+			if len(deltas) == 0 {
+				_, err := db.Get([]byte("n"))
+				_ = db.Close()
+				return err == ErrNotFound
+			}
+			if value, err := db.Get([]byte("n")); err != nil || string(value) != want {
+				return false
+			}
+			if err := db.Close(); err != nil {
+				return false
+			}
+			db = openTestDB(t, dir, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+			defer db.Close()
+			value, err := db.Get([]byte("n"))
+			return err == nil && string(value) == want
+		},
+		gen.SliceOf(gen.Int32()),
+		gen.IntRange(-1, 100),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestMergeOnExistingValue(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) {
+		opts.MergeOperator = AppendOperator
+		opts.ValueLogThreshold = 8
+	})
+	defer db.Close()
+
+	_ = db.SetWithTTL([]byte("log"), []byte("a value in the value log;"), time.Hour)
+	_ = db.Merge([]byte("log"), []byte("b;"))
+	_ = db.Merge([]byte("log"), []byte("c;"))
+	expectValue(t, db, "log", "a value in the value log;b;c;")
+	if ttl, _ := db.TTL([]byte("log")); ttl <= 0 {
+		t.Fatalf("expected merge to keep the TTL, got %v", ttl)
+	}
+
+	keys, values, err := db.Scan([]byte("lo"), 0)
+	if err != nil || len(keys) != 1 || string(values[0]) != "a value in the value log;b;c;" {
+		t.Fatalf("scan: %q %v", values, err)
+	}
+	value, err := db.GetInto(make([]byte, 0, 64), []byte("log"))
+	if err != nil || string(value) != "a value in the value log;b;c;" {
+		t.Fatalf("get into: %q %v", value, err)
+	}
+
+	if _, err := db.Persist([]byte("log")); err != nil {
+		t.Fatalf("persist: %v", err)
+	}
+	expectValue(t, db, "log", "a value in the value log;b;c;")
+	_ = db.Merge([]byte("log"), []byte("d;"))
+	if err := db.ValueLogGC(); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	expectValue(t, db, "log", "a value in the value log;b;c;d;")
+}
+
+func TestMergeFoldsLongOperandChains(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	defer db.Close()
+	for i := 0; i < maxMergeOperands*2+1; i++ {
+		_ = db.Merge([]byte("n"), []byte("1"))
+	}
+	entry, _ := db.def.index.Get("n")
+	if len(entry.Operands) >= maxMergeOperands {
+		t.Fatalf("expected operands to be folded, got %d", len(entry.Operands))
+	}
+	expectValue(t, db, "n", intToString(maxMergeOperands*2+1))
+}
+
+func TestMergeAfterExpiryStartsFresh(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	_ = db.SetWithTTL([]byte("n"), []byte("10"), 20*time.Millisecond)
+	_ = db.Merge([]byte("n"), []byte("1"))
+	time.Sleep(40 * time.Millisecond)
+	_ = db.Merge([]byte("n"), []byte("2"))
+	expectValue(t, db, "n", "2")
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	defer db.Close()
+	expectValue(t, db, "n", "2")
+}
+
+func TestMergeRequiresOperator(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	if err := db.Merge([]byte("n"), []byte("1")); err != ErrNoMergeOperator {
+		t.Fatalf("expected ErrNoMergeOperator, got %v", err)
+	}
+	_ = db.Close()
+
+	db = openTestDB(t, dir, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	_ = db.Merge([]byte("n"), []byte("1"))
+	_ = db.Close()
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if _, err := db.Get([]byte("n")); err != ErrNoMergeOperator {
+		t.Fatalf("expected ErrNoMergeOperator, got %v", err)
+	}
+	if err := db.Compact(); err != ErrNoMergeOperator {
+		t.Fatalf("expected compaction to refuse unfolded operands, got %v", err)
+	}
+}
+
+func TestMergeRejectsInvalidOperand(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	defer db.Close()
+	if err := db.Merge([]byte("n"), []byte("abc")); err != ErrInvalidValue {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+	if _, err := db.Get([]byte("n")); err != ErrNotFound {
+		t.Fatalf("expected the rejected operand not written, got %v", err)
+	}
+	if err := db.Merge([]byte("n"), []byte("2")); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if value, _ := db.Get([]byte("n")); string(value) != "2" {
+		t.Fatalf("expected 2, got %q", value)
+	}
+}
+
+func TestCompactKeepsUnfoldableOperands(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	_ = db.Set([]byte("bad"), []byte("abc"))
+	_ = db.Set([]byte("good"), []byte("1"))
+	_ = db.Merge([]byte("bad"), []byte("1"))
+	_ = db.Merge([]byte("bad"), []byte("2"))
+	_ = db.Merge([]byte("good"), []byte("1"))
+	if _, err := db.Get([]byte("bad")); err != ErrInvalidValue {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("expected compaction to skip past the bad key, got %v", err)
+	}
+	_ = db.Close()
+
+	db = openTestDB(t, dir, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	defer db.Close()
+	if value, _ := db.Get([]byte("good")); string(value) != "2" {
+		t.Fatalf("expected 2, got %q", value)
+	}
+	if _, err := db.Get([]byte("bad")); err != ErrInvalidValue {
+		t.Fatalf("expected ErrInvalidValue after reopen, got %v", err)
+	}
+	// Overwriting the base repairs the key; the kept operands are dropped.
+	_ = db.Set([]byte("bad"), []byte("10"))
+	_ = db.Merge([]byte("bad"), []byte("5"))
+	if value, _ := db.Get([]byte("bad")); string(value) != "15" {
+		t.Fatalf("expected 15, got %q", value)
+	}
+}
+
+func TestBuiltinMergeOperators(t *testing.T) {
+	cases := []struct {
+		name     string
+		op       MergeOperator
+		existing []byte
+		operands []string
+		want     string
+	}{
+		{"int64 add", Int64AddOperator, []byte("5"), []string{"3", "-10"}, "-2"},
+		{"int64 add missing", Int64AddOperator, nil, []string{"3"}, "3"},
+		{"float add", FloatAddOperator, []byte("1.5"), []string{"0.25"}, "1.75"},
+		{"append", AppendOperator, []byte("a"), []string{"b", "c"}, "abc"},
+		{"append empty", AppendOperator, []byte{}, []string{"b"}, "b"},
+		{"max", MaxOperator, []byte("5"), []string{"3", "9", "7"}, "9"},
+		{"max missing", MaxOperator, nil, []string{"-3", "-9"}, "-3"},
+		{"min", MinOperator, []byte("5"), []string{"3", "9"}, "3"},
+	}
+	for _, tc := range cases {
+		operands := make([][]byte, len(tc.operands))
+		for i, operand := range tc.operands {
+			operands[i] = []byte(operand)
+		}
+		got, err := tc.op.Merge([]byte("k"), tc.existing, operands)
+		if err != nil || string(got) != tc.want {
+			t.Fatalf("%s: got %q %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+
+	if _, err := Int64AddOperator.Merge([]byte("k"), []byte("x"), [][]byte{[]byte("1")}); err != ErrInvalidValue {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+
+	union, err := SetUnionOperator.Merge([]byte("k"), EncodeSet([]byte("b"), []byte("a")),
+		[][]byte{EncodeSet([]byte("c"), []byte("a"))})
+	if err != nil {
+		t.Fatalf("union: %v", err)
+	}
+	members, err := DecodeSet(union)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	got := make([]string, len(members))
+	for i, member := range members {
+		got[i] = string(member)
+	}
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Fatalf("unexpected union: %v", got)
+	}
+}
+
+func TestFollowerFoldsMerges(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	defer writer.Close()
+	_ = writer.Merge([]byte("n"), []byte("4"))
+
+	reader := openTestDB(t, dir, readOnly, func(opts *Options) { opts.MergeOperator = Int64AddOperator })
+	defer reader.Close()
+	_ = writer.Merge([]byte("n"), []byte("5"))
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	expectValue(t, reader, "n", "9")
+}
@@ -3,30 +3,64 @@ package minikv
 import (
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/bretuobay/mini-kv/internal/index"
 	"github.com/bretuobay/mini-kv/internal/manifest"
 	"github.com/bretuobay/mini-kv/internal/snapshot"
+	"github.com/bretuobay/mini-kv/internal/vlog"
 	"github.com/bretuobay/mini-kv/internal/wal"
 )
 
 // DB is the main database handle.
 type DB struct {
-	mu         sync.RWMutex
-	path       string
-	opts       Options
-	index      *index.MemIndex
+	mu   sync.RWMutex
+	path string
+	opts Options
+	// def is the default family; families holds every live family by ID,
+	// including def. Both are guarded by mu.
+	def      *Family
+	families map[uint32]*Family
+	// lastVersion is the last collection version handed out, guarded by mu.
+	lastVersion uint64
+	// reaped queues expire records for keys removed by the TTL sweep or by
+	// reads, until the sweep writes them to the WAL under mu. expired queues
+	// the same keys for Options.OnExpire, signalling expiredCh.
+	reapMu    sync.Mutex
+	reaped    []wal.WALRecord
+	expired   []expiredEntry
+	expiredCh chan struct{}
+	// pushed is closed by the next list push to wake BLPop, guarded by mu.
+	pushed chan struct{}
+	// released is closed by the next lease release to wake Lock, guarded by mu.
+	released   chan struct{}
 	wal        *wal.WALManager
+	vlog       *vlog.Manager
 	snap       *snapshot.Manager
-	manifest   *manifest.Manifest
+	manifest   *manifest.Log
 	lockFile   *os.File
-	syncTicker *time.Ticker
-	ttlTicker  *time.Ticker
-	stats      *statsTracker
-	statsOnce  sync.Once
+	syncTicker Ticker
+	ttlTicker  Ticker
+	vlogTicker Ticker
+	compTicker Ticker
+	// followTicker drives Refresh on read-only databases with FollowInterval set.
+	followTicker Ticker
+	refreshMu    sync.Mutex
+	follow       walPosition
+	stats        *statsTracker
+	statsOnce    sync.Once
+	// compactMu is held by a running Compact or BulkLoader.Commit, which
+	// both publish to the snapshot chain.
 	compactMu  sync.Mutex
-	compacting bool
+	hasBase    bool
+	deltas     int
+	walMark    uint64
+	openedAt   time.Time
+	lastWrite  atomic.Int64
+	lastCompAt atomic.Int64
+	lastCompNs atomic.Int64
+	pinMu      sync.Mutex
+	pins       map[string]int
 	stopCh     chan struct{}
 	wg         sync.WaitGroup
 	closed     bool
@@ -3,15 +3,16 @@ package minikv
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"syscall"
-	"time"
 
 	"github.com/bretuobay/mini-kv/internal/index"
 	"github.com/bretuobay/mini-kv/internal/manifest"
 	"github.com/bretuobay/mini-kv/internal/snapshot"
+	"github.com/bretuobay/mini-kv/internal/vlog"
 	"github.com/bretuobay/mini-kv/internal/wal"
 )
 
@@ -21,85 +22,143 @@ func Open(opts Options) (*DB, error) {
 		return nil, fmt.Errorf("minikv: path required")
 	}
 	opts = withDefaults(opts)
+	if opts.MaxWALSize < 0 || opts.MaxWALSize >= maxWALSize {
+		return nil, fmt.Errorf("minikv: MaxWALSize must be under 4 GiB")
+	}
 
 	if err := os.MkdirAll(opts.Path, 0o755); err != nil {
 		return nil, err
 	}
 
-	lockFile, err := os.OpenFile(filepath.Join(opts.Path, "LOCK"), os.O_CREATE|os.O_RDWR, 0o644)
+	lockFile, err := lockDir(opts.Path, opts.ReadOnly)
 	if err != nil {
 		return nil, err
 	}
-
-	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
-		_ = lockFile.Close()
-		if errors.Is(err, syscall.EWOULDBLOCK) {
-			return nil, ErrLocked
+	unlock := func() {
+		_ = unlockFile(lockFile)
+	}
+	if opts.ReadOnly {
+		db, err := openReadOnly(opts, lockFile)
+		if err != nil {
+			unlock()
 		}
-		return nil, err
+		return db, err
 	}
 
-	idx := index.NewMemIndex()
-	snapMgr := snapshot.NewManager(filepath.Join(opts.Path, "snapshots"))
-	walMgr, err := wal.OpenWAL(filepath.Join(opts.Path, "wal"), opts.MaxWALSize)
-	if err != nil {
-		_ = lockFile.Close()
+	if err := checkFormat(opts.Path); err != nil {
+		unlock()
 		return nil, err
 	}
 
-	manifestPath := filepath.Join(opts.Path, "MANIFEST")
-	man, err := loadManifest(manifestPath)
+	snapMgr := snapshot.NewManager(filepath.Join(opts.Path, "snapshots"))
+	snapMgr.SetRateLimit(opts.Compaction.RateLimit)
+	walMgr, err := wal.OpenWAL(filepath.Join(opts.Path, "wal"), opts.MaxWALSize)
 	if err != nil {
-		_ = walMgr.Close()
-		_ = lockFile.Close()
+		unlock()
 		return nil, err
 	}
 
-	if path, ok := latestSnapshotPath(man); ok {
-		head, entries, err := snapMgr.LoadSnapshot(path)
+	var vlogMgr *vlog.Manager
+	vlogDir := filepath.Join(opts.Path, "vlog")
+	if opts.ValueLogThreshold > 0 || dirExists(vlogDir) {
+		vlogMgr, err = vlog.Open(vlogDir, opts.ValueLogFileSize)
 		if err != nil {
 			_ = walMgr.Close()
-			_ = lockFile.Close()
+			unlock()
 			return nil, err
 		}
-		_ = head
-		now := time.Now().UnixNano()
-		for _, entry := range entries {
-			if entry.ExpiresAt >= 0 && entry.ExpiresAt <= now {
-				continue
-			}
-			idx.SetEntry(string(entry.Key), entry.Value, entry.ExpiresAt, entry.CreatedAt)
+	}
+	manLog, err := openManifest(opts.Path)
+	if err != nil {
+		if vlogMgr != nil {
+			_ = vlogMgr.Close()
 		}
+		_ = walMgr.Close()
+		unlock()
+		return nil, err
 	}
-
-	if err := replayWAL(idx, filepath.Join(opts.Path, "wal"), man.LastSnapshotSeq); err != nil {
+	closeFiles := func() {
+		if vlogMgr != nil {
+			_ = vlogMgr.Close()
+		}
+		_ = manLog.Close()
 		_ = walMgr.Close()
-		_ = lockFile.Close()
+		unlock()
+	}
+
+	walDir := filepath.Join(opts.Path, "wal")
+	if err := snapMgr.RemoveTemp(); err != nil {
+		closeFiles()
+		return nil, err
+	}
+	if err := reconcileManifest(manLog, walDir, snapMgr); err != nil {
+		closeFiles()
+		return nil, err
+	}
+	indexes, deltaCount, hasBase, err := loadSnapshotChain(snapMgr, manLog, opts.Clock.Now().UnixNano())
+	if err != nil {
+		closeFiles()
 		return nil, err
 	}
 
-	_ = refreshManifest(opts.Path)
+	dirty := make(map[uint32]map[string]struct{})
+	if err := replayWAL(indexes, walDir, manLog.State().LastSnapshotSeq, dirty, opts.Clock.Now().UnixNano()); err != nil {
+		closeFiles()
+		return nil, err
+	}
 
-	db := &DB{
-		path:     opts.Path,
-		opts:     opts,
-		index:    idx,
-		wal:      walMgr,
-		snap:     snapMgr,
-		manifest: &man,
-		lockFile: lockFile,
-		stats:    newStatsTracker(),
+	db := newDB(opts, indexes[0])
+	db.wal = walMgr
+	db.vlog = vlogMgr
+	db.snap = snapMgr
+	db.manifest = manLog
+	db.lockFile = lockFile
+	db.hasBase = hasBase
+	db.deltas = deltaCount
+	if err := db.loadFamilies(manLog.State(), indexes); err != nil {
+		closeFiles()
+		return nil, err
+	}
+	db.linkFamiliesLocked()
+	db.loadCollectionsLocked()
+	for id, keys := range dirty {
+		db.families[id].dirty = keys
+	}
+	if err := db.registerIndexes(); err != nil {
+		closeFiles()
+		return nil, err
+	}
+	if vlogMgr != nil {
+		// A sealed segment may point into the value log, so its values must
+		// be on disk before it is.
+		walMgr.SetPreRotateHook(vlogMgr.Sync)
 	}
-	walMgr.SetRotateHook(func() {
-		db.compactAsync()
-		_ = refreshManifest(opts.Path)
+	walMgr.SetRotateHook(func(seq uint64) {
+		_ = db.logWALSegment(seq)
+		if !opts.Compaction.IdleOnly {
+			db.compactAsync()
+		}
 	})
 	db.startSyncWorker()
 	db.startTTLWorker()
+	db.startExpiryNotifier()
+	db.startCompactionWorker()
+	db.startValueLogGCWorker()
+	// The limit may have been lowered since the data was written.
+	db.mu.Lock()
+	db.evictLocked()
+	db.mu.Unlock()
 	return db, nil
 }
 
+// maxWALSize bounds MaxWALSize so WAL offsets fit the 32 bits fencing
+// tokens give them.
+const maxWALSize = 1 << 32
+
 func withDefaults(opts Options) Options {
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
 	if opts.MaxKeySize == 0 {
 		opts.MaxKeySize = MaxKeySize
 	}
@@ -115,22 +174,35 @@ func withDefaults(opts Options) Options {
 	if opts.SyncMode == 0 {
 		opts.SyncMode = SyncPeriodic
 	}
-	return opts
-}
-
-func loadManifest(path string) (manifest.Manifest, error) {
-	if _, err := os.Stat(path); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return manifest.Manifest{}, nil
-		}
-		return manifest.Manifest{}, err
+	if opts.SnapshotRetention.KeepLast == 0 && opts.SnapshotRetention.KeepFor == 0 {
+		opts.SnapshotRetention.KeepLast = SnapshotKeepLast
+	}
+	if opts.MaxSnapshotDeltas == 0 {
+		opts.MaxSnapshotDeltas = MaxSnapshotDeltas
+	}
+	if opts.Compaction.IdleOnly && opts.Compaction.IdleAfter == 0 {
+		opts.Compaction.IdleAfter = defaultIdleAfter
+	}
+	if opts.ValueLogFileSize == 0 {
+		opts.ValueLogFileSize = ValueLogFileSize
+	}
+	if opts.ValueLogGCRatio == 0 {
+		opts.ValueLogGCRatio = ValueLogGCRatio
+	}
+	if opts.TTLSweepInterval == 0 {
+		opts.TTLSweepInterval = TTLSweepInterval
 	}
-	return manifest.ReadManifest(path)
+	if opts.TTLSweepBudget == 0 {
+		opts.TTLSweepBudget = TTLSweepBudget
+	}
+	return opts
 }
 
-func latestSnapshotPath(man manifest.Manifest) (string, bool) {
+// snapshotChain returns the newest full snapshot and the deltas layered on
+// top of it in sequence order.
+func snapshotChain(man manifest.Manifest) (manifest.SnapshotInfo, []manifest.SnapshotInfo, bool) {
 	if len(man.Snapshots) == 0 {
-		return "", false
+		return manifest.SnapshotInfo{}, nil, false
 	}
 	var latest manifest.SnapshotInfo
 	for i, snap := range man.Snapshots {
@@ -139,17 +211,128 @@ func latestSnapshotPath(man manifest.Manifest) (string, bool) {
 		}
 	}
 	if latest.Path == "" {
-		return "", false
+		return manifest.SnapshotInfo{}, nil, false
+	}
+	deltas := make([]manifest.SnapshotInfo, 0, len(man.Deltas))
+	for _, delta := range man.Deltas {
+		if delta.Seq > latest.Seq {
+			deltas = append(deltas, delta)
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].Seq < deltas[j].Seq })
+	return latest, deltas, true
+}
+
+// loadSnapshotChain builds the family indexes from the manifest's snapshot chain. A
+// newest chain file that is truncated or fails its checksum was left by a
+// crash during EncodeSnapshot; it is removed and the chain falls back to the
+// previous state, whose WAL segments are still on disk. The removal is
+// committed to the MANIFEST.
+func loadSnapshotChain(snapMgr *snapshot.Manager, manLog *manifest.Log, now int64) (map[uint32]*index.MemIndex, int, bool, error) {
+	for {
+		man := manLog.State()
+		indexes := familyIndexes(man)
+		base, deltas, ok := snapshotChain(man)
+		if !ok {
+			return indexes, 0, false, nil
+		}
+		chain := append([]manifest.SnapshotInfo{base}, deltas...)
+		var partial string
+		for i, info := range chain {
+			err := loadSnapshot(indexes, snapMgr, info.Path, now)
+			if err == nil {
+				continue
+			}
+			if i == len(chain)-1 && isPartialSnapshot(err) {
+				partial = info.Path
+				break
+			}
+			return nil, 0, false, err
+		}
+		if partial == "" {
+			return indexes, len(deltas), true, nil
+		}
+		if err := dropSnapshot(manLog, man, partial); err != nil {
+			return nil, 0, false, err
+		}
+	}
+}
+
+func isPartialSnapshot(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, snapshot.ErrInvalidSnapshot) ||
+		errors.Is(err, snapshot.ErrSnapshotChecksum)
+}
+
+// dropSnapshot removes path from the manifest, resetting the last snapshot
+// sequence to the newest remaining snapshot, and deletes the file.
+func dropSnapshot(manLog *manifest.Log, man manifest.Manifest, path string) error {
+	edit := manifest.VersionEdit{RemovedFiles: []string{path}, HasLastSnapshotSeq: true}
+	for _, info := range append(append([]manifest.SnapshotInfo(nil), man.Snapshots...), man.Deltas...) {
+		if info.Path != path && info.Seq > edit.LastSnapshotSeq {
+			edit.LastSnapshotSeq = info.Seq
+		}
+	}
+	if err := manLog.Apply(edit); err != nil {
+		return err
 	}
-	return latest.Path, true
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 
-func replayWAL(idx *index.MemIndex, walDir string, minSeq uint64) error {
+// loadSnapshot applies a full or delta snapshot file to the family indexes,
+// leaving out entries expired at now. Entries of families missing from
+// indexes were dropped and are skipped.
+func loadSnapshot(indexes map[uint32]*index.MemIndex, snapMgr *snapshot.Manager, path string, now int64) error {
+	_, entries, err := snapMgr.LoadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		idx, ok := indexes[entry.Family]
+		if !ok {
+			continue
+		}
+		if entry.Tombstone || (entry.ExpiresAt >= 0 && entry.ExpiresAt <= now) {
+			idx.Delete(string(entry.Key))
+			continue
+		}
+		if len(entry.Operands) > 0 {
+			idx.SetUnmerged(string(entry.Key), index.Entry{
+				Value:     entry.Value,
+				ExpiresAt: entry.ExpiresAt,
+				CreatedAt: entry.CreatedAt,
+				Pointer:   entry.Pointer,
+				Operands:  entry.Operands,
+				NoBase:    entry.NoBase,
+			})
+			continue
+		}
+		if entry.Pointer {
+			idx.SetValuePointer(string(entry.Key), entry.Value, entry.ExpiresAt, entry.CreatedAt)
+			continue
+		}
+		if entry.Meta {
+			idx.SetMeta(string(entry.Key), entry.Value, entry.ExpiresAt, entry.CreatedAt)
+			continue
+		}
+		idx.SetEntry(string(entry.Key), entry.Value, entry.ExpiresAt, entry.CreatedAt)
+	}
+	return nil
+}
+
+// replayWAL applies segments newer than minSeq to the family indexes and
+// records every replayed key in dirty, since none of them are in the
+// snapshot chain yet. Records of keys expired at now are dropped, and
+// records of dropped families are skipped.
+func replayWAL(indexes map[uint32]*index.MemIndex, walDir string, minSeq uint64, dirty map[uint32]map[string]struct{}, now int64) error {
 	segments, err := wal.ListSegments(walDir)
 	if err != nil {
 		return err
 	}
-	now := time.Now().UnixNano()
 	for _, path := range segments {
 		seq, ok := parseSegmentSeq(path)
 		if ok && seq <= minSeq {
@@ -160,21 +343,72 @@ func replayWAL(idx *index.MemIndex, walDir string, minSeq uint64) error {
 			return err
 		}
 		for _, rec := range records {
-			switch rec.Type {
-			case wal.RecordDelete:
-				idx.Delete(string(rec.Key))
-			case wal.RecordSet:
-				if rec.ExpiresAt >= 0 && rec.ExpiresAt <= now {
-					idx.Delete(string(rec.Key))
-					continue
+			idx, ok := indexes[rec.Family]
+			if !ok {
+				continue
+			}
+			if dirty[rec.Family] == nil {
+				dirty[rec.Family] = make(map[string]struct{})
+			}
+			if rec.Type == wal.RecordDeleteRange {
+				for _, key := range idx.DeleteRange(string(rec.Key), string(rec.Value)) {
+					dirty[rec.Family][key] = struct{}{}
 				}
-				idx.SetEntry(string(rec.Key), rec.Value, rec.ExpiresAt, rec.Timestamp)
+				continue
+			}
+			dirty[rec.Family][string(rec.Key)] = struct{}{}
+			if err := applyWALRecord(idx, rec, now); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
 			}
 		}
 	}
 	return nil
 }
 
+// applyWALRecord replays a single WAL record into idx. A record type it does
+// not know, written by a newer version, fails with ErrCorruptWAL rather than
+// being skipped, since skipping it would silently lose the write.
+func applyWALRecord(idx *index.MemIndex, rec wal.WALRecord, now int64) error {
+	switch rec.Type {
+	case wal.RecordDelete:
+		idx.Delete(string(rec.Key))
+	case wal.RecordSet, wal.RecordSetPointer:
+		if rec.ExpiresAt >= 0 && rec.ExpiresAt <= now {
+			idx.Delete(string(rec.Key))
+			return nil
+		}
+		if rec.Type == wal.RecordSetPointer {
+			idx.SetValuePointer(string(rec.Key), rec.Value, rec.ExpiresAt, rec.Timestamp)
+			return nil
+		}
+		idx.SetEntry(string(rec.Key), rec.Value, rec.ExpiresAt, rec.Timestamp)
+	case wal.RecordSetMeta:
+		if rec.ExpiresAt >= 0 && rec.ExpiresAt <= now {
+			idx.Delete(string(rec.Key))
+			return nil
+		}
+		idx.SetMeta(string(rec.Key), rec.Value, rec.ExpiresAt, rec.Timestamp)
+	case wal.RecordMerge:
+		if rec.ExpiresAt >= 0 && rec.ExpiresAt <= now {
+			idx.Delete(string(rec.Key))
+			return nil
+		}
+		idx.Merge(string(rec.Key), rec.Value, rec.ExpiresAt, rec.Timestamp)
+	case wal.RecordExpire:
+		idx.DeleteExpired(string(rec.Key), rec.ExpiresAt)
+	case wal.RecordDeleteRange:
+		idx.DeleteRange(string(rec.Key), string(rec.Value))
+	default:
+		return fmt.Errorf("%w: unknown record type %d", ErrCorruptWAL, rec.Type)
+	}
+	return nil
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
 func parseSegmentSeq(path string) (uint64, bool) {
 	base := filepath.Base(path)
 	if !strings.HasSuffix(base, ".log") {
@@ -40,7 +40,7 @@ func TestOpenCloseReopenPreservesData(t *testing.T) {
 				_ = db.Close()
 				return false
 			}
-			db.index.SetEntry(key, value, -1, 1)
+			db.def.index.SetEntry(key, value, -1, 1)
 
 			if err := db.Close(); err != nil {
 				return false
@@ -52,7 +52,7 @@ func TestOpenCloseReopenPreservesData(t *testing.T) {
 			}
 			defer db2.Close()
 
-			entry, ok := db2.index.Get(key)
+			entry, ok := db2.def.index.Get(key)
 			if !ok {
 				return false
 			}
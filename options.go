@@ -1,5 +1,7 @@
 package minikv
 
+import "time"
+
 // SyncMode controls when WAL data is flushed to disk.
 type SyncMode uint8
 
@@ -9,15 +11,122 @@ const (
 	SyncManual
 )
 
+// EvictionPolicy selects the keys removed once a database exceeds
+// Options.MaxMemoryBytes.
+type EvictionPolicy uint8
+
+const (
+	// NoEviction rejects writes with ErrMemoryLimit; deletes still succeed.
+	NoEviction EvictionPolicy = iota
+	// EvictLRU removes the least recently read or written keys.
+	EvictLRU
+	// EvictLFU removes the least frequently read keys. Read counts decay
+	// while a key is idle, so keys that were popular long ago age out.
+	EvictLFU
+	// EvictVolatileTTL removes the keys with a TTL that expire soonest and
+	// never removes keys without one.
+	EvictVolatileTTL
+)
+
 // Options configures database behavior.
 type Options struct {
-	Path         string
-	ReadOnly     bool
-	SyncMode     SyncMode
-	MaxKeySize   int
-	MaxValueSize int
-	MaxBatchSize int
-	MaxWALSize   int64
+	Path string
+	// ReadOnly opens a view that can run alongside the process writing the
+	// database. Call Refresh to catch up with the writer, or set
+	// FollowInterval to do so in the background.
+	ReadOnly       bool
+	FollowInterval time.Duration
+	SyncMode       SyncMode
+	MaxKeySize     int
+	MaxValueSize   int
+	MaxBatchSize   int
+	MaxWALSize     int64
+
+	// MaxSnapshotDeltas bounds the delta chain; once reached, Compact writes a full snapshot.
+	MaxSnapshotDeltas int
+	Compaction        CompactionPolicy
+	SnapshotRetention SnapshotRetention
+
+	// ValueLogThreshold stores values larger than this many bytes in the value
+	// log, so the WAL and snapshots only carry a pointer. Zero disables it.
+	ValueLogThreshold int
+	ValueLogFileSize  int64
+	// ValueLogGCRatio is the live-bytes ratio below which a value-log file is rewritten.
+	ValueLogGCRatio float64
+
+	// Indexes registers secondary indexes on the default family when the
+	// database opens. They are built from the loaded data on every Open.
+	Indexes map[string]IndexFunc
+
+	// TTLSweepInterval is how often the background sweep removes expired
+	// keys, and TTLSweepBudget caps how many it removes per tick so a burst
+	// of expiries is spread over several ticks; a negative budget removes
+	// every expired key. Expired keys are never returned by reads, whether or
+	// not they have been swept.
+	TTLSweepInterval time.Duration
+	TTLSweepBudget   int
+
+	// DefaultTTL expires keys written to the default family without an
+	// explicit TTL, as FamilyOptions.DefaultTTL does for other families. Zero
+	// keeps them until deleted.
+	DefaultTTL time.Duration
+
+	// OnExpire is called with the key and value of every key removed because
+	// it expired, whether by the TTL sweep or lazily by a read, in any family.
+	// Keys are reported when they are reaped, not the instant they expire.
+	// Calls are made one at a time, in reap order, from a single goroutine
+	// owned by the DB, and never with the DB locked, so OnExpire may call back
+	// into the DB; a slow callback delays later calls but not the sweep or
+	// reads. value is nil for collections and for values that can no longer
+	// be read. Calls for keys reaped before Close finish before Close returns.
+	OnExpire func(key, value []byte)
+
+	// MaxMemoryBytes bounds the estimated size of the keys and inline values
+	// held in memory, across all families. Once a write takes the database
+	// past it, EvictionPolicy picks keys to remove until it fits again;
+	// collections are removed whole. Evictions are written to the WAL as
+	// deletes, so evicted keys stay gone after a restart. If nothing can be
+	// evicted, writes fail with ErrMemoryLimit until keys are deleted or
+	// expire. Zero means no limit. LRU and LFU pick among a sample of keys,
+	// so they approximate their policy rather than follow it exactly.
+	MaxMemoryBytes int64
+	EvictionPolicy EvictionPolicy
+
+	// Clock supplies the time for expiry decisions, WAL timestamps and the
+	// background workers' tickers. It defaults to the system clock; tests
+	// can pass a FakeClock to expire keys without sleeping.
+	Clock Clock
+
+	// MergeOperator folds operands written with Merge. Reopening a database
+	// holding unfolded operands without it fails reads of those keys.
+	MergeOperator MergeOperator
+}
+
+// CompactionPolicy configures background compaction triggers. WAL rotation
+// always requests a compaction unless IdleOnly is set; the fields below add
+// triggers checked by a background worker. Zero values disable a trigger.
+type CompactionPolicy struct {
+	// WALBytes compacts once this many bytes were appended to the WAL since the last snapshot.
+	WALBytes int64
+	// GarbageRatio compacts once overwritten, deleted or expired bytes reach this fraction of live bytes.
+	GarbageRatio float64
+	// Interval compacts at most this long after the previous compaction when there are changes.
+	Interval time.Duration
+	// IdleOnly runs compaction only after no writes for IdleAfter. With no
+	// other trigger set, becoming idle with pending changes is the trigger.
+	IdleOnly  bool
+	IdleAfter time.Duration
+	// RateLimit caps snapshot write throughput in bytes per second.
+	RateLimit int64
+}
+
+// SnapshotRetention controls which superseded snapshot chains are kept after
+// compaction. A chain is a full snapshot plus its deltas; the current chain
+// and chains pinned with PinSnapshot are never removed. A chain is kept if it
+// is among the KeepLast newest chains or was written within KeepFor.
+type SnapshotRetention struct {
+	KeepLast int
+	KeepFor  time.Duration
 }
 
 // DefaultOptions returns a baseline configuration for a database at path.
@@ -29,5 +138,14 @@ func DefaultOptions(path string) Options {
 		MaxValueSize: MaxValueSize,
 		MaxBatchSize: MaxBatchSize,
 		MaxWALSize:   MaxWALSize,
+
+		MaxSnapshotDeltas: MaxSnapshotDeltas,
+		SnapshotRetention: SnapshotRetention{KeepLast: SnapshotKeepLast},
+
+		ValueLogFileSize: ValueLogFileSize,
+		ValueLogGCRatio:  ValueLogGCRatio,
+
+		TTLSweepInterval: TTLSweepInterval,
+		TTLSweepBudget:   TTLSweepBudget,
 	}
 }
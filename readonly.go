@@ -0,0 +1,232 @@
+package minikv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/manifest"
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+	"github.com/bretuobay/mini-kv/internal/vlog"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+// refreshAttempts bounds how often Refresh restarts when the writer removes
+// a file between reading the MANIFEST and opening the file.
+const refreshAttempts = 5
+
+// walPosition is how far a read-only database has applied the WAL.
+type walPosition struct {
+	loaded bool
+	seq    uint64
+	offset int64
+}
+
+// openReadOnly opens a view of the database that can run alongside the
+// writer. It never modifies the directory: the snapshot chain named by the
+// MANIFEST is loaded and WAL segments are tailed from where it ends.
+func openReadOnly(opts Options, lockFile *os.File) (*DB, error) {
+	version, err := readFormat(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case version != 0 && version < FormatVersion:
+		return nil, fmt.Errorf("%w (format %d, current %d)", ErrUpgradeRequired, version, FormatVersion)
+	case version > FormatVersion:
+		return nil, fmt.Errorf("%w: format %d is newer than %d", ErrUnsupportedFormat, version, FormatVersion)
+	}
+
+	db := newDB(opts, index.NewMemIndex())
+	db.vlog = vlog.OpenReader(filepath.Join(opts.Path, "vlog"))
+	db.snap = snapshot.NewManager(filepath.Join(opts.Path, "snapshots"))
+	db.lockFile = lockFile
+	if err := db.registerIndexes(); err != nil {
+		_ = db.vlog.Close()
+		return nil, err
+	}
+	if err := db.refresh(); err != nil {
+		_ = db.vlog.Close()
+		return nil, err
+	}
+	db.startTTLWorker()
+	db.startExpiryNotifier()
+	db.startFollowWorker()
+	return db, nil
+}
+
+// Refresh catches a read-only database up with the writer. It applies WAL
+// records appended since the last refresh, and reloads the snapshot chain
+// when the writer has compacted away segments that were not yet applied.
+// On a writable database Refresh does nothing.
+func (db *DB) Refresh() error {
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if !db.opts.ReadOnly {
+		return nil
+	}
+	return db.refresh()
+}
+
+func (db *DB) refresh() error {
+	db.refreshMu.Lock()
+	defer db.refreshMu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < refreshAttempts; attempt++ {
+		err = db.refreshOnce()
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return err
+}
+
+func (db *DB) refreshOnce() error {
+	man, err := manifest.ReadManifest(filepath.Join(db.path, "MANIFEST"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	pos := db.follow
+	var indexes map[uint32]*index.MemIndex
+	if !pos.loaded || man.LastSnapshotSeq >= pos.seq {
+		indexes, err = loadChain(db.snap, man, db.now())
+		if err != nil {
+			return err
+		}
+		pos = walPosition{seq: man.LastSnapshotSeq + 1}
+	}
+
+	segments, err := wal.ListSegments(filepath.Join(db.path, "wal"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var records []wal.WALRecord
+	found := false
+	for _, path := range segments {
+		seq, ok := parseSegmentSeq(path)
+		if !ok || seq < pos.seq {
+			continue
+		}
+		if seq > pos.seq && !found && (pos.offset > 0 || indexes != nil) {
+			// The segment being tailed vanished: the writer compacted it.
+			return os.ErrNotExist
+		}
+		found = true
+		offset := int64(0)
+		if seq == pos.seq {
+			offset = pos.offset
+		}
+		recs, next, err := wal.ReadWALFrom(path, offset)
+		if err != nil {
+			return err
+		}
+		records = append(records, recs...)
+		pos = walPosition{seq: seq, offset: next}
+	}
+	pos.loaded = true
+
+	now := db.now()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.followFamiliesLocked(man, indexes); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		f := db.families[rec.Family]
+		if f == nil && rec.Family >= man.NextFamilyID {
+			// The family was created after the MANIFEST was read.
+			if man, err = manifest.ReadManifest(filepath.Join(db.path, "MANIFEST")); err != nil {
+				return err
+			}
+			if err := db.followFamiliesLocked(man, nil); err != nil {
+				return err
+			}
+			f = db.families[rec.Family]
+		}
+		if f != nil {
+			if err := applyWALRecord(f.index, rec, now); err != nil {
+				return err
+			}
+		}
+	}
+	db.follow = pos
+	return nil
+}
+
+// followFamiliesLocked brings the family set in line with man: new families
+// are added and dropped ones are marked so their handles fail. When the
+// snapshot chain was reloaded, indexes replaces every family's index.
+func (db *DB) followFamiliesLocked(man manifest.Manifest, indexes map[uint32]*index.MemIndex) error {
+	live := map[uint32]bool{0: true}
+	for _, info := range man.Families {
+		live[info.ID] = true
+		if db.families[info.ID] != nil {
+			continue
+		}
+		opts, err := decodeFamilyOptions(info.Options)
+		if err != nil {
+			return err
+		}
+		db.addFamily(info.ID, info.Name, opts, index.NewMemIndex())
+	}
+	for id, f := range db.families {
+		if !live[id] {
+			db.dropFamilyLocked(f)
+			continue
+		}
+		if idx := indexes[id]; idx != nil {
+			f.replaceIndexLocked(idx)
+		}
+	}
+	db.linkFamiliesLocked()
+	return nil
+}
+
+// loadChain builds the family indexes from the snapshot chain named by man,
+// leaving out entries expired at now.
+func loadChain(snapMgr *snapshot.Manager, man manifest.Manifest, now int64) (map[uint32]*index.MemIndex, error) {
+	indexes := familyIndexes(man)
+	base, deltas, ok := snapshotChain(man)
+	if !ok {
+		return indexes, nil
+	}
+	for _, info := range append([]manifest.SnapshotInfo{base}, deltas...) {
+		if err := loadSnapshot(indexes, snapMgr, info.Path, now); err != nil {
+			return nil, err
+		}
+	}
+	return indexes, nil
+}
+
+func (db *DB) startFollowWorker() {
+	if db.opts.FollowInterval <= 0 {
+		return
+	}
+	if db.stopCh == nil {
+		db.stopCh = make(chan struct{})
+	}
+	if db.followTicker == nil {
+		db.followTicker = db.opts.Clock.NewTicker(db.opts.FollowInterval)
+	}
+
+	db.wg.Add(1)
+	go func() {
+		defer db.wg.Done()
+		for {
+			select {
+			case <-db.followTicker.C():
+				_ = db.refresh()
+			case <-db.stopCh:
+				return
+			}
+		}
+	}()
+}
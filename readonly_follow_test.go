@@ -0,0 +1,144 @@
+package minikv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// readOnly opens the database as a read-only follower.
+func readOnly(opts *Options) {
+	opts.ReadOnly = true
+}
+
+func expectValue(t *testing.T, db *DB, key, want string) {
+	t.Helper()
+	value, err := db.Get([]byte(key))
+	if err != nil || string(value) != want {
+		t.Fatalf("get %s: %q %v, want %q", key, value, err, want)
+	}
+}
+
+func TestReadOnlyOpensAlongsideWriter(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir)
+	defer writer.Close()
+	_ = writer.Set([]byte("a"), []byte("1"))
+
+	reader := openTestDB(t, dir, readOnly)
+	defer reader.Close()
+	expectValue(t, reader, "a", "1")
+
+	if err := reader.Set([]byte("b"), []byte("2")); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if err := reader.Compact(); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly from Compact, got %v", err)
+	}
+	if _, err := Open(DefaultOptions(dir)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected second writer to be locked out, got %v", err)
+	}
+	if err := Upgrade(dir); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected Upgrade to be locked out, got %v", err)
+	}
+}
+
+func TestReadOnlyRefreshTailsWAL(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir)
+	defer writer.Close()
+	_ = writer.Set([]byte("a"), []byte("1"))
+
+	reader := openTestDB(t, dir, readOnly)
+	defer reader.Close()
+
+	_ = writer.Set([]byte("a"), []byte("2"))
+	_ = writer.Set([]byte("b"), []byte("1"))
+	if _, err := reader.Get([]byte("b")); err != ErrNotFound {
+		t.Fatalf("expected stale view before Refresh, got %v", err)
+	}
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	expectValue(t, reader, "a", "2")
+	expectValue(t, reader, "b", "1")
+
+	_ = writer.Delete([]byte("a"))
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if _, err := reader.Get([]byte("a")); err != ErrNotFound {
+		t.Fatalf("expected delete to be followed, got %v", err)
+	}
+}
+
+func TestReadOnlyRefreshAcrossCompaction(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir)
+	defer writer.Close()
+	for i := 0; i < 10; i++ {
+		_ = writer.Set([]byte("k"+intToString(i)), []byte("v0"))
+	}
+
+	reader := openTestDB(t, dir, readOnly)
+	defer reader.Close()
+
+	// Compact twice so the segments the reader was tailing are deleted.
+	for round := 1; round <= 2; round++ {
+		_ = writer.Set([]byte("k0"), []byte("v"+intToString(round)))
+		_ = writer.Delete([]byte("k" + intToString(round)))
+		if err := writer.Compact(); err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+	}
+	_ = writer.Set([]byte("k9"), []byte("after"))
+
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	expectValue(t, reader, "k0", "v2")
+	expectValue(t, reader, "k9", "after")
+	expectValue(t, reader, "k3", "v0")
+	for _, key := range []string{"k1", "k2"} {
+		if _, err := reader.Get([]byte(key)); err != ErrNotFound {
+			t.Fatalf("expected %s deleted, got %v", key, err)
+		}
+	}
+}
+
+func TestReadOnlyFollowInterval(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir)
+	defer writer.Close()
+
+	reader := openTestDB(t, dir, readOnly, func(opts *Options) {
+		opts.FollowInterval = 10 * time.Millisecond
+	})
+	defer reader.Close()
+
+	_ = writer.Set([]byte("a"), []byte("1"))
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if value, err := reader.Get([]byte("a")); err == nil && string(value) == "1" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected follower to catch up with writer")
+}
+
+func TestReadOnlyFollowsValueLog(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir, func(opts *Options) { opts.ValueLogThreshold = 8 })
+	defer writer.Close()
+
+	reader := openTestDB(t, dir, readOnly)
+	defer reader.Close()
+
+	large := "a value stored out of line"
+	_ = writer.Set([]byte("big"), []byte(large))
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	expectValue(t, reader, "big", large)
+}
@@ -0,0 +1,293 @@
+package minikv
+
+import (
+	"sort"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+)
+
+// indexBuildBatch is how many keys buildSecondary reads values for at a time.
+const indexBuildBatch = 256
+
+// IndexFunc returns the terms a key is indexed under, derived from its key
+// and value. It runs for the keys written since the last IndexScan when the
+// next one starts, so it must be fast, must not call back into the DB and
+// must not retain value.
+type IndexFunc func(key, value []byte) [][]byte
+
+// secondaryIndex maps terms to keys for one IndexFunc. It is guarded by the
+// owning family's secMu.
+type secondaryIndex struct {
+	extract IndexFunc
+	terms   map[string]map[string]struct{}
+	keys    map[string][]string
+}
+
+func newSecondaryIndex(fn IndexFunc) *secondaryIndex {
+	return &secondaryIndex{
+		extract: fn,
+		terms:   make(map[string]map[string]struct{}),
+		keys:    make(map[string][]string),
+	}
+}
+
+func (s *secondaryIndex) add(key string, value []byte) {
+	seen := make(map[string]struct{})
+	for _, term := range s.extract([]byte(key), value) {
+		t := string(term)
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		keys := s.terms[t]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			s.terms[t] = keys
+		}
+		keys[key] = struct{}{}
+		s.keys[key] = append(s.keys[key], t)
+	}
+}
+
+func (s *secondaryIndex) remove(key string) {
+	for _, t := range s.keys[key] {
+		keys := s.terms[t]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(s.terms, t)
+		}
+	}
+	delete(s.keys, key)
+}
+
+// registerIndexes builds the indexes named in Options.Indexes on the default family.
+func (db *DB) registerIndexes() error {
+	for name, fn := range db.opts.Indexes {
+		if err := db.def.CreateIndex(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateIndex registers a secondary index on the default family.
+func (db *DB) CreateIndex(name string, fn IndexFunc) error {
+	return db.def.CreateIndex(name, fn)
+}
+
+// CreateIndex registers a secondary index on the family and builds it from
+// the current keys. Indexes live in memory: from then on every Set, Delete,
+// Batch.Write and expiry queues the key, and IndexScan indexes the queued
+// keys before it looks up a term, so writes never read values for indexing.
+// Indexes are rebuilt from the data when registered again after Open (see
+// Options.Indexes).
+func (f *Family) CreateIndex(name string, fn IndexFunc) error {
+	if name == "" || fn == nil {
+		return ErrInvalidIndex
+	}
+	db := f.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := f.unavailableLocked(); err != nil {
+		return err
+	}
+
+	f.secMu.Lock()
+	_, exists := f.secondary[name]
+	f.secMu.Unlock()
+	if exists {
+		return ErrIndexExists
+	}
+	// Installing before building lets the observer see expiries that race
+	// with the build; writes cannot, since db.mu is held.
+	sec := newSecondaryIndex(fn)
+	f.secMu.Lock()
+	if f.secondary == nil {
+		f.secondary = make(map[string]*secondaryIndex)
+	}
+	f.secondary[name] = sec
+	f.secMu.Unlock()
+	f.buildSecondary(sec)
+	return nil
+}
+
+// DropIndex removes a secondary index from the default family.
+func (db *DB) DropIndex(name string) error {
+	return db.def.DropIndex(name)
+}
+
+// DropIndex removes a secondary index from the family.
+func (f *Family) DropIndex(name string) error {
+	db := f.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := f.unavailableLocked(); err != nil {
+		return err
+	}
+	f.secMu.Lock()
+	defer f.secMu.Unlock()
+	if _, ok := f.secondary[name]; !ok {
+		return ErrIndexNotFound
+	}
+	delete(f.secondary, name)
+	if len(f.secondary) == 0 {
+		f.secPending = nil
+	}
+	return nil
+}
+
+// IndexScan returns the keys of the default family indexed under term.
+func (db *DB) IndexScan(name string, term []byte) ([][]byte, error) {
+	return db.def.IndexScan(name, term)
+}
+
+// IndexScan returns the live keys indexed under term, sorted.
+func (f *Family) IndexScan(name string, term []byte) ([][]byte, error) {
+	db := f.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := f.unavailableLocked(); err != nil {
+		return nil, err
+	}
+
+	f.syncSecondary()
+	f.secMu.Lock()
+	sec, ok := f.secondary[name]
+	if !ok {
+		f.secMu.Unlock()
+		return nil, ErrIndexNotFound
+	}
+	keys := make([]string, 0, len(sec.terms[string(term)]))
+	for key := range sec.terms[string(term)] {
+		keys = append(keys, key)
+	}
+	f.secMu.Unlock()
+
+	sort.Strings(keys)
+	results := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		// Get drops keys that expired since the last sweep, which in turn
+		// removes them from the index; secMu must not be held here.
+		if _, ok := f.index.Get(key); ok {
+			results = append(results, []byte(key))
+		}
+	}
+	return results, nil
+}
+
+// observe queues a changed key for the family's secondary indexes and for
+// orphan collection. It is the index observer, so it runs with the index lock
+// held and leaves reading the value, which may mean a value-log read or a
+// merge fold, to syncSecondary. Families without indexes queue nothing.
+func (f *Family) observe(key string, entry *index.Entry) {
+	f.trackCollection(key, entry)
+	f.secMu.Lock()
+	defer f.secMu.Unlock()
+	if len(f.secondary) == 0 {
+		return
+	}
+	if f.secPending == nil {
+		f.secPending = make(map[string]struct{})
+	}
+	f.secPending[key] = struct{}{}
+}
+
+// syncSecondary brings the secondary indexes up to date with the keys queued
+// by observe. The caller holds db.mu, so the values cannot change under it;
+// neither the index lock nor secMu is held while they are read. Concurrent
+// calls wait on secSync for the one indexing to finish.
+func (f *Family) syncSecondary() {
+	f.secSync.Lock()
+	defer f.secSync.Unlock()
+	f.secMu.Lock()
+	pending := f.secPending
+	f.secPending = nil
+	f.secMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	values := make(map[string][]byte, len(keys))
+	for _, entry := range f.index.Lookup(keys) {
+		value, err := f.db.entryValue(string(entry.Key), &entry.Entry)
+		if err != nil {
+			// An unreadable value cannot be indexed; it stays out of every index.
+			continue
+		}
+		values[string(entry.Key)] = value
+	}
+
+	f.secMu.Lock()
+	defer f.secMu.Unlock()
+	for _, key := range keys {
+		value, live := values[key]
+		for _, sec := range f.secondary {
+			sec.remove(key)
+			if live {
+				sec.add(key, value)
+			}
+		}
+	}
+}
+
+// buildSecondary indexes every key currently in the family. The keys are
+// listed first and their values read a batch at a time outside the index
+// lock, since reading one may mean a value-log read or a merge fold.
+func (f *Family) buildSecondary(sec *secondaryIndex) {
+	var keys []string
+	f.index.ForEach(func(key string, _ *index.Entry) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > indexBuildBatch {
+			batch = batch[:indexBuildBatch]
+		}
+		keys = keys[len(batch):]
+		entries := f.index.Lookup(batch)
+		values := make(map[string][]byte, len(entries))
+		for _, entry := range entries {
+			value, err := f.db.entryValue(string(entry.Key), &entry.Entry)
+			if err != nil {
+				// An unreadable value cannot be indexed; it stays out of the index.
+				continue
+			}
+			values[string(entry.Key)] = value
+		}
+		f.secMu.Lock()
+		for key, value := range values {
+			sec.remove(key)
+			sec.add(key, value)
+		}
+		f.secMu.Unlock()
+	}
+}
+
+// replaceIndexLocked swaps in a rebuilt primary index, as followers do after
+// reloading the snapshot chain, and rebuilds the secondary indexes from it.
+func (f *Family) replaceIndexLocked(idx *index.MemIndex) {
+	f.index.SetObserver(nil)
+	f.index.SetExpiryHook(nil)
+	f.index = idx
+	idx.SetObserver(f.observe)
+	idx.SetExpiryHook(f.reapedExpired)
+	idx.SetAccessTracking(f.db.tracksAccess())
+	idx.SetClock(f.db.now)
+
+	f.secMu.Lock()
+	rebuilt := make(map[string]*secondaryIndex, len(f.secondary))
+	for name, sec := range f.secondary {
+		rebuilt[name] = newSecondaryIndex(sec.extract)
+	}
+	f.secondary = rebuilt
+	f.secPending = nil
+	f.secMu.Unlock()
+	for _, sec := range rebuilt {
+		f.buildSecondary(sec)
+	}
+}
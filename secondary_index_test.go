@@ -0,0 +1,241 @@
+package minikv
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// byTag indexes values of the form "tag1,tag2:body" under each tag.
+func byTag(_ []byte, value []byte) [][]byte {
+	tags, _, ok := bytes.Cut(value, []byte(":"))
+	if !ok {
+		return nil
+	}
+	return bytes.Split(tags, []byte(","))
+}
+
+// tagIndex indexes values by their tag.
+func tagIndex(opts *Options) {
+	opts.Indexes = map[string]IndexFunc{"tag": byTag}
+}
+
+func expectIndexScan(t *testing.T, f *Family, term string, want ...string) {
+	t.Helper()
+	keys, err := f.IndexScan("tag", []byte(term))
+	if err != nil {
+		t.Fatalf("index scan %s: %v", term, err)
+	}
+	got := make([]string, len(keys))
+	for i, key := range keys {
+		got[i] = string(key)
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("index scan %s: got %v, want %v", term, got, want)
+	}
+}
+
+func TestIndexFollowsWrites(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), tagIndex)
+	defer db.Close()
+
+	_ = db.Set([]byte("a"), []byte("red,blue:1"))
+	_ = db.Set([]byte("b"), []byte("red:2"))
+	_ = db.Set([]byte("c"), []byte("plain"))
+	expectIndexScan(t, db.def, "red", "a", "b")
+	expectIndexScan(t, db.def, "blue", "a")
+
+	_ = db.Set([]byte("a"), []byte("green:1"))
+	expectIndexScan(t, db.def, "red", "b")
+	expectIndexScan(t, db.def, "blue")
+	expectIndexScan(t, db.def, "green", "a")
+
+	_ = db.Delete([]byte("b"))
+	expectIndexScan(t, db.def, "red")
+
+	batch := db.NewBatch()
+	batch.Set([]byte("d"), []byte("red:4"))
+	batch.Delete([]byte("a"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	expectIndexScan(t, db.def, "red", "d")
+	expectIndexScan(t, db.def, "green")
+
+	if _, err := db.IndexScan("missing", []byte("red")); err != ErrIndexNotFound {
+		t.Fatalf("expected ErrIndexNotFound, got %v", err)
+	}
+	if err := db.CreateIndex("tag", byTag); err != ErrIndexExists {
+		t.Fatalf("expected ErrIndexExists, got %v", err)
+	}
+	if err := db.CreateIndex("", byTag); err != ErrInvalidIndex {
+		t.Fatalf("expected ErrInvalidIndex, got %v", err)
+	}
+	if err := db.DropIndex("tag"); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if _, err := db.IndexScan("tag", []byte("red")); err != ErrIndexNotFound {
+		t.Fatalf("expected dropped index to be gone, got %v", err)
+	}
+}
+
+func TestIndexReadsValuesOnScanOnly(t *testing.T) {
+	var calls int
+	counting := func(key, value []byte) [][]byte {
+		calls++
+		return byTag(key, value)
+	}
+	db := openTestDB(t, t.TempDir(), tagIndex, func(opts *Options) { opts.ValueLogThreshold = 16 })
+	defer db.Close()
+	if err := db.CreateIndex("counted", counting); err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	large := "red:" + strings.Repeat("x", 64)
+	for i := 0; i < 3; i++ {
+		_ = db.Set([]byte("a"), []byte(large))
+	}
+	if calls != 0 {
+		t.Fatalf("expected writes only to queue the key, got %d extract calls", calls)
+	}
+	expectIndexScan(t, db.def, "red", "a")
+	if _, err := db.IndexScan("counted", []byte("red")); err != nil {
+		t.Fatalf("index scan: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected the key indexed once from the value log, got %d extract calls", calls)
+	}
+
+	users, err := db.CreateFamily("users", FamilyOptions{})
+	if err != nil {
+		t.Fatalf("create family: %v", err)
+	}
+	_ = users.Set([]byte("u"), []byte("red:1"))
+	if users.secPending != nil {
+		t.Fatalf("expected a family without indexes to queue nothing")
+	}
+}
+
+func TestIndexFollowsExpiry(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), tagIndex)
+	defer db.Close()
+
+	_ = db.SetWithTTL([]byte("short"), []byte("red:1"), 20*time.Millisecond)
+	_ = db.Set([]byte("long"), []byte("red:2"))
+	expectIndexScan(t, db.def, "red", "long", "short")
+	time.Sleep(40 * time.Millisecond)
+	expectIndexScan(t, db.def, "red", "long")
+
+	_ = db.SetWithTTL([]byte("swept"), []byte("blue:3"), 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	db.cleanupExpired()
+	db.def.secMu.Lock()
+	_, stale := db.def.secondary["tag"].keys["swept"]
+	db.def.secMu.Unlock()
+	if stale {
+		t.Fatalf("expected TTL sweep to remove expired key from the index")
+	}
+}
+
+func TestIndexRebuiltOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, tagIndex, func(opts *Options) { opts.ValueLogThreshold = 8 })
+	_ = db.Set([]byte("snap"), []byte("red:a value stored in the value log"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Set([]byte("wal"), []byte("red:1"))
+	_ = db.Set([]byte("moved"), []byte("blue:2"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = db.Set([]byte("moved"), []byte("red:2"))
+	expectIndexScan(t, db.def, "red", "moved", "snap", "wal")
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, tagIndex, func(opts *Options) { opts.ValueLogThreshold = 8 })
+	defer db.Close()
+	expectIndexScan(t, db.def, "red", "moved", "snap", "wal")
+	expectIndexScan(t, db.def, "blue")
+}
+
+func TestIndexPerFamily(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), tagIndex)
+	defer db.Close()
+	docs := createFamily(t, db, "docs", FamilyOptions{})
+	_ = docs.Set([]byte("x"), []byte("red:1"))
+
+	if err := docs.CreateIndex("tag", byTag); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	_ = db.Set([]byte("y"), []byte("red:2"))
+	expectIndexScan(t, docs, "red", "x")
+	expectIndexScan(t, db.def, "red", "y")
+}
+
+func TestIndexOnFollower(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir)
+	defer writer.Close()
+	_ = writer.Set([]byte("a"), []byte("red:1"))
+	if err := writer.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	reader := openTestDB(t, dir, readOnly, tagIndex)
+	defer reader.Close()
+	expectIndexScan(t, reader.def, "red", "a")
+
+	_ = writer.Set([]byte("b"), []byte("red:2"))
+	if err := writer.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = writer.Delete([]byte("a"))
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	expectIndexScan(t, reader.def, "red", "b")
+}
+
+// stallingOperator concatenates operands, first signalling entered and
+// stalling when entered is set, to hold a reader mid-fold.
+type stallingOperator struct {
+	entered chan struct{}
+}
+
+func (o *stallingOperator) Merge(_, existing []byte, operands [][]byte) ([]byte, error) {
+	if o.entered != nil {
+		o.entered <- struct{}{}
+		o.entered = nil
+		time.Sleep(50 * time.Millisecond)
+	}
+	return append(existing, bytes.Join(operands, nil)...), nil
+}
+
+func TestConcurrentIndexScansSeePriorWrites(t *testing.T) {
+	op := &stallingOperator{}
+	db := openTestDB(t, t.TempDir(), tagIndex, func(opts *Options) { opts.MergeOperator = op })
+	defer db.Close()
+
+	if err := db.Merge([]byte("a"), []byte("red:1")); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	entered := make(chan struct{}, 1)
+	op.entered = entered
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := db.IndexScan("tag", []byte("red")); err != nil {
+			t.Errorf("index scan: %v", err)
+		}
+	}()
+	// The first scan is now folding "a" to index it; a second scan must
+	// wait for it rather than miss the key.
+	<-entered
+	expectIndexScan(t, db.def, "red", "a")
+	wg.Wait()
+}
@@ -2,5 +2,12 @@ package minikv
 
 // Set stores a key-value pair.
 func (db *DB) Set(key []byte, value []byte) error {
-	return db.setWithExpiresAt(key, value, -1)
+	return db.def.Set(key, value)
+}
+
+// Set stores a key-value pair in the family. The key expires after the
+// family's DefaultTTL when one is configured, whatever TTL it had before;
+// SetKeepTTL keeps it.
+func (f *Family) Set(key []byte, value []byte) error {
+	return f.setWithExpiresAt(key, value, f.defaultExpiresAt())
 }
@@ -7,7 +7,7 @@ import (
 )
 
 func TestSetValidatesKeySize(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp"), index: index.NewMemIndex()}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
 	key := make([]byte, db.opts.MaxKeySize+1)
 	if err := db.Set(key, []byte("v")); err != ErrKeyTooLarge {
 		t.Fatalf("expected ErrKeyTooLarge, got %v", err)
@@ -15,7 +15,7 @@ func TestSetValidatesKeySize(t *testing.T) {
 }
 
 func TestSetValidatesValueSize(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp"), index: index.NewMemIndex()}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
 	value := make([]byte, db.opts.MaxValueSize+1)
 	if err := db.Set([]byte("k"), value); err != ErrValueTooLarge {
 		t.Fatalf("expected ErrValueTooLarge, got %v", err)
@@ -23,14 +23,15 @@ func TestSetValidatesValueSize(t *testing.T) {
 }
 
 func TestSetReadOnly(t *testing.T) {
-	db := &DB{opts: Options{ReadOnly: true, MaxKeySize: MaxKeySize, MaxValueSize: MaxValueSize}, index: index.NewMemIndex()}
+	db := newDB(Options{ReadOnly: true, MaxKeySize: MaxKeySize, MaxValueSize: MaxValueSize}, index.NewMemIndex())
 	if err := db.Set([]byte("k"), []byte("v")); err != ErrReadOnly {
 		t.Fatalf("expected ErrReadOnly, got %v", err)
 	}
 }
 
 func TestSetClosed(t *testing.T) {
-	db := &DB{opts: DefaultOptions("/tmp"), index: index.NewMemIndex(), closed: true}
+	db := newDB(DefaultOptions("/tmp"), index.NewMemIndex())
+	db.closed = true
 	if err := db.Set([]byte("k"), []byte("v")); err != ErrClosed {
 		t.Fatalf("expected ErrClosed, got %v", err)
 	}
@@ -0,0 +1,261 @@
+package minikv
+
+import (
+	"bytes"
+	"sort"
+)
+
+// A set stores each member as a sub-key with an empty value.
+
+// SAdd adds members to the set at key and returns how many were not already in it.
+func (db *DB) SAdd(key []byte, members ...[]byte) (int, error) {
+	return db.def.SAdd(key, members...)
+}
+
+// SAdd adds members to the set at key in the family. A new set gets the
+// family's DefaultTTL; Expire and Persist on key apply to the whole set.
+func (f *Family) SAdd(key []byte, members ...[]byte) (int, error) {
+	var added int
+	err := f.writeCollection(key, longestMember(members), nil, func(t *txn) error {
+		for _, member := range members {
+			ok, err := t.sadd(f, key, member)
+			if err != nil {
+				return err
+			}
+			if ok {
+				added++
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// SRem removes members from the set at key and returns how many were in it.
+// Removing the last member removes the set.
+func (db *DB) SRem(key []byte, members ...[]byte) (int, error) {
+	return db.def.SRem(key, members...)
+}
+
+// SRem removes members from the set at key in the family.
+func (f *Family) SRem(key []byte, members ...[]byte) (int, error) {
+	var removed int
+	err := f.writeCollection(key, longestMember(members), nil, func(t *txn) error {
+		for _, member := range members {
+			ok, err := t.srem(f, key, member)
+			if err != nil {
+				return err
+			}
+			if ok {
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// SIsMember reports whether member is in the set at key.
+func (db *DB) SIsMember(key, member []byte) (bool, error) {
+	return db.def.SIsMember(key, member)
+}
+
+// SIsMember reports whether member is in the set at key in the family.
+func (f *Family) SIsMember(key, member []byte) (bool, error) {
+	var ok bool
+	err := f.readCollection(key, kindSet, func(meta *collectionMeta, sub *Family) error {
+		ok = sub.index.Exists(string(append(memberPrefix(key, meta.version), member...)))
+		return nil
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return ok, err
+}
+
+// SMembers returns the members of the set at key in lexicographic order.
+func (db *DB) SMembers(key []byte) ([][]byte, error) {
+	return db.def.SMembers(key)
+}
+
+// SMembers returns the members of the set at key in the family in
+// lexicographic order. A missing set yields no members.
+func (f *Family) SMembers(key []byte) ([][]byte, error) {
+	return f.SScan(key, nil, 0)
+}
+
+// SScan returns up to limit members of the set at key matching prefix, in
+// lexicographic order.
+func (db *DB) SScan(key, prefix []byte, limit int) ([][]byte, error) {
+	return db.def.SScan(key, prefix, limit)
+}
+
+// SScan returns up to limit members of the set at key in the family matching
+// prefix, in lexicographic order.
+func (f *Family) SScan(key, prefix []byte, limit int) ([][]byte, error) {
+	var members [][]byte
+	err := f.readCollection(key, kindSet, func(meta *collectionMeta, sub *Family) error {
+		members = sub.smembersLocked(memberPrefix(key, meta.version), prefix, limit)
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return members, err
+}
+
+// SCard returns the number of members in the set at key.
+func (db *DB) SCard(key []byte) (int, error) {
+	return db.def.SCard(key)
+}
+
+// SCard returns the number of members in the set at key in the family; 0 if
+// it does not exist.
+func (f *Family) SCard(key []byte) (int, error) {
+	var count int
+	err := f.readCollection(key, kindSet, func(meta *collectionMeta, _ *Family) error {
+		count = int(meta.count)
+		return nil
+	})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return count, err
+}
+
+// SInter returns the members present in every set at keys, in lexicographic order.
+func (db *DB) SInter(keys ...[]byte) ([][]byte, error) {
+	return db.def.SInter(keys...)
+}
+
+// SInter returns the members present in every set at keys in the family. A
+// missing key counts as an empty set.
+func (f *Family) SInter(keys ...[]byte) ([][]byte, error) {
+	return f.combineSets(keys, func(count int, _ bool) bool { return count == len(keys) })
+}
+
+// SUnion returns the members present in any set at keys, in lexicographic order.
+func (db *DB) SUnion(keys ...[]byte) ([][]byte, error) {
+	return db.def.SUnion(keys...)
+}
+
+// SUnion returns the members present in any set at keys in the family.
+func (f *Family) SUnion(keys ...[]byte) ([][]byte, error) {
+	return f.combineSets(keys, func(int, bool) bool { return true })
+}
+
+// SDiff returns the members of the first set at keys that are in none of the
+// others, in lexicographic order.
+func (db *DB) SDiff(keys ...[]byte) ([][]byte, error) {
+	return db.def.SDiff(keys...)
+}
+
+// SDiff returns the members of the first set at keys in the family that are
+// in none of the others.
+func (f *Family) SDiff(keys ...[]byte) ([][]byte, error) {
+	return f.combineSets(keys, func(count int, inFirst bool) bool { return inFirst && count == 1 })
+}
+
+// combineSets reads the sets at keys under one lock and returns, in
+// lexicographic order, the members for which keep reports true given how
+// many of the sets hold them and whether the first does.
+func (f *Family) combineSets(keys [][]byte, keep func(count int, inFirst bool) bool) ([][]byte, error) {
+	counts := make(map[string]int)
+	first := make(map[string]bool)
+	err := f.readCollections(func() error {
+		for i, key := range keys {
+			meta, sub, err := f.collectionLocked(key, kindSet)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			for _, member := range sub.smembersLocked(memberPrefix(key, meta.version), nil, 0) {
+				counts[string(member)]++
+				if i == 0 {
+					first[string(member)] = true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var members [][]byte
+	for member, count := range counts {
+		if keep(count, first[member]) {
+			members = append(members, []byte(member))
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return bytes.Compare(members[i], members[j]) < 0 })
+	return members, nil
+}
+
+// smembersLocked returns up to limit members under base matching prefix, in
+// lexicographic order. f is the member family; callers must hold db.mu.
+func (f *Family) smembersLocked(base, prefix []byte, limit int) [][]byte {
+	entries := f.index.Scan(string(append(base[:len(base):len(base)], prefix...)), limit)
+	members := make([][]byte, len(entries))
+	for i, entry := range entries {
+		members[i] = entry.Key[len(base):]
+	}
+	return members
+}
+
+func longestMember(members [][]byte) []byte {
+	var longest []byte
+	for _, member := range members {
+		if len(member) > len(longest) {
+			longest = member
+		}
+	}
+	return longest
+}
+
+// sadd stages member in the set at key and reports whether it is new.
+func (t *txn) sadd(f *Family, key, member []byte) (bool, error) {
+	meta, err := t.meta(f, string(key), kindSet)
+	if err != nil {
+		return false, err
+	}
+	if meta == nil {
+		meta = t.newMeta(f, kindSet)
+	}
+	sub, err := f.subFamilyLocked(true)
+	if err != nil {
+		return false, err
+	}
+	name := append(memberPrefix(key, meta.version), member...)
+	if _, exists, err := t.get(sub, string(name)); err != nil || exists {
+		return false, err
+	}
+	if err := t.set(sub, name, nil, -1); err != nil {
+		return false, err
+	}
+	meta.count++
+	t.putMeta(f, key, meta)
+	return true, nil
+}
+
+// srem stages the removal of member from the set at key and reports whether
+// it was in the set.
+func (t *txn) srem(f *Family, key, member []byte) (bool, error) {
+	meta, err := t.meta(f, string(key), kindSet)
+	if err != nil || meta == nil {
+		return false, err
+	}
+	sub, err := f.subFamilyLocked(false)
+	if err != nil || sub == nil {
+		return false, err
+	}
+	name := append(memberPrefix(key, meta.version), member...)
+	if _, exists, err := t.get(sub, string(name)); err != nil || !exists {
+		return false, err
+	}
+	t.delete(sub, name)
+	meta.count--
+	t.putMeta(f, key, meta)
+	return true, nil
+}
@@ -0,0 +1,174 @@
+package minikv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func expectMembers(t *testing.T, got [][]byte, err error, want string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("members: %v", err)
+	}
+	if string(bytes.Join(got, []byte(","))) != want {
+		t.Fatalf("got members %q, want %q", bytes.Join(got, []byte(",")), want)
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	if n, err := db.SAdd([]byte("tags"), []byte("go"), []byte("db"), []byte("go")); err != nil || n != 2 {
+		t.Fatalf("sadd: %d %v", n, err)
+	}
+	if n, _ := db.SAdd([]byte("tags"), []byte("kv"), []byte("db")); n != 1 {
+		t.Fatalf("expected one new member, got %d", n)
+	}
+	if ok, err := db.SIsMember([]byte("tags"), []byte("kv")); err != nil || !ok {
+		t.Fatalf("sismember: %v %v", ok, err)
+	}
+	if ok, _ := db.SIsMember([]byte("tags"), []byte("rust")); ok {
+		t.Fatalf("expected rust not to be a member")
+	}
+	if ok, err := db.SIsMember([]byte("missing"), []byte("go")); err != nil || ok {
+		t.Fatalf("expected missing set to have no members, got %v %v", ok, err)
+	}
+	members, err := db.SMembers([]byte("tags"))
+	expectMembers(t, members, err, "db,go,kv")
+	if n, _ := db.SCard([]byte("tags")); n != 3 {
+		t.Fatalf("expected 3 members, got %d", n)
+	}
+	members, err = db.SScan([]byte("tags"), []byte("g"), 0)
+	expectMembers(t, members, err, "go")
+	members, err = db.SScan([]byte("tags"), nil, 2)
+	expectMembers(t, members, err, "db,go")
+
+	if n, err := db.SRem([]byte("tags"), []byte("go"), []byte("rust")); err != nil || n != 1 {
+		t.Fatalf("srem: %d %v", n, err)
+	}
+	_, _ = db.SRem([]byte("tags"), []byte("db"), []byte("kv"))
+	if ok, _ := db.Exists([]byte("tags")); ok {
+		t.Fatalf("expected empty set to be removed")
+	}
+
+	_ = db.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	if _, err := db.SAdd([]byte("hash"), []byte("x")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := db.SUnion([]byte("hash")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	_, _ = db.SAdd([]byte("a"), []byte("1"), []byte("2"), []byte("3"), []byte("4"))
+	_, _ = db.SAdd([]byte("b"), []byte("2"), []byte("3"), []byte("5"))
+	_, _ = db.SAdd([]byte("c"), []byte("3"), []byte("4"), []byte("6"))
+
+	members, err := db.SInter([]byte("a"), []byte("b"), []byte("c"))
+	expectMembers(t, members, err, "3")
+	members, err = db.SUnion([]byte("a"), []byte("b"), []byte("c"))
+	expectMembers(t, members, err, "1,2,3,4,5,6")
+	members, err = db.SDiff([]byte("a"), []byte("b"), []byte("c"))
+	expectMembers(t, members, err, "1")
+	members, err = db.SDiff([]byte("a"))
+	expectMembers(t, members, err, "1,2,3,4")
+	members, err = db.SInter([]byte("a"), []byte("missing"))
+	expectMembers(t, members, err, "")
+	members, err = db.SUnion([]byte("missing"), []byte("b"))
+	expectMembers(t, members, err, "2,3,5")
+}
+
+func TestSetExpiryAndDumpKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_, _ = db.SAdd([]byte("tags"), []byte("a"), []byte("b"))
+	_, _ = db.Expire([]byte("tags"), time.Hour)
+	_ = db.Set([]byte("plain"), []byte("value"))
+
+	var buf bytes.Buffer
+	if err := db.DumpKeys(&buf); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected only user keys, got %q", buf.String())
+	}
+	fields := strings.Split(lines[1], "\t")
+	if len(fields) != 4 || fields[0] != "tags" || fields[1] != "2" || fields[2] == "-1" || fields[3] != "set" {
+		t.Fatalf("unexpected set line %q", lines[1])
+	}
+	if fields := strings.Split(lines[0], "\t"); len(fields) != 3 || fields[1] != "5" {
+		t.Fatalf("unexpected plain line %q", lines[0])
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	members, err := db.SMembers([]byte("tags"))
+	expectMembers(t, members, err, "a,b")
+	_, _ = db.Expire([]byte("tags"), 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if n, _ := db.SCard([]byte("tags")); n != 0 {
+		t.Fatalf("expected expired set to be empty, got %d", n)
+	}
+	_, _ = db.SAdd([]byte("tags"), []byte("c"))
+	members, err = db.SMembers([]byte("tags"))
+	expectMembers(t, members, err, "c")
+}
+
+func TestSetBatchIsAtomic(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	_, _ = db.SAdd([]byte("post:1"), []byte("draft"))
+
+	batch := db.NewBatch()
+	batch.SRem([]byte("post:1"), []byte("draft"))
+	batch.SAdd([]byte("post:1"), []byte("published"))
+	batch.SAdd([]byte("tag:published"), []byte("post:1"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	members, err := db.SMembers([]byte("post:1"))
+	expectMembers(t, members, err, "published")
+
+	_ = db.Set([]byte("plain"), []byte("v"))
+	batch = db.NewBatch()
+	batch.SAdd([]byte("post:1"), []byte("archived"))
+	batch.SAdd([]byte("plain"), []byte("x"))
+	if err := batch.Write(); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	members, err = db.SMembers([]byte("post:1"))
+	expectMembers(t, members, err, "published")
+}
+
+func TestKeyCountsSkipCollections(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+	_ = db.Set([]byte("plain"), []byte("v"))
+	_, _ = db.SAdd([]byte("set"), []byte("a"))
+	_ = db.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, _ = db.ZAdd([]byte("zset"), 1, []byte("m"))
+
+	keys, _, err := db.Scan(nil, 0)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected scan to return only the plain key, got %q %v", keys, err)
+	}
+	if count, _ := db.Count(); count != len(keys) {
+		t.Fatalf("expected Count to match the scan, got %d", count)
+	}
+	if matched, _ := db.Keys("*"); len(matched) != 1 || matched[0] != "plain" {
+		t.Fatalf("expected Keys to match the scan, got %q", matched)
+	}
+	if stats, _ := db.Stats(); stats.KeyCount != len(keys) {
+		t.Fatalf("expected Stats.KeyCount to match the scan, got %d", stats.KeyCount)
+	}
+}
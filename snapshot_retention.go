@@ -0,0 +1,157 @@
+package minikv
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/manifest"
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+)
+
+// SnapshotPin keeps a snapshot chain on disk until Release is called, so
+// that backup tools can copy it while compaction continues.
+type SnapshotPin struct {
+	// Seq is the WAL sequence covered by the newest file in the chain.
+	Seq uint64
+	// Paths lists the chain files in load order: the full snapshot first,
+	// then its deltas.
+	Paths []string
+
+	db       *DB
+	released bool
+}
+
+// PinSnapshot pins the current snapshot chain. Returns ErrNotFound if no
+// snapshot has been written yet.
+func (db *DB) PinSnapshot() (*SnapshotPin, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if db.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	chains := snapshotChains(db.manifest.State())
+	if len(chains) == 0 {
+		return nil, ErrNotFound
+	}
+	current := chains[len(chains)-1]
+
+	db.pinMu.Lock()
+	defer db.pinMu.Unlock()
+	if db.pins == nil {
+		db.pins = make(map[string]int)
+	}
+	db.pins[current.paths[0]]++
+	return &SnapshotPin{
+		Seq:   current.seq,
+		Paths: append([]string(nil), current.paths...),
+		db:    db,
+	}, nil
+}
+
+// Release unpins the chain. The files become eligible for removal at the
+// next compaction.
+func (p *SnapshotPin) Release() {
+	if p == nil || p.released {
+		return
+	}
+	p.released = true
+	p.db.pinMu.Lock()
+	defer p.db.pinMu.Unlock()
+	base := p.Paths[0]
+	if p.db.pins[base] <= 1 {
+		delete(p.db.pins, base)
+		return
+	}
+	p.db.pins[base]--
+}
+
+// snapshotFiles groups a full snapshot with the deltas written on top of it.
+type snapshotFiles struct {
+	seq     uint64 // newest sequence in the chain
+	paths   []string
+	written time.Time
+}
+
+// snapshotChains returns the committed snapshot chains ordered oldest
+// first. Deltas belong to the newest full snapshot with a lower sequence;
+// deltas older than every full snapshot are returned as a chain of their own.
+func snapshotChains(man manifest.Manifest) []snapshotFiles {
+	type file struct {
+		path string
+		seq  uint64
+		base bool
+	}
+	files := make([]file, 0, len(man.Snapshots)+len(man.Deltas))
+	for _, info := range man.Snapshots {
+		files = append(files, file{path: info.Path, seq: info.Seq, base: true})
+	}
+	for _, info := range man.Deltas {
+		files = append(files, file{path: info.Path, seq: info.Seq})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].seq != files[j].seq {
+			return files[i].seq < files[j].seq
+		}
+		return files[i].base && !files[j].base
+	})
+
+	var chains []snapshotFiles
+	for _, f := range files {
+		if f.base || len(chains) == 0 {
+			chains = append(chains, snapshotFiles{})
+		}
+		chain := &chains[len(chains)-1]
+		chain.seq = f.seq
+		chain.paths = append(chain.paths, f.path)
+		if written, ok := snapshotWritten(f.path); ok && written.After(chain.written) {
+			chain.written = written
+		}
+	}
+	return chains
+}
+
+// snapshotWritten returns when a snapshot file was written: the timestamp in
+// its header, taken from Options.Clock, or the file's modification time if
+// the header cannot be read.
+func snapshotWritten(path string) (time.Time, bool) {
+	if r, err := snapshot.OpenReader(path); err == nil {
+		defer r.Close()
+		return time.Unix(0, r.Header().Timestamp), true
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// pruneSnapshots removes superseded chains that fall outside the retention
+// policy. The newest chain and pinned chains are always kept.
+func (db *DB) pruneSnapshots() error {
+	chains := snapshotChains(db.manifest.State())
+	retention := db.opts.SnapshotRetention
+	now := time.Unix(0, db.now())
+
+	db.pinMu.Lock()
+	defer db.pinMu.Unlock()
+	var expired []string
+	for i, chain := range chains {
+		age := len(chains) - 1 - i
+		if age == 0 || db.pins[chain.paths[0]] > 0 {
+			continue
+		}
+		if retention.KeepLast > 0 && age < retention.KeepLast {
+			continue
+		}
+		if retention.KeepFor > 0 && now.Sub(chain.written) < retention.KeepFor {
+			continue
+		}
+		expired = append(expired, chain.paths...)
+	}
+	return db.removeFiles(expired)
+}
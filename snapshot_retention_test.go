@@ -0,0 +1,146 @@
+package minikv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/manifest"
+)
+
+func compactRounds(t *testing.T, db *DB, rounds int) {
+	t.Helper()
+	for round := 0; round < rounds; round++ {
+		if err := db.Set([]byte("k"), []byte("r"+intToString(round))); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		if err := db.Compact(); err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+	}
+}
+
+func TestSnapshotRetentionKeepLast(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) { opts.SnapshotRetention = SnapshotRetention{KeepLast: 2} })
+	defer db.Close()
+
+	compactRounds(t, db, 5)
+	if got := dirCount(filepath.Join(dir, "snapshots"), ".snap"); got != 2 {
+		t.Fatalf("expected 2 snapshots retained, got %d", got)
+	}
+}
+
+func TestSnapshotPinSurvivesPruning(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) { opts.SnapshotRetention = SnapshotRetention{KeepLast: 1} })
+	defer db.Close()
+
+	compactRounds(t, db, 1)
+	pin, err := db.PinSnapshot()
+	if err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	compactRounds(t, db, 3)
+	for _, path := range pin.Paths {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected pinned file %s to survive: %v", path, err)
+		}
+	}
+
+	pin.Release()
+	compactRounds(t, db, 1)
+	if _, err := os.Stat(pin.Paths[0]); !os.IsNotExist(err) {
+		t.Fatalf("expected released snapshot to be pruned, got %v", err)
+	}
+	if got := dirCount(filepath.Join(dir, "snapshots"), ".snap"); got != 1 {
+		t.Fatalf("expected 1 snapshot retained, got %d", got)
+	}
+}
+
+func TestPinSnapshotWithoutSnapshot(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.SnapshotRetention = SnapshotRetention{KeepLast: 1} })
+	defer db.Close()
+	if _, err := db.PinSnapshot(); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestOpenRemovesPartialSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, func(opts *Options) { opts.SnapshotRetention = SnapshotRetention{KeepLast: 2} })
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Simulate a newest snapshot that was committed but is truncated on disk;
+	// the WAL segments it would cover still exist.
+	snapDir := filepath.Join(dir, "snapshots")
+	paths, _ := filepath.Glob(filepath.Join(snapDir, "*.snap"))
+	if len(paths) != 1 {
+		t.Fatalf("expected one snapshot, got %v", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	partial := filepath.Join(snapDir, "snapshot_999999.snap")
+	if err := os.WriteFile(partial, data[:len(data)/2], 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	manLog, err := manifest.OpenLog(filepath.Join(dir, "MANIFEST"), 0)
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	err = manLog.Apply(manifest.VersionEdit{
+		AddSnapshots:       []manifest.SnapshotInfo{{Seq: 999999, Path: partial}},
+		HasLastSnapshotSeq: true,
+		LastSnapshotSeq:    999999,
+	})
+	_ = manLog.Close()
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+
+	db = openTestDB(t, dir, func(opts *Options) { opts.SnapshotRetention = SnapshotRetention{KeepLast: 2} })
+	defer db.Close()
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Fatalf("expected partial snapshot to be removed, got %v", err)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		value, err := db.Get([]byte(key))
+		if err != nil || string(value) != want {
+			t.Fatalf("get %s: %q %v", key, value, err)
+		}
+	}
+}
+
+func TestSnapshotRetentionKeepForUsesClock(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewFakeClock(fakeEpoch)
+	db := openTestDB(t, dir, func(opts *Options) {
+		opts.Clock = clock
+		opts.SnapshotRetention = SnapshotRetention{KeepFor: time.Hour}
+	})
+	defer db.Close()
+
+	compactRounds(t, db, 2)
+	if got := dirCount(filepath.Join(dir, "snapshots"), ".snap"); got != 2 {
+		t.Fatalf("expected both snapshots kept within KeepFor, got %d", got)
+	}
+	clock.Advance(2 * time.Hour)
+	compactRounds(t, db, 1)
+	if got := dirCount(filepath.Join(dir, "snapshots"), ".snap"); got != 1 {
+		t.Fatalf("expected snapshots older than KeepFor pruned, got %d", got)
+	}
+}
@@ -18,11 +18,17 @@ type Stats struct {
 	WALSize       int64
 	SnapshotCount int
 	MemoryBytes   int64
+	ValueLogSize  int64
+
+	LastCompaction         time.Time
+	LastCompactionDuration time.Duration
 
 	Reads   uint64
 	Writes  uint64
 	Deletes uint64
 	Scans   uint64
+	// Evictions counts keys removed to stay within MaxMemoryBytes.
+	Evictions uint64
 
 	ReadLatencyP50  time.Duration
 	ReadLatencyP95  time.Duration
@@ -38,6 +44,8 @@ type statsTracker struct {
 	deletes atomic.Uint64
 	scans   atomic.Uint64
 
+	evictions atomic.Uint64
+
 	readLatency  *latencyTracker
 	writeLatency *latencyTracker
 }
@@ -115,44 +123,63 @@ func (db *DB) Stats() (Stats, error) {
 		return Stats{}, ErrClosed
 	}
 	statsTracker := db.statsOrInit()
-	keyCount := db.index.Count()
-	memBytes := db.index.Size()
+	var keyCount int
+	var memBytes int64
+	for _, f := range db.families {
+		if f.parent == nil {
+			keyCount += f.index.Count()
+		}
+		memBytes += f.index.Size()
+	}
 	walDir := filepath.Join(db.path, "wal")
 	snapDir := filepath.Join(db.path, "snapshots")
+	vlogDir := filepath.Join(db.path, "vlog")
 	db.mu.RUnlock()
 
 	walSize := dirSize(walDir, ".log")
 	snapCount := dirCount(snapDir, ".snap")
+	vlogSize := dirSize(vlogDir, ".vlog")
+
+	var lastCompaction time.Time
+	if at := db.lastCompAt.Load(); at > 0 {
+		lastCompaction = time.Unix(0, at)
+	}
 
 	readP50, readP95, readP99 := statsTracker.readLatency.percentiles()
 	writeP50, writeP95, writeP99 := statsTracker.writeLatency.percentiles()
 
 	return Stats{
-		KeyCount:        keyCount,
-		WALSize:         walSize,
-		SnapshotCount:   snapCount,
-		MemoryBytes:     memBytes,
-		Reads:           statsTracker.reads.Load(),
-		Writes:          statsTracker.writes.Load(),
-		Deletes:         statsTracker.deletes.Load(),
-		Scans:           statsTracker.scans.Load(),
-		ReadLatencyP50:  readP50,
-		ReadLatencyP95:  readP95,
-		ReadLatencyP99:  readP99,
-		WriteLatencyP50: writeP50,
-		WriteLatencyP95: writeP95,
-		WriteLatencyP99: writeP99,
+		KeyCount:               keyCount,
+		WALSize:                walSize,
+		SnapshotCount:          snapCount,
+		MemoryBytes:            memBytes,
+		ValueLogSize:           vlogSize,
+		LastCompaction:         lastCompaction,
+		LastCompactionDuration: time.Duration(db.lastCompNs.Load()),
+		Reads:                  statsTracker.reads.Load(),
+		Writes:                 statsTracker.writes.Load(),
+		Deletes:                statsTracker.deletes.Load(),
+		Scans:                  statsTracker.scans.Load(),
+		Evictions:              statsTracker.evictions.Load(),
+		ReadLatencyP50:         readP50,
+		ReadLatencyP95:         readP95,
+		ReadLatencyP99:         readP99,
+		WriteLatencyP50:        writeP50,
+		WriteLatencyP95:        writeP95,
+		WriteLatencyP99:        writeP99,
 	}, nil
 }
 
-// DumpKeys writes all non-expired keys with metadata to w.
+// DumpKeys writes all non-expired keys with metadata to w, one per line:
+// key, value length and expiry separated by tabs. For a collection the
+// length is its member count, followed by a fourth column naming its type.
 func (db *DB) DumpKeys(w io.Writer) error {
 	db.mu.RLock()
 	if db.closed {
 		db.mu.RUnlock()
 		return ErrClosed
 	}
-	entries := db.index.Scan("", 0)
+	entries := db.def.index.Scan("", 0)
 	db.mu.RUnlock()
 
 	writer := bufio.NewWriter(w)
@@ -161,19 +188,27 @@ func (db *DB) DumpKeys(w io.Writer) error {
 		if entry.Entry.ExpiresAt >= 0 {
 			expires = time.Unix(0, entry.Entry.ExpiresAt).UTC().Format(time.RFC3339Nano)
 		}
+		size := valueLen(&entry.Entry)
+		kind := ""
+		if entry.Entry.Meta {
+			if meta, err := decodeMeta(entry.Entry.Value); err == nil {
+				size = int(meta.count)
+				kind = "\t" + meta.kind.String()
+			}
+		}
 		if _, err := writer.WriteString(string(entry.Key)); err != nil {
 			return err
 		}
 		if _, err := writer.WriteString("\t"); err != nil {
 			return err
 		}
-		if _, err := writer.WriteString(intToString(len(entry.Entry.Value))); err != nil {
+		if _, err := writer.WriteString(intToString(size)); err != nil {
 			return err
 		}
 		if _, err := writer.WriteString("\t"); err != nil {
 			return err
 		}
-		if _, err := writer.WriteString(expires); err != nil {
+		if _, err := writer.WriteString(expires + kind); err != nil {
 			return err
 		}
 		if _, err := writer.WriteString("\n"); err != nil {
@@ -12,7 +12,7 @@ func (db *DB) Sync() error {
 	if db.wal == nil {
 		return nil
 	}
-	return db.wal.Sync()
+	return db.syncWAL()
 }
 
 func (db *DB) startSyncWorker() {
@@ -23,7 +23,7 @@ func (db *DB) startSyncWorker() {
 		db.stopCh = make(chan struct{})
 	}
 	if db.syncTicker == nil {
-		db.syncTicker = time.NewTicker(1 * time.Second)
+		db.syncTicker = db.opts.Clock.NewTicker(1 * time.Second)
 	}
 
 	db.wg.Add(1)
@@ -31,7 +31,7 @@ func (db *DB) startSyncWorker() {
 		defer db.wg.Done()
 		for {
 			select {
-			case <-db.syncTicker.C:
+			case <-db.syncTicker.C():
 				_ = db.Sync()
 			case <-db.stopCh:
 				return
@@ -47,11 +47,23 @@ func (db *DB) stopWorkers() {
 	if db.ttlTicker != nil {
 		db.ttlTicker.Stop()
 	}
+	if db.vlogTicker != nil {
+		db.vlogTicker.Stop()
+	}
+	if db.compTicker != nil {
+		db.compTicker.Stop()
+	}
+	if db.followTicker != nil {
+		db.followTicker.Stop()
+	}
 	if db.stopCh != nil {
 		close(db.stopCh)
 	}
 	db.wg.Wait()
 	db.syncTicker = nil
 	db.ttlTicker = nil
+	db.vlogTicker = nil
+	db.compTicker = nil
+	db.followTicker = nil
 	db.stopCh = nil
 }
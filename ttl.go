@@ -4,17 +4,63 @@ import "time"
 
 // SetWithTTL stores a key-value pair with a TTL duration.
 func (db *DB) SetWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return db.def.SetWithTTL(key, value, ttl)
+}
+
+// SetWithTTL stores a key-value pair in the family with a TTL duration. A
+// non-positive ttl falls back to the family's DefaultTTL.
+func (f *Family) SetWithTTL(key []byte, value []byte, ttl time.Duration) error {
 	if ttl <= 0 {
-		return db.Set(key, value)
+		return f.Set(key, value)
 	}
-	expiresAt := time.Now().Add(ttl).UnixNano()
-	return db.setWithExpiresAt(key, value, expiresAt)
+	expiresAt := f.db.now() + int64(ttl)
+	return f.setWithExpiresAt(key, value, expiresAt)
+}
+
+// SetWithExpireAt stores a key-value pair that expires at t.
+func (db *DB) SetWithExpireAt(key []byte, value []byte, t time.Time) error {
+	return db.def.SetWithExpireAt(key, value, t)
+}
+
+// SetWithExpireAt stores a key-value pair in the family that expires at t. A
+// zero t falls back to the family's DefaultTTL; a t in the past stores a key
+// that is already expired.
+func (f *Family) SetWithExpireAt(key []byte, value []byte, t time.Time) error {
+	if t.IsZero() {
+		return f.Set(key, value)
+	}
+	return f.setWithExpiresAt(key, value, t.UnixNano())
+}
+
+// SetKeepTTL stores a key-value pair, keeping the key's current TTL.
+func (db *DB) SetKeepTTL(key []byte, value []byte) error {
+	return db.def.SetKeepTTL(key, value)
+}
+
+// SetKeepTTL stores a key-value pair in the family, keeping the expiry of the
+// value it replaces, like Redis SET KEEPTTL. A new key gets the family's
+// DefaultTTL.
+func (f *Family) SetKeepTTL(key []byte, value []byte) error {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	expiresAt := f.defaultExpiresAt()
+	if entry, ok := f.index.Get(string(key)); ok {
+		expiresAt = entry.ExpiresAt
+	}
+	return f.setWithExpiresAtLocked(key, value, expiresAt, 0, false)
 }
 
 // TTL returns the remaining TTL for a key, or ErrNotFound.
 // Returns -1 for keys without expiration.
 func (db *DB) TTL(key []byte) (time.Duration, error) {
-	if len(key) > db.opts.MaxKeySize {
+	return db.def.TTL(key)
+}
+
+// TTL returns the remaining TTL for a key in the family, or ErrNotFound.
+// Returns -1 for keys without expiration.
+func (f *Family) TTL(key []byte) (time.Duration, error) {
+	db := f.db
+	if len(key) > f.opts.MaxKeySize {
 		return 0, ErrKeyTooLarge
 	}
 	if len(key) == 0 {
@@ -22,11 +68,11 @@ func (db *DB) TTL(key []byte) (time.Duration, error) {
 	}
 
 	db.mu.RLock()
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		db.mu.RUnlock()
-		return 0, ErrClosed
+		return 0, err
 	}
-	entry, ok := db.index.Get(string(key))
+	entry, ok := f.index.Get(string(key))
 	db.mu.RUnlock()
 	if !ok {
 		return 0, ErrNotFound
@@ -35,7 +81,7 @@ func (db *DB) TTL(key []byte) (time.Duration, error) {
 		return -1, nil
 	}
 
-	now := time.Now().UnixNano()
+	now := db.now()
 	if entry.ExpiresAt <= now {
 		return 0, ErrNotFound
 	}
@@ -44,10 +90,34 @@ func (db *DB) TTL(key []byte) (time.Duration, error) {
 
 // Expire sets a TTL on an existing key.
 func (db *DB) Expire(key []byte, ttl time.Duration) (bool, error) {
+	return db.def.Expire(key, ttl)
+}
+
+// Expire sets a TTL on an existing key in the family.
+func (f *Family) Expire(key []byte, ttl time.Duration) (bool, error) {
 	if ttl <= 0 {
 		return false, nil
 	}
-	if len(key) > db.opts.MaxKeySize {
+	return f.expireAt(key, f.db.now()+int64(ttl))
+}
+
+// ExpireAt sets an existing key to expire at t.
+func (db *DB) ExpireAt(key []byte, t time.Time) (bool, error) {
+	return db.def.ExpireAt(key, t)
+}
+
+// ExpireAt sets an existing key in the family to expire at t. A t in the past
+// expires the key right away. A zero t changes nothing.
+func (f *Family) ExpireAt(key []byte, t time.Time) (bool, error) {
+	if t.IsZero() {
+		return false, nil
+	}
+	return f.expireAt(key, t.UnixNano())
+}
+
+func (f *Family) expireAt(key []byte, expiresAt int64) (bool, error) {
+	db := f.db
+	if len(key) > f.opts.MaxKeySize {
 		return false, ErrKeyTooLarge
 	}
 	if len(key) == 0 {
@@ -57,25 +127,29 @@ func (db *DB) Expire(key []byte, ttl time.Duration) (bool, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if db.closed {
-		return false, ErrClosed
+	if err := f.unavailableLocked(); err != nil {
+		return false, err
 	}
 	if db.opts.ReadOnly {
 		return false, ErrReadOnly
 	}
 
-	entry, ok := db.index.Get(string(key))
+	entry, ok := f.index.Get(string(key))
 	if !ok {
 		return false, nil
 	}
-
-	expiresAt := time.Now().Add(ttl).UnixNano()
-	return db.updateExpiresAtLocked(key, entry.Value, expiresAt, entry.CreatedAt)
+	return f.updateExpiresAtLocked(key, entry, expiresAt)
 }
 
 // Persist removes expiration from an existing key.
 func (db *DB) Persist(key []byte) (bool, error) {
-	if len(key) > db.opts.MaxKeySize {
+	return db.def.Persist(key)
+}
+
+// Persist removes expiration from an existing key in the family.
+func (f *Family) Persist(key []byte) (bool, error) {
+	db := f.db
+	if len(key) > f.opts.MaxKeySize {
 		return false, ErrKeyTooLarge
 	}
 	if len(key) == 0 {
@@ -85,17 +159,17 @@ func (db *DB) Persist(key []byte) (bool, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if db.closed {
-		return false, ErrClosed
+	if err := f.unavailableLocked(); err != nil {
+		return false, err
 	}
 	if db.opts.ReadOnly {
 		return false, ErrReadOnly
 	}
 
-	entry, ok := db.index.Get(string(key))
+	entry, ok := f.index.Get(string(key))
 	if !ok {
 		return false, nil
 	}
 
-	return db.updateExpiresAtLocked(key, entry.Value, -1, entry.CreatedAt)
+	return f.updateExpiresAtLocked(key, entry, -1)
 }
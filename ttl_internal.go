@@ -3,24 +3,26 @@ package minikv
 import (
 	"time"
 
+	"github.com/bretuobay/mini-kv/internal/index"
 	"github.com/bretuobay/mini-kv/internal/wal"
 )
 
-func (db *DB) setWithExpiresAt(key []byte, value []byte, expiresAt int64) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.setWithExpiresAtLocked(key, value, expiresAt, 0, false)
+func (f *Family) setWithExpiresAt(key []byte, value []byte, expiresAt int64) error {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	return f.setWithExpiresAtLocked(key, value, expiresAt, 0, false)
 }
 
-func (db *DB) setWithExpiresAtLocked(key []byte, value []byte, expiresAt int64, createdAt int64, preserveCreated bool) error {
+func (f *Family) setWithExpiresAtLocked(key []byte, value []byte, expiresAt int64, createdAt int64, preserveCreated bool) error {
+	db := f.db
 	stats := db.statsOrInit()
 	start := time.Now()
-	if len(key) > db.opts.MaxKeySize {
+	if len(key) > f.opts.MaxKeySize {
 		stats.writes.Add(1)
 		stats.writeLatency.add(time.Since(start))
 		return ErrKeyTooLarge
 	}
-	if len(value) > db.opts.MaxValueSize {
+	if len(value) > f.opts.MaxValueSize {
 		stats.writes.Add(1)
 		stats.writeLatency.add(time.Since(start))
 		return ErrValueTooLarge
@@ -31,28 +33,32 @@ func (db *DB) setWithExpiresAtLocked(key []byte, value []byte, expiresAt int64,
 		return ErrNotFound
 	}
 
-	if db.closed {
+	if err := f.unavailableLocked(); err != nil {
 		stats.writes.Add(1)
 		stats.writeLatency.add(time.Since(start))
-		return ErrClosed
+		return err
 	}
 	if db.opts.ReadOnly {
 		stats.writes.Add(1)
 		stats.writeLatency.add(time.Since(start))
 		return ErrReadOnly
 	}
+	if err := db.checkMemoryLocked(); err != nil {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return err
+	}
 
-	now := time.Now().UnixNano()
+	now := db.now()
 	if !preserveCreated || createdAt == 0 {
 		createdAt = now
 	}
 
-	record := wal.WALRecord{
-		Type:      wal.RecordSet,
-		Timestamp: now,
-		ExpiresAt: expiresAt,
-		Key:       append([]byte(nil), key...),
-		Value:     append([]byte(nil), value...),
+	record, err := f.encodeValueLocked(key, value, expiresAt, now)
+	if err != nil {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
+		return err
 	}
 	if err := db.wal.AppendRecord(record); err != nil {
 		stats.writes.Add(1)
@@ -60,22 +66,70 @@ func (db *DB) setWithExpiresAtLocked(key []byte, value []byte, expiresAt int64,
 		return err
 	}
 	if db.opts.SyncMode == SyncAlways {
-		if err := db.wal.Sync(); err != nil {
+		if err := db.syncWAL(); err != nil {
 			stats.writes.Add(1)
 			stats.writeLatency.add(time.Since(start))
 			return err
 		}
 	}
 
-	db.index.SetEntry(string(key), value, expiresAt, createdAt)
+	f.applyRecordLocked(record, createdAt)
+	db.evictLocked()
 	stats.writes.Add(1)
 	stats.writeLatency.add(time.Since(start))
 	return nil
 }
 
-func (db *DB) updateExpiresAtLocked(key []byte, value []byte, expiresAt int64, createdAt int64) (bool, error) {
-	if err := db.setWithExpiresAtLocked(key, value, expiresAt, createdAt, true); err != nil {
+func (f *Family) updateExpiresAtLocked(key []byte, entry *index.Entry, expiresAt int64) (bool, error) {
+	if entry.Meta {
+		// A collection's TTL lives on its metadata entry alone.
+		return f.setMetaExpiresAtLocked(key, entry, expiresAt)
+	}
+	if len(entry.Operands) > 0 {
+		// Pending merge operands are folded into the rewritten value.
+		value, err := f.db.entryValue(string(key), entry)
+		if err != nil {
+			return false, err
+		}
+		if err := f.setWithExpiresAtLocked(key, value, expiresAt, entry.CreatedAt, true); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if !entry.Pointer {
+		if err := f.setWithExpiresAtLocked(key, entry.Value, expiresAt, entry.CreatedAt, true); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	// Value-log entries keep their pointer; only the expiry is rewritten.
+	db := f.db
+	stats := db.statsOrInit()
+	start := time.Now()
+	record := wal.WALRecord{
+		Type:      wal.RecordSetPointer,
+		Timestamp: db.now(),
+		ExpiresAt: expiresAt,
+		Key:       append([]byte(nil), key...),
+		Value:     append([]byte(nil), entry.Value...),
+		Family:    f.id,
+	}
+	createdAt := entry.CreatedAt
+	if err := db.wal.AppendRecord(record); err != nil {
+		stats.writes.Add(1)
+		stats.writeLatency.add(time.Since(start))
 		return false, err
 	}
+	if db.opts.SyncMode == SyncAlways {
+		if err := db.syncWAL(); err != nil {
+			stats.writes.Add(1)
+			stats.writeLatency.add(time.Since(start))
+			return false, err
+		}
+	}
+	f.applyRecordLocked(record, createdAt)
+	stats.writes.Add(1)
+	stats.writeLatency.add(time.Since(start))
 	return true, nil
 }
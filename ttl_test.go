@@ -6,20 +6,18 @@ import (
 )
 
 func TestSetWithTTLExpires(t *testing.T) {
-	dir := t.TempDir()
-	db, err := Open(DefaultOptions(dir))
-	if err != nil {
-		t.Fatalf("open: %v", err)
-	}
+	clock := NewFakeClock(fakeEpoch)
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Clock = clock })
 	defer db.Close()
 
 	if err := db.SetWithTTL([]byte("k"), []byte("v"), 10*time.Millisecond); err != nil {
 		t.Fatalf("set: %v", err)
 	}
+	clock.Advance(9 * time.Millisecond)
 	if _, err := db.Get([]byte("k")); err != nil {
 		t.Fatalf("get: %v", err)
 	}
-	time.Sleep(15 * time.Millisecond)
+	clock.Advance(time.Millisecond)
 	if _, err := db.Get([]byte("k")); err != ErrNotFound {
 		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
@@ -92,3 +90,108 @@ func TestExpireAndPersist(t *testing.T) {
 		t.Fatalf("expected -1, got %v", remaining)
 	}
 }
+
+func TestExpireAtAndSetWithExpireAt(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	at := time.Now().Add(time.Hour)
+	if err := db.SetWithExpireAt([]byte("k"), []byte("v"), at); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if remaining, _ := db.TTL([]byte("k")); remaining <= 59*time.Minute || remaining > time.Hour {
+		t.Fatalf("expected about an hour left, got %v", remaining)
+	}
+	if ok, err := db.ExpireAt([]byte("k"), at.Add(time.Hour)); err != nil || !ok {
+		t.Fatalf("expireat: %v %v", ok, err)
+	}
+	if remaining, _ := db.TTL([]byte("k")); remaining <= time.Hour {
+		t.Fatalf("expected the expiry to move later, got %v", remaining)
+	}
+	if ok, _ := db.ExpireAt([]byte("missing"), at); ok {
+		t.Fatalf("expected ExpireAt on a missing key to report false")
+	}
+	if ok, _ := db.ExpireAt([]byte("k"), time.Time{}); ok {
+		t.Fatalf("expected a zero time to change nothing")
+	}
+	if ok, _ := db.ExpireAt([]byte("k"), time.Now().Add(-time.Second)); !ok {
+		t.Fatalf("expected ExpireAt in the past to apply")
+	}
+	if _, err := db.Get([]byte("k")); err != ErrNotFound {
+		t.Fatalf("expected a past expiry to remove the key, got %v", err)
+	}
+	if err := db.SetWithExpireAt([]byte("gone"), []byte("v"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if ok, _ := db.Exists([]byte("gone")); ok {
+		t.Fatalf("expected a key stored with a past expiry to be expired")
+	}
+}
+
+func TestSetKeepTTL(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	_ = db.SetWithTTL([]byte("session"), []byte("a"), time.Hour)
+	if err := db.SetKeepTTL([]byte("session"), []byte("b")); err != nil {
+		t.Fatalf("set keepttl: %v", err)
+	}
+	if value, _ := db.Get([]byte("session")); string(value) != "b" {
+		t.Fatalf("expected the new value, got %q", value)
+	}
+	if remaining, _ := db.TTL([]byte("session")); remaining <= 0 {
+		t.Fatalf("expected the TTL to be kept, got %v", remaining)
+	}
+	if err := db.SetKeepTTL([]byte("new"), []byte("v")); err != nil {
+		t.Fatalf("set keepttl: %v", err)
+	}
+	if remaining, _ := db.TTL([]byte("new")); remaining != -1 {
+		t.Fatalf("expected a new key to have no TTL, got %v", remaining)
+	}
+
+}
+
+func TestSetNXWithTTLExpires(t *testing.T) {
+	clock := NewFakeClock(fakeEpoch)
+	db := openTestDB(t, t.TempDir(), func(opts *Options) { opts.Clock = clock })
+	defer db.Close()
+
+	if ok, err := db.SetNXWithTTL([]byte("lock"), []byte("owner-1"), 30*time.Second); err != nil || !ok {
+		t.Fatalf("setnx: %v %v", ok, err)
+	}
+	if ok, _ := db.SetNXWithTTL([]byte("lock"), []byte("owner-2"), time.Second); ok {
+		t.Fatalf("expected a held lock to be refused")
+	}
+	clock.Advance(30 * time.Second)
+	if ok, _ := db.SetNXWithTTL([]byte("lock"), []byte("owner-2"), time.Second); !ok {
+		t.Fatalf("expected an expired lock to be taken")
+	}
+	if value, _ := db.Get([]byte("lock")); string(value) != "owner-2" {
+		t.Fatalf("expected the new owner, got %q", value)
+	}
+}
+
+func TestOptionsDefaultTTL(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.DefaultTTL = time.Hour
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	_ = db.Set([]byte("k"), []byte("v"))
+	if remaining, _ := db.TTL([]byte("k")); remaining <= 0 || remaining > time.Hour {
+		t.Fatalf("expected the default TTL, got %v", remaining)
+	}
+	_ = db.SetWithTTL([]byte("short"), []byte("v"), time.Minute)
+	if remaining, _ := db.TTL([]byte("short")); remaining > time.Minute {
+		t.Fatalf("expected an explicit TTL to win, got %v", remaining)
+	}
+	if ok, _ := db.SetNX([]byte("nx"), []byte("v")); !ok {
+		t.Fatalf("setnx failed")
+	}
+	if remaining, _ := db.TTL([]byte("nx")); remaining <= 0 {
+		t.Fatalf("expected SetNX to use the default TTL, got %v", remaining)
+	}
+}
@@ -1,13 +1,16 @@
 package minikv
 
-import "time"
+import (
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
 
 func (db *DB) startTTLWorker() {
 	if db.stopCh == nil {
 		db.stopCh = make(chan struct{})
 	}
 	if db.ttlTicker == nil {
-		db.ttlTicker = time.NewTicker(1 * time.Second)
+		db.ttlTicker = db.opts.Clock.NewTicker(db.opts.TTLSweepInterval)
 	}
 
 	db.wg.Add(1)
@@ -15,7 +18,7 @@ func (db *DB) startTTLWorker() {
 		defer db.wg.Done()
 		for {
 			select {
-			case <-db.ttlTicker.C:
+			case <-db.ttlTicker.C():
 				db.cleanupExpired()
 			case <-db.stopCh:
 				return
@@ -24,13 +27,140 @@ func (db *DB) startTTLWorker() {
 	}()
 }
 
+// cleanupExpired removes up to Options.TTLSweepBudget expired keys across
+// all families. Each index keeps its keys with a TTL in a heap ordered by
+// expiry, so a sweep only touches the keys it removes.
 func (db *DB) cleanupExpired() {
-	db.mu.Lock()
+	db.mu.RLock()
 	if db.closed {
-		db.mu.Unlock()
+		db.mu.RUnlock()
+		return
+	}
+	indexes := make([]*index.MemIndex, 0, len(db.families))
+	for _, f := range db.families {
+		indexes = append(indexes, f.index)
+	}
+	db.mu.RUnlock()
+
+	now := db.now()
+	budget := db.opts.TTLSweepBudget
+	for _, idx := range indexes {
+		removed := idx.ExpireDue(now, budget)
+		if budget > 0 {
+			if budget -= len(removed); budget <= 0 {
+				break
+			}
+		}
+	}
+
+	db.reapMu.Lock()
+	pending := len(db.reaped)
+	db.reapMu.Unlock()
+	if pending == 0 {
 		return
 	}
-	// Count() performs expiration cleanup under the index lock; avoid holding DB lock.
+	db.mu.Lock()
+	if !db.closed {
+		db.writeReapedLocked()
+	}
 	db.mu.Unlock()
-	_ = db.index.Count()
+}
+
+// expiredEntry is a key reaped by its index, waiting for Options.OnExpire.
+type expiredEntry struct {
+	key   string
+	entry *index.Entry
+}
+
+// reapedExpired queues an expire record, and an OnExpire call, for a key the
+// index removed because it expired. It runs under the index lock, possibly
+// with db.mu held only for reading, so the record is written later by
+// writeReapedLocked and the call made by the expiry notifier.
+func (f *Family) reapedExpired(key string, entry *index.Entry) {
+	db := f.db
+	db.reapMu.Lock()
+	defer db.reapMu.Unlock()
+	if !db.opts.ReadOnly {
+		db.reaped = append(db.reaped, wal.WALRecord{
+			Type:      wal.RecordExpire,
+			Timestamp: f.db.now(),
+			ExpiresAt: entry.ExpiresAt,
+			Key:       []byte(key),
+			Family:    f.id,
+		})
+	}
+	if db.expiredCh != nil {
+		db.expired = append(db.expired, expiredEntry{key: key, entry: entry})
+		select {
+		case db.expiredCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// startExpiryNotifier starts the goroutine that calls Options.OnExpire.
+func (db *DB) startExpiryNotifier() {
+	if db.opts.OnExpire == nil {
+		return
+	}
+	if db.stopCh == nil {
+		db.stopCh = make(chan struct{})
+	}
+	db.reapMu.Lock()
+	db.expiredCh = make(chan struct{}, 1)
+	db.reapMu.Unlock()
+
+	db.wg.Add(1)
+	go func() {
+		defer db.wg.Done()
+		for {
+			select {
+			case <-db.expiredCh:
+				db.notifyExpired()
+			case <-db.stopCh:
+				db.notifyExpired()
+				return
+			}
+		}
+	}()
+}
+
+// notifyExpired calls Options.OnExpire for every queued key. Values are
+// resolved under a read lock that is released before each call.
+func (db *DB) notifyExpired() {
+	db.reapMu.Lock()
+	expired := db.expired
+	db.expired = nil
+	db.reapMu.Unlock()
+	for _, e := range expired {
+		db.mu.RLock()
+		value, err := db.entryValue(e.key, e.entry)
+		db.mu.RUnlock()
+		if err != nil {
+			value = nil
+		}
+		db.opts.OnExpire([]byte(e.key), value)
+	}
+}
+
+// writeReapedLocked appends the queued expire records to the WAL, so replay
+// and followers remove the keys without relying on their own clock. The
+// records are conditional on the expiry, so one written after the key was
+// set again leaves the new value alone. Callers must hold db.mu.
+func (db *DB) writeReapedLocked() {
+	db.reapMu.Lock()
+	records := db.reaped
+	db.reaped = nil
+	db.reapMu.Unlock()
+	for _, record := range records {
+		f, ok := db.families[record.Family]
+		if !ok {
+			continue
+		}
+		// Expiry stays implied by ExpiresAt if the record cannot be written.
+		if err := db.wal.AppendRecord(record); err != nil {
+			return
+		}
+		f.markDirtyLocked(record)
+	}
 }
@@ -1,6 +1,15 @@
 package minikv
 
-import "testing"
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
 
 func TestTTLWorkerStartsAndStops(t *testing.T) {
 	db, err := Open(DefaultOptions(t.TempDir()))
@@ -17,3 +26,223 @@ func TestTTLWorkerStartsAndStops(t *testing.T) {
 		t.Fatalf("expected ttl worker stopped")
 	}
 }
+
+func TestTTLSweepRespectsBudget(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), func(opts *Options) {
+		opts.TTLSweepInterval = time.Hour
+		opts.TTLSweepBudget = 3
+	})
+	defer db.Close()
+	docs := createFamily(t, db, "docs", FamilyOptions{})
+
+	for i := 0; i < 4; i++ {
+		_ = db.SetWithTTL([]byte("k"+intToString(i)), []byte("v"), time.Millisecond)
+		_ = docs.SetWithTTL([]byte("k"+intToString(i)), []byte("v"), time.Millisecond)
+	}
+	_ = db.Set([]byte("kept"), []byte("v"))
+	time.Sleep(5 * time.Millisecond)
+
+	stored := func() int { return db.def.index.Len() + docs.index.Len() }
+	db.cleanupExpired()
+	if n := stored(); n != 6 {
+		t.Fatalf("expected one sweep to remove 3 keys, %d left", n)
+	}
+	db.cleanupExpired()
+	db.cleanupExpired()
+	if n := stored(); n != 1 {
+		t.Fatalf("expected later sweeps to remove the rest, %d left", n)
+	}
+}
+
+func TestTTLSweepRunsAtInterval(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.TTLSweepInterval = 5 * time.Millisecond
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	_ = db.SetWithTTL([]byte("k"), []byte("v"), time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for db.def.index.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the sweep to remove the expired key")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func walRecordsOfType(t *testing.T, dir string, recordType wal.RecordType) []wal.WALRecord {
+	t.Helper()
+	segments, err := wal.ListSegments(filepath.Join(dir, "wal"))
+	if err != nil {
+		t.Fatalf("list segments: %v", err)
+	}
+	var found []wal.WALRecord
+	for _, path := range segments {
+		records, err := wal.ReadWAL(path)
+		if err != nil {
+			t.Fatalf("read wal: %v", err)
+		}
+		for _, record := range records {
+			if record.Type == recordType {
+				found = append(found, record)
+			}
+		}
+	}
+	return found
+}
+
+func TestReapedKeysAreWrittenToWAL(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions(dir)
+	opts.SyncMode = SyncManual
+	opts.TTLSweepInterval = time.Hour
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = db.SetWithTTL([]byte("swept"), []byte("v"), time.Millisecond)
+	_ = db.SetWithTTL([]byte("read"), []byte("v"), time.Millisecond)
+	_ = db.SetWithTTL([]byte("renewed"), []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := db.Get([]byte("read")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	db.def.index.Delete("renewed")
+	db.cleanupExpired()
+	_ = db.Set([]byte("renewed"), []byte("again"))
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	records := walRecordsOfType(t, dir, wal.RecordExpire)
+	keys := make(map[string]bool)
+	for _, record := range records {
+		keys[string(record.Key)] = true
+		if record.ExpiresAt <= 0 || record.ExpiresAt > record.Timestamp {
+			t.Fatalf("expected the expiry of the reaped entry, got %+v", record)
+		}
+	}
+	if len(records) != 2 || !keys["swept"] || !keys["read"] {
+		t.Fatalf("expected expire records for swept and read, got %v", keys)
+	}
+
+	db, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	expectValue(t, db, "renewed", "again")
+}
+
+func TestExpireRecordReplayIgnoresLocalClock(t *testing.T) {
+	now := time.Now().UnixNano()
+	idx := index.NewMemIndex()
+	// Entries that look live to a clock running behind the writer's.
+	idx.Set("reaped", []byte("v"), now+int64(time.Hour))
+	idx.Set("renewed", []byte("v"), now+int64(2*time.Hour))
+	idx.Set("persisted", []byte("v"), -1)
+
+	for _, key := range []string{"reaped", "renewed", "persisted", "missing"} {
+		applyWALRecord(idx, wal.WALRecord{
+			Type:      wal.RecordExpire,
+			Timestamp: now + int64(time.Hour),
+			ExpiresAt: now + int64(time.Hour),
+			Key:       []byte(key),
+		}, now)
+	}
+	if _, ok := idx.Get("reaped"); ok {
+		t.Fatalf("expected the reaped key to be removed")
+	}
+	if _, ok := idx.Get("renewed"); !ok {
+		t.Fatalf("expected a key with a later expiry to stay")
+	}
+	if _, ok := idx.Get("persisted"); !ok {
+		t.Fatalf("expected a key without TTL to stay")
+	}
+}
+
+func TestFollowerAppliesExpireRecords(t *testing.T) {
+	dir := t.TempDir()
+	writer := openTestDB(t, dir, func(opts *Options) { opts.TTLSweepInterval = time.Hour })
+	defer writer.Close()
+	reader := openTestDB(t, dir, readOnly)
+	defer reader.Close()
+
+	_ = writer.SetWithTTL([]byte("k"), []byte("v"), 10*time.Millisecond)
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if reader.def.index.Len() != 1 {
+		t.Fatalf("expected the follower to hold the key")
+	}
+	time.Sleep(20 * time.Millisecond)
+	writer.cleanupExpired()
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if n := reader.def.index.Len(); n != 0 {
+		t.Fatalf("expected the expire record to remove the key on the follower, %d keys left", n)
+	}
+}
+
+func TestOnExpireReportsReapedKeys(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	got := make(map[string]string)
+	var db *DB
+	var active, overlapped atomic.Int32
+	opts := DefaultOptions(dir)
+	opts.SyncMode = SyncManual
+	opts.TTLSweepInterval = time.Hour
+	opts.ValueLogThreshold = 4
+	opts.OnExpire = func(key, value []byte) {
+		if active.Add(1) > 1 {
+			overlapped.Store(1)
+		}
+		defer active.Add(-1)
+		// The DB is not locked during the callback.
+		_ = db.Set(append([]byte("seen:"), key...), value)
+		mu.Lock()
+		got[string(key)] = string(value)
+		mu.Unlock()
+	}
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = db.SetWithTTL([]byte("swept"), []byte("a"), time.Millisecond)
+	_ = db.SetWithTTL([]byte("read"), []byte("a large value"), time.Millisecond)
+	_, _ = db.SAdd([]byte("set"), []byte("m"))
+	_, _ = db.Expire([]byte("set"), time.Millisecond)
+	for i := 0; i < 50; i++ {
+		_ = db.SetWithTTL([]byte("bulk"+intToString(i)), []byte("v"), time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := db.Get([]byte("read")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	db.cleanupExpired()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 53 {
+		t.Fatalf("expected every reaped key to be reported before Close returned, got %d", len(got))
+	}
+	if got["swept"] != "a" || got["read"] != "a large value" {
+		t.Fatalf("expected values to be reported, got %q and %q", got["swept"], got["read"])
+	}
+	if value, ok := got["set"]; !ok || value != "" {
+		t.Fatalf("expected the set to be reported without a value, got %q %v", value, ok)
+	}
+	if overlapped.Load() != 0 {
+		t.Fatalf("expected calls to be made one at a time")
+	}
+}
@@ -0,0 +1,73 @@
+package minikv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+// Upgrade rewrites the data directory at path into the current on-disk
+// format. WAL segments and snapshots from older layouts are rewritten in
+// place, a text MANIFEST is converted to the binary log, and FORMAT is
+// updated last, so an interrupted upgrade can simply be run again. The
+// database must not be open.
+func Upgrade(path string) error {
+	for _, name := range []string{writerLockFile, readerLockFile} {
+		lockFile, err := flockFile(filepath.Join(path, name), syscall.LOCK_EX)
+		if err != nil {
+			return err
+		}
+		defer unlockFile(lockFile)
+	}
+
+	version, err := readFormat(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version == FormatVersion:
+		return nil
+	case version > FormatVersion:
+		return fmt.Errorf("%w: format %d is newer than %d", ErrUnsupportedFormat, version, FormatVersion)
+	case version == 0:
+		return writeFormat(path, FormatVersion)
+	}
+
+	segments, err := wal.ListSegments(filepath.Join(path, "wal"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, segment := range segments {
+		if err := wal.UpgradeSegment(segment); err != nil {
+			return fmt.Errorf("upgrade %s: %w", segment, err)
+		}
+	}
+
+	snapMgr := snapshot.NewManager(filepath.Join(path, "snapshots"))
+	for _, list := range []func() ([]string, error){snapMgr.ListSnapshots, snapMgr.ListDeltas} {
+		paths, err := list()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, snapPath := range paths {
+			if err := snapMgr.Upgrade(snapPath); err != nil {
+				return fmt.Errorf("upgrade %s: %w", snapPath, err)
+			}
+		}
+	}
+
+	manLog, err := openManifest(path)
+	if err != nil {
+		return err
+	}
+	if err := manLog.Close(); err != nil {
+		return err
+	}
+
+	return writeFormat(path, FormatVersion)
+}
@@ -0,0 +1,140 @@
+package minikv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+// writeLegacyDir lays out a version 1 data directory: a version 1 snapshot
+// holding a=1, a headerless WAL segment setting b=2, a text MANIFEST and no
+// FORMAT file.
+func writeLegacyDir(t *testing.T, dir string) {
+	t.Helper()
+	snapDir := filepath.Join(dir, "snapshots")
+	walDir := filepath.Join(dir, "wal")
+	if err := os.MkdirAll(walDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	snapPath, err := snapshot.NewManager(snapDir).CreateSnapshot(
+		[]snapshot.Entry{{Key: []byte("a"), Value: []byte("1"), ExpiresAt: -1}}, 1, 1, 1)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	segPath := wal.SegmentPath(walDir, 2)
+	record := wal.EncodeWALRecord(wal.WALRecord{Type: wal.RecordSet, Key: []byte("b"), Value: []byte("2"), ExpiresAt: -1})
+	if err := os.WriteFile(segPath, record, 0o644); err != nil {
+		t.Fatalf("wal: %v", err)
+	}
+	text := fmt.Sprintf("current_wal_seq: 2\nlast_snapshot_seq: 1\nwal: 2 %q\nsnapshot: 1 %q\n", segPath, snapPath)
+	if err := os.WriteFile(filepath.Join(dir, "MANIFEST"), []byte(text), 0o644); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+}
+
+func TestOpenWritesFormatMarker(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	defer db.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "FORMAT"))
+	if err != nil {
+		t.Fatalf("read FORMAT: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != intToString(FormatVersion) {
+		t.Fatalf("unexpected FORMAT %q", data)
+	}
+}
+
+func TestOpenRejectsLegacyFormat(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyDir(t, dir)
+	_, err := Open(DefaultOptions(dir))
+	if !errors.Is(err, ErrUpgradeRequired) {
+		t.Fatalf("expected ErrUpgradeRequired, got %v", err)
+	}
+}
+
+func TestOpenRejectsNewerFormat(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "FORMAT"), []byte(intToString(FormatVersion+1)+"\n"), 0o644); err != nil {
+		t.Fatalf("write FORMAT: %v", err)
+	}
+	if _, err := Open(DefaultOptions(dir)); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+	if err := Upgrade(dir); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected Upgrade to refuse newer format, got %v", err)
+	}
+}
+
+func TestUpgradeRewritesLegacyDir(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyDir(t, dir)
+	if err := Upgrade(dir); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if err := Upgrade(dir); err != nil {
+		t.Fatalf("second upgrade: %v", err)
+	}
+
+	if version, err := wal.SegmentVersion(wal.SegmentPath(filepath.Join(dir, "wal"), 2)); err != nil || version != wal.Version {
+		t.Fatalf("expected upgraded WAL segment, got %d %v", version, err)
+	}
+	head, _, err := snapshot.DecodeSnapshot(filepath.Join(dir, "snapshots", "snapshot_000001.snap"))
+	if err != nil || head.Version != snapshot.Version {
+		t.Fatalf("expected upgraded snapshot, got %+v %v", head, err)
+	}
+
+	db := openTestDB(t, dir)
+	defer db.Close()
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		value, err := db.Get([]byte(key))
+		if err != nil || string(value) != want {
+			t.Fatalf("get %s: %q %v", key, value, err)
+		}
+	}
+}
+
+func TestUpgradeFormat2Dir(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_ = db.Set([]byte("a"), []byte("1"))
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := writeFormat(dir, 2); err != nil {
+		t.Fatalf("write FORMAT: %v", err)
+	}
+	if _, err := Open(DefaultOptions(dir)); !errors.Is(err, ErrUpgradeRequired) {
+		t.Fatalf("expected ErrUpgradeRequired, got %v", err)
+	}
+	if err := Upgrade(dir); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if value, err := db.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Fatalf("get a: %q %v", value, err)
+	}
+}
+
+func TestOpenRejectsUnknownWALRecord(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_ = db.Set([]byte("a"), []byte("1"))
+	if _, err := db.wal.AppendRaw(wal.EncodeWALRecord(wal.WALRecord{Type: 99, Key: []byte("b")})); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := Open(DefaultOptions(dir)); !errors.Is(err, ErrCorruptWAL) {
+		t.Fatalf("expected ErrCorruptWAL, got %v", err)
+	}
+}
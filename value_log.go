@@ -0,0 +1,303 @@
+package minikv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/index"
+	"github.com/bretuobay/mini-kv/internal/manifest"
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+	"github.com/bretuobay/mini-kv/internal/vlog"
+	"github.com/bretuobay/mini-kv/internal/wal"
+)
+
+const valueLogGCInterval = 1 * time.Minute
+
+// encodeValueLocked returns the WAL record for a set of key to value in the family.
+// Values above ValueLogThreshold are appended to the value log and the
+// record carries only the pointer. Callers must hold db.mu.
+func (f *Family) encodeValueLocked(key []byte, value []byte, expiresAt int64, timestamp int64) (wal.WALRecord, error) {
+	db := f.db
+	record := wal.WALRecord{
+		Type:      wal.RecordSet,
+		Timestamp: timestamp,
+		ExpiresAt: expiresAt,
+		Key:       append([]byte(nil), key...),
+		Value:     append([]byte(nil), value...),
+		Family:    f.id,
+	}
+	if db.vlog == nil || len(value) <= db.opts.ValueLogThreshold {
+		return record, nil
+	}
+	ptr, err := db.vlog.Append(value)
+	if err != nil {
+		return wal.WALRecord{}, err
+	}
+	record.Type = wal.RecordSetPointer
+	record.Value = ptr.Encode()
+	return record, nil
+}
+
+// applyRecordLocked mirrors a set record produced by encodeValueLocked into the family index.
+func (f *Family) applyRecordLocked(record wal.WALRecord, createdAt int64) {
+	f.markDirtyLocked(record)
+	if record.Type == wal.RecordSetPointer {
+		f.index.SetValuePointer(string(record.Key), record.Value, record.ExpiresAt, createdAt)
+		return
+	}
+	f.index.SetEntry(string(record.Key), record.Value, record.ExpiresAt, createdAt)
+}
+
+// resolveValue returns a copy of the entry's value, reading it from the
+// value log when the entry holds a pointer. Callers must hold db.mu so
+// that value-log GC cannot remove the file mid-read.
+func (db *DB) resolveValue(entry *index.Entry) ([]byte, error) {
+	if !entry.Pointer {
+		return append([]byte(nil), entry.Value...), nil
+	}
+	if db.vlog == nil {
+		return nil, ErrCorruptVLog
+	}
+	ptr, err := vlog.DecodePointer(entry.Value)
+	if err != nil {
+		return nil, ErrCorruptVLog
+	}
+	value, err := db.vlog.Read(ptr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptVLog, err)
+	}
+	return value, nil
+}
+
+// valueLen returns the logical value length without reading the value log.
+func valueLen(entry *index.Entry) int {
+	if !entry.Pointer {
+		return len(entry.Value)
+	}
+	ptr, err := vlog.DecodePointer(entry.Value)
+	if err != nil {
+		return 0
+	}
+	return int(ptr.Length)
+}
+
+// syncWAL flushes the value log before the WAL so that no durable WAL
+// record points at value-log data that is not yet on disk.
+func (db *DB) syncWAL() error {
+	if db.vlog != nil {
+		if err := db.vlog.Sync(); err != nil {
+			return err
+		}
+	}
+	return db.wal.Sync()
+}
+
+// ValueLogGC rewrites value-log files whose live-bytes ratio has fallen below
+// ValueLogGCRatio and removes them. Live values are copied to the current file
+// and re-pointed through the WAL, so the rewrite survives restarts. A file
+// still referenced by a snapshot chain on disk, such as one kept by
+// SnapshotRetention or pinned with PinSnapshot, is removed by a later run once
+// compaction has pruned those chains.
+func (db *DB) ValueLogGC() error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		db.mu.RUnlock()
+		return ErrReadOnly
+	}
+	if db.vlog == nil {
+		db.mu.RUnlock()
+		return nil
+	}
+	files, err := db.vlog.Files()
+	if err != nil {
+		db.mu.RUnlock()
+		return err
+	}
+	current := db.vlog.CurrentSeq()
+	live := make(map[uint64]int64, len(files))
+	for _, f := range db.families {
+		f.index.ForEach(func(_ string, entry *index.Entry) bool {
+			if !entry.Pointer {
+				return true
+			}
+			if ptr, err := vlog.DecodePointer(entry.Value); err == nil {
+				live[ptr.Seq] += int64(ptr.Length)
+			}
+			return true
+		})
+	}
+	db.mu.RUnlock()
+
+	var rewritten []uint64
+	for _, file := range files {
+		if file.Seq >= current {
+			continue
+		}
+		if file.Size > 0 && float64(live[file.Seq])/float64(file.Size) >= db.opts.ValueLogGCRatio {
+			continue
+		}
+		if live[file.Seq] > 0 {
+			if err := db.rewriteValueLogFile(file.Seq); err != nil {
+				return err
+			}
+		}
+		rewritten = append(rewritten, file.Seq)
+	}
+	return db.removeValueLogFiles(rewritten)
+}
+
+func (db *DB) rewriteValueLogFile(seq uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+
+	type move struct {
+		family *Family
+		key    string
+		entry  index.Entry
+		ptr    vlog.Pointer
+	}
+	var moves []move
+	for _, f := range db.families {
+		f.index.ForEach(func(key string, entry *index.Entry) bool {
+			if !entry.Pointer {
+				return true
+			}
+			ptr, err := vlog.DecodePointer(entry.Value)
+			if err == nil && ptr.Seq == seq {
+				moves = append(moves, move{family: f, key: key, entry: *entry, ptr: ptr})
+			}
+			return true
+		})
+	}
+
+	for _, m := range moves {
+		if len(m.entry.Operands) > 0 {
+			// Re-pointing would drop the operands; write the folded value instead.
+			value, err := db.entryValue(m.key, &m.entry)
+			if err != nil {
+				return err
+			}
+			record, err := m.family.encodeValueLocked([]byte(m.key), value, m.entry.ExpiresAt, m.entry.CreatedAt)
+			if err != nil {
+				return err
+			}
+			if err := db.wal.AppendRecord(record); err != nil {
+				return err
+			}
+			m.family.applyRecordLocked(record, m.entry.CreatedAt)
+			continue
+		}
+		value, err := db.vlog.Read(m.ptr)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptVLog, err)
+		}
+		ptr, err := db.vlog.Append(value)
+		if err != nil {
+			return err
+		}
+		record := wal.WALRecord{
+			Type:      wal.RecordSetPointer,
+			Timestamp: m.entry.CreatedAt,
+			ExpiresAt: m.entry.ExpiresAt,
+			Key:       []byte(m.key),
+			Value:     ptr.Encode(),
+			Family:    m.family.id,
+		}
+		if err := db.wal.AppendRecord(record); err != nil {
+			return err
+		}
+		m.family.applyRecordLocked(record, m.entry.CreatedAt)
+	}
+	if len(moves) > 0 {
+		return db.syncWAL()
+	}
+	return nil
+}
+
+// removeValueLogFiles removes the rewritten files no snapshot chain on disk
+// refers to. compactMu is held so that a compaction which read the index
+// before the rewrite cannot commit a chain pointing into a removed file.
+func (db *DB) removeValueLogFiles(seqs []uint64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+	referenced, err := db.snapshotValueLogRefs()
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	for _, seq := range seqs {
+		if referenced[seq] {
+			continue
+		}
+		if err := db.vlog.Remove(seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotValueLogRefs returns the value-log files referenced by the
+// snapshot and delta files in the manifest.
+func (db *DB) snapshotValueLogRefs() (map[uint64]bool, error) {
+	man := db.manifest.State()
+	referenced := make(map[uint64]bool)
+	for _, infos := range [][]manifest.SnapshotInfo{man.Snapshots, man.Deltas} {
+		for _, info := range infos {
+			_, err := snapshot.ScanSnapshot(info.Path, func(entry snapshot.Entry) error {
+				if !entry.Pointer {
+					return nil
+				}
+				if ptr, err := vlog.DecodePointer(entry.Value); err == nil {
+					referenced[ptr.Seq] = true
+				}
+				return nil
+			})
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	return referenced, nil
+}
+
+func (db *DB) startValueLogGCWorker() {
+	if db.vlog == nil || db.opts.ReadOnly {
+		return
+	}
+	if db.stopCh == nil {
+		db.stopCh = make(chan struct{})
+	}
+	if db.vlogTicker == nil {
+		db.vlogTicker = db.opts.Clock.NewTicker(valueLogGCInterval)
+	}
+
+	db.wg.Add(1)
+	go func() {
+		defer db.wg.Done()
+		for {
+			select {
+			case <-db.vlogTicker.C():
+				_ = db.ValueLogGC()
+			case <-db.stopCh:
+				return
+			}
+		}
+	}()
+}
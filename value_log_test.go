@@ -0,0 +1,247 @@
+package minikv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bretuobay/mini-kv/internal/snapshot"
+	"github.com/bretuobay/mini-kv/internal/vlog"
+)
+
+// smallValueLog moves values over 64 bytes to value-log files of 1 KiB.
+func smallValueLog(opts *Options) {
+	opts.ValueLogThreshold = 64
+	opts.ValueLogFileSize = 1024
+}
+
+func TestValueLogStoresLargeValuesOutOfLine(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, smallValueLog)
+
+	large := bytes.Repeat([]byte("x"), 512)
+	if err := db.Set([]byte("big"), large); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := db.Set([]byte("small"), []byte("v")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	walSize := dirSize(filepath.Join(dir, "wal"), ".log")
+	if walSize >= int64(len(large)) {
+		t.Fatalf("expected WAL to hold only a pointer, size %d", walSize)
+	}
+	value, err := db.Get([]byte("big"))
+	if err != nil || !bytes.Equal(value, large) {
+		t.Fatalf("get: %v", err)
+	}
+	buf, err := db.GetInto(nil, []byte("big"))
+	if err != nil || !bytes.Equal(buf, large) {
+		t.Fatalf("get into: %v", err)
+	}
+	_, values, err := db.Scan([]byte("b"), 0)
+	if err != nil || len(values) != 1 || !bytes.Equal(values[0], large) {
+		t.Fatalf("scan: %v", err)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, smallValueLog)
+	defer db.Close()
+	value, err = db.Get([]byte("big"))
+	if err != nil || !bytes.Equal(value, large) {
+		t.Fatalf("get after reopen: %v", err)
+	}
+}
+
+func TestValueLogExpireKeepsPointer(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), smallValueLog)
+	defer db.Close()
+
+	large := bytes.Repeat([]byte("y"), 256)
+	if err := db.Set([]byte("k"), large); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	before, _ := db.Stats()
+	if ok, err := db.Expire([]byte("k"), time.Hour); err != nil || !ok {
+		t.Fatalf("expire: %v %v", ok, err)
+	}
+	after, _ := db.Stats()
+	if after.ValueLogSize != before.ValueLogSize {
+		t.Fatalf("expected expire not to rewrite value, %d -> %d", before.ValueLogSize, after.ValueLogSize)
+	}
+	value, err := db.Get([]byte("k"))
+	if err != nil || !bytes.Equal(value, large) {
+		t.Fatalf("get: %v", err)
+	}
+}
+
+func TestValueLogGCRewritesSparseFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, smallValueLog)
+
+	for i := 0; i < 16; i++ {
+		value := bytes.Repeat([]byte{byte('a' + i)}, 200)
+		if err := db.Set([]byte("k"+intToString(i)), value); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	// Overwrite most keys with small inline values so early files become sparse.
+	for i := 1; i < 16; i++ {
+		if err := db.Set([]byte("k"+intToString(i)), []byte("s")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+
+	vlogDir := filepath.Join(dir, "vlog")
+	before := dirCount(vlogDir, ".vlog")
+	if err := db.ValueLogGC(); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	after := dirCount(vlogDir, ".vlog")
+	if after >= before {
+		t.Fatalf("expected gc to remove files, before %d after %d", before, after)
+	}
+
+	want := bytes.Repeat([]byte("a"), 200)
+	value, err := db.Get([]byte("k0"))
+	if err != nil || !bytes.Equal(value, want) {
+		t.Fatalf("get after gc: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir, smallValueLog)
+	defer db.Close()
+	value, err = db.Get([]byte("k0"))
+	if err != nil || !bytes.Equal(value, want) {
+		t.Fatalf("get after reopen: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(vlogDir, "000001.vlog")); !os.IsNotExist(err) {
+		t.Fatalf("expected first value-log file to be collected, got %v", err)
+	}
+}
+
+func TestValueLogGCKeepsFilesOfPinnedChain(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, smallValueLog)
+	defer db.Close()
+
+	want := make(map[string][]byte)
+	for i := 0; i < 16; i++ {
+		key := "k" + intToString(i)
+		want[key] = bytes.Repeat([]byte{byte('a' + i)}, 200)
+		if err := db.Set([]byte(key), want[key]); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	pin, err := db.PinSnapshot()
+	if err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	for i := 0; i < 16; i++ {
+		if err := db.Set([]byte("k"+intToString(i)), []byte("s")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	// Move past the pinned chain so only the pin keeps it.
+	compactRounds(t, db, 2*MaxSnapshotDeltas)
+	if err := db.ValueLogGC(); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+
+	// Restoring from the pinned chain must still find every value it points to.
+	restored := 0
+	for _, path := range pin.Paths {
+		_, err := snapshot.ScanSnapshot(path, func(entry snapshot.Entry) error {
+			if !entry.Pointer {
+				return nil
+			}
+			ptr, err := vlog.DecodePointer(entry.Value)
+			if err != nil {
+				return err
+			}
+			value, err := db.vlog.Read(ptr)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(value, want[string(entry.Key)]) {
+				t.Fatalf("restore %s: got %q", entry.Key, value)
+			}
+			restored++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("restore from pin: %v", err)
+		}
+	}
+	if restored != len(want) {
+		t.Fatalf("expected %d values restored from the pin, got %d", len(want), restored)
+	}
+
+	pin.Release()
+	compactRounds(t, db, 1)
+	if err := db.ValueLogGC(); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vlog", "000001.vlog")); !os.IsNotExist(err) {
+		t.Fatalf("expected the unpinned file to be collected, got %v", err)
+	}
+}
+
+func TestWALRotationSyncsValueLogFirst(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions(dir)
+	opts.SyncMode = SyncManual
+	opts.ValueLogThreshold = 64
+	opts.MaxWALSize = 256
+	opts.Compaction.IdleOnly = true
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	large := bytes.Repeat([]byte("x"), 512)
+	for i := 0; i < 20; i++ {
+		if err := db.Set([]byte("big"+intToString(i)), large); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if db.wal.CurrentSeq() < 3 {
+		t.Fatalf("expected the writes to rotate the WAL, at segment %d", db.wal.CurrentSeq())
+	}
+	// A rotation that cannot sync the value log must not seal the segment.
+	seq := db.wal.CurrentSeq()
+	_ = db.vlog.Close()
+	if _, err := db.wal.Rotate(); err == nil {
+		t.Fatalf("expected rotation to fail without a value log to sync")
+	}
+	if db.wal.CurrentSeq() != seq {
+		t.Fatalf("expected segment %d kept, got %d", seq, db.wal.CurrentSeq())
+	}
+	// Simulate a crash: release the lock without closing.
+	_ = db.wal.Close()
+	_ = db.lockFile.Close()
+	db.lockFile = nil
+
+	db, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 20; i++ {
+		if value, err := db.Get([]byte("big" + intToString(i))); err != nil || !bytes.Equal(value, large) {
+			t.Fatalf("get big%d after crash: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package minikv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// A sorted set keeps two member keys per element under its version prefix:
+// zsetScoreTag + member holds the score, and zsetRankTag + score + member
+// orders elements by score, then member, for range queries.
+const (
+	zsetScoreTag = 'm'
+	zsetRankTag  = 's'
+)
+
+// encodeScore encodes a score so that byte order matches numeric order.
+func encodeScore(score float64) []byte {
+	if score == 0 {
+		score = 0 // fold -0 into 0
+	}
+	bits := math.Float64bits(score)
+	if bits>>63 == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+func decodeScore(data []byte) (float64, error) {
+	if len(data) != 8 {
+		return 0, ErrInvalidValue
+	}
+	bits := binary.BigEndian.Uint64(data)
+	if bits>>63 == 1 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), nil
+}
+
+func zsetScoreKey(base, member []byte) []byte {
+	key := append(base[:len(base):len(base)], zsetScoreTag)
+	return append(key, member...)
+}
+
+func zsetRankKey(base []byte, score float64, member []byte) []byte {
+	key := append(base[:len(base):len(base)], zsetRankTag)
+	key = append(key, encodeScore(score)...)
+	return append(key, member...)
+}
+
+// ZAdd sets the score of member in the sorted set at key and reports whether
+// the member is new.
+func (db *DB) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	return db.def.ZAdd(key, score, member)
+}
+
+// ZAdd sets the score of member in the sorted set at key in the family. A new
+// sorted set gets the family's DefaultTTL; Expire and Persist on key apply to
+// the whole set. NaN scores are rejected with ErrInvalidValue.
+func (f *Family) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	var added bool
+	err := f.writeCollection(key, member, nil, func(t *txn) error {
+		var err error
+		added, err = t.zadd(f, key, member, score)
+		return err
+	})
+	return added, err
+}
+
+// ZRem removes member from the sorted set at key. Removing the last member
+// removes the set.
+func (db *DB) ZRem(key, member []byte) (bool, error) {
+	return db.def.ZRem(key, member)
+}
+
+// ZRem removes member from the sorted set at key in the family.
+func (f *Family) ZRem(key, member []byte) (bool, error) {
+	var removed bool
+	err := f.writeCollection(key, member, nil, func(t *txn) error {
+		var err error
+		removed, err = t.zrem(f, key, member)
+		return err
+	})
+	return removed, err
+}
+
+// ZIncrBy adds delta to the score of member in the sorted set at key and
+// returns the new score.
+func (db *DB) ZIncrBy(key []byte, delta float64, member []byte) (float64, error) {
+	return db.def.ZIncrBy(key, delta, member)
+}
+
+// ZIncrBy adds delta to the score of member in the sorted set at key in the
+// family. A missing member starts at zero.
+func (f *Family) ZIncrBy(key []byte, delta float64, member []byte) (float64, error) {
+	var score float64
+	err := f.writeCollection(key, member, nil, func(t *txn) error {
+		current, _, err := t.zscore(f, key, member)
+		if err != nil {
+			return err
+		}
+		score = current + delta
+		_, err = t.zadd(f, key, member, score)
+		return err
+	})
+	return score, err
+}
+
+// ZScore returns the score of member in the sorted set at key.
+func (db *DB) ZScore(key, member []byte) (float64, error) {
+	return db.def.ZScore(key, member)
+}
+
+// ZScore returns the score of member in the sorted set at key in the family.
+func (f *Family) ZScore(key, member []byte) (float64, error) {
+	var score float64
+	err := f.readCollection(key, kindZSet, func(meta *collectionMeta, sub *Family) error {
+		var err error
+		score, err = sub.zscoreLocked(memberPrefix(key, meta.version), member)
+		return err
+	})
+	return score, err
+}
+
+// ZRangeByScore returns up to limit members of the sorted set at key with a
+// score within [min, max], ordered by score and then member, with their scores.
+func (db *DB) ZRangeByScore(key []byte, min, max float64, limit int) ([][]byte, []float64, error) {
+	return db.def.ZRangeByScore(key, min, max, limit)
+}
+
+// ZRangeByScore returns up to limit members of the sorted set at key in the
+// family with a score within [min, max]. A missing set yields no members.
+func (f *Family) ZRangeByScore(key []byte, min, max float64, limit int) ([][]byte, []float64, error) {
+	var members [][]byte
+	var scores []float64
+	err := f.readCollection(key, kindZSet, func(meta *collectionMeta, sub *Family) error {
+		if min > max {
+			return nil
+		}
+		base := memberPrefix(key, meta.version)
+		start := zsetRankKey(base, min, nil)
+		// Members are at most MaxKeySize bytes, so every member with score max
+		// sorts before max followed by more 0xff bytes than that.
+		end := zsetRankKey(base, max, bytes.Repeat([]byte{0xff}, f.opts.MaxKeySize+1))
+		for _, entry := range sub.index.ScanRange(string(start), string(end), limit) {
+			score, member, err := parseRankKey(base, entry.Key)
+			if err != nil {
+				return err
+			}
+			members = append(members, member)
+			scores = append(scores, score)
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil, nil, nil
+	}
+	return members, scores, err
+}
+
+// ZRank returns the 0-based position of member in the sorted set at key,
+// ordered by score and then member.
+func (db *DB) ZRank(key, member []byte) (int, error) {
+	return db.def.ZRank(key, member)
+}
+
+// ZRank returns the 0-based position of member in the sorted set at key in
+// the family. It returns ErrNotFound if the member is not in the set.
+func (f *Family) ZRank(key, member []byte) (int, error) {
+	var rank int
+	err := f.readCollection(key, kindZSet, func(meta *collectionMeta, sub *Family) error {
+		base := memberPrefix(key, meta.version)
+		score, err := sub.zscoreLocked(base, member)
+		if err != nil {
+			return err
+		}
+		start := append(base[:len(base):len(base)], zsetRankTag)
+		end := zsetRankKey(base, score, member)
+		rank = len(sub.index.ScanRange(string(start), string(end), 0)) - 1
+		return nil
+	})
+	return rank, err
+}
+
+// ZCard returns the number of members in the sorted set at key.
+func (db *DB) ZCard(key []byte) (int, error) {
+	return db.def.ZCard(key)
+}
+
+// ZCard returns the number of members in the sorted set at key in the
+// family; 0 if it does not exist.
+func (f *Family) ZCard(key []byte) (int, error) {
+	var count int
+	err := f.readCollection(key, kindZSet, func(meta *collectionMeta, _ *Family) error {
+		count = int(meta.count)
+		return nil
+	})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return count, err
+}
+
+// zscoreLocked returns the score of member under base in the member family.
+func (f *Family) zscoreLocked(base, member []byte) (float64, error) {
+	entry, ok := f.index.Get(string(zsetScoreKey(base, member)))
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return decodeScore(entry.Value)
+}
+
+func parseRankKey(base, key []byte) (float64, []byte, error) {
+	if len(key) < len(base)+9 {
+		return 0, nil, ErrInvalidValue
+	}
+	rest := key[len(base)+1:]
+	score, err := decodeScore(rest[:8])
+	if err != nil {
+		return 0, nil, err
+	}
+	return score, append([]byte(nil), rest[8:]...), nil
+}
+
+// zscore returns the score of member in the sorted set at key as of the
+// staged writes, and whether it is in the set.
+func (t *txn) zscore(f *Family, key, member []byte) (float64, bool, error) {
+	meta, err := t.meta(f, string(key), kindZSet)
+	if err != nil || meta == nil {
+		return 0, false, err
+	}
+	sub, err := f.subFamilyLocked(false)
+	if err != nil || sub == nil {
+		return 0, false, err
+	}
+	value, ok, err := t.get(sub, string(zsetScoreKey(memberPrefix(key, meta.version), member)))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	score, err := decodeScore(value)
+	return score, err == nil, err
+}
+
+// zadd stages the score of member in the sorted set at key and reports
+// whether the member is new.
+func (t *txn) zadd(f *Family, key, member []byte, score float64) (bool, error) {
+	if math.IsNaN(score) {
+		return false, ErrInvalidValue
+	}
+	old, exists, err := t.zscore(f, key, member)
+	if err != nil {
+		return false, err
+	}
+	if exists && old == score {
+		return false, nil
+	}
+	meta, err := t.meta(f, string(key), kindZSet)
+	if err != nil {
+		return false, err
+	}
+	if meta == nil {
+		meta = t.newMeta(f, kindZSet)
+	}
+	sub, err := f.subFamilyLocked(true)
+	if err != nil {
+		return false, err
+	}
+	base := memberPrefix(key, meta.version)
+	if err := t.set(sub, zsetRankKey(base, score, member), nil, -1); err != nil {
+		return false, err
+	}
+	if err := t.set(sub, zsetScoreKey(base, member), encodeScore(score), -1); err != nil {
+		return false, err
+	}
+	if exists {
+		t.delete(sub, zsetRankKey(base, old, member))
+		return false, nil
+	}
+	meta.count++
+	t.putMeta(f, key, meta)
+	return true, nil
+}
+
+// zrem stages the removal of member from the sorted set at key and reports
+// whether it was in the set.
+func (t *txn) zrem(f *Family, key, member []byte) (bool, error) {
+	score, exists, err := t.zscore(f, key, member)
+	if err != nil || !exists {
+		return false, err
+	}
+	meta, err := t.meta(f, string(key), kindZSet)
+	if err != nil {
+		return false, err
+	}
+	sub, err := f.subFamilyLocked(false)
+	if err != nil {
+		return false, err
+	}
+	base := memberPrefix(key, meta.version)
+	t.delete(sub, zsetScoreKey(base, member))
+	t.delete(sub, zsetRankKey(base, score, member))
+	meta.count--
+	t.putMeta(f, key, meta)
+	return true, nil
+}
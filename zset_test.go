@@ -0,0 +1,234 @@
+package minikv
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+func TestScoreEncodingPreservesOrder(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 500
+	properties := gopter.NewProperties(parameters)
+
+	properties.Property("encoded scores sort like the scores", prop.ForAll(
+		func(a, b float64) bool {
+			ea, eb := string(encodeScore(a)), string(encodeScore(b))
+			da, err := decodeScore([]byte(ea))
+			if err != nil || da != a {
+				return false
+			}
+			switch {
+			case a < b:
+				return ea < eb
+			case a > b:
+				return ea > eb
+			}
+			return ea == eb
+		},
+		gen.Float64(),
+		gen.Float64(),
+	))
+
+	properties.TestingRun(t)
+
+	ordered := []float64{math.Inf(-1), -1e300, -1, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 1, 1e300, math.Inf(1)}
+	for i := 1; i < len(ordered); i++ {
+		if string(encodeScore(ordered[i-1])) >= string(encodeScore(ordered[i])) {
+			t.Fatalf("expected %v to sort before %v", ordered[i-1], ordered[i])
+		}
+	}
+	if string(encodeScore(math.Copysign(0, -1))) != string(encodeScore(0)) {
+		t.Fatalf("expected -0 and 0 to encode alike")
+	}
+}
+
+func TestSortedSetOperations(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	for _, m := range []struct {
+		member string
+		score  float64
+	}{{"carol", 30}, {"alice", 10}, {"bob", 20}, {"dave", 20}, {"eve", -5}} {
+		if added, err := db.ZAdd([]byte("board"), m.score, []byte(m.member)); err != nil || !added {
+			t.Fatalf("zadd %s: %v %v", m.member, added, err)
+		}
+	}
+	if added, _ := db.ZAdd([]byte("board"), 40, []byte("carol")); added {
+		t.Fatalf("expected updating a score not to report a new member")
+	}
+	if n, _ := db.ZCard([]byte("board")); n != 5 {
+		t.Fatalf("expected 5 members, got %d", n)
+	}
+	if score, err := db.ZScore([]byte("board"), []byte("carol")); err != nil || score != 40 {
+		t.Fatalf("zscore: %v %v", score, err)
+	}
+	if _, err := db.ZScore([]byte("board"), []byte("nobody")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	members, scores, err := db.ZRangeByScore([]byte("board"), 10, 40, 0)
+	if err != nil {
+		t.Fatalf("zrangebyscore: %v", err)
+	}
+	want := []string{"alice", "bob", "dave", "carol"}
+	if len(members) != len(want) {
+		t.Fatalf("expected %v, got %q", want, members)
+	}
+	for i, member := range members {
+		if string(member) != want[i] {
+			t.Fatalf("expected %v, got %q", want, members)
+		}
+	}
+	if scores[0] != 10 || scores[3] != 40 {
+		t.Fatalf("unexpected scores %v", scores)
+	}
+	if members, _, _ := db.ZRangeByScore([]byte("board"), math.Inf(-1), 15, 1); len(members) != 1 || string(members[0]) != "eve" {
+		t.Fatalf("expected limited range to return eve, got %q", members)
+	}
+	if members, _, _ := db.ZRangeByScore([]byte("board"), 50, 10, 0); len(members) != 0 {
+		t.Fatalf("expected empty range, got %q", members)
+	}
+
+	for member, want := range map[string]int{"eve": 0, "alice": 1, "bob": 2, "dave": 3, "carol": 4} {
+		if rank, err := db.ZRank([]byte("board"), []byte(member)); err != nil || rank != want {
+			t.Fatalf("zrank %s: %d %v, want %d", member, rank, err, want)
+		}
+	}
+
+	if score, err := db.ZIncrBy([]byte("board"), -50, []byte("carol")); err != nil || score != -10 {
+		t.Fatalf("zincrby: %v %v", score, err)
+	}
+	if rank, _ := db.ZRank([]byte("board"), []byte("carol")); rank != 0 {
+		t.Fatalf("expected carol first after zincrby, got %d", rank)
+	}
+	if score, _ := db.ZIncrBy([]byte("board"), 2.5, []byte("frank")); score != 2.5 {
+		t.Fatalf("expected a new member to start at zero, got %v", score)
+	}
+	if _, err := db.ZAdd([]byte("board"), math.NaN(), []byte("x")); err != ErrInvalidValue {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+
+	for _, member := range []string{"alice", "bob", "carol", "dave", "eve", "frank"} {
+		if removed, err := db.ZRem([]byte("board"), []byte(member)); err != nil || !removed {
+			t.Fatalf("zrem %s: %v %v", member, removed, err)
+		}
+	}
+	if ok, _ := db.Exists([]byte("board")); ok {
+		t.Fatalf("expected empty sorted set to be removed")
+	}
+	if n := db.def.sub.index.Len(); n != 0 {
+		t.Fatalf("expected no members left, got %d", n)
+	}
+
+	_, _ = db.RPush([]byte("list"), []byte("x"))
+	if _, err := db.ZAdd([]byte("list"), 1, []byte("x")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestSortedSetBatchIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	batch := db.NewBatch()
+	batch.ZAdd([]byte("z"), 1, []byte("a"))
+	batch.ZAdd([]byte("z"), 2, []byte("b"))
+	batch.ZAdd([]byte("z"), 3, []byte("a"))
+	batch.ZRem([]byte("z"), []byte("b"))
+	batch.ZAdd([]byte("z"), 0, []byte("c"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	batch = db.NewBatch()
+	batch.ZAdd([]byte("z"), 9, []byte("d"))
+	batch.ZAdd([]byte("z"), math.NaN(), []byte("e"))
+	if err := batch.Write(); err != ErrInvalidValue {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	members, scores, err := db.ZRangeByScore([]byte("z"), math.Inf(-1), math.Inf(1), 0)
+	if err != nil || len(members) != 2 || string(members[0]) != "c" || string(members[1]) != "a" || scores[1] != 3 {
+		t.Fatalf("unexpected members %q %v %v", members, scores, err)
+	}
+	if n, _ := db.ZCard([]byte("z")); n != 2 {
+		t.Fatalf("expected 2 members, got %d", n)
+	}
+}
+
+func TestSortedSetMatchesModel(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 30
+	properties := gopter.NewProperties(parameters)
+
+	type op struct {
+		remove bool
+		member string
+		score  float64
+	}
+	genOp := gopter.CombineGens(gen.IntRange(0, 3), gen.IntRange(0, 5), gen.IntRange(-3, 3)).Map(func(v []interface{}) op {
+		return op{remove: v[0].(int) == 0, member: "m" + intToString(v[1].(int)), score: float64(v[2].(int))}
+	})
+
+	properties.Property("sorted set matches a map across reopen", prop.ForAll(
+		func(ops []op) bool {
+			dir := t.TempDir()
+			db := openTestDB(t, dir)
+			model := make(map[string]float64)
+			for _, o := range ops {
+				if o.remove {
+					_, _ = db.ZRem([]byte("z"), []byte(o.member))
+					delete(model, o.member)
+				} else {
+					_, _ = db.ZAdd([]byte("z"), o.score, []byte(o.member))
+					model[o.member] = o.score
+				}
+			}
+			_ = db.Close()
+			db = openTestDB(t, dir)
+			defer db.Close()
+
+			want := make([]string, 0, len(model))
+			for member := range model {
+				want = append(want, member)
+			}
+			sort.Slice(want, func(i, j int) bool {
+				if model[want[i]] != model[want[j]] {
+					return model[want[i]] < model[want[j]]
+				}
+				return want[i] < want[j]
+			})
+			members, scores, err := db.ZRangeByScore([]byte("z"), math.Inf(-1), math.Inf(1), 0)
+			if err != nil || len(members) != len(want) {
+				return false
+			}
+			for i, member := range members {
+				if string(member) != want[i] || scores[i] != model[want[i]] {
+					return false
+				}
+				if rank, err := db.ZRank([]byte("z"), member); err != nil || rank != i {
+					return false
+				}
+			}
+			n, _ := db.ZCard([]byte("z"))
+			return n == len(model)
+		},
+		gen.SliceOf(genOp),
+	))
+
+	properties.TestingRun(t)
+}